@@ -0,0 +1,122 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"io"
+	"strings"
+	"time"
+)
+
+// An animated meme is a sequence of frames on the same template, each with
+// its own caption, written as an animated PNG. -frames 2 (or more) blinks
+// the caption, showing and hiding it in turn; repeated -frame-text flags
+// cycle through several captions instead:
+//
+//	memegen -frame-text "WAIT" -frame-text "FOR IT" out.png
+//
+// Every frame is rendered as a still meme would be, so all other options
+// apply to each of them.
+
+const defaultFrameDelay = 500 * time.Millisecond
+
+// frameTextFlags collects repeated -frame-text flags, in order. It
+// implements flag.Value.
+type frameTextFlags []string
+
+// String formats the captions as a list separated by " | ".
+func (f *frameTextFlags) String() string {
+	return strings.Join(*f, " | ")
+}
+
+// Set adds the caption of the next frame.
+func (f *frameTextFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// animationFrames returns the caption of each of n frames: texts over and
+// over, or with no texts caption and no caption in turn, for blinking. n 0
+// means one frame per text, or two to blink.
+func animationFrames(caption string, texts []string, n int) []string {
+	if len(texts) == 0 {
+		texts = []string{caption, ""}
+	}
+	if n == 0 {
+		n = len(texts)
+	}
+	frames := make([]string, n)
+	for i := range frames {
+		frames[i] = texts[i%len(texts)]
+	}
+	return frames
+}
+
+// renderAnimation renders a frame for each of opts.FrameTexts, each with
+// that caption, and writes them to destWriter as an animated PNG. With a
+// caption bar, every frame gets the bar of the frame whose caption needs
+// the tallest one, so that they are all the same size.
+func (res *resources) renderAnimation(ctx context.Context, opts Options, destWriter io.Writer) error {
+	frameOpts := make([]Options, len(opts.FrameTexts))
+	lays := make([]*layout, len(opts.FrameTexts))
+	barHeight := 0
+	for i, text := range opts.FrameTexts {
+		frame := opts
+		frame.Text, frame.FrameTexts = text, nil
+		lay, err := res.layout(frame)
+		if err != nil {
+			return fmt.Errorf("frame %d: %w", i+1, err)
+		}
+		if lay.Bar != nil {
+			barHeight = max(barHeight, lay.Bar.H)
+		}
+		frameOpts[i], lays[i] = frame, lay
+	}
+
+	frames := make([]image.Image, len(opts.FrameTexts))
+	for i, frame := range frameOpts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		lay := lays[i]
+		if lay.Bar != nil && lay.Bar.H < barHeight {
+			frame.minBarHeight = barHeight
+			var err error
+			if lay, err = res.layout(frame); err != nil {
+				return fmt.Errorf("frame %d: %w", i+1, err)
+			}
+		}
+		if frame.Text == "" {
+			lay.Captions = nil // A blink frame
+		}
+		img, release := res.canvas(lay, frame)
+		defer release()
+		if frame.AutoColor {
+			chooseAutoColors(lay, img, frame)
+		}
+		if err := res.drawForeground(ctx, img, lay, frame); err != nil {
+			return fmt.Errorf("frame %d: %w", i+1, err)
+		}
+		frames[i] = img
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	out, finishOutput, err := encodeOutput(destWriter, opts.Encode, formatPNG)
+	if err != nil {
+		return err
+	}
+	if !opts.NoMetadata {
+		out = newMetadataWriter(out, pngMetadata(opts))
+	}
+	delay := opts.FrameDelay
+	if delay == 0 {
+		delay = defaultFrameDelay
+	}
+	if err := writeAPNG(out, frames, delay, opts.Loops); err != nil {
+		return fmt.Errorf("encoding or writing animated PNG: %w", err)
+	}
+	return finishOutput()
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"image/png"
+	"slices"
+	"testing"
+)
+
+func TestAnimationFrames(t *testing.T) {
+	cases := []struct {
+		texts []string
+		n     int
+		want  []string
+	}{
+		{nil, 0, []string{"HI", ""}},
+		{nil, 5, []string{"HI", "", "HI", "", "HI"}},
+		{[]string{"A", "B", "C"}, 0, []string{"A", "B", "C"}},
+		{[]string{"A", "B"}, 3, []string{"A", "B", "A"}},
+		{[]string{"A"}, 0, []string{"A"}},
+	}
+	for _, tc := range cases {
+		if got := animationFrames("HI", tc.texts, tc.n); !slices.Equal(got, tc.want) {
+			t.Errorf("animationFrames(%q, %d) = %q, want %q", tc.texts, tc.n, got, tc.want)
+		}
+	}
+}
+
+// TestRenderAnimation checks that each frame of an animation is the still
+// meme with that frame's caption.
+func TestRenderAnimation(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	render := func(opts Options) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := run(opts, &buf, templateData, fontBytes); err != nil {
+			t.Fatalf("run(%+v): %v", opts, err)
+		}
+		return buf.Bytes()
+	}
+
+	anim := render(Options{Text: "BLINK", FrameTexts: []string{"BLINK", "", "ONCE MORE"}, Loops: 1, Watermark: "@memegen"})
+	frames, n, loops, _ := apngFrames(t, anim)
+	if len(frames) != 3 || n != 3 || loops != 1 {
+		t.Fatalf("got %d frames (acTL %d) and %d loops, want 3 frames and 1 loop", len(frames), n, loops)
+	}
+	for i, text := range []string{"BLINK", "", "ONCE MORE"} {
+		want, err := png.Decode(bytes.NewReader(render(Options{Text: text, Watermark: "@memegen"})))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := diffImages(want, frames[i]); err != nil {
+			t.Errorf("frame %d (%q): %v", i+1, text, err)
+		}
+	}
+
+	// One frame is just a still meme
+	if still := render(Options{Text: "HI", FrameTexts: []string{"HI"}}); !bytes.Equal(still, render(Options{Text: "HI"})) {
+		t.Error("a one-frame animation differs from the still meme")
+	}
+}
+
+// TestRenderAnimationCaptionBar checks that with a caption bar, frames
+// whose captions wrap to different numbers of lines, or are blank, are all
+// given the tallest bar.
+func TestRenderAnimationCaptionBar(t *testing.T) {
+	long := "THIS CAPTION IS FAR TOO LONG TO FIT ON A SINGLE LINE OF THE BAR"
+	opts := Options{Text: "HI", CaptionBar: true, FrameTexts: []string{"HI", long, ""}}
+	var buf bytes.Buffer
+	if err := run(opts, &buf, loadTestTemplate(t), fontBytes); err != nil {
+		t.Fatal(err)
+	}
+	frames, _, _, _ := apngFrames(t, buf.Bytes())
+	if len(frames) != 3 {
+		t.Fatalf("got %d frames, want 3", len(frames))
+	}
+	var still bytes.Buffer
+	if err := run(Options{Text: long, CaptionBar: true}, &still, loadTestTemplate(t), fontBytes); err != nil {
+		t.Fatal(err)
+	}
+	want, err := png.Decode(&still)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, f := range frames {
+		if f.Bounds() != want.Bounds() {
+			t.Errorf("frame %d is %v, want %v as for the longest caption", i+1, f.Bounds(), want.Bounds())
+		}
+	}
+	if err := diffImages(want, frames[1]); err != nil {
+		t.Errorf("frame with the longest caption: %v", err)
+	}
+}
@@ -0,0 +1,135 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"image"
+	"image/png"
+	"io"
+	"time"
+)
+
+// image/png writes only still images. An animated PNG (APNG) is an
+// ordinary PNG whose IDAT holds the first frame, plus an acTL chunk giving
+// the frame count and a frame control chunk (fcTL) per frame; the later
+// frames' image data goes in fdAT chunks, which are IDAT chunks prefixed
+// with a sequence number. Viewers without APNG support show the first
+// frame. writeAPNG encodes each frame with image/png and reassembles the
+// chunks.
+
+// maxFrameDelay is the longest frame delay an fcTL chunk can hold in
+// milliseconds.
+const maxFrameDelay = 65535 * time.Millisecond
+
+// pngChunkData is one chunk of an encoded PNG, without its length and CRC.
+type pngChunkData struct {
+	typ  string
+	data []byte
+}
+
+// writeAPNG writes frames to w as an animated PNG, each shown for delay
+// and the whole played loops times, 0 for forever. All frames must have the
+// same bounds. A single frame is written as an ordinary PNG.
+func writeAPNG(w io.Writer, frames []image.Image, delay time.Duration, loops int) error {
+	switch {
+	case len(frames) == 0:
+		return errors.New("animation has no frames")
+	case len(frames) == 1:
+		return png.Encode(w, frames[0])
+	case delay < 0 || delay > maxFrameDelay:
+		return fmt.Errorf("frame delay %v out of range (0 to %v)", delay, maxFrameDelay)
+	case loops < 0:
+		return fmt.Errorf("loop count %d must not be negative", loops)
+	}
+	bounds := frames[0].Bounds()
+	var header []byte
+	data := make([][]pngChunkData, len(frames))
+	for i, f := range frames {
+		if f.Bounds().Size() != bounds.Size() {
+			return fmt.Errorf("frame %d is %dx%d, but frame 1 is %dx%d",
+				i+1, f.Bounds().Dx(), f.Bounds().Dy(), bounds.Dx(), bounds.Dy())
+		}
+		var buf bytes.Buffer
+		if err := png.Encode(&buf, f); err != nil {
+			return fmt.Errorf("encoding frame %d: %w", i+1, err)
+		}
+		chunks, err := readPNGChunks(buf.Bytes())
+		if err != nil {
+			return fmt.Errorf("encoding frame %d: %w", i+1, err)
+		}
+		// image/png picks the color type from the pixels, and the frames
+		// all share the first one's
+		if i == 0 {
+			header = chunks[0].data
+		} else if !bytes.Equal(chunks[0].data, header) {
+			return fmt.Errorf("frame %d: encodes with a different color type than frame 1 (mixed opaque and transparent frames)", i+1)
+		}
+		for _, c := range chunks {
+			if c.typ == "IDAT" {
+				data[i] = append(data[i], c)
+			}
+		}
+	}
+
+	var out bytes.Buffer
+	out.WriteString(pngSignature)
+	out.Write(pngChunk("IHDR", header))
+	actl := binary.BigEndian.AppendUint32(nil, uint32(len(frames)))
+	out.Write(pngChunk("acTL", binary.BigEndian.AppendUint32(actl, uint32(loops))))
+	var seq uint32
+	for i, chunks := range data {
+		out.Write(pngChunk("fcTL", frameControl(seq, bounds.Size(), delay)))
+		seq++
+		for _, c := range chunks {
+			if i == 0 {
+				out.Write(pngChunk("IDAT", c.data))
+				continue
+			}
+			out.Write(pngChunk("fdAT", append(binary.BigEndian.AppendUint32(nil, seq), c.data...)))
+			seq++
+		}
+	}
+	out.Write(pngChunk("IEND", nil))
+	_, err := out.WriteTo(w)
+	return err
+}
+
+// frameControl returns the data of an fcTL chunk for a full-size frame
+// shown for delay, which replaces the previous frame entirely.
+func frameControl(seq uint32, size image.Point, delay time.Duration) []byte {
+	b := binary.BigEndian.AppendUint32(nil, seq)
+	b = binary.BigEndian.AppendUint32(b, uint32(size.X))
+	b = binary.BigEndian.AppendUint32(b, uint32(size.Y))
+	b = binary.BigEndian.AppendUint32(b, 0) // x offset
+	b = binary.BigEndian.AppendUint32(b, 0) // y offset
+	b = binary.BigEndian.AppendUint16(b, uint16(delay.Milliseconds()))
+	b = binary.BigEndian.AppendUint16(b, 1000) // Delay in milliseconds
+	// APNG_DISPOSE_OP_NONE, APNG_BLEND_OP_SOURCE
+	return append(b, 0, 0)
+}
+
+// readPNGChunks splits an encoded PNG into its chunks, IHDR first.
+func readPNGChunks(b []byte) ([]pngChunkData, error) {
+	rest, ok := bytes.CutPrefix(b, []byte(pngSignature))
+	if !ok {
+		return nil, errors.New("not a PNG")
+	}
+	var chunks []pngChunkData
+	for len(rest) > 0 {
+		if len(rest) < 12 {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		n := binary.BigEndian.Uint32(rest)
+		if uint64(n) > uint64(len(rest)-12) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunks = append(chunks, pngChunkData{typ: string(rest[4:8]), data: rest[8 : 8+n]})
+		rest = rest[12+n:]
+	}
+	if len(chunks) == 0 || chunks[0].typ != "IHDR" {
+		return nil, errors.New("PNG does not start with IHDR")
+	}
+	return chunks, nil
+}
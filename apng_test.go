@@ -0,0 +1,131 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/color"
+	"image/png"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+// apngFrames splits an animated PNG into its frames, each as a still PNG
+// image/png can decode, and returns them with the acTL frame and loop
+// counts and the chunk types in order.
+func apngFrames(t *testing.T, b []byte) (frames []image.Image, numFrames, loops uint32, types []string) {
+	t.Helper()
+	chunks, err := readPNGChunks(b)
+	if err != nil {
+		t.Fatalf("reading chunks: %v", err)
+	}
+	var ihdr []byte
+	var data [][]pngChunkData
+	for _, c := range chunks {
+		types = append(types, c.typ)
+		switch c.typ {
+		case "IHDR":
+			ihdr = c.data
+		case "acTL":
+			numFrames, loops = binary.BigEndian.Uint32(c.data), binary.BigEndian.Uint32(c.data[4:])
+		case "fcTL":
+			data = append(data, nil)
+		case "IDAT":
+			data[len(data)-1] = append(data[len(data)-1], c)
+		case "fdAT":
+			data[len(data)-1] = append(data[len(data)-1], pngChunkData{"IDAT", c.data[4:]})
+		}
+	}
+	for i, frame := range data {
+		var still bytes.Buffer
+		still.WriteString(pngSignature)
+		still.Write(pngChunk("IHDR", ihdr))
+		for _, c := range frame {
+			still.Write(pngChunk(c.typ, c.data))
+		}
+		still.Write(pngChunk("IEND", nil))
+		img, err := png.Decode(&still)
+		if err != nil {
+			t.Fatalf("decoding frame %d: %v", i+1, err)
+		}
+		frames = append(frames, img)
+	}
+	return frames, numFrames, loops, types
+}
+
+func TestWriteAPNG(t *testing.T) {
+	var frames []image.Image
+	for _, c := range []color.RGBA{{255, 0, 0, 255}, {0, 255, 0, 255}, {0, 0, 255, 255}} {
+		img := image.NewRGBA(image.Rect(0, 0, 8, 4))
+		for i := range img.Pix {
+			img.Pix[i] = []uint8{c.R, c.G, c.B, c.A}[i%4]
+		}
+		frames = append(frames, img)
+	}
+	var buf bytes.Buffer
+	if err := writeAPNG(&buf, frames, 250*time.Millisecond, 2); err != nil {
+		t.Fatal(err)
+	}
+
+	// Viewers without APNG support see the first frame
+	first, err := png.Decode(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("decoding as a still PNG: %v", err)
+	}
+	if err := diffImages(frames[0], first); err != nil {
+		t.Errorf("still image: %v", err)
+	}
+
+	got, n, loops, types := apngFrames(t, buf.Bytes())
+	want := []string{"IHDR", "acTL", "fcTL", "IDAT", "fcTL", "fdAT", "fcTL", "fdAT", "IEND"}
+	if !slices.Equal(types, want) {
+		t.Errorf("chunks %v, want %v", types, want)
+	}
+	if n != 3 || loops != 2 {
+		t.Errorf("acTL says %d frames, %d loops; want 3 and 2", n, loops)
+	}
+	for i := range frames {
+		if err := diffImages(frames[i], got[i]); err != nil {
+			t.Errorf("frame %d: %v", i+1, err)
+		}
+	}
+	chunks, _ := readPNGChunks(buf.Bytes())
+	for _, c := range chunks {
+		if c.typ == "fcTL" && (binary.BigEndian.Uint16(c.data[20:]) != 250 || binary.BigEndian.Uint16(c.data[22:]) != 1000) {
+			t.Errorf("fcTL delay %d/%d, want 250/1000", binary.BigEndian.Uint16(c.data[20:]), binary.BigEndian.Uint16(c.data[22:]))
+		}
+	}
+}
+
+func TestWriteAPNGSingleFrame(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 4, 4))
+	var got, want bytes.Buffer
+	if err := writeAPNG(&got, []image.Image{img}, time.Second, 0); err != nil {
+		t.Fatal(err)
+	}
+	png.Encode(&want, img)
+	if !bytes.Equal(got.Bytes(), want.Bytes()) {
+		t.Error("a single frame is not written as a plain PNG")
+	}
+}
+
+func TestWriteAPNGErrors(t *testing.T) {
+	small, large := image.NewRGBA(image.Rect(0, 0, 4, 4)), image.NewRGBA(image.Rect(0, 0, 8, 4))
+	cases := []struct {
+		frames []image.Image
+		delay  time.Duration
+		want   string
+	}{
+		{nil, time.Second, "no frames"},
+		{[]image.Image{small, large}, time.Second, "frame 2 is 8x4, but frame 1 is 4x4"},
+		{[]image.Image{small, small}, 70 * time.Second, "out of range"},
+	}
+	for _, tc := range cases {
+		err := writeAPNG(&bytes.Buffer{}, tc.frames, tc.delay, 0)
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("got %v, want an error containing %q", err, tc.want)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+	"golang.org/x/image/math/fixed"
+)
+
+// -arc bends each caption line along a circular arc, for the "laughing
+// emoji circle" look: positive angles make an arch, rising in the middle,
+// negative ones a bowl. The arc spans the given angle and its chord is the
+// line's measured ink width, so the line keeps its width and center; the
+// apex and the chord ends sit equally far above and below the straight
+// baseline. Each glyph is drawn upright with its outline, then rotated to
+// follow the tangent at its place on the arc. An arc of 0 draws straight
+// lines, exactly as without -arc.
+
+// maxArc is the largest arc in degrees, a semicircle.
+const maxArc = 180.0
+
+// clampArc limits deg to ±maxArc, reporting whether it had to.
+func clampArc(deg float64) (float64, bool) {
+	c := min(max(deg, -maxArc), maxArc)
+	return c, c != deg
+}
+
+// arcGeometry is the circle a line of ink width w is bent along.
+type arcGeometry struct {
+	theta  float64 // Angle spanned in radians, negative for a bowl
+	radius float64 // Signed like theta
+	center vec     // Of the circle
+	width  float64 // The chord, the line's ink width
+}
+
+// newArcGeometry returns the arc of deg degrees for a line whose ink is
+// centered on x with width w and whose straight baseline is at y.
+func newArcGeometry(deg, x, y, w float64) arcGeometry {
+	theta := deg * math.Pi / 180
+	r := w / (2 * math.Sin(theta/2))
+	sagitta := r * (1 - math.Cos(theta/2)) // How far the apex rises above the chord
+	apex := y - sagitta/2
+	return arcGeometry{theta: theta, radius: r, center: vec{x, apex + r}, width: w}
+}
+
+// at returns the point u pixels along the chord from its middle, mapped
+// onto the arc, and the tangent's angle there in radians clockwise.
+func (g arcGeometry) at(u float64) (vec, float64) {
+	phi := g.theta * u / g.width
+	return vec{g.center.x + g.radius*math.Sin(phi), g.center.y - g.radius*math.Cos(phi)}, phi
+}
+
+// ends returns the ends of the chord, left first.
+func (g arcGeometry) ends() (vec, vec) {
+	a, _ := g.at(-g.width / 2)
+	b, _ := g.at(g.width / 2)
+	return a, b
+}
+
+// drawArc draws line l along an arc of deg degrees onto dst, one glyph at
+// a time with drawLine. With outlined set, all glyphs are drawn complete
+// first and then all fills again on top, so that no glyph's outline covers
+// a neighbour's fill, as on a straight line.
+func drawArc(dst *image.RGBA, style textStyle, l lineLayout, deg float64, outlined bool, drawLine func(*textPainter, lineLayout) error) error {
+	if l.Box == nil {
+		return nil // Nothing to draw
+	}
+	g := lineArc(l, deg)
+	offsets, _ := layoutLine(style.font, style.size, dpi, style.hinting, style.tracking, l.Text)
+	passes := []bool{false}
+	if outlined {
+		passes = append(passes, true)
+	}
+	for _, fillOnly := range passes {
+		for i, r := range []rune(l.Text) {
+			glyph := string(r)
+			ext, err := style.measure(glyph)
+			if err != nil {
+				return fmt.Errorf("measuring text width: %w", err)
+			}
+			pen := fixed.Point26_6{X: l.pt.X + offsets[i], Y: l.pt.Y}
+			src := ext.inkRect(pen)
+			if src.Empty() {
+				continue // A space
+			}
+			src = src.Inset(-(style.thickness + 2)) // Room for the outline
+			layer := image.NewRGBA(src)
+			p := newTextPainter(layer, style)
+			p.fillOnly = fillOnly
+			if err := drawLine(p, lineLayout{Text: glyph, pt: pen}); err != nil {
+				return err
+			}
+
+			// Rotate the glyph about the middle of its advance on the
+			// baseline, and move that point onto the arc
+			anchor := vec{fix2f(pen.X + ext.Advance/2), fix2f(pen.Y)}
+			to, phi := g.at(anchor.x - g.center.x)
+			sin, cos := math.Sin(phi), math.Cos(phi)
+			s2d := f64.Aff3{
+				cos, -sin, to.x - anchor.x*cos + anchor.y*sin,
+				sin, cos, to.y - anchor.x*sin - anchor.y*cos,
+			}
+			xdraw.BiLinear.Transform(dst, s2d, layer, src, xdraw.Over, nil)
+		}
+	}
+	return nil
+}
+
+// arcSpill returns how far the ink of a line spills out of its straight
+// bounds when bent along an arc of deg degrees: side beyond either end,
+// rise above the top and drop below the bottom. The line's ink is w wide
+// and reaches above pixels above its baseline and below pixels below it.
+// Each point of the ink moves out from or in towards the circle's center
+// with its glyph, so the extremes are at the ends and the middle of the
+// arc, on the top or bottom edge of the ink.
+func arcSpill(deg, w, above, below float64) (side, rise, drop float64) {
+	if deg == 0 || w <= 0 {
+		return 0, 0, 0
+	}
+	g := newArcGeometry(deg, 0, 0, w)
+	minX, maxX, minY, maxY := 0.0, 0.0, 0.0, 0.0
+	for _, phi := range []float64{-g.theta / 2, 0, g.theta / 2} {
+		for _, d := range []float64{above, -below} {
+			x := g.center.x + (g.radius+d)*math.Sin(phi)
+			y := g.center.y - (g.radius+d)*math.Cos(phi)
+			minX, maxX = min(minX, x), max(maxX, x)
+			minY, maxY = min(minY, y), max(maxY, y)
+		}
+	}
+	return max(maxX-w/2, -w/2-minX, 0), max(-minY-above, 0), max(maxY-below, 0)
+}
+
+// arcRoom is the room beyond their straight layout that the lines of a
+// caption need on an arc, enough for the line needing the most.
+type arcRoom struct {
+	rise, drop int // Above each line's ascent and below its descent
+}
+
+// spacing returns the extra distance between the baselines of lines.
+func (r arcRoom) spacing() int {
+	return r.rise + r.drop
+}
+
+// linesArcRoom returns the room lines in style need on style's arc.
+func linesArcRoom(style textStyle, lines []string) (arcRoom, error) {
+	var room arcRoom
+	if style.arc == 0 {
+		return room, nil
+	}
+	ascent, descent := style.verticalMetrics()
+	for _, line := range lines {
+		ext, err := style.measure(line)
+		if err != nil {
+			return arcRoom{}, fmt.Errorf("measuring text width: %w", err)
+		}
+		_, rise, drop := arcSpill(style.arc, float64(ext.inkWidth()), float64(ascent), float64(descent))
+		room.rise, room.drop = max(room.rise, int(math.Ceil(rise))), max(room.drop, int(math.Ceil(drop)))
+	}
+	return room, nil
+}
+
+// arcSide returns how far lines in style up to w pixels wide spill beyond
+// their ends on style's arc.
+func arcSide(style textStyle, w int) int {
+	if style.arc == 0 {
+		return 0
+	}
+	ascent, descent := style.verticalMetrics()
+	side, _, _ := arcSpill(style.arc, float64(w), float64(ascent), float64(descent))
+	return int(math.Ceil(side))
+}
+
+// bentRect returns the rectangle the ink of line l covers once bent along
+// an arc of deg degrees.
+func bentRect(l lineLayout, deg float64) image.Rectangle {
+	side, rise, drop := arcSpill(deg, float64(l.ink.Dx()), float64(l.Baseline-l.ink.Min.Y), float64(l.ink.Max.Y-l.Baseline))
+	return image.Rect(
+		l.ink.Min.X-int(math.Ceil(side)), l.ink.Min.Y-int(math.Ceil(rise)),
+		l.ink.Max.X+int(math.Ceil(side)), l.ink.Max.Y+int(math.Ceil(drop)))
+}
+
+// lineArc returns the arc of deg degrees line l is bent along, its chord
+// spanning the line's ink on its baseline.
+func lineArc(l lineLayout, deg float64) arcGeometry {
+	return newArcGeometry(deg, float64(l.ink.Min.X+l.ink.Max.X)/2, float64(l.Baseline), float64(l.ink.Dx()))
+}
+
+// fix2f converts a fixed-point number to float64 pixels.
+func fix2f(x fixed.Int26_6) float64 {
+	return float64(x) / 64
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"math"
+	"strings"
+	"testing"
+)
+
+func TestArcGeometry(t *testing.T) {
+	const x, y, w = 240.0, 100.0, 300.0
+	for _, deg := range []float64{30, -30, 180} {
+		g := newArcGeometry(deg, x, y, w)
+		from, to := g.ends()
+		if math.Abs(from.x-(x-w/2)) > 1e-9 || math.Abs(to.x-(x+w/2)) > 1e-9 {
+			t.Errorf("%v°: chord from x %.2f to %.2f, want %v to %v", deg, from.x, to.x, x-w/2, x+w/2)
+		}
+		if math.Abs(from.y-to.y) > 1e-9 {
+			t.Errorf("%v°: chord ends at y %.2f and %.2f, want level", deg, from.y, to.y)
+		}
+		apex, phi := g.at(0)
+		if apex.x != x || phi != 0 {
+			t.Errorf("%v°: apex at x %.2f turned %v, want %v upright", deg, apex.x, phi, x)
+		}
+		// The apex and the chord straddle the baseline
+		if math.Abs((apex.y+from.y)/2-y) > 1e-9 {
+			t.Errorf("%v°: apex at y %.2f and chord at %.2f, want centered on %v", deg, apex.y, from.y, y)
+		}
+		if (apex.y < from.y) != (deg > 0) {
+			t.Errorf("%v°: apex at y %.2f, chord at %.2f: curving the wrong way", deg, apex.y, from.y)
+		}
+		// The ends follow the tangent, by half the arc
+		if _, phi := g.at(w / 2); math.Abs(phi-deg*math.Pi/360) > 1e-9 {
+			t.Errorf("%v°: right end turned %v, want %v", deg, phi, deg*math.Pi/360)
+		}
+	}
+}
+
+func TestClampArc(t *testing.T) {
+	for _, tc := range []struct {
+		in, want float64
+		clamped  bool
+	}{
+		{30, 30, false}, {-180, -180, false}, {200, 180, true}, {-1000, -180, true}, {math.Inf(1), 180, true},
+	} {
+		if got, clamped := clampArc(tc.in); got != tc.want || clamped != tc.clamped {
+			t.Errorf("clampArc(%v) = %v, %v, want %v, %v", tc.in, got, clamped, tc.want, tc.clamped)
+		}
+	}
+}
+
+// TestArcLayout checks that lines on an arc wrap instead of condensing,
+// and that layout reports the clamped arc.
+func TestArcLayout(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	var buf bytes.Buffer
+	opts := Options{Text: "A CAPTION JUST A BIT TOO WIDE", Arc: 400, Measure: true}
+	if err := run(opts, &buf, templateData, fontBytes); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var lay layout
+	if err := json.Unmarshal(buf.Bytes(), &lay); err != nil {
+		t.Fatalf("decoding layout: %v", err)
+	}
+	cl := lay.Captions[0]
+	if cl.Arc != maxArc {
+		t.Errorf("arc = %v, want %v", cl.Arc, maxArc)
+	}
+	for _, l := range cl.Lines {
+		if l.Condense != 0 {
+			t.Errorf("line %q condensed on an arc", l.Text)
+		}
+	}
+}
+
+func TestArcSVG(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	var buf bytes.Buffer
+	if err := run(Options{Text: "BOWL", Arc: -60, Format: formatSVG}, &buf, templateData, fontBytes); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	svg := buf.String()
+	for _, want := range []string{`<path id="arc-1-1" d="M`, ` 0 0 0 `, `<textPath href="#arc-1-1" startOffset="50%" text-anchor="middle">BOWL</textPath>`} {
+		if !strings.Contains(svg, want) {
+			t.Errorf("SVG lacks %s", want)
+		}
+	}
+}
+
+// TestArcSpill checks the spill against points sampled over the ink of a
+// bent line.
+func TestArcSpill(t *testing.T) {
+	const w, above, below = 300.0, 80.0, 20.0
+	for _, deg := range []float64{10, -10, 90, -90, 180, -180} {
+		g := newArcGeometry(deg, 0, 0, w)
+		minX, maxX, minY, maxY := 0.0, 0.0, 0.0, 0.0
+		for i := 0; i <= 100; i++ {
+			p, phi := g.at(w * (float64(i)/100 - 0.5))
+			for _, d := range []float64{above, -below} {
+				x, y := p.x+d*math.Sin(phi), p.y-d*math.Cos(phi)
+				minX, maxX = min(minX, x), max(maxX, x)
+				minY, maxY = min(minY, y), max(maxY, y)
+			}
+		}
+		side, rise, drop := arcSpill(deg, w, above, below)
+		want := []float64{max(maxX-w/2, -w/2-minX), -minY - above, maxY - below}
+		for i, got := range []float64{side, rise, drop} {
+			if math.Abs(got-max(want[i], 0)) > 1e-6 {
+				t.Errorf("%v°: spill %v, %v, %v, want %v", deg, side, rise, drop, want)
+				break
+			}
+		}
+	}
+}
+
+// TestArcLayoutRoom checks that lines bent up to a semicircle are laid
+// out with the room they take: their boxes stay inside the caption area
+// and clear of each other.
+func TestArcLayoutRoom(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	for _, deg := range []float64{180, -180, 90} {
+		for _, position := range []string{positionTop, positionMiddle, positionBottom} {
+			var buf bytes.Buffer
+			opts := Options{Text: "ARCS ARE REALLY GREAT FOR SURE", Arc: deg, Position: position, Measure: true}
+			if err := run(opts, &buf, templateData, fontBytes); err != nil {
+				t.Fatalf("run: %v", err)
+			}
+			var lay layout
+			if err := json.Unmarshal(buf.Bytes(), &lay); err != nil {
+				t.Fatalf("decoding layout: %v", err)
+			}
+			cl := lay.Captions[0]
+			if cl.FitResult == fitOverflows || cl.TextHeight > cl.AvailableHeight {
+				t.Errorf("%v° %s: %s, %dpx of %dpx", deg, position, cl.FitResult, cl.TextHeight, cl.AvailableHeight)
+			}
+			area := cl.Area.rect()
+			for i, l := range cl.Lines {
+				if !l.Box.rect().In(area) {
+					t.Errorf("%v° %s: line %d at %+v is outside %v", deg, position, i+1, *l.Box, area)
+				}
+				if i > 0 && l.Box.rect().Overlaps(cl.Lines[i-1].Box.rect()) {
+					t.Errorf("%v° %s: lines %d and %d overlap", deg, position, i, i+1)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"math/rand/v2"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// errOutputExists is the error for an output file that exists and may not
+// be replaced.
+var errOutputExists = errors.New("already exists")
+
+// outputFile is an output being written. A regular file is written under a
+// temporary name in the same directory and only moved into place by
+// commit, so a failed render never leaves a partial file behind or
+// destroys the file it was meant to replace.
+type outputFile struct {
+	*os.File
+	path  string // Final path; the file is already there when empty
+	force bool   // Whether commit may replace a file at path
+}
+
+// createOutput opens path for writing. An existing regular file is only
+// replaced if force is set; without it, a file or symlink that appears at
+// path while the output is written is not replaced either. Devices and
+// pipes, such as /dev/stdout, are written directly.
+func createOutput(path string, force bool) (*outputFile, error) {
+	perm := fs.FileMode(0o666) // Before the umask, as os.Create
+	info, err := os.Stat(path)
+	switch {
+	case err == nil && !info.Mode().IsRegular():
+		f, err := os.OpenFile(path, os.O_WRONLY, 0)
+		if err != nil {
+			return nil, fmt.Errorf("creating output file: %w", err)
+		}
+		return &outputFile{File: f}, nil
+	case err == nil && !force:
+		return nil, fmt.Errorf("output file %s %w (use -force to overwrite)", path, errOutputExists)
+	case err == nil:
+		perm = info.Mode().Perm() // os.Create keeps the mode of a file it truncates
+	case !errors.Is(err, fs.ErrNotExist):
+		return nil, fmt.Errorf("creating output file: %w", err)
+	}
+
+	// Creating the file with perm, rather than os.CreateTemp's 0600, lets
+	// the umask apply as it would to os.Create
+	dir, base := filepath.Split(path)
+	for {
+		tmp := filepath.Join(dir, "."+base+"."+strconv.FormatUint(rand.Uint64(), 36)+".tmp")
+		f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+		if errors.Is(err, fs.ErrExist) {
+			continue
+		}
+		if err != nil {
+			return nil, fmt.Errorf("creating output file: %w", err)
+		}
+		if perm != 0o666 {
+			// An existing file's mode, exactly; the umask doesn't apply
+			if err := f.Chmod(perm); err != nil {
+				f.Close()
+				os.Remove(tmp)
+				return nil, fmt.Errorf("creating output file: %w", err)
+			}
+		}
+		return &outputFile{File: f, path: path, force: force}, nil
+	}
+}
+
+// commit closes the file and moves it into place.
+func (f *outputFile) commit() error {
+	if err := f.Close(); err != nil {
+		f.discard()
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	if f.path == "" {
+		return nil
+	}
+	if !f.force {
+		return f.commitNew()
+	}
+	if err := os.Rename(f.Name(), f.path); err != nil {
+		f.discard()
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	return nil
+}
+
+// commitNew moves the closed file into place unless something is already
+// there. Unlike a rename, a hard link fails rather than replace it.
+// Filesystems without hard links get a copy, created exclusively.
+func (f *outputFile) commitNew() error {
+	defer os.Remove(f.Name())
+	err := os.Link(f.Name(), f.path)
+	if err == nil {
+		return nil
+	}
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("output file %s %w (use -force to overwrite)", f.path, errOutputExists)
+	}
+	src, err := os.Open(f.Name())
+	if err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	dst, err := os.OpenFile(f.path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if errors.Is(err, fs.ErrExist) {
+		return fmt.Errorf("output file %s %w (use -force to overwrite)", f.path, errOutputExists)
+	}
+	if err != nil {
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	_, err = io.Copy(dst, src)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		os.Remove(f.path)
+		return fmt.Errorf("writing output file: %w", err)
+	}
+	return nil
+}
+
+// discard closes the file and removes it, leaving whatever was at the
+// final path untouched.
+func (f *outputFile) discard() {
+	f.Close()
+	if f.path != "" {
+		os.Remove(f.Name())
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateOutputRefusesExisting(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "out.png")
+	if err := os.WriteFile(path, []byte("old"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeFile(path, []byte("new"), false); err == nil || !strings.Contains(err.Error(), "-force") {
+		t.Errorf("overwriting without -force: got %v, want an error suggesting -force", err)
+	}
+	if _, err := writeFile(path, []byte("new"), true); err != nil {
+		t.Fatal(err)
+	}
+	got, _ := os.ReadFile(path)
+	info, _ := os.Stat(path)
+	if string(got) != "new" || info.Mode().Perm() != 0o600 {
+		t.Errorf("after -force: %q with mode %v, want \"new\" with the old mode 0600", got, info.Mode().Perm())
+	}
+	checkNoTempFiles(t, filepath.Dir(path))
+}
+
+// TestCreateOutputRefusesLateArrivals checks that without -force, a file
+// or symlink appearing at the path while the output is written is left
+// alone.
+func TestCreateOutputRefusesLateArrivals(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.png")
+	for _, arrive := range []func() error{
+		func() error { return os.WriteFile(path, []byte("theirs"), 0o644) },
+		func() error { return os.Symlink(filepath.Join(dir, "nowhere"), path) },
+	} {
+		f, err := createOutput(path, false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("ours")
+		if err := arrive(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.commit(); !errors.Is(err, errOutputExists) {
+			t.Errorf("commit = %v, want an error saying the file exists", err)
+		}
+		if got, _ := os.ReadFile(path); string(got) == "ours" {
+			t.Error("the file that appeared was replaced")
+		}
+		checkNoTempFiles(t, dir)
+		if err := os.Remove(path); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestCreateOutputMatchesCreateMode(t *testing.T) {
+	dir := t.TempDir()
+	ref, err := os.Create(filepath.Join(dir, "ref"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ref.Close()
+	if _, err := writeFile(filepath.Join(dir, "out.png"), []byte("x"), false); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := os.Stat(filepath.Join(dir, "ref"))
+	got, _ := os.Stat(filepath.Join(dir, "out.png"))
+	if got.Mode() != want.Mode() {
+		t.Errorf("mode %v, want %v as os.Create gives", got.Mode(), want.Mode())
+	}
+}
+
+func TestCreateOutputDiscard(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "out.png")
+	if err := os.WriteFile(path, []byte("old"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := createOutput(path, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("half an ima")
+	f.discard() // As after a failed render
+
+	if got, _ := os.ReadFile(path); string(got) != "old" {
+		t.Errorf("failed write left %q, want the old file untouched", got)
+	}
+	checkNoTempFiles(t, dir)
+}
+
+func TestCreateOutputDevice(t *testing.T) {
+	if _, err := os.Stat(os.DevNull); err != nil {
+		t.Skip(err)
+	}
+	// Not refused for existing, nor replaced by a regular file
+	if _, err := writeFile(os.DevNull, []byte("x"), false); err != nil {
+		t.Fatal(err)
+	}
+	if info, _ := os.Stat(os.DevNull); info.Mode().IsRegular() {
+		t.Fatalf("%s became a regular file", os.DevNull)
+	}
+}
+
+// checkNoTempFiles fails if anything but out.png is left in dir.
+func checkNoTempFiles(t *testing.T, dir string) {
+	t.Helper()
+	entries, _ := os.ReadDir(dir)
+	for _, e := range entries {
+		if e.Name() != "out.png" {
+			t.Errorf("left behind %s", e.Name())
+		}
+	}
+}
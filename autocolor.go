@@ -0,0 +1,100 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+)
+
+// -auto-color keeps captions readable on any template. Once the background
+// is ready, the region each caption is drawn over, its text block and the
+// box padding around it, is sampled for its mean luminance: bright regions
+// get the usual black text with a white outline, dark ones white text with
+// a black outline. Colors given with -fill, -outline or in a -spec box are
+// kept, and plain captions on a caption bar or poster are left alone.
+
+// autoColorThreshold is the mean luminance, from 0 to 1, from which a
+// region counts as bright.
+const autoColorThreshold = 0.5
+
+// chooseAutoColors sets the fill and outline of each caption in lay that
+// has none from the luminance of bg behind it, reporting each choice to
+// opts.Log.
+func chooseAutoColors(lay *layout, bg *image.RGBA, opts Options) {
+	for i := range lay.Captions {
+		cl := &lay.Captions[i]
+		if cl.plain || cl.Block == nil {
+			continue
+		}
+		r := image.Rect(cl.Block.X-cl.margin.X, cl.Block.Y-cl.margin.Y,
+			cl.Block.X+cl.Block.W+cl.margin.X, cl.Block.Y+cl.Block.H+cl.margin.Y).Intersect(bg.Bounds())
+		lum := meanLuminance(bg, r)
+		if cl.TextBox != nil {
+			// The text sits on the box, which lets the background through
+			// by its alpha
+			boxColor := opts.TextBoxColor
+			if boxColor == (color.NRGBA{}) {
+				boxColor = defaultTextBoxColor
+			}
+			a := float64(boxColor.A) / 255
+			lum = luminance(boxColor)*a + lum*(1-a)
+		}
+
+		fill, outline := color.NRGBA{A: 255}, color.NRGBA{255, 255, 255, 255} // Black on white
+		choice := "black text, white outline"
+		if lum < autoColorThreshold {
+			fill, outline = outline, fill
+			choice = "white text, black outline"
+		}
+		var kept []string
+		if cl.fill == (color.NRGBA{}) && len(opts.FillGradient) == 0 {
+			cl.fill = fill
+		} else {
+			kept = append(kept, "fill")
+		}
+		if cl.outline == (color.NRGBA{}) {
+			cl.outline = outline
+		} else {
+			kept = append(kept, "outline")
+		}
+
+		if opts.Log != nil {
+			note := ""
+			switch len(kept) {
+			case 1:
+				note = fmt.Sprintf(" (keeping the given %s)", kept[0])
+			case 2:
+				note = " (keeping the given fill and outline)"
+			}
+			fmt.Fprintf(opts.Log, "auto-color: caption %d: luminance %.2f over %dx%d at %d,%d: %s%s\n",
+				i+1, lum, r.Dx(), r.Dy(), r.Min.X, r.Min.Y, choice, note)
+		}
+	}
+}
+
+// meanLuminance returns the mean luminance of img over r, from 0 to 1, or
+// 1 for an empty r.
+func meanLuminance(img *image.RGBA, r image.Rectangle) float64 {
+	if r.Empty() {
+		return 1
+	}
+	var sum float64
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(r.Min.X, y):]
+		for i := 0; i < 4*r.Dx(); i += 4 {
+			sum += rgbLuminance(row[i], row[i+1], row[i+2])
+		}
+	}
+	return sum / float64(r.Dx()*r.Dy())
+}
+
+// luminance returns the luminance of c from 0 to 1, ignoring its alpha.
+func luminance(c color.NRGBA) float64 {
+	return rgbLuminance(c.R, c.G, c.B)
+}
+
+// rgbLuminance returns the luminance of a color from 0 to 1, weighting the
+// channels by the Rec. 709 coefficients.
+func rgbLuminance(r, g, b uint8) float64 {
+	return (0.2126*float64(r) + 0.7152*float64(g) + 0.0722*float64(b)) / 255
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"strings"
+	"testing"
+)
+
+func TestChooseAutoColors(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	white, black := color.NRGBA{255, 255, 255, 255}, color.NRGBA{0, 0, 0, 255}
+	red := color.NRGBA{R: 200, A: 255}
+	cases := []struct {
+		name                string
+		background          color.NRGBA
+		opts                Options
+		wantFill, wantOutln color.NRGBA
+		wantLog             string
+	}{
+		{"bright", color.NRGBA{230, 230, 200, 255}, Options{}, black, white, "black text, white outline\n"},
+		{"dark", color.NRGBA{20, 30, 60, 255}, Options{}, white, black, "white text, black outline\n"},
+		{"dark with a light box", color.NRGBA{20, 30, 60, 255}, Options{TextBox: true, TextBoxColor: color.NRGBA{255, 255, 255, 220}},
+			black, white, "black text, white outline\n"},
+		{"fill given", color.NRGBA{20, 30, 60, 255}, Options{Fill: red}, red, black, "(keeping the given fill)\n"},
+		{"both given", color.NRGBA{20, 30, 60, 255}, Options{Fill: red, Outline: red}, red, red, "(keeping the given fill and outline)\n"},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bounds := image.Rect(0, 0, 480, 270)
+			tc.opts.Text, tc.opts.AutoColor = "HELLO", true
+			lay, err := computeLayout(bounds, ttFont, tc.opts)
+			if err != nil {
+				t.Fatalf("computeLayout: %v", err)
+			}
+			bg := image.NewRGBA(bounds)
+			draw.Draw(bg, bounds, image.NewUniform(tc.background), image.Point{}, draw.Src)
+			var log strings.Builder
+			tc.opts.Log = &log
+			chooseAutoColors(lay, bg, tc.opts)
+
+			cl := lay.Captions[0]
+			if cl.fill != tc.wantFill || cl.outline != tc.wantOutln {
+				t.Errorf("fill %v outline %v, want %v and %v", cl.fill, cl.outline, tc.wantFill, tc.wantOutln)
+			}
+			if !strings.HasPrefix(log.String(), "auto-color: caption 1: ") || !strings.HasSuffix(log.String(), tc.wantLog) {
+				t.Errorf("logged %q, want the choice ending in %q", log.String(), tc.wantLog)
+			}
+		})
+	}
+}
+
+func TestChooseAutoColorsSamplesTheCaption(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	// Mostly dark, but bright at the bottom where the caption goes
+	bounds := image.Rect(0, 0, 480, 270)
+	bg := image.NewRGBA(bounds)
+	draw.Draw(bg, bounds, image.White, image.Point{}, draw.Src)
+	draw.Draw(bg, image.Rect(0, 0, 480, 150), image.Black, image.Point{}, draw.Src)
+
+	opts := Options{Text: "HI", Position: positionBottom, AutoColor: true}
+	lay, err := computeLayout(bounds, ttFont, opts)
+	if err != nil {
+		t.Fatalf("computeLayout: %v", err)
+	}
+	chooseAutoColors(lay, bg, opts)
+	if fill := lay.Captions[0].fill; fill != (color.NRGBA{A: 255}) {
+		t.Errorf("bottom caption over white got fill %v, want black", fill)
+	}
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// batchJob renders and delivers the i'th image of a batch, returning its
+// destination and the number of bytes delivered.
+type batchJob func(i int) (dest string, n int64, err error)
+
+// runBatch runs n jobs on a pool of the given number of workers. Results are
+// reported in job order regardless of completion order, and a failing job
+// only fails its own entry. Each job done is reported to p, if not nil.
+//
+// If haltFile is set, workers check for it before starting each job. Once
+// it exists, jobs in progress finish but no more start; the result lists
+// the jobs that ran and is marked halted.
+func runBatch(n, workers int, haltFile string, p *progress, job batchJob) *multiResult {
+	type outcome struct {
+		ran  bool
+		dest string
+		n    int64
+		err  error
+	}
+	outcomes := make([]outcome, n)
+	next := make(chan int)
+	var halted atomic.Bool
+	var wg sync.WaitGroup
+	for w := 0; w < min(max(workers, 1), n); w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				if halted.Load() || haltRequested(haltFile) {
+					halted.Store(true)
+					continue
+				}
+				o := outcome{ran: true}
+				o.dest, o.n, o.err = job(i)
+				p.add(o.err)
+				outcomes[i] = o // Each index is written by one worker only
+			}
+		}()
+	}
+	for i := 0; i < n && !halted.Load(); i++ {
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+
+	r := &multiResult{Halted: halted.Load()}
+	for _, o := range outcomes {
+		if !o.ran {
+			r.Skipped++
+			continue
+		}
+		r.add(o.dest, o.n, o.err)
+	}
+	return r
+}
+
+// haltRequested reports whether path is set and exists. It costs a stat.
+func haltRequested(path string) bool {
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+// readBatchCaptions reads one caption per line from path, skipping blank
+// lines.
+func readBatchCaptions(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading batch file: %w", err)
+	}
+	defer f.Close()
+
+	var captions []string
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if line := strings.TrimSpace(sc.Text()); line != "" {
+			captions = append(captions, line)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, fmt.Errorf("reading batch file: %w", err)
+	}
+	if len(captions) == 0 {
+		return nil, fmt.Errorf("batch file %s has no captions", path)
+	}
+	return captions, nil
+}
+
+// batchFileNames returns the names of a batch of n images in dir:
+// meme-NNN.ext, numbered from 1 and zero-padded to a common width.
+func batchFileNames(dir, ext string, n int) func(i int, caption string) (string, error) {
+	digits := len(strconv.Itoa(n))
+	return func(i int, _ string) (string, error) {
+		return filepath.Join(dir, fmt.Sprintf("meme-%0*d%s", digits, i+1, ext)), nil
+	}
+}
+
+// renderBatchJob returns a job rendering captions[i], uppercased, over base
+// into the file name gives it, with a sidecar if sidecars is set. Existing
+// files are only replaced if force is set. If dedupe is not nil, images it
+// already has are skipped with an error wrapping errDuplicate, and the ones
+// written are added to it. Once ctx is done, renders in progress stop and
+// later ones fail without writing anything; files already written are
+// complete and stay.
+func renderBatchJob(ctx context.Context, res *resources, base Options, captions []string, name func(i int, caption string) (string, error), force, sidecars bool, dedupe *batchDedupe) batchJob {
+	return func(i int) (string, int64, error) {
+		dest, err := name(i, captions[i])
+		if err != nil {
+			return captions[i], 0, err
+		}
+		opts := base
+		opts.Text, opts.TextInput = strings.ToUpper(captions[i]), captions[i]
+		opts.UniqueSeed += uint64(i) // Distinct perturbations per image
+		var buf bytes.Buffer
+		var sc *sidecar
+		if sidecars {
+			sc, err = res.renderSidecar(ctx, opts, &buf)
+		} else {
+			err = res.renderContext(ctx, opts, &buf)
+		}
+		if err != nil {
+			return dest, 0, err
+		}
+		if dedupe != nil {
+			if err := dedupe.check(buf.Bytes()); err != nil {
+				return dest, 0, err
+			}
+		}
+		n, err := writeFile(dest, buf.Bytes(), force)
+		if err == nil && sc != nil {
+			err = writeSidecar(dest, sc, force)
+		}
+		if err == nil && dedupe != nil {
+			err = dedupe.add(dest, buf.Bytes(), captions[i])
+		}
+		return dest, n, err
+	}
+}
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+func TestRunBatchOrderAndFailures(t *testing.T) {
+	var running, peak atomic.Int32
+	result := runBatch(20, 4, "", nil, func(i int) (string, int64, error) {
+		n := running.Add(1)
+		defer running.Add(-1)
+		for {
+			p := peak.Load()
+			if n <= p || peak.CompareAndSwap(p, n) {
+				break
+			}
+		}
+		if i == 7 {
+			return "job-7", 0, errors.New("boom")
+		}
+		return fmt.Sprintf("job-%d", i), int64(i), nil
+	})
+
+	if len(result.Artifacts) != 20 {
+		t.Fatalf("got %d artifacts, want 20", len(result.Artifacts))
+	}
+	for i, a := range result.Artifacts {
+		if want := fmt.Sprintf("job-%d", i); a.Destination != want {
+			t.Errorf("artifact %d is %s, want results in job order", i, a.Destination)
+		}
+		if failed := a.Status == statusFailed; failed != (i == 7) {
+			t.Errorf("artifact %d status %s", i, a.Status)
+		}
+	}
+	if code := result.exitCode(); code != exitPartialFailure {
+		t.Errorf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+	if p := peak.Load(); p > 4 {
+		t.Errorf("%d jobs ran at once with 4 workers", p)
+	}
+}
+
+// TestRunBatchHaltFile creates the halt file from inside a job: the jobs
+// after it must not start, and the result must say what was done.
+func TestRunBatchHaltFile(t *testing.T) {
+	halt := filepath.Join(t.TempDir(), "halt")
+	var started []int
+	result := runBatch(10, 1, halt, nil, func(i int) (string, int64, error) {
+		started = append(started, i)
+		if i == 2 {
+			if err := os.WriteFile(halt, nil, 0o644); err != nil {
+				t.Error(err)
+			}
+		}
+		return fmt.Sprintf("job-%d", i), 1, nil
+	})
+	if len(started) != 3 {
+		t.Errorf("jobs %v started, want 0 to 2", started)
+	}
+	if !result.Halted || len(result.Artifacts) != 3 || result.Skipped != 7 {
+		t.Errorf("halted %v with %d artifacts and %d skipped, want 3 and 7", result.Halted, len(result.Artifacts), result.Skipped)
+	}
+	if code := result.exitCode(); code != exitHalted {
+		t.Errorf("exit code %d, want %d", code, exitHalted)
+	}
+	var table strings.Builder
+	if err := result.print(&table, false); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(table.String(), "job-2") || !strings.Contains(table.String(), "Halted with 7 not started") {
+		t.Errorf("summary:\n%s", table.String())
+	}
+
+	// A halt file present from the start stops everything
+	result = runBatch(3, 2, halt, nil, func(i int) (string, int64, error) {
+		t.Errorf("job %d started", i)
+		return "", 0, nil
+	})
+	if !result.Halted || result.Skipped != 3 {
+		t.Errorf("halted %v with %d skipped, want all 3", result.Halted, result.Skipped)
+	}
+}
+
+func TestRenderBatchJob(t *testing.T) {
+	res, err := loadResources(loadTestTemplate(t), fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	captions := make([]string, 12)
+	for i := range captions {
+		captions[i] = fmt.Sprintf("CAPTION %d", i+1)
+	}
+	result := runBatch(len(captions), 3, "", nil, renderBatchJob(context.Background(), res, Options{}, captions, batchFileNames(dir, ".png", len(captions)), false, false, nil))
+	if code := result.exitCode(); code != 0 {
+		t.Fatalf("exit code %d: %+v", code, result.Artifacts)
+	}
+	for i, a := range result.Artifacts {
+		if want := filepath.Join(dir, fmt.Sprintf("meme-%02d.png", i+1)); a.Destination != want {
+			t.Errorf("artifact %d written to %s, want %s", i, a.Destination, want)
+		}
+	}
+
+	// Each worker's output must match a serial render of the same caption
+	var want strings.Builder
+	if err := run(Options{Text: captions[4]}, &want, loadTestTemplate(t), fontBytes); err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(result.Artifacts[4].Destination)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want.String() {
+		t.Error("parallel render differs from a serial render of the same caption")
+	}
+}
+
+// BenchmarkBatch renders a 100-caption batch with increasing worker counts;
+// time per batch should fall close to linearly up to the number of cores.
+func BenchmarkBatch(b *testing.B) {
+	res, err := loadResources(loadTestTemplate(b), fontBytes)
+	if err != nil {
+		b.Fatal(err)
+	}
+	captions := make([]string, 100)
+	for i := range captions {
+		captions[i] = fmt.Sprintf("BATCH CAPTION NUMBER %d", i)
+	}
+	for _, workers := range []int{1, 2, 4, 8} {
+		b.Run(fmt.Sprintf("jobs=%d", workers), func(b *testing.B) {
+			for range b.N {
+				runBatch(len(captions), workers, "", nil, func(i int) (string, int64, error) {
+					return "", 0, res.render(Options{Text: captions[i]}, io.Discard)
+				})
+			}
+		})
+	}
+}
+
+// TestRenderBatchJobCancelled checks that a cancelled batch writes
+// nothing more.
+func TestRenderBatchJobCancelled(t *testing.T) {
+	res, err := loadResources(loadTestTemplate(t), fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	dir := t.TempDir()
+	captions := []string{"ONE", "TWO"}
+	result := runBatch(len(captions), 2, "", nil, renderBatchJob(ctx, res, Options{}, captions, batchFileNames(dir, ".png", len(captions)), false, true, nil))
+	if code := result.exitCode(); code != 1 {
+		t.Errorf("exit code %d, want 1", code)
+	}
+	if entries, err := os.ReadDir(dir); err != nil || len(entries) != 0 {
+		t.Errorf("cancelled batch left %d files (%v)", len(entries), err)
+	}
+}
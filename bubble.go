@@ -0,0 +1,343 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// Speech bubbles are comic-style captions: the text in plain black on a
+// white bubble with a black border, and a tail pointing at the speaker.
+// -bubble gives one as
+//
+//	text@x,y
+//
+// where x,y is the tip of the tail, in the coordinate forms parsePoint
+// accepts, relative to the template at its -width/-height size. The bubble
+// is sized to the wrapped text and placed next to the tip, above it if
+// there is room, so that it stays on the canvas.
+
+// Bubble shapes accepted in Options.BubbleShape
+const (
+	bubbleRect    = "rect" // Rounded rectangle (default)
+	bubbleEllipse = "ellipse"
+)
+
+// Bubble proportions, in pixels before -scale
+const (
+	defaultBubbleSize = 28.0 // Font size in points
+	bubbleBorder      = 3
+	bubbleRadius      = 18 // Corner radius of rect bubbles
+	bubbleMargin      = 6  // Space kept between the bubble and the canvas edges
+	bubbleTailBase    = 12 // Half the width of the tail where it joins the bubble
+	bubbleTailMin     = 24 // Shortest tail
+	bubbleTailMax     = 80 // Longest tail
+)
+
+// bubbleMaxWidth is the widest a bubble's text wraps to, as a fraction of
+// the canvas width.
+const bubbleMaxWidth = 0.4
+
+// bubble is one -bubble.
+type bubble struct {
+	Text string
+	At   string // Coordinates of the tail's tip, resolved at layout
+}
+
+// bubbleFlags collects repeated -bubble flags as given, in order. They are
+// parsed with parseBubble along with the other options, so that a bad one
+// is reported with the rest. It implements flag.Value.
+type bubbleFlags []string
+
+// String formats the bubbles as a space-separated list.
+func (f *bubbleFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+// Set adds a bubble given as text@x,y.
+func (f *bubbleFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// parseBubble parses text@x,y, split by cutAt.
+func parseBubble(s string) (bubble, error) {
+	text, at, ok := cutAt(s)
+	if !ok || strings.Count(at, ",") != 1 {
+		return bubble{}, fmt.Errorf("bubble %q: want text@x,y", s)
+	}
+	return bubble{Text: text, At: at}, nil
+}
+
+// bubbleLayout is the placement of one speech bubble.
+type bubbleLayout struct {
+	Shape   string        `json:"shape"`
+	Body    pixelRect     `json:"body"`             // The bubble without its tail
+	Tail    []pixelPoint  `json:"tail"`             // Triangle from the body to the tip, tip last; empty if the tip is inside the body
+	Border  int           `json:"border"`           // Border thickness in pixels
+	Radius  int           `json:"radius,omitempty"` // Corner radius of rect bubbles
+	Caption captionLayout `json:"caption"`          // The text, plain black in the body
+}
+
+// pixelPoint is a point in output pixel coordinates.
+type pixelPoint struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+}
+
+// layoutBubbles places each bubble for a template at tmpl on canvas, the
+// text at the largest size up to the bubble size at which the bubble fits
+// the canvas.
+func layoutBubbles(canvas, tmpl image.Rectangle, ttFont *typeface, opts Options) ([]bubbleLayout, error) {
+	scale := opts.Scale
+	if scale == 0 {
+		scale = 1
+	}
+	shape := opts.BubbleShape
+	if shape == "" {
+		shape = bubbleRect
+	}
+	bounds := canvas.Inset(scalePx(bubbleMargin, scale))
+	// Coordinates refer to the template as it is before -scale
+	w, h := int(math.Round(float64(tmpl.Dx())/scale)), int(math.Round(float64(tmpl.Dy())/scale))
+	// The text is drawn plainly and straight
+	textOpts := opts
+	textOpts.Rotate, textOpts.Arc, textOpts.Fit, textOpts.NoCondense, textOpts.TextBox = 0, 0, fitShrink, true, false
+
+	bubbles := make([]bubbleLayout, len(opts.Bubbles))
+	for i, b := range opts.Bubbles {
+		pt, err := parsePoint(b.At, w, h)
+		if err != nil {
+			return nil, fmt.Errorf("bubble %d: %w", i+1, err)
+		}
+		tip := tmpl.Min.Add(image.Pt(scalePx(pt.X, scale), scalePx(pt.Y, scale)))
+
+		box, body, err := sizeBubble(b.Text, shape, bounds, ttFont, textOpts)
+		if err != nil {
+			return nil, fmt.Errorf("bubble %d: %w", i+1, err)
+		}
+		body = placeBubble(body, tip, bounds, scale)
+		box.Rect = box.Rect.Add(body.Min)
+		caption, err := layoutBox(box, ttFont, textOpts)
+		if err != nil {
+			return nil, fmt.Errorf("bubble %d: %w", i+1, err)
+		}
+		bubbles[i] = bubbleLayout{
+			Shape:   shape,
+			Body:    *newPixelRect(body),
+			Tail:    bubbleTail(shape, body, tip, scale),
+			Border:  scalePx(bubbleBorder, scale),
+			Caption: caption,
+		}
+		if shape == bubbleRect {
+			bubbles[i].Radius = scalePx(bubbleRadius, scale)
+		}
+	}
+	return bubbles, nil
+}
+
+// sizeBubble wraps text at the largest font size, from the bubble size
+// down to minFitSize, at which the bubble fits within bounds. It returns
+// the caption box of the text, relative to the body, and the body's size
+// at the origin.
+func sizeBubble(text, shape string, bounds image.Rectangle, ttFont *typeface, opts Options) (captionBox, image.Rectangle, error) {
+	size := defaultBubbleSize
+	if opts.BubbleSize > 0 {
+		size = opts.BubbleSize
+	}
+	if opts.Scale != 0 {
+		size *= opts.Scale
+	}
+	pad := scalePx(bubbleRadius, opts.Scale) / 2
+	maxWidth := int(float64(bounds.Dx()) * bubbleMaxWidth)
+	attempt := func(size float64) (box captionBox, body image.Rectangle, fits bool, err error) {
+		style := captionStyle(ttFont, size, opts)
+		box = captionBox{Text: text, Position: positionMiddle, Align: alignCenter, Size: size, Plain: true,
+			Fill: color.NRGBA{A: 255}}
+		box.Rect = image.Rect(0, 0, maxWidth, 0)
+		lines, err := wrapCaption(box, style, false)
+		if err != nil {
+			return captionBox{}, image.Rectangle{}, false, err
+		}
+		inkWidth := 0
+		for _, line := range lines {
+			ext, err := style.measure(line)
+			if err != nil {
+				return captionBox{}, image.Rectangle{}, false, fmt.Errorf("measuring text width: %w", err)
+			}
+			inkWidth = max(inkWidth, ext.inkWidth())
+		}
+		// The text box, with room for the ink as fits measures it
+		tw, th := inkWidth+2*style.thickness+1, textHeight(style, len(lines))
+		bw, bh := tw+2*pad, th+2*pad
+		if shape == bubbleEllipse {
+			// The ellipse through the corners of the text box, with its
+			// aspect ratio
+			bw, bh = int(math.Ceil(float64(tw)*math.Sqrt2))+2*pad, int(math.Ceil(float64(th)*math.Sqrt2))+2*pad
+		}
+		x, y := (bw-tw)/2, (bh-th)/2
+		box.Rect = image.Rect(x, y, x+tw, y+th)
+		return box, image.Rect(0, 0, bw, bh), bw <= bounds.Dx() && bh <= bounds.Dy() && inkWidth <= maxWidth, nil
+	}
+
+	size, fits, err := largestFit(capFontSize(size, bounds.Dy()), func(size float64) (bool, error) {
+		_, _, fits, err := attempt(size)
+		return fits, err
+	})
+	if err != nil {
+		return captionBox{}, image.Rectangle{}, err
+	}
+	if !fits {
+		return captionBox{}, image.Rectangle{}, errors.New("text does not fit in a bubble on the canvas")
+	}
+	box, body, _, err := attempt(size)
+	return box, body, err
+}
+
+// placeBubble returns body moved next to tip, leaving room for the tail:
+// above the tip and centered on it if that stays within bounds, otherwise
+// the first of above and to one side, below, and below to one side that
+// does. If none does, the first of them pushed into bounds that keeps
+// clear of the tip is used, or failing that the first pushed into bounds.
+func placeBubble(body image.Rectangle, tip image.Point, bounds image.Rectangle, scale float64) image.Rectangle {
+	w, h := body.Dx(), body.Dy()
+	tail := min(max(h/2, scalePx(bubbleTailMin, scale)), scalePx(bubbleTailMax, scale))
+	var candidates []image.Rectangle
+	for _, y := range []int{tip.Y - tail - h, tip.Y + tail} {
+		for _, x := range []int{tip.X - w/2, tip.X - w/4, tip.X - 3*w/4} {
+			candidates = append(candidates, image.Rect(x, y, x+w, y+h))
+		}
+	}
+	for _, r := range candidates {
+		if r.In(bounds) {
+			return r
+		}
+	}
+	for i, r := range candidates {
+		shift := image.Pt(max(bounds.Min.X-r.Min.X, 0)+min(bounds.Max.X-r.Max.X, 0),
+			max(bounds.Min.Y-r.Min.Y, 0)+min(bounds.Max.Y-r.Max.Y, 0))
+		candidates[i] = r.Add(shift)
+		if !tip.In(candidates[i]) {
+			return candidates[i]
+		}
+	}
+	return candidates[0]
+}
+
+// bubbleTail returns the tail from the body to tip: a triangle whose base
+// lies inside the body, on the side facing the tip, so that the two merge.
+// It is empty if the tip lies within the body.
+func bubbleTail(shape string, body image.Rectangle, tip image.Point, scale float64) []pixelPoint {
+	if tip.In(body) {
+		return nil
+	}
+	half := float64(scalePx(bubbleTailBase, scale))
+	x0, y0, x1, y1 := float64(body.Min.X), float64(body.Min.Y), float64(body.Max.X), float64(body.Max.Y)
+	c := vec{(x0 + x1) / 2, (y0 + y1) / 2}
+	rx, ry := (x1-x0)/2, (y1-y0)/2
+
+	// The tail leaves the top or bottom when the tip is more above or below
+	// than beside the body, or the left or right side otherwise
+	vertical := math.Abs(float64(tip.Y)-c.y)/ry >= math.Abs(float64(tip.X)-c.x)/rx
+	var base vec
+	if vertical {
+		sign := math.Copysign(1, float64(tip.Y)-c.y)
+		reach := rx - float64(scalePx(bubbleRadius, scale)) - half
+		if shape == bubbleEllipse {
+			reach = rx*0.5 - half
+		}
+		dx := min(max(float64(tip.X)-c.x, -reach), reach)
+		if reach < 0 {
+			dx = 0
+		}
+		// Depth at which the whole base is inside the body's edge
+		edge := ry
+		if shape == bubbleEllipse {
+			edge = ry * math.Sqrt(max(1-math.Pow((math.Abs(dx)+half)/rx, 2), 0))
+		}
+		base = vec{c.x + dx, c.y + sign*(edge-half)}
+		return tailPoints(vec{base.x - half, base.y}, vec{base.x + half, base.y}, tip)
+	}
+	sign := math.Copysign(1, float64(tip.X)-c.x)
+	reach := ry - float64(scalePx(bubbleRadius, scale)) - half
+	if shape == bubbleEllipse {
+		reach = ry*0.5 - half
+	}
+	dy := min(max(float64(tip.Y)-c.y, -reach), reach)
+	if reach < 0 {
+		dy = 0
+	}
+	edge := rx
+	if shape == bubbleEllipse {
+		edge = rx * math.Sqrt(max(1-math.Pow((math.Abs(dy)+half)/ry, 2), 0))
+	}
+	base = vec{c.x + sign*(edge-half), c.y + dy}
+	return tailPoints(vec{base.x, base.y - half}, vec{base.x, base.y + half}, tip)
+}
+
+// tailPoints returns the triangle a, b, tip in pixels.
+func tailPoints(a, b vec, tip image.Point) []pixelPoint {
+	return []pixelPoint{
+		{int(math.Round(a.x)), int(math.Round(a.y))},
+		{int(math.Round(b.x)), int(math.Round(b.y))},
+		{tip.X, tip.Y},
+	}
+}
+
+// bubblePolygons returns the outline of the bubble b and its tail, grown
+// or shrunk by inset pixels: the border is the difference between the
+// shape at inset 0 and at the border thickness.
+func bubblePolygons(b bubbleLayout, inset float64) [][]vec {
+	r := b.Body
+	x0, y0 := float64(r.X)+inset, float64(r.Y)+inset
+	x1, y1 := float64(r.X+r.W)-inset, float64(r.Y+r.H)-inset
+	var body []vec
+	if b.Shape == bubbleEllipse {
+		body = ellipsePolygon(vec{(x0 + x1) / 2, (y0 + y1) / 2}, (x1-x0)/2, (y1-y0)/2)
+	} else {
+		body = roundedRectPolygon(x0, y0, x1, y1, max(float64(b.Radius)-inset, 0))
+	}
+	polys := [][]vec{body}
+	if len(b.Tail) == 3 {
+		polys = append(polys, insetTriangle(b.Tail, inset))
+	}
+	return polys
+}
+
+// insetTriangle returns the triangle t with each edge moved inward by d,
+// by shrinking it about its incenter.
+func insetTriangle(t []pixelPoint, d float64) []vec {
+	p := make([]vec, 3)
+	for i, q := range t {
+		p[i] = vec{float64(q.X), float64(q.Y)}
+	}
+	// Each vertex is weighted by the length of the opposite side
+	a, b, c := math.Hypot(p[1].x-p[2].x, p[1].y-p[2].y), math.Hypot(p[0].x-p[2].x, p[0].y-p[2].y), math.Hypot(p[0].x-p[1].x, p[0].y-p[1].y)
+	perimeter := a + b + c
+	if perimeter == 0 {
+		return p
+	}
+	in := vec{(a*p[0].x + b*p[1].x + c*p[2].x) / perimeter, (a*p[0].y + b*p[1].y + c*p[2].y) / perimeter}
+	s := (a + b + c) / 2
+	area := math.Sqrt(max(s*(s-a)*(s-b)*(s-c), 0))
+	r := area / s // Inradius
+	k := max(r-d, 0) / r
+	if r == 0 {
+		k = 0
+	}
+	for i := range p {
+		p[i] = vec{in.x + (p[i].x-in.x)*k, in.y + (p[i].y-in.y)*k}
+	}
+	return p
+}
+
+// drawBubble draws the bubble of b onto dst: its shape in black, then in
+// white inset by the border, which leaves a black border around the union
+// of body and tail. The caption is drawn separately.
+func drawBubble(dst *image.RGBA, b bubbleLayout) {
+	fillPolygons(dst, bubblePolygons(b, 0), color.NRGBA{A: 255})
+	fillPolygons(dst, bubblePolygons(b, float64(b.Border)), color.NRGBA{255, 255, 255, 255})
+}
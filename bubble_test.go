@@ -0,0 +1,105 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+)
+
+func TestParseBubble(t *testing.T) {
+	cases := []struct {
+		spec string
+		want bubble
+	}{
+		{"HI@10,20", bubble{"HI", "10,20"}},
+		{"EMAIL ME@HOME@50%,90%", bubble{"EMAIL ME@HOME", "50%,90%"}},
+		{"THERE@@w-40,h/2", bubble{"THERE", "@w-40,h/2"}},
+	}
+	for _, tc := range cases {
+		got, err := parseBubble(tc.spec)
+		if err != nil {
+			t.Errorf("parseBubble(%q): %v", tc.spec, err)
+		} else if got != tc.want {
+			t.Errorf("parseBubble(%q) = %+v, want %+v", tc.spec, got, tc.want)
+		}
+	}
+	for _, s := range []string{"HI", "@10,20", "HI@10", "HI@1,2,3"} {
+		if _, err := parseBubble(s); err == nil {
+			t.Errorf("parseBubble(%q) accepted", s)
+		}
+	}
+}
+
+func TestPlaceBubble(t *testing.T) {
+	bounds := image.Rect(0, 0, 480, 270)
+	small, tall := image.Rect(0, 0, 100, 60), image.Rect(0, 0, 100, 120)
+	cases := []struct {
+		name string
+		body image.Rectangle
+		tip  image.Point
+		want image.Rectangle
+	}{
+		{"above", small, image.Pt(240, 200), image.Rect(190, 110, 290, 170)},
+		{"above to the right", small, image.Pt(40, 200), image.Rect(15, 110, 115, 170)},
+		{"below", small, image.Pt(240, 20), image.Rect(190, 50, 290, 110)},
+		{"pushed in", tall, image.Pt(240, 135), image.Rect(190, 0, 290, 120)},
+		{"pushed in by the edge", small, image.Pt(10, 200), image.Rect(0, 110, 100, 170)},
+		{"pushed in below", small, image.Pt(475, 10), image.Rect(380, 40, 480, 100)},
+	}
+	for _, tc := range cases {
+		if got := placeBubble(tc.body, tc.tip, bounds, 1); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
+
+func TestLayoutBubbles(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	bounds := image.Rect(0, 0, 480, 270)
+	for _, shape := range []string{bubbleRect, bubbleEllipse} {
+		opts := Options{BubbleShape: shape, Bubbles: []bubble{
+			{"THIS IS A LONGER THING TO SAY", "100,250"},
+			{"HI", "@w-10,10"},
+		}}
+		lay, err := computeLayout(bounds, ttFont, opts)
+		if err != nil {
+			t.Fatalf("%s: %v", shape, err)
+		}
+		if len(lay.Bubbles) != 2 {
+			t.Fatalf("%s: got %d bubbles, want 2", shape, len(lay.Bubbles))
+		}
+		tips := []image.Point{{100, 250}, {470, 10}}
+		for i, b := range lay.Bubbles {
+			body := b.Body.rect()
+			if !body.In(bounds) {
+				t.Errorf("%s bubble %d: body %v leaves the canvas", shape, i+1, body)
+			}
+			if len(b.Tail) != 3 || b.Tail[2] != (pixelPoint{tips[i].X, tips[i].Y}) {
+				t.Errorf("%s bubble %d: tail %v does not end at %v", shape, i+1, b.Tail, tips[i])
+			}
+			for _, p := range b.Tail[:2] {
+				if !image.Pt(p.X, p.Y).In(body) {
+					t.Errorf("%s bubble %d: tail base %v is outside the body %v", shape, i+1, p, body)
+				}
+			}
+			if cl := b.Caption; cl.Block == nil || !cl.Block.rect().In(body) || !cl.plain || cl.FitResult == fitOverflows {
+				t.Errorf("%s bubble %d: caption %+v not fitted plainly inside the body %v", shape, i+1, cl.Block, body)
+			}
+		}
+	}
+}
+
+func TestInsetTriangle(t *testing.T) {
+	// A 3-4-5 right triangle has an inradius of 1
+	tri := []pixelPoint{{0, 0}, {4, 0}, {0, 3}}
+	got := insetTriangle(tri, 0.5)
+	want := []vec{{0.5, 0.5}, {2.5, 0.5}, {0.5, 2}}
+	for i := range want {
+		if math.Abs(got[i].x-want[i].x) > 1e-9 || math.Abs(got[i].y-want[i].y) > 1e-9 {
+			t.Errorf("vertex %d = %v, want %v", i, got[i], want[i])
+		}
+	}
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"io"
+	"os"
+	"sync"
+)
+
+// drawBackground returns a new canvas for lay holding everything drawn
+// before the captions: the caption bar or poster frame, if any, the
+// template scaled into place and filtered, and the overlays on top.
+// Warnings go to warnings, or standard error if it is nil.
+func drawBackground(lay *layout, template image.Image, barColor color.NRGBA, filters []imageFilter, overlays []overlay, warnings io.Writer) *image.RGBA {
+	// Create a new RGBA image to draw on. This ensures we have an image type
+	// that supports setting individual pixel colors. It is larger than the
+	// template in caption-bar mode.
+	canvas := image.NewRGBA(image.Rect(0, 0, lay.Width, lay.Height))
+	if lay.Poster != nil {
+		drawPosterFrame(canvas, lay.Poster)
+	}
+	if lay.Bar != nil {
+		if barColor == (color.NRGBA{}) {
+			barColor = defaultBarColor
+		}
+		draw.Draw(canvas, lay.Bar.rect(), image.NewUniform(barColor), image.Point{}, draw.Src)
+	}
+	srcBounds := template.Bounds()
+	tmplRect := lay.Template.rect()
+	if tmplRect.Size() == srcBounds.Size() {
+		draw.Draw(canvas, tmplRect, template, srcBounds.Min, draw.Src)
+	} else {
+		if tmplRect.Dx() > srcBounds.Dx() || tmplRect.Dy() > srcBounds.Dy() {
+			if warnings == nil {
+				warnings = os.Stderr
+			}
+			fmt.Fprintf(warnings, "Warning: upscaling template from %dx%d to %dx%d, it may look blurry\n",
+				srcBounds.Dx(), srcBounds.Dy(), tmplRect.Dx(), tmplRect.Dy())
+		}
+		scaleInto(canvas, tmplRect, template)
+	}
+	applyFilters(canvas.SubImage(tmplRect).(*image.RGBA), filters)
+	drawOverlays(canvas, lay.Overlays, overlays)
+	return canvas
+}
+
+// backgroundKey identifies a background by everything drawBackground
+// draws into it.
+type backgroundKey struct {
+	size     image.Point
+	template image.Rectangle
+	bar      image.Rectangle
+	poster   posterLayout
+	barColor color.NRGBA
+	filters  string // As formatFilters
+	overlays string // As overlayKey
+}
+
+// maxCachedBackgrounds is how many backgrounds a canvasCache keeps. Each is
+// a full-size canvas, and a batch whose caption bars vary in height needs
+// one per height, so it starts over when full rather than growing without
+// bound.
+const maxCachedBackgrounds = 16
+
+// canvasCache lets renders sharing resources skip converting and scaling
+// the template every time. Each distinct background is drawn once; a
+// render then starts from a plain copy of it, into a canvas recycled from
+// an earlier render when one of the right size is free.
+type canvasCache struct {
+	mu          sync.Mutex
+	backgrounds map[backgroundKey]*cachedBackground
+	free        sync.Pool // *image.RGBA canvases done with
+}
+
+// cachedBackground is a background drawn once, by the first render to need
+// it and without the cache's lock held, so that renders needing other
+// backgrounds don't wait for it.
+type cachedBackground struct {
+	once sync.Once
+	img  *image.RGBA
+}
+
+func newCanvasCache() *canvasCache {
+	return &canvasCache{backgrounds: make(map[backgroundKey]*cachedBackground)}
+}
+
+// canvas returns a canvas for lay with the background for opts drawn, and
+// a function to call once the canvas is no longer needed. Without a cache
+// the background is drawn straight onto a new canvas.
+func (res *resources) canvas(lay *layout, opts Options) (*image.RGBA, func()) {
+	c := res.canvases
+	if c == nil {
+		return drawBackground(lay, res.template, opts.CaptionBarColor, opts.Filters, opts.Overlays, opts.Warnings), func() {}
+	}
+
+	key := backgroundKey{
+		size:     image.Pt(lay.Width, lay.Height),
+		template: lay.Template.rect(),
+		barColor: opts.CaptionBarColor,
+		filters:  formatFilters(opts.Filters),
+		overlays: overlayKey(lay.Overlays, opts.Overlays),
+	}
+	if lay.Bar != nil {
+		key.bar = lay.Bar.rect()
+	}
+	if lay.Poster != nil {
+		key.poster = *lay.Poster
+	}
+	c.mu.Lock()
+	entry, ok := c.backgrounds[key]
+	if !ok {
+		if len(c.backgrounds) >= maxCachedBackgrounds {
+			clear(c.backgrounds)
+		}
+		entry = &cachedBackground{}
+		c.backgrounds[key] = entry
+	}
+	c.mu.Unlock()
+	entry.once.Do(func() {
+		entry.img = drawBackground(lay, res.template, opts.CaptionBarColor, opts.Filters, opts.Overlays, opts.Warnings)
+	})
+	bg := entry.img
+
+	dst, _ := c.free.Get().(*image.RGBA)
+	if dst == nil || dst.Rect != bg.Rect {
+		dst = image.NewRGBA(bg.Rect)
+	}
+	copy(dst.Pix, bg.Pix)
+	return dst, func() { c.free.Put(dst) }
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+// TestCanvasCacheIdentical checks that renders reusing cached backgrounds
+// and recycled canvases are byte-identical to renders without the cache,
+// including after a canvas was dirtied by an earlier render.
+func TestCanvasCacheIdentical(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	cached, err := loadResources(templateData, fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached.canvases = newCanvasCache()
+
+	cases := []Options{
+		{Text: "FIRST"},
+		{Text: "SECOND", Position: positionBottom},
+		{Text: "SCALED", Width: 240},
+		{Text: "BAR", CaptionBar: true, CaptionBarColor: color.NRGBA{R: 40, G: 40, B: 40, A: 255}},
+		{Text: "FIRST AGAIN", Unique: true, UniqueSeed: 3},
+	}
+	for round := 0; round < 2; round++ {
+		for _, opts := range cases {
+			var want, got bytes.Buffer
+			if err := run(opts, &want, templateData, fontBytes); err != nil {
+				t.Fatal(err)
+			}
+			if err := cached.render(opts, &got); err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(got.Bytes(), want.Bytes()) {
+				t.Errorf("round %d %q: cached render differs", round, opts.Text)
+			}
+		}
+	}
+	if n := len(cached.canvases.backgrounds); n != 3 {
+		t.Errorf("%d backgrounds cached, want 3 (full size, scaled, with bar)", n)
+	}
+}
+
+// TestCanvasCacheBounded checks that renders with ever different
+// backgrounds don't keep them all.
+func TestCanvasCacheBounded(t *testing.T) {
+	res, err := loadResources(loadTestTemplate(t), fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.canvases = newCanvasCache()
+	for width := 100; width < 100+2*maxCachedBackgrounds; width++ {
+		var out bytes.Buffer
+		if err := res.render(Options{Text: "HI", Width: width}, &out); err != nil {
+			t.Fatal(err)
+		}
+		if n := len(res.canvases.backgrounds); n > maxCachedBackgrounds {
+			t.Fatalf("%d backgrounds cached, want at most %d", n, maxCachedBackgrounds)
+		}
+	}
+}
+
+// BenchmarkRenderLargeTemplate renders onto a 4K template with and without
+// the canvas cache; compare B/op to see the per-render template copy saved.
+func BenchmarkRenderLargeTemplate(b *testing.B) {
+	tmpl := image.NewNRGBA(image.Rect(0, 0, 3840, 2160))
+	for i := range tmpl.Pix {
+		tmpl.Pix[i] = uint8(i * 7)
+	}
+	var data bytes.Buffer
+	if err := png.Encode(&data, tmpl); err != nil {
+		b.Fatal(err)
+	}
+	for _, cache := range []bool{false, true} {
+		name := "uncached"
+		if cache {
+			name = "cached"
+		}
+		b.Run(name, func(b *testing.B) {
+			res, err := loadResources(data.Bytes(), fontBytes)
+			if err != nil {
+				b.Fatal(err)
+			}
+			if cache {
+				res.canvases = newCanvasCache()
+			}
+			var out bytes.Buffer
+			b.ReportAllocs()
+			for range b.N {
+				out.Reset()
+				if err := res.render(Options{Text: "BENCHMARK"}, &out); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,28 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseColor(t *testing.T) {
+	cases := map[string]color.NRGBA{
+		"black":     {0, 0, 0, 255},
+		"White":     {255, 255, 255, 255},
+		"#f80":      {255, 136, 0, 255},
+		"#FFDD00":   {255, 221, 0, 255},
+		"00000080":  {0, 0, 0, 128},
+		"#12345678": {0x12, 0x34, 0x56, 0x78},
+	}
+	for in, want := range cases {
+		got, err := parseColor(in)
+		if err != nil || got != want {
+			t.Errorf("parseColor(%q) = %v, %v; want %v", in, got, err, want)
+		}
+	}
+	for _, bad := range []string{"", "#12", "#1234567", "#gg0000", "mauve"} {
+		if _, err := parseColor(bad); err == nil {
+			t.Errorf("parseColor(%q) accepted", bad)
+		}
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"image"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// maxCondense is how much wider than the available width a caption line may
+// be and still be squashed to fit rather than wrapped. At 25% the 2px
+// outline still ends up 1.6px wide on the glyphs' sides.
+const maxCondense = 0.25
+
+// condenseScale returns the horizontal scale that fits a line of ink width
+// inkWidth into width, or 0 if it fits already or is too wide to condense.
+func condenseScale(inkWidth, width int) float64 {
+	if inkWidth <= width || float64(inkWidth) > float64(width)*(1+maxCondense) {
+		return 0
+	}
+	return float64(width) / float64(inkWidth)
+}
+
+// condenseRect scales r horizontally by scale about its center.
+func condenseRect(r image.Rectangle, scale float64) image.Rectangle {
+	center := float64(r.Min.X+r.Max.X) / 2
+	w := int(math.Round(float64(r.Dx()) * scale))
+	minX := int(math.Round(center - float64(w)/2))
+	return image.Rect(minX, r.Min.Y, minX+w, r.Max.Y)
+}
+
+// drawCondensed draws a condensed caption line: the text is drawn at full
+// size with drawLine onto a layer covering its ink and outline, and the layer
+// is then scaled horizontally onto dst, shifted by any alignment. Rows map
+// one to one, so the baseline stays where it was laid out.
+func drawCondensed(dst *image.RGBA, style textStyle, l lineLayout, drawLine func(*textPainter, lineLayout) error) error {
+	src := l.ink.Inset(-style.thickness)
+	layer := image.NewRGBA(src)
+	if err := drawLine(newTextPainter(layer, style), l); err != nil {
+		return err
+	}
+	r := condenseRect(src, l.Condense).Add(image.Pt(l.shift, 0))
+	xdraw.CatmullRom.Scale(dst, r, layer, src, xdraw.Over, nil)
+	return nil
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"image"
+	"testing"
+)
+
+func TestCondense(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	const text = "HI THERE"
+	ext, err := newTextStyle(ttFont, fontSize).measure(text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	natural := ext.inkWidth()
+	margins := 2 * (paddingX + outlineThickness)
+
+	cases := []struct {
+		name       string
+		available  int // Caption width
+		noCondense bool
+		lines      int
+		condensed  bool
+	}{
+		{"fits", natural, false, 1, false},
+		{"slightly too wide", natural * 10 / 11, false, 1, true},
+		{"too wide to condense", natural * 10 / 14, false, 2, false},
+		{"condensing disabled", natural * 10 / 11, true, 2, false},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			bounds := image.Rect(0, 0, tc.available+margins, 540)
+			lay, err := computeLayout(bounds, ttFont, Options{Text: text, NoCondense: tc.noCondense})
+			if err != nil {
+				t.Fatal(err)
+			}
+			lines := lay.Captions[0].Lines
+			if len(lines) != tc.lines {
+				t.Fatalf("got %d lines, want %d", len(lines), tc.lines)
+			}
+			l := lines[0]
+			if got := l.Condense != 0; got != tc.condensed {
+				t.Fatalf("condense = %v, want condensed %v", l.Condense, tc.condensed)
+			}
+			if !tc.condensed {
+				return
+			}
+			if d := l.Box.W - tc.available; d < -1 || d > 1 {
+				t.Errorf("condensed width %d, want %d", l.Box.W, tc.available)
+			}
+			if l.Clamped || !l.Box.rect().In(bounds.Inset(paddingX)) {
+				t.Errorf("condensed line %+v not centered inside the padding", l.Box)
+			}
+			// Condensing only changes the horizontal extent
+			if l.Box.Y != l.ink.Min.Y || l.Box.H != l.ink.Dy() || l.Baseline != paddingY+fontSize {
+				t.Errorf("condensing moved the line vertically: box %+v, ink %v, baseline %d", l.Box, l.ink, l.Baseline)
+			}
+		})
+	}
+}
+
+// TestCondensedOutline checks that a condensed line keeps its outline: the
+// leftmost inked column of the render is still outline-colored.
+func TestCondensedOutline(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	opts := Options{Text: "HI THERE"}
+	ext, err := newTextStyle(ttFont, fontSize).measure(opts.Text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	bounds := image.Rect(0, 0, ext.inkWidth()*4/5+2*(paddingX+outlineThickness)+1, 300)
+	lay, err := computeLayout(bounds, ttFont, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l := lay.Captions[0].Lines[0]
+	if l.Condense == 0 || l.Condense > 0.81 {
+		t.Fatalf("condense = %v, want about 0.8", l.Condense)
+	}
+
+	dst := image.NewRGBA(bounds)
+	if err := drawCaption(context.Background(), dst, ttFont, lay.Captions[0], opts); err != nil {
+		t.Fatal(err)
+	}
+	// Scan the baseline row, inside the "H" stem, from the left
+	y := l.Baseline - 10
+	for x := 0; x < bounds.Dx(); x++ {
+		c := dst.RGBAAt(x, y)
+		if c.A < 128 {
+			continue
+		}
+		if c.R < 128 {
+			t.Errorf("first solid pixel at (%d,%d) is %v, want the white outline", x, y, c)
+		}
+		return
+	}
+	t.Fatal("no ink found on the scan row")
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+)
+
+// A config file is a JSON object whose keys are flag names without the dash
+// and whose values become that flag's default:
+//
+//	{
+//	  "watermark": "@me",
+//	  "textbox": true,
+//	  "tracking": 4
+//	}
+//
+// Precedence is built-in defaults < config file < command-line flags.
+//
+// The one key that names no flag, "font-metrics", gives -metrics-override
+// values for particular fonts, keyed by the -font path or its file name:
+//
+//	{
+//	  "font-metrics": {"impact.ttf": "ascent=0.78,descent=0.22"}
+//	}
+
+// Where a flag's effective value came from, as reported by -print-config.
+const (
+	sourceDefault = "default"
+	sourceConfig  = "config"
+	sourceFlag    = "flag"
+)
+
+// configOnlyFlags are flags that would be meaningless in a config file.
+var configOnlyFlags = map[string]bool{"config": true, "print-config": true}
+
+// fontMetricsKey is the config key holding metrics overrides per font.
+const fontMetricsKey = "font-metrics"
+
+// defaultConfigPath returns the config file used when -config is not given,
+// or "" if the user config directory is unknown.
+func defaultConfigPath() string {
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return ""
+	}
+	return filepath.Join(dir, "memegen", "config.json")
+}
+
+// loadConfig reads the config file at path. A missing file, or an empty
+// path, is an empty config unless required is set.
+func loadConfig(path string, required bool) (map[string]json.RawMessage, error) {
+	if path == "" && !required {
+		return nil, nil
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) && !required {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading config: %w", err)
+	}
+	var cfg map[string]json.RawMessage
+	if err := json.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	return cfg, nil
+}
+
+// applyConfig sets every flag in fs named by a key in cfg, unless the flag
+// was given explicitly on the command line. It returns the source of each
+// flag's value, plus a warning for each key that names no flag.
+func applyConfig(fs *flag.FlagSet, cfg map[string]json.RawMessage) (sources map[string]string, warnings []string, err error) {
+	sources = make(map[string]string)
+	fs.VisitAll(func(f *flag.Flag) { sources[f.Name] = sourceDefault })
+	fs.Visit(func(f *flag.Flag) { sources[f.Name] = sourceFlag })
+
+	keys := make([]string, 0, len(cfg))
+	for key := range cfg {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys) // Deterministic warnings and errors
+
+	for _, key := range keys {
+		if key == fontMetricsKey {
+			continue // Applied by applyFontMetrics once the font is known
+		}
+		if fs.Lookup(key) == nil || configOnlyFlags[key] {
+			warnings = append(warnings, fmt.Sprintf("unknown key %q ignored", key))
+			continue
+		}
+		if sources[key] == sourceFlag {
+			continue // The command line wins
+		}
+		value, err := configValue(cfg[key])
+		if err != nil {
+			return nil, nil, fmt.Errorf("config key %q: %w", key, err)
+		}
+		if err := fs.Set(key, value); err != nil {
+			return nil, nil, fmt.Errorf("config key %q: %w", key, err)
+		}
+		sources[key] = sourceConfig
+	}
+	return sources, warnings, nil
+}
+
+// applyFontMetrics sets -metrics-override from the config's "font-metrics"
+// entry for the font at path, unless the flag was given on the command
+// line. A font's own entry beats a plain "metrics-override" key.
+func applyFontMetrics(fs *flag.FlagSet, cfg map[string]json.RawMessage, sources map[string]string, path string) error {
+	raw, ok := cfg[fontMetricsKey]
+	if !ok {
+		return nil
+	}
+	var byFont map[string]string
+	if err := json.Unmarshal(raw, &byFont); err != nil {
+		return fmt.Errorf("config key %q: want an object of font names and overrides: %w", fontMetricsKey, err)
+	}
+	if path == "" || sources["metrics-override"] == sourceFlag {
+		return nil
+	}
+	value, ok := byFont[path]
+	if !ok {
+		value, ok = byFont[filepath.Base(path)]
+	}
+	if !ok {
+		return nil
+	}
+	if err := fs.Set("metrics-override", value); err != nil {
+		return fmt.Errorf("config key %q: %w", fontMetricsKey, err)
+	}
+	sources["metrics-override"] = sourceConfig
+	return nil
+}
+
+// configValue converts a JSON scalar to the string form flag.Set expects.
+func configValue(raw json.RawMessage) (string, error) {
+	var v any
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.UseNumber() // Keep integers as written
+	if err := dec.Decode(&v); err != nil {
+		return "", err
+	}
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case json.Number:
+		return v.String(), nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	default:
+		return "", errors.New("value must be a string, number or boolean")
+	}
+}
+
+// effectiveSetting is one entry of the -print-config output.
+type effectiveSetting struct {
+	Value  any    `json:"value"`
+	Source string `json:"source"`
+}
+
+// printConfig writes the merged configuration of fs as JSON, with the
+// source of each value.
+func printConfig(w io.Writer, fs *flag.FlagSet, sources map[string]string) error {
+	settings := make(map[string]effectiveSetting)
+	fs.VisitAll(func(f *flag.Flag) {
+		if configOnlyFlags[f.Name] {
+			return
+		}
+		var value any = f.Value.String()
+		if g, ok := f.Value.(flag.Getter); ok {
+			value = g.Get()
+		}
+		settings[f.Name] = effectiveSetting{Value: value, Source: sources[f.Name]}
+	})
+	data, err := json.MarshalIndent(settings, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding config: %w", err)
+	}
+	_, err = fmt.Fprintf(w, "%s\n", data)
+	return err
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// testFlagSet returns a flag set shaped like the command line's, with
+// explicit arguments already parsed.
+func testFlagSet(t *testing.T, args ...string) (*flag.FlagSet, *string, *int, *bool) {
+	t.Helper()
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	watermark := fs.String("watermark", "", "")
+	tracking := fs.Int("tracking", 0, "")
+	textBox := fs.Bool("textbox", false, "")
+	fs.String("config", "", "")
+	if err := fs.Parse(args); err != nil {
+		t.Fatalf("parsing flags: %v", err)
+	}
+	return fs, watermark, tracking, textBox
+}
+
+func TestApplyConfigPrecedence(t *testing.T) {
+	fs, watermark, tracking, textBox := testFlagSet(t, "-tracking", "2")
+	cfg := map[string]json.RawMessage{
+		"watermark": json.RawMessage(`"@me"`),
+		"tracking":  json.RawMessage(`4`),
+	}
+
+	sources, warnings, err := applyConfig(fs, cfg)
+	if err != nil {
+		t.Fatalf("applyConfig: %v", err)
+	}
+	if len(warnings) != 0 {
+		t.Errorf("unexpected warnings %q", warnings)
+	}
+	if *watermark != "@me" {
+		t.Errorf("watermark = %q, want the config value", *watermark)
+	}
+	if *tracking != 2 {
+		t.Errorf("tracking = %d, want the command-line value 2", *tracking)
+	}
+	if *textBox {
+		t.Error("textbox = true, want the built-in default")
+	}
+	want := map[string]string{"watermark": sourceConfig, "tracking": sourceFlag, "textbox": sourceDefault}
+	for name, src := range want {
+		if sources[name] != src {
+			t.Errorf("source of %s = %q, want %q", name, sources[name], src)
+		}
+	}
+}
+
+func TestApplyConfigUnknownKeys(t *testing.T) {
+	fs, _, _, textBox := testFlagSet(t)
+	cfg := map[string]json.RawMessage{
+		"textbox": json.RawMessage(`true`),
+		"colour":  json.RawMessage(`"red"`),
+		"config":  json.RawMessage(`"other.json"`),
+	}
+	_, warnings, err := applyConfig(fs, cfg)
+	if err != nil {
+		t.Fatalf("applyConfig: %v", err)
+	}
+	if !*textBox {
+		t.Error("known key not applied alongside unknown ones")
+	}
+	if len(warnings) != 2 || !strings.Contains(warnings[0], `"colour"`) || !strings.Contains(warnings[1], `"config"`) {
+		t.Errorf("warnings = %q, want one each for colour and config", warnings)
+	}
+}
+
+func TestApplyConfigBadValues(t *testing.T) {
+	for _, raw := range []string{`"four"`, `[1]`, `{"a":1}`, `1.5`} {
+		fs, _, _, _ := testFlagSet(t)
+		_, _, err := applyConfig(fs, map[string]json.RawMessage{"tracking": json.RawMessage(raw)})
+		if err == nil || !strings.Contains(err.Error(), `"tracking"`) {
+			t.Errorf("tracking %s: err = %v, want an error naming the key", raw, err)
+		}
+	}
+}
+
+func TestApplyFontMetrics(t *testing.T) {
+	cfg := map[string]json.RawMessage{
+		"metrics-override": json.RawMessage(`"descent=0.3"`),
+		fontMetricsKey:     json.RawMessage(`{"impact.ttf": "ascent=0.78,descent=0.22", "/fonts/odd.ttf": "descent=30px"}`),
+	}
+	cases := []struct {
+		args []string
+		font string
+		want string
+	}{
+		{nil, "", "descent=0.3"}, // No -font: the plain key
+		{nil, "/usr/share/fonts/impact.ttf", "ascent=0.78,descent=0.22"},      // By file name
+		{nil, "/fonts/odd.ttf", "descent=30px"},                               // By path
+		{nil, "other.ttf", "descent=0.3"},                                     // No entry
+		{[]string{"-metrics-override", "ascent=1"}, "impact.ttf", "ascent=1"}, // The command line wins
+	}
+	for _, tc := range cases {
+		fs := flag.NewFlagSet("test", flag.ContinueOnError)
+		metrics := fs.String("metrics-override", "", "")
+		if err := fs.Parse(tc.args); err != nil {
+			t.Fatal(err)
+		}
+		sources, warnings, err := applyConfig(fs, cfg)
+		if err != nil || len(warnings) != 0 {
+			t.Fatalf("applyConfig: warnings %q, err %v", warnings, err)
+		}
+		if err := applyFontMetrics(fs, cfg, sources, tc.font); err != nil {
+			t.Fatalf("font %q: %v", tc.font, err)
+		}
+		if *metrics != tc.want {
+			t.Errorf("font %q, args %q: metrics-override = %q, want %q", tc.font, tc.args, *metrics, tc.want)
+		}
+	}
+
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("metrics-override", "", "")
+	bad := map[string]json.RawMessage{fontMetricsKey: json.RawMessage(`"ascent=0.8"`)}
+	if err := applyFontMetrics(fs, bad, map[string]string{}, ""); err == nil || !strings.Contains(err.Error(), fontMetricsKey) {
+		t.Errorf("font-metrics string: err = %v, want an error naming the key", err)
+	}
+}
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	missing := filepath.Join(dir, "missing.json")
+	if cfg, err := loadConfig(missing, false); err != nil || cfg != nil {
+		t.Errorf("optional missing config = %v, %v; want empty", cfg, err)
+	}
+	if _, err := loadConfig(missing, true); err == nil {
+		t.Error("required missing config: want an error")
+	}
+
+	bad := filepath.Join(dir, "bad.json")
+	if err := os.WriteFile(bad, []byte(`{"watermark": `), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadConfig(bad, false); err == nil || !strings.Contains(err.Error(), bad) {
+		t.Errorf("malformed config: err = %v, want one naming the file", err)
+	}
+}
+
+func TestPrintConfig(t *testing.T) {
+	fs, _, _, _ := testFlagSet(t, "-tracking", "3")
+	sources, _, err := applyConfig(fs, map[string]json.RawMessage{"watermark": json.RawMessage(`"@me"`)})
+	if err != nil {
+		t.Fatalf("applyConfig: %v", err)
+	}
+	var buf bytes.Buffer
+	if err := printConfig(&buf, fs, sources); err != nil {
+		t.Fatalf("printConfig: %v", err)
+	}
+	var got map[string]effectiveSetting
+	if err := json.Unmarshal(buf.Bytes(), &got); err != nil {
+		t.Fatalf("decoding output: %v\n%s", err, buf.Bytes())
+	}
+	if _, ok := got["config"]; ok {
+		t.Error("-config itself should not be printed")
+	}
+	if s := got["tracking"]; s.Value != float64(3) || s.Source != sourceFlag {
+		t.Errorf("tracking = %+v, want 3 from flag", s)
+	}
+	if s := got["watermark"]; s.Value != "@me" || s.Source != sourceConfig {
+		t.Errorf("watermark = %+v, want @me from config", s)
+	}
+}
@@ -0,0 +1,241 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Placement coordinates are written either as plain numbers ("40,300"),
+// percentages of the template size ("50%,90%"), or, with a leading '@', as
+// small expressions over the template width w and height h:
+//
+//	@w/2,h-120
+//	@w*0.25+10,h*0.9
+//
+// Expressions support numbers, w, h, + - * /, unary minus and parentheses.
+// w and h are the dimensions of the canvas after any scaling. Results are
+// rounded to the nearest pixel; keeping them inside the canvas is up to the
+// caller, since some placements (overlays) may legitimately hang off-canvas.
+
+// parsePoint parses an "x,y" placement for a w×h canvas.
+func parsePoint(spec string, w, h int) (image.Point, error) {
+	vals, err := parseCoords(spec, 2, w, h)
+	if err != nil {
+		return image.Point{}, err
+	}
+	return image.Pt(vals[0], vals[1]), nil
+}
+
+// parseCoords parses a comma-separated list of exactly n coordinates. In the
+// non-expression forms, even positions (x, width) are relative to w and odd
+// positions (y, height) to h when given as percentages.
+func parseCoords(spec string, n, w, h int) ([]int, error) {
+	expr, isExpr := strings.CutPrefix(spec, "@")
+	parts := strings.Split(expr, ",")
+	if len(parts) != n {
+		return nil, fmt.Errorf("coordinates %q: want %d comma-separated values, got %d", spec, n, len(parts))
+	}
+
+	vals := make([]int, n)
+	offset := 0 // Byte offset of the current part within spec, for errors
+	if isExpr {
+		offset = 1
+	}
+	for i, part := range parts {
+		var (
+			v   float64
+			err error
+		)
+		if isExpr {
+			v, err = evalCoordExpr(part, float64(w), float64(h))
+			var perr *coordExprError
+			if errors.As(err, &perr) {
+				return nil, fmt.Errorf("coordinates %q: %s at column %d", spec, perr.msg, offset+perr.pos+1)
+			}
+		} else {
+			rel := w
+			if i%2 == 1 {
+				rel = h
+			}
+			v, err = parsePlainCoord(strings.TrimSpace(part), rel)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("coordinates %q: %w", spec, err)
+		}
+		if math.IsInf(v, 0) || math.IsNaN(v) || math.Abs(v) > math.MaxInt32 {
+			return nil, fmt.Errorf("coordinates %q: value %d out of range", spec, i+1)
+		}
+		vals[i] = int(math.Round(v))
+		offset += len(part) + 1
+	}
+	return vals, nil
+}
+
+// parsePlainCoord parses a number of pixels or a percentage of rel.
+func parsePlainCoord(s string, rel int) (float64, error) {
+	if pct, ok := strings.CutSuffix(s, "%"); ok {
+		v, err := strconv.ParseFloat(pct, 64)
+		if err != nil {
+			return 0, fmt.Errorf("bad percentage %q", s)
+		}
+		return v * float64(rel) / 100, nil
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad coordinate %q (use pixels, a percentage, or an @expression)", s)
+	}
+	return v, nil
+}
+
+// coordExprError is a syntax or evaluation error at a byte offset within a
+// single coordinate expression.
+type coordExprError struct {
+	pos int
+	msg string
+}
+
+func (e *coordExprError) Error() string {
+	return fmt.Sprintf("%s at offset %d", e.msg, e.pos)
+}
+
+// evalCoordExpr evaluates a single coordinate expression.
+func evalCoordExpr(expr string, w, h float64) (float64, error) {
+	p := &coordParser{src: expr, w: w, h: h}
+	p.skipSpace()
+	if p.pos == len(p.src) {
+		return 0, &coordExprError{pos: p.pos, msg: "empty expression"}
+	}
+	v, err := p.parseSum()
+	if err != nil {
+		return 0, err
+	}
+	if p.pos != len(p.src) {
+		return 0, &coordExprError{pos: p.pos, msg: fmt.Sprintf("unexpected %q", p.src[p.pos])}
+	}
+	return v, nil
+}
+
+// coordParser is a recursive-descent parser/evaluator for the grammar
+//
+//	sum     = product { ("+" | "-") product }
+//	product = unary { ("*" | "/") unary }
+//	unary   = [ "-" | "+" ] unary | primary
+//	primary = number | "w" | "h" | "(" sum ")"
+type coordParser struct {
+	src  string
+	pos  int
+	w, h float64
+}
+
+func (p *coordParser) skipSpace() {
+	for p.pos < len(p.src) && p.src[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+func (p *coordParser) parseSum() (float64, error) {
+	v, err := p.parseProduct()
+	if err != nil {
+		return 0, err
+	}
+	for p.pos < len(p.src) && (p.src[p.pos] == '+' || p.src[p.pos] == '-') {
+		op := p.src[p.pos]
+		p.pos++
+		p.skipSpace()
+		rhs, err := p.parseProduct()
+		if err != nil {
+			return 0, err
+		}
+		if op == '+' {
+			v += rhs
+		} else {
+			v -= rhs
+		}
+	}
+	return v, nil
+}
+
+func (p *coordParser) parseProduct() (float64, error) {
+	v, err := p.parseUnary()
+	if err != nil {
+		return 0, err
+	}
+	for p.pos < len(p.src) && (p.src[p.pos] == '*' || p.src[p.pos] == '/') {
+		op := p.src[p.pos]
+		opPos := p.pos
+		p.pos++
+		p.skipSpace()
+		rhs, err := p.parseUnary()
+		if err != nil {
+			return 0, err
+		}
+		if op == '*' {
+			v *= rhs
+		} else {
+			if rhs == 0 {
+				return 0, &coordExprError{pos: opPos, msg: "division by zero"}
+			}
+			v /= rhs
+		}
+	}
+	return v, nil
+}
+
+func (p *coordParser) parseUnary() (float64, error) {
+	if p.pos < len(p.src) && (p.src[p.pos] == '-' || p.src[p.pos] == '+') {
+		neg := p.src[p.pos] == '-'
+		p.pos++
+		p.skipSpace()
+		v, err := p.parseUnary()
+		if neg {
+			v = -v
+		}
+		return v, err
+	}
+	return p.parsePrimary()
+}
+
+func (p *coordParser) parsePrimary() (float64, error) {
+	if p.pos == len(p.src) {
+		return 0, &coordExprError{pos: p.pos, msg: "unexpected end of expression"}
+	}
+	start := p.pos
+	var v float64
+	switch ch := p.src[p.pos]; {
+	case ch == 'w':
+		v = p.w
+		p.pos++
+	case ch == 'h':
+		v = p.h
+		p.pos++
+	case ch == '(':
+		p.pos++
+		p.skipSpace()
+		inner, err := p.parseSum()
+		if err != nil {
+			return 0, err
+		}
+		if p.pos == len(p.src) || p.src[p.pos] != ')' {
+			return 0, &coordExprError{pos: p.pos, msg: "missing ')'"}
+		}
+		p.pos++
+		v = inner
+	case ch >= '0' && ch <= '9' || ch == '.':
+		for p.pos < len(p.src) && (p.src[p.pos] >= '0' && p.src[p.pos] <= '9' || p.src[p.pos] == '.') {
+			p.pos++
+		}
+		n, err := strconv.ParseFloat(p.src[start:p.pos], 64)
+		if err != nil {
+			return 0, &coordExprError{pos: start, msg: fmt.Sprintf("bad number %q", p.src[start:p.pos])}
+		}
+		v = n
+	default:
+		return 0, &coordExprError{pos: p.pos, msg: fmt.Sprintf("unexpected %q", ch)}
+	}
+	p.skipSpace()
+	return v, nil
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"image"
+	"strings"
+	"testing"
+)
+
+func TestEvalCoordExpr(t *testing.T) {
+	cases := []struct {
+		expr string
+		want float64
+	}{
+		{"w/2", 240},
+		{"h-120", 150},
+		{"w*0.25+10", 130},
+		{"10+w*0.25", 130}, // * binds tighter than +
+		{"(10+w)*0.25", 122.5},
+		{"w-h-10", 200}, // left-associative
+		{"w/2/2", 120},
+		{"-h+300", 30},
+		{"--5", 5},
+		{" w / 4 ", 120},
+	}
+	for _, tc := range cases {
+		got, err := evalCoordExpr(tc.expr, 480, 270)
+		if err != nil {
+			t.Errorf("evalCoordExpr(%q): %v", tc.expr, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("evalCoordExpr(%q) = %v, want %v", tc.expr, got, tc.want)
+		}
+	}
+}
+
+func TestParsePoint(t *testing.T) {
+	cases := []struct {
+		spec string
+		want image.Point
+	}{
+		{"40,300", image.Pt(40, 300)},
+		{"-20,10", image.Pt(-20, 10)}, // off-canvas is the caller's business
+		{"50%,10%", image.Pt(240, 27)},
+		{"@w/2,h-120", image.Pt(240, 150)},
+		{"@w*0.25+10,h*0.9", image.Pt(130, 243)},
+		{"@w+100,h*2", image.Pt(580, 540)},
+	}
+	for _, tc := range cases {
+		got, err := parsePoint(tc.spec, 480, 270)
+		if err != nil {
+			t.Errorf("parsePoint(%q): %v", tc.spec, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parsePoint(%q) = %v, want %v", tc.spec, got, tc.want)
+		}
+	}
+}
+
+func TestParsePointErrors(t *testing.T) {
+	cases := []struct {
+		spec, wantErr string
+	}{
+		{"@w/0,10", "division by zero at column 3"},
+		{"@w/(h-h),10", "division by zero at column 3"},
+		{"@w/2,h-1x0", `unexpected 'x' at column 9`},
+		{"@w/2,(h-1", "missing ')' at column 10"},
+		{"@w/2,", "empty expression at column 6"},
+		{"@w*,1", "unexpected end of expression at column 4"},
+		{"10", "want 2 comma-separated values, got 1"},
+		{"ten,10", `bad coordinate "ten"`},
+		{"@w*99999999,0", "value 1 out of range"},
+	}
+	for _, tc := range cases {
+		_, err := parsePoint(tc.spec, 480, 270)
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("parsePoint(%q) error = %v, want it to contain %q", tc.spec, err, tc.wantErr)
+		}
+	}
+}
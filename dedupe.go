@@ -0,0 +1,324 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"image"
+	"io"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+const (
+	defaultDedupeIndex     = "memegen-dedupe.json" // Default index file
+	defaultDedupeThreshold = -1                    // Max dHash Hamming distance for a near-duplicate, -1 for none
+	exitDuplicateFound     = 3                     // Exit status of "dedupe check" when a match exists
+)
+
+// dedupeEntry is one image recorded in a dedupe index.
+type dedupeEntry struct {
+	Path    string    `json:"path"`
+	SHA256  string    `json:"sha256"`
+	Pixels  string    `json:"pixels,omitempty"` // SHA-256 of the decoded pixels
+	DHash   string    `json:"dhash"`            // 64-bit difference hash, 16 hex digits
+	Caption string    `json:"caption,omitempty"`
+	Added   time.Time `json:"added"`
+}
+
+// dedupeIndex is the small local database of previously generated memes. It
+// is stored as a JSON document.
+type dedupeIndex struct {
+	Entries []dedupeEntry `json:"entries"`
+}
+
+// dedupeMatch is an index entry matching a checked image.
+type dedupeMatch struct {
+	Entry    dedupeEntry
+	Exact    bool // Byte-identical file
+	Same     bool // Same pixels in a different file, such as a re-encode
+	Distance int  // dHash Hamming distance, 0 for exact and same-pixel matches
+}
+
+// runDedupe implements "memegen dedupe [flags] add|check file.png". It
+// returns the process exit status alongside any error.
+func runDedupe(args []string, stdout io.Writer) (int, error) {
+	fs := flag.NewFlagSet("dedupe", flag.ContinueOnError)
+	indexPath := fs.String("index", defaultDedupeIndex, "Index file to read and update")
+	threshold := fs.Int("threshold", defaultDedupeThreshold, "Maximum perceptual hash distance (0-64) reported as a near-duplicate; -1 reports only images with the same pixels")
+	caption := fs.String("caption", "", "With add: caption to store alongside the entry")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s dedupe [flags] add|check file.png\n", os.Args[0])
+		fmt.Fprintf(fs.Output(), "  check exits with status %d when a duplicate is found.\n", exitDuplicateFound)
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return 0, nil
+		}
+		return 2, nil // The flag set has already reported the problem
+	}
+	if fs.NArg() != 2 {
+		fs.Usage()
+		return 2, errors.New("dedupe needs an action (add or check) and a file")
+	}
+	if *threshold < -1 || *threshold > 64 {
+		return 2, fmt.Errorf("-threshold must be between -1 and 64, got %d", *threshold)
+	}
+	action, path := fs.Arg(0), fs.Arg(1)
+
+	entry, err := hashImageFile(path)
+	if err != nil {
+		return 1, err
+	}
+	ix, err := loadDedupeIndex(*indexPath)
+	if err != nil {
+		return 1, err
+	}
+
+	switch action {
+	case "add":
+		entry.Caption = *caption
+		entry.Added = time.Now().UTC()
+		replaced := ix.add(entry)
+		if err := ix.save(*indexPath); err != nil {
+			return 1, err
+		}
+		if replaced {
+			fmt.Fprintf(stdout, "Updated %s in %s\n", path, *indexPath)
+		} else {
+			fmt.Fprintf(stdout, "Added %s to %s\n", path, *indexPath)
+		}
+		return 0, nil
+	case "check":
+		matches := ix.matches(entry, *threshold)
+		if len(matches) == 0 {
+			fmt.Fprintf(stdout, "No duplicates of %s\n", path)
+			return 0, nil
+		}
+		for _, m := range matches {
+			kind := "exact duplicate"
+			switch {
+			case m.Same:
+				kind = "re-encoded duplicate"
+			case !m.Exact:
+				kind = fmt.Sprintf("near duplicate (distance %d)", m.Distance)
+			}
+			fmt.Fprintf(stdout, "%s: %s", kind, m.Entry.Path)
+			if m.Entry.Caption != "" {
+				fmt.Fprintf(stdout, " %q", m.Entry.Caption)
+			}
+			fmt.Fprintln(stdout)
+		}
+		return exitDuplicateFound, nil
+	default:
+		return 2, fmt.Errorf("unknown dedupe action %q (want add or check)", action)
+	}
+}
+
+// hashImageFile computes the exact and perceptual hashes of an image file.
+func hashImageFile(path string) (dedupeEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return dedupeEntry{}, fmt.Errorf("reading image: %w", err)
+	}
+	return hashImage(path, data)
+}
+
+// hashImage computes the exact and perceptual hashes of data, the contents
+// of the image file at path.
+func hashImage(path string, data []byte) (dedupeEntry, error) {
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return dedupeEntry{}, fmt.Errorf("decoding %s: %w", path, err)
+	}
+	sum := sha256.Sum256(data)
+	pixels := image.NewNRGBA(img.Bounds().Sub(img.Bounds().Min))
+	xdraw.Draw(pixels, pixels.Bounds(), img, img.Bounds().Min, xdraw.Src)
+	pixelSum := sha256.Sum256(append(fmt.Appendf(nil, "%dx%d:", pixels.Rect.Dx(), pixels.Rect.Dy()), pixels.Pix...))
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		abs = path
+	}
+	return dedupeEntry{
+		Path:   abs,
+		SHA256: hex.EncodeToString(sum[:]),
+		Pixels: hex.EncodeToString(pixelSum[:]),
+		DHash:  fmt.Sprintf("%016x", dHash(img)),
+	}, nil
+}
+
+// dHash computes a 64-bit difference hash: the image is downscaled to 9×8
+// grayscale and each bit records whether a pixel is brighter than its right
+// neighbour. Small edits flip only a few bits, so the Hamming distance
+// between hashes measures visual similarity.
+func dHash(img image.Image) uint64 {
+	small := image.NewGray(image.Rect(0, 0, 9, 8))
+	xdraw.ApproxBiLinear.Scale(small, small.Bounds(), img, img.Bounds(), xdraw.Src, nil)
+
+	var hash uint64
+	for y := 0; y < 8; y++ {
+		for x := 0; x < 8; x++ {
+			hash <<= 1
+			if small.GrayAt(x, y).Y > small.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// loadDedupeIndex reads the index at path. A missing file is an empty index.
+func loadDedupeIndex(path string) (*dedupeIndex, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &dedupeIndex{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("reading dedupe index: %w", err)
+	}
+	var ix dedupeIndex
+	if err := json.Unmarshal(data, &ix); err != nil {
+		return nil, fmt.Errorf("parsing dedupe index %s: %w", path, err)
+	}
+	return &ix, nil
+}
+
+// save writes the index to path, replacing it atomically.
+func (ix *dedupeIndex) save(path string) error {
+	data, err := json.MarshalIndent(ix, "", "  ")
+	if err != nil {
+		return fmt.Errorf("encoding dedupe index: %w", err)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0o644); err != nil {
+		return fmt.Errorf("writing dedupe index: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("writing dedupe index: %w", err)
+	}
+	return nil
+}
+
+// add records e, in place of any entries for the same path or the same
+// image, so that adding a file again updates it rather than listing it
+// twice. It reports whether an entry was replaced.
+func (ix *dedupeIndex) add(e dedupeEntry) bool {
+	kept, at := ix.Entries[:0], -1
+	for _, entry := range ix.Entries {
+		if entry.Path == e.Path || entry.SHA256 == e.SHA256 {
+			if at < 0 {
+				at = len(kept)
+				kept = append(kept, e)
+			}
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	if at < 0 {
+		kept = append(kept, e)
+	}
+	ix.Entries = kept
+	return at >= 0
+}
+
+// matches returns the entries that are exact duplicates of e, have the same
+// pixels, or whose perceptual hash is within threshold bits of it, in that
+// order. A negative threshold skips the perceptual hash, which can't tell
+// captions on the same template apart.
+func (ix *dedupeIndex) matches(e dedupeEntry, threshold int) []dedupeMatch {
+	want, err := strconv.ParseUint(e.DHash, 16, 64)
+	if err != nil {
+		return nil
+	}
+	var exact, same, near []dedupeMatch
+	for _, entry := range ix.Entries {
+		if entry.SHA256 == e.SHA256 {
+			exact = append(exact, dedupeMatch{Entry: entry, Exact: true})
+			continue
+		}
+		if e.Pixels != "" && entry.Pixels == e.Pixels {
+			same = append(same, dedupeMatch{Entry: entry, Same: true})
+			continue
+		}
+		got, err := strconv.ParseUint(entry.DHash, 16, 64)
+		if err != nil {
+			continue // Skip corrupt entries rather than failing the check
+		}
+		if d := bits.OnesCount64(want ^ got); d <= threshold {
+			near = append(near, dedupeMatch{Entry: entry, Distance: d})
+		}
+	}
+	return append(append(exact, same...), near...)
+}
+
+// errDuplicate marks a batch image that wasn't written because the dedupe
+// index already has it.
+var errDuplicate = errors.New("duplicate")
+
+// batchDedupe is the dedupe index of a -skip-duplicates batch run. Images
+// already in it are skipped and the ones written are added. Only exact
+// duplicates are skipped, since memes on the same template are perceptual
+// near-duplicates of each other whatever their captions. It is safe for
+// concurrent use.
+type batchDedupe struct {
+	mu   sync.Mutex
+	path string
+	ix   *dedupeIndex
+}
+
+// loadBatchDedupe reads the index at path for a batch run.
+func loadBatchDedupe(path string) (*batchDedupe, error) {
+	ix, err := loadDedupeIndex(path)
+	if err != nil {
+		return nil, err
+	}
+	return &batchDedupe{path: path, ix: ix}, nil
+}
+
+// check returns an error wrapping errDuplicate if data is byte-identical to
+// an image in the index.
+func (d *batchDedupe) check(data []byte) error {
+	sum := sha256.Sum256(data)
+	want := hex.EncodeToString(sum[:])
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, e := range d.ix.Entries {
+		if e.SHA256 == want {
+			return fmt.Errorf("%w of %s", errDuplicate, e.Path)
+		}
+	}
+	return nil
+}
+
+// add records data, just written to path with caption, in the index.
+func (d *batchDedupe) add(path string, data []byte, caption string) error {
+	entry, err := hashImage(path, data)
+	if err != nil {
+		return err
+	}
+	entry.Caption = caption
+	entry.Added = time.Now().UTC()
+	d.mu.Lock()
+	d.ix.add(entry)
+	d.mu.Unlock()
+	return nil
+}
+
+// save writes the index back to its file.
+func (d *batchDedupe) save() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.ix.save(d.path)
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"image"
+	"image/color"
+	"image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// renderTestMeme renders a caption onto the test template and returns the
+// decoded image.
+func renderTestMeme(t *testing.T, text string) *image.RGBA {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := run(Options{Text: text}, &buf, loadTestTemplate(t), fontBytes); err != nil {
+		t.Fatalf("run(%q): %v", text, err)
+	}
+	img, err := png.Decode(&buf)
+	if err != nil {
+		t.Fatalf("decoding render: %v", err)
+	}
+	return img.(*image.RGBA)
+}
+
+// perturb returns a copy of img with every 97th pixel nudged and the whole
+// image slightly brightened, like a re-encode or light edit would.
+func perturb(img *image.RGBA) *image.RGBA {
+	out := image.NewRGBA(img.Bounds())
+	copy(out.Pix, img.Pix)
+	for i := 0; i < len(out.Pix); i += 4 {
+		for c := 0; c < 3; c++ {
+			out.Pix[i+c] = uint8(min(int(out.Pix[i+c])+3, 255))
+		}
+		if (i/4)%97 == 0 {
+			out.Pix[i] ^= 0x10
+		}
+	}
+	return out
+}
+
+func writePNG(t *testing.T, path string, img image.Image) {
+	t.Helper()
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDHashSimilarity(t *testing.T) {
+	const threshold = 6
+	orig := renderTestMeme(t, "HELLO")
+	other := renderTestMeme(t, "GOODBYE FOREVER")
+
+	near := bits.OnesCount64(dHash(orig) ^ dHash(perturb(orig)))
+	if near > threshold {
+		t.Errorf("perturbed copy distance %d exceeds threshold %d", near, threshold)
+	}
+	far := bits.OnesCount64(dHash(orig) ^ dHash(other))
+	if far <= threshold {
+		t.Errorf("different caption distance %d within threshold %d", far, threshold)
+	}
+
+	flat := image.NewUniform(color.Gray{128})
+	if h := dHash(image.NewPaletted(image.Rect(0, 0, 10, 10), []color.Color{flat.C})); h != 0 {
+		t.Errorf("flat image hash = %016x, want 0", h)
+	}
+}
+
+func TestDedupeAddCheck(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(dir, "index.json")
+	orig := renderTestMeme(t, "HELLO")
+
+	origPath := filepath.Join(dir, "orig.png")
+	samePath := filepath.Join(dir, "same.png")
+	nearPath := filepath.Join(dir, "near.png")
+	otherPath := filepath.Join(dir, "other.png")
+	writePNG(t, origPath, orig)
+	writePNG(t, nearPath, perturb(orig))
+	writePNG(t, otherPath, renderTestMeme(t, "GOODBYE FOREVER"))
+
+	// The same pixels in a different file, like a re-encode
+	var same bytes.Buffer
+	if err := (&png.Encoder{CompressionLevel: png.NoCompression}).Encode(&same, orig); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(samePath, same.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	if status, err := runDedupe([]string{"-index", index, "-caption", "HELLO", "add", origPath}, &out); err != nil || status != 0 {
+		t.Fatalf("add: status %d, err %v", status, err)
+	}
+
+	// The index must survive a round trip through the file
+	ix, err := loadDedupeIndex(index)
+	if err != nil || len(ix.Entries) != 1 || ix.Entries[0].Caption != "HELLO" {
+		t.Fatalf("reloaded index = %+v, err %v", ix, err)
+	}
+
+	cases := []struct {
+		path       string
+		threshold  string
+		wantStatus int
+		wantOutput string
+	}{
+		{origPath, "-1", exitDuplicateFound, "exact duplicate: " + origPath + ` "HELLO"`},
+		{samePath, "-1", exitDuplicateFound, "re-encoded duplicate: " + origPath},
+		{nearPath, "-1", 0, "No duplicates"},
+		{nearPath, "6", exitDuplicateFound, "near duplicate (distance "},
+		{otherPath, "6", 0, "No duplicates"},
+	}
+	for _, tc := range cases {
+		out.Reset()
+		status, err := runDedupe([]string{"-index", index, "-threshold", tc.threshold, "check", tc.path}, &out)
+		if err != nil {
+			t.Fatalf("check %s: %v", tc.path, err)
+		}
+		if status != tc.wantStatus || !strings.Contains(out.String(), tc.wantOutput) {
+			t.Errorf("check %s: status %d, output %q; want status %d containing %q",
+				filepath.Base(tc.path), status, out.String(), tc.wantStatus, tc.wantOutput)
+		}
+	}
+
+	// Adding the same file again updates its entry
+	out.Reset()
+	if status, err := runDedupe([]string{"-index", index, "-caption", "HI", "add", origPath}, &out); err != nil || status != 0 || !strings.HasPrefix(out.String(), "Updated ") {
+		t.Fatalf("add again: status %d, err %v, output %q", status, err, out.String())
+	}
+	if ix, err := loadDedupeIndex(index); err != nil || len(ix.Entries) != 1 || ix.Entries[0].Caption != "HI" {
+		t.Fatalf("index after adding again = %+v, err %v", ix, err)
+	}
+
+	// The threshold bounds what counts as near: at the maximum everything is
+	out.Reset()
+	if status, _ := runDedupe([]string{"-index", index, "-threshold", "64", "check", otherPath}, &out); status != exitDuplicateFound {
+		t.Errorf("threshold 64 did not report a near duplicate: %q", out.String())
+	}
+}
+
+// TestDedupeDifferentCaptions checks that by default two captions on one
+// template are not duplicates, even though their perceptual hashes match.
+func TestDedupeDifferentCaptions(t *testing.T) {
+	dir := t.TempDir()
+	index := filepath.Join(dir, "index.json")
+	var paths []string
+	for _, text := range []string{"WORLD", "SHIP IT"} {
+		var buf bytes.Buffer
+		if err := run(Options{Text: text}, &buf, templateImageBytes, fontBytes); err != nil {
+			t.Fatal(err)
+		}
+		path := filepath.Join(dir, text+".png")
+		if err := os.WriteFile(path, buf.Bytes(), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, path)
+	}
+
+	var out bytes.Buffer
+	if status, err := runDedupe([]string{"-index", index, "add", paths[0]}, &out); err != nil || status != 0 {
+		t.Fatalf("add: status %d, err %v", status, err)
+	}
+	out.Reset()
+	status, err := runDedupe([]string{"-index", index, "check", paths[1]}, &out)
+	if err != nil || status != 0 {
+		t.Errorf("check: status %d, err %v, output %q; want no duplicates", status, err, out.String())
+	}
+}
+
+// TestDedupeAddReplaces checks that adding a path or an image already in
+// the index replaces its entry.
+func TestDedupeAddReplaces(t *testing.T) {
+	ix := &dedupeIndex{Entries: []dedupeEntry{
+		{Path: "/a.png", SHA256: "aa", Caption: "A"},
+		{Path: "/b.png", SHA256: "bb", Caption: "B"},
+		{Path: "/c.png", SHA256: "cc", Caption: "C"},
+	}}
+	cases := []struct {
+		add      dedupeEntry
+		replaced bool
+		want     string
+	}{
+		{dedupeEntry{Path: "/b.png", SHA256: "b2", Caption: "B2"}, true, "A B2 C"},   // Same path, new image
+		{dedupeEntry{Path: "/a2.png", SHA256: "aa", Caption: "A2"}, true, "A2 B2 C"}, // Same image, moved
+		{dedupeEntry{Path: "/c.png", SHA256: "b2", Caption: "BC"}, true, "A2 BC"},    // Both, in two entries
+		{dedupeEntry{Path: "/d.png", SHA256: "dd", Caption: "D"}, false, "A2 BC D"},
+	}
+	for _, tc := range cases {
+		if got := ix.add(tc.add); got != tc.replaced {
+			t.Errorf("adding %+v replaced = %v, want %v", tc.add, got, tc.replaced)
+		}
+		var captions []string
+		for _, e := range ix.Entries {
+			captions = append(captions, e.Caption)
+		}
+		if got := strings.Join(captions, " "); got != tc.want {
+			t.Errorf("after adding %+v, index holds %s, want %s", tc.add, got, tc.want)
+		}
+	}
+}
+
+// TestBatchSkipDuplicates runs a batch twice against the same index: the
+// second run must skip every meme instead of failing on the existing
+// files, and a new caption must still be rendered.
+func TestBatchSkipDuplicates(t *testing.T) {
+	res, err := loadResources(loadTestTemplate(t), fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	index := filepath.Join(dir, "index.json")
+	batch := func(captions []string) *multiResult {
+		t.Helper()
+		dedupe, err := loadBatchDedupe(index)
+		if err != nil {
+			t.Fatal(err)
+		}
+		name := func(_ int, caption string) (string, error) { return filepath.Join(dir, caption+".png"), nil }
+		result := runBatch(len(captions), 2, "", nil, renderBatchJob(context.Background(), res, Options{}, captions, name, false, false, dedupe))
+		if err := dedupe.save(); err != nil {
+			t.Fatal(err)
+		}
+		return result
+	}
+
+	if result := batch([]string{"one", "two"}); result.exitCode() != 0 {
+		t.Fatalf("first run: %+v", result.Artifacts)
+	}
+	result := batch([]string{"one", "two", "three"})
+	if code := result.exitCode(); code != 0 {
+		t.Errorf("second run: exit code %d, want 0", code)
+	}
+	for i, want := range []string{statusDuplicate, statusDuplicate, statusOK} {
+		if a := result.Artifacts[i]; a.Status != want {
+			t.Errorf("second run, artifact %d: status %s (%s%s), want %s", i, a.Status, a.Error, a.Response, want)
+		}
+	}
+	ix, err := loadDedupeIndex(index)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ix.Entries) != 3 || ix.Entries[2].Caption != "three" {
+		t.Errorf("index has %+v, want the three memes written", ix.Entries)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"encoding/base64"
+	"fmt"
+	"io"
+)
+
+// Text encodings of the output accepted in Options.Encode
+const (
+	encodeBase64  = "base64"  // Raw base64
+	encodeDataURI = "datauri" // base64 behind a data: URI prefix
+)
+
+// formatMIMETypes maps output formats to their MIME types for data URIs.
+var formatMIMETypes = map[string]string{
+	formatPNG: "image/png",
+	formatSVG: "image/svg+xml",
+}
+
+// encodeOutput wraps w so the image written to it reaches w in the given
+// encoding, streaming rather than buffering. The returned finish function
+// must be called once the image is complete; it flushes the encoder and
+// ends the output with a newline. With no encoding, w is returned as is.
+// Nothing reaches w before the image does, not even a data URI's prefix, so
+// a render that fails or is cancelled first leaves w untouched.
+func encodeOutput(w io.Writer, encoding, format string) (io.Writer, func() error, error) {
+	pw := &prefixWriter{w: w}
+	switch encoding {
+	case "":
+		return w, func() error { return nil }, nil
+	case encodeBase64:
+	case encodeDataURI:
+		pw.prefix = fmt.Sprintf("data:%s;base64,", formatMIMETypes[format])
+	default:
+		return nil, nil, fmt.Errorf("unknown output encoding %q (want base64 or datauri)", encoding)
+	}
+
+	enc := base64.NewEncoder(base64.StdEncoding, pw)
+	finish := func() error {
+		if err := enc.Close(); err != nil {
+			return fmt.Errorf("writing %s output: %w", encoding, err)
+		}
+		if _, err := io.WriteString(pw, "\n"); err != nil {
+			return fmt.Errorf("writing %s output: %w", encoding, err)
+		}
+		return nil
+	}
+	return enc, finish, nil
+}
+
+// prefixWriter writes prefix to w ahead of the first write.
+type prefixWriter struct {
+	w      io.Writer
+	prefix string
+}
+
+func (p *prefixWriter) Write(b []byte) (int, error) {
+	if p.prefix != "" {
+		if _, err := io.WriteString(p.w, p.prefix); err != nil {
+			return 0, fmt.Errorf("writing data URI: %w", err)
+		}
+		p.prefix = ""
+	}
+	return p.w.Write(b)
+}
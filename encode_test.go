@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func TestEncodeOutput(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	render := func(opts Options) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := run(opts, &buf, templateData, fontBytes); err != nil {
+			t.Fatalf("run(%+v): %v", opts, err)
+		}
+		return buf.Bytes()
+	}
+
+	cases := []struct {
+		encode, format, prefix string
+	}{
+		{encodeBase64, formatPNG, ""},
+		{encodeDataURI, formatPNG, "data:image/png;base64,"},
+		{encodeDataURI, formatSVG, "data:image/svg+xml;base64,"},
+	}
+	for _, tc := range cases {
+		raw := render(Options{Text: "HI", Format: tc.format})
+		got := string(render(Options{Text: "HI", Format: tc.format, Encode: tc.encode}))
+
+		body, ok := strings.CutSuffix(got, "\n")
+		if !ok {
+			t.Errorf("%s %s: output does not end with a newline", tc.encode, tc.format)
+		}
+		body, ok = strings.CutPrefix(body, tc.prefix)
+		if !ok {
+			t.Errorf("%s %s: output starts %.30q, want %q", tc.encode, tc.format, got, tc.prefix)
+		}
+		decoded, err := base64.StdEncoding.DecodeString(body)
+		if err != nil {
+			t.Fatalf("%s %s: decoding: %v", tc.encode, tc.format, err)
+		}
+		if !bytes.Equal(decoded, raw) {
+			t.Errorf("%s %s: decoded output differs from the unencoded %s", tc.encode, tc.format, tc.format)
+		}
+	}
+
+	// The prefix waits for the image
+	var buf bytes.Buffer
+	if _, _, err := encodeOutput(&buf, encodeDataURI, formatPNG); err != nil || buf.Len() != 0 {
+		t.Errorf("data URI encoder wrote %q before the image, err %v", buf.String(), err)
+	}
+
+	buf.Reset()
+	err := run(Options{Text: "HI", Encode: "hex"}, &buf, templateData, fontBytes)
+	if err == nil || !strings.Contains(err.Error(), `"hex"`) {
+		t.Errorf("unknown encoding: err = %v", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("unknown encoding wrote %d bytes", buf.Len())
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"strconv"
+	"strings"
+)
+
+// imageFilter is one step of a -filter list, applied to the template
+// before the captions are drawn.
+type imageFilter struct {
+	Name   string // One of the filter names below
+	Amount int    // Percent, for filterBrightness and filterContrast
+}
+
+// Filter names accepted by -filter
+const (
+	filterGrayscale  = "grayscale"
+	filterSepia      = "sepia"
+	filterInvert     = "invert"
+	filterBrightness = "brightness" // brightness=N adds N% of full white, -100 to 100
+	filterContrast   = "contrast"   // contrast=N stretches by N% around mid-gray, -100 to 100
+)
+
+// String returns f as written in a -filter list.
+func (f imageFilter) String() string {
+	if f.Name == filterBrightness || f.Name == filterContrast {
+		return f.Name + "=" + strconv.Itoa(f.Amount)
+	}
+	return f.Name
+}
+
+// parseFilters parses a comma-separated filter list such as
+// "grayscale,brightness=+20". Errors name the offending token.
+func parseFilters(s string) ([]imageFilter, error) {
+	var filters []imageFilter
+	for _, tok := range strings.Split(s, ",") {
+		tok = strings.TrimSpace(tok)
+		name, arg, hasArg := strings.Cut(tok, "=")
+		switch name {
+		case filterGrayscale, filterSepia, filterInvert:
+			if hasArg {
+				return nil, fmt.Errorf("filter %q takes no amount", tok)
+			}
+			filters = append(filters, imageFilter{Name: name})
+		case filterBrightness, filterContrast:
+			amount, err := strconv.Atoi(arg)
+			if !hasArg || err != nil || amount < -100 || amount > 100 {
+				return nil, fmt.Errorf("filter %q: want %s=N with N a whole percentage from -100 to 100", tok, name)
+			}
+			filters = append(filters, imageFilter{Name: name, Amount: amount})
+		default:
+			return nil, fmt.Errorf("unknown filter %q (want grayscale, sepia, invert, brightness=N or contrast=N)", tok)
+		}
+	}
+	return filters, nil
+}
+
+// formatFilters returns filters as a -filter list.
+func formatFilters(filters []imageFilter) string {
+	toks := make([]string, len(filters))
+	for i, f := range filters {
+		toks[i] = f.String()
+	}
+	return strings.Join(toks, ",")
+}
+
+// applyFilters applies filters in order to img.
+func applyFilters(img *image.RGBA, filters []imageFilter) {
+	for _, f := range filters {
+		switch f.Name {
+		case filterGrayscale:
+			grayscale(img)
+		case filterSepia:
+			sepia(img)
+		case filterInvert:
+			invert(img)
+		case filterBrightness:
+			brightness(img, f.Amount)
+		case filterContrast:
+			contrast(img, f.Amount)
+		}
+	}
+}
+
+// The filters work in place on premultiplied color, scaling by each pixel's
+// alpha where straight color would use full intensity, so that the result
+// is the same as filtering the straight color. Alpha itself is untouched.
+
+// mapPixels replaces the color of every pixel of img with fn of its color
+// and alpha; fn's results are clamped to the valid range below alpha.
+func mapPixels(img *image.RGBA, fn func(r, g, b, a float64) (float64, float64, float64)) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		row := img.Pix[img.PixOffset(b.Min.X, y):img.PixOffset(b.Max.X, y)]
+		for i := 0; i < len(row); i += 4 {
+			p := row[i : i+4 : i+4]
+			a := float64(p[3])
+			r, g, bl := fn(float64(p[0]), float64(p[1]), float64(p[2]), a)
+			clamp := func(v float64) uint8 { return uint8(min(max(v, 0), a) + 0.5) }
+			p[0], p[1], p[2] = clamp(r), clamp(g), clamp(bl)
+		}
+	}
+}
+
+// grayscale replaces each pixel by its Rec. 601 luma.
+func grayscale(img *image.RGBA) {
+	mapPixels(img, func(r, g, b, _ float64) (float64, float64, float64) {
+		y := 0.299*r + 0.587*g + 0.114*b
+		return y, y, y
+	})
+}
+
+// sepia applies the common sepia tone matrix.
+func sepia(img *image.RGBA) {
+	mapPixels(img, func(r, g, b, _ float64) (float64, float64, float64) {
+		return 0.393*r + 0.769*g + 0.189*b,
+			0.349*r + 0.686*g + 0.168*b,
+			0.272*r + 0.534*g + 0.131*b
+	})
+}
+
+// invert replaces each color channel by its complement.
+func invert(img *image.RGBA) {
+	mapPixels(img, func(r, g, b, a float64) (float64, float64, float64) {
+		return a - r, a - g, a - b
+	})
+}
+
+// brightness adds percent% of full intensity to every channel, darkening
+// for negative percentages.
+func brightness(img *image.RGBA, percent int) {
+	mapPixels(img, func(r, g, b, a float64) (float64, float64, float64) {
+		d := a * float64(percent) / 100
+		return r + d, g + d, b + d
+	})
+}
+
+// contrast scales every channel's distance from mid-gray by 1+percent/100,
+// flattening the image to gray at -100.
+func contrast(img *image.RGBA, percent int) {
+	k := 1 + float64(percent)/100
+	mapPixels(img, func(r, g, b, a float64) (float64, float64, float64) {
+		mid := a / 2
+		return mid + (r-mid)*k, mid + (g-mid)*k, mid + (b-mid)*k
+	})
+}
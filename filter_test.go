@@ -0,0 +1,93 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+// filterFixture returns a 3x1 image: opaque orange, half-transparent blue
+// (premultiplied) and fully transparent.
+func filterFixture() *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 1))
+	img.SetRGBA(0, 0, color.RGBA{200, 100, 0, 255})
+	img.SetRGBA(1, 0, color.RGBA{0, 0, 128, 128})
+	img.SetRGBA(2, 0, color.RGBA{})
+	return img
+}
+
+// checkFilter runs filter on the fixture and compares the pixels.
+func checkFilter(t *testing.T, filter func(*image.RGBA), want [3]color.RGBA) {
+	t.Helper()
+	img := filterFixture()
+	filter(img)
+	for x, w := range want {
+		if got := img.RGBAAt(x, 0); got != w {
+			t.Errorf("pixel %d = %v, want %v", x, got, w)
+		}
+	}
+}
+
+func TestGrayscale(t *testing.T) {
+	// Luma 0.299*200 + 0.587*100 = 118.5; the blue pixel keeps its alpha
+	checkFilter(t, grayscale, [3]color.RGBA{{119, 119, 119, 255}, {15, 15, 15, 128}, {}})
+}
+
+func TestSepia(t *testing.T) {
+	checkFilter(t, sepia, [3]color.RGBA{{156, 138, 108, 255}, {24, 22, 17, 128}, {}})
+}
+
+func TestInvert(t *testing.T) {
+	checkFilter(t, invert, [3]color.RGBA{{55, 155, 255, 255}, {128, 128, 0, 128}, {}})
+}
+
+func TestBrightness(t *testing.T) {
+	checkFilter(t, func(img *image.RGBA) { brightness(img, 20) },
+		[3]color.RGBA{{251, 151, 51, 255}, {26, 26, 128, 128}, {}})
+	checkFilter(t, func(img *image.RGBA) { brightness(img, -100) },
+		[3]color.RGBA{{0, 0, 0, 255}, {0, 0, 0, 128}, {}})
+}
+
+func TestContrast(t *testing.T) {
+	checkFilter(t, func(img *image.RGBA) { contrast(img, 50) },
+		[3]color.RGBA{{236, 86, 0, 255}, {0, 0, 128, 128}, {}})
+	// -100 flattens everything to mid-gray
+	checkFilter(t, func(img *image.RGBA) { contrast(img, -100) },
+		[3]color.RGBA{{128, 128, 128, 255}, {64, 64, 64, 128}, {}})
+}
+
+func TestParseFilters(t *testing.T) {
+	filters, err := parseFilters("grayscale, brightness=+20,contrast=-10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := formatFilters(filters), "grayscale,brightness=20,contrast=-10"; got != want {
+		t.Errorf("parsed %q, want %q", got, want)
+	}
+
+	for _, tc := range []struct{ list, token string }{
+		{"grayscale,blur", `"blur"`},
+		{"brightness", `"brightness"`},
+		{"brightness=lots", `"brightness=lots"`},
+		{"contrast=150", `"contrast=150"`},
+		{"invert=1", `"invert=1"`},
+		{"sepia,", `""`},
+	} {
+		_, err := parseFilters(tc.list)
+		if err == nil || !strings.Contains(err.Error(), tc.token) {
+			t.Errorf("parseFilters(%q) = %v, want an error naming %s", tc.list, err, tc.token)
+		}
+	}
+}
+
+func TestApplyFiltersInOrder(t *testing.T) {
+	// Inverting then darkening differs from darkening then inverting
+	a, b := filterFixture(), filterFixture()
+	applyFilters(a, []imageFilter{{Name: filterInvert}, {Name: filterBrightness, Amount: -20}})
+	invert(b)
+	brightness(b, -20)
+	if string(a.Pix) != string(b.Pix) {
+		t.Errorf("applyFilters = %v, want %v", a.Pix, b.Pix)
+	}
+}
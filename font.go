@@ -0,0 +1,159 @@
+package main
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/opentype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// Font file signatures, the first four bytes of the file
+const (
+	sigTrueType   = 0x00010000 // TrueType outlines, .ttf and most .otf
+	sigAppleTrue  = 0x74727565 // "true", TrueType outlines in older Mac fonts
+	sigCollection = 0x74746366 // "ttcf", a .ttc collection of fonts
+	sigCFF        = 0x4f54544f // "OTTO", OpenType with PostScript (CFF) outlines
+	sigWOFF       = 0x774f4646 // "wOFF"
+	sigWOFF2      = 0x774f4632 // "wOF2"
+)
+
+// loadFont reads the font file at path and prepares it for
+// parseTypeface. The kind of font is recognised from its signature rather
+// than its extension. For a collection, index selects the font to use; for
+// a single font it must be 0.
+func loadFont(path string, index int) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading font: %w", err)
+	}
+	return prepareFont(data, index)
+}
+
+// prepareFont checks the signature of a font file and normalises what the
+// parsers would otherwise reject: it selects the index'th font of a
+// collection and accepts the old Mac signature.
+func prepareFont(data []byte, index int) ([]byte, error) {
+	if len(data) < 12 {
+		return nil, errors.New("font file is too short")
+	}
+	sig := binary.BigEndian.Uint32(data)
+	if sig != sigCollection && index != 0 {
+		return nil, fmt.Errorf("-font-index %d given, but the font is not a collection (.ttc)", index)
+	}
+
+	switch sig {
+	case sigTrueType, sigCFF:
+		return data, nil
+	case sigAppleTrue:
+		// Same tables as any TrueType font, under another name
+		data = append([]byte(nil), data...)
+		binary.BigEndian.PutUint32(data, sigTrueType)
+		return data, nil
+	case sigCollection:
+		// The parsers take the first font of a collection, so make the
+		// wanted one first: the rest of the file stays as it is, since
+		// table offsets are relative to the start of the file
+		numFonts := int(binary.BigEndian.Uint32(data[8:]))
+		if len(data) < 12+4*numFonts {
+			return nil, errors.New("font collection header is truncated")
+		}
+		if index < 0 || index >= numFonts {
+			return nil, fmt.Errorf("-font-index %d out of range: the collection has %d fonts (0-%d)", index, numFonts, numFonts-1)
+		}
+		data = append([]byte(nil), data...)
+		copy(data[12:16], data[12+4*index:16+4*index])
+		return data, nil
+	case sigWOFF, sigWOFF2:
+		return nil, errors.New("WOFF web fonts are compressed; decompress to .ttf first")
+	default:
+		return nil, errors.New("not a TrueType or OpenType font")
+	}
+}
+
+// isCFF reports whether the font prepared in data, or the first font of a
+// prepared collection, has PostScript (CFF) outlines.
+func isCFF(data []byte) bool {
+	if len(data) < 16 {
+		return false
+	}
+	sig := binary.BigEndian.Uint32(data)
+	if sig == sigCollection {
+		offset := int64(binary.BigEndian.Uint32(data[12:]))
+		if offset+4 > int64(len(data)) {
+			return false
+		}
+		sig = binary.BigEndian.Uint32(data[offset:])
+	}
+	return sig == sigCFF
+}
+
+// fontMediaType returns the media type of the font in data, as used in a
+// data URI.
+func fontMediaType(data []byte) string {
+	if isCFF(data) {
+		return "font/otf"
+	}
+	return "font/ttf"
+}
+
+// typeface is a parsed font. TrueType outlines are measured and drawn
+// with freetype, as they always were; PostScript (CFF) outlines, which
+// freetype can't read, are measured with sfnt and drawn through a
+// font.Face. Either way a typeface is only read, so renders can share it.
+type typeface struct {
+	tt  *truetype.Font // TrueType outlines
+	cff *sfnt.Font     // CFF outlines, if tt is nil
+}
+
+// parseTypeface parses a font prepared by prepareFont, or the first font
+// of a collection.
+func parseTypeface(data []byte) (*typeface, error) {
+	if !isCFF(data) {
+		tt, err := freetype.ParseFont(data)
+		if err != nil {
+			return nil, err
+		}
+		return &typeface{tt: tt}, nil
+	}
+	var f *sfnt.Font
+	c, err := sfnt.ParseCollection(data) // Also reads a single font
+	if err == nil {
+		f, err = c.Font(0)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &typeface{cff: f}, nil
+}
+
+// name returns the full name of the font, or "" if it has none.
+func (f *typeface) name() string {
+	if f.tt != nil {
+		return f.tt.Name(truetype.NameIDFontFullName)
+	}
+	name, _ := f.cff.Name(nil, sfnt.NameIDFull)
+	return name
+}
+
+// newFace returns a face of the font at size points and dpi with hinting.
+func (f *typeface) newFace(size, dpi float64, hinting font.Hinting) font.Face {
+	if f.tt != nil {
+		return truetype.NewFace(f.tt, &truetype.Options{Size: size, DPI: dpi, Hinting: hinting})
+	}
+	face, _ := opentype.NewFace(f.cff, &opentype.FaceOptions{Size: size, DPI: dpi, Hinting: hinting}) // Never fails
+	return face
+}
+
+// cffScale returns the pixels per em at size points and dpi, rounded as
+// opentype faces round it, so that glyphs measured with sfnt line up with
+// the ones the face draws.
+func cffScale(size, dpi float64) fixed.Int26_6 {
+	return fixed.Int26_6(0.5 + size*dpi*64/72)
+}
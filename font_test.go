@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/gofont/gobold"
+	"golang.org/x/image/math/fixed"
+)
+
+// makeCollection packs fonts into a .ttc, moving each font's tables and
+// rebasing their offsets onto the start of the collection.
+func makeCollection(fonts ...[]byte) []byte {
+	data := make([]byte, 12+4*len(fonts))
+	copy(data, "ttcf")
+	binary.BigEndian.PutUint32(data[4:], 0x00010000)
+	binary.BigEndian.PutUint32(data[8:], uint32(len(fonts)))
+	for i, f := range fonts {
+		base := len(data)
+		binary.BigEndian.PutUint32(data[12+4*i:], uint32(base))
+		f = append([]byte(nil), f...)
+		for t := range int(binary.BigEndian.Uint16(f[4:])) {
+			rec := f[12+16*t:]
+			binary.BigEndian.PutUint32(rec[8:], binary.BigEndian.Uint32(rec[8:])+uint32(base))
+		}
+		data = append(data, f...)
+	}
+	return data
+}
+
+func fontName(t *testing.T, data []byte) string {
+	t.Helper()
+	f, err := truetype.Parse(data)
+	if err != nil {
+		t.Fatalf("parsing prepared font: %v", err)
+	}
+	return f.Name(truetype.NameIDFontFullName)
+}
+
+func TestPrepareFontCollection(t *testing.T) {
+	ttc := makeCollection(fontBytes, gobold.TTF)
+	want := []string{fontName(t, fontBytes), fontName(t, gobold.TTF)}
+	for i, name := range want {
+		data, err := prepareFont(ttc, i)
+		if err != nil {
+			t.Fatalf("index %d: %v", i, err)
+		}
+		if got := fontName(t, data); got != name {
+			t.Errorf("index %d selected %q, want %q", i, got, name)
+		}
+	}
+	if _, err := prepareFont(ttc, 2); err == nil || !strings.Contains(err.Error(), "has 2 fonts") {
+		t.Errorf("index past the end: err = %v", err)
+	}
+	if got := fontName(t, ttc); got != want[0] {
+		t.Errorf("collection not left intact: first font is %q", got)
+	}
+}
+
+func TestPrepareFontSignatures(t *testing.T) {
+	if data, err := prepareFont(fontBytes, 0); err != nil || &data[0] != &fontBytes[0] {
+		t.Errorf("plain TrueType font not passed through: %v", err)
+	}
+	apple := append([]byte("true"), fontBytes[4:]...)
+	data, err := prepareFont(apple, 0)
+	if err != nil {
+		t.Fatalf("\"true\" signature: %v", err)
+	}
+	fontName(t, data)
+
+	cff := append([]byte("OTTO"), fontBytes[4:]...)
+	if data, err := prepareFont(cff, 0); err != nil || !isCFF(data) {
+		t.Errorf("\"OTTO\" signature: %v", err)
+	}
+
+	cases := map[string]string{
+		"wOF2": "WOFF",
+		"GIF8": "not a TrueType or OpenType font",
+	}
+	for sig, want := range cases {
+		_, err := prepareFont(append([]byte(sig), fontBytes[4:]...), 0)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("%s: err = %v, want it to mention %q", sig, err, want)
+		}
+	}
+	if _, err := prepareFont(fontBytes, 1); err == nil || !strings.Contains(err.Error(), "not a collection") {
+		t.Errorf("-font-index on a single font: err = %v", err)
+	}
+}
+
+// loadTestCFF returns a small OpenType font with CFF outlines, from the sfnt
+// package's tests. It has glyphs for 0, 1 and Q.
+func loadTestCFF(t *testing.T) []byte {
+	t.Helper()
+	data, err := loadFont(filepath.Join("testdata", "CFFTest.otf"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseTypefaceCFF(t *testing.T) {
+	data := loadTestCFF(t)
+	f, err := parseTypeface(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f.cff == nil || f.name() != "CFFTest" {
+		t.Errorf("parsed as CFF %v, named %q", f.cff != nil, f.name())
+	}
+	if got := fontMediaType(data); got != "font/otf" {
+		t.Errorf("media type %q, want font/otf", got)
+	}
+
+	// Glyphs are laid out side by side, above the baseline except for the
+	// tail of the Q
+	offsets, ext := layoutLine(f, 100, dpi, hintingModes[hintingFull], 0, "Q01")
+	if len(offsets) != 3 || offsets[1] <= offsets[0] || offsets[2] <= offsets[1] || ext.Advance <= offsets[2] {
+		t.Errorf("pen offsets %v, advance %v", offsets, ext.Advance)
+	}
+	if ext.InkMax <= ext.InkMin || ext.InkTop >= 0 || ext.InkBottom <= 0 {
+		t.Errorf("ink of %+v", ext)
+	}
+	if _, tracked := layoutLine(f, 100, dpi, hintingModes[hintingFull], 10, "Q01"); tracked.Advance != ext.Advance+fixed.I(20) {
+		t.Errorf("tracking moved the advance from %v to %v", ext.Advance, tracked.Advance)
+	}
+
+	// The first font of a collection decides how it is parsed
+	data, err = prepareFont(makeCollection(fontBytes, data), 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if f, err := parseTypeface(data); err != nil || f.cff == nil {
+		t.Errorf("CFF font from a collection: %v", err)
+	}
+}
+
+// TestGoldenCFF renders captions in a CFF font, stamped and stroked.
+func TestGoldenCFF(t *testing.T) {
+	fontData := loadTestCFF(t)
+	cases := []struct {
+		name string
+		opts Options
+	}{
+		{"cff", Options{Text: "Q1 0Q"}},
+		{"cff-stroke", Options{Text: "Q1 0Q", OutlineStyle: outlineStroke, Position: positionBottom}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := run(tc.opts, &buf, loadTestTemplate(t), fontData); err != nil {
+				t.Fatalf("run(%+v): %v", tc.opts, err)
+			}
+			compareGolden(t, filepath.Join("testdata", "golden", tc.name+".png"), buf.Bytes())
+		})
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"math"
+	"strings"
+)
+
+// parseGradient parses a comma-separated list of two or more colors, as
+// accepted by parseColor, into gradient stops from top to bottom.
+func parseGradient(s string) ([]color.NRGBA, error) {
+	parts := strings.Split(s, ",")
+	if len(parts) < 2 {
+		return nil, errors.New("want at least two colors separated by commas, e.g. #FFDD00,#FF3300")
+	}
+	stops := make([]color.NRGBA, len(parts))
+	for i, p := range parts {
+		c, err := parseColor(strings.TrimSpace(p))
+		if err != nil {
+			return nil, fmt.Errorf("stop %d: %w", i+1, err)
+		}
+		stops[i] = c
+	}
+	return stops, nil
+}
+
+// gradient is a vertical gradient through evenly spaced stops, the first at
+// row top and the last at row bottom, each held beyond its end. Like
+// image.Uniform it has no edges, so it can be the source of any draw.
+type gradient struct {
+	stops       []color.NRGBA
+	top, bottom int
+}
+
+func (g *gradient) ColorModel() color.Model { return color.NRGBAModel }
+
+func (g *gradient) Bounds() image.Rectangle {
+	return image.Rect(-1e9, -1e9, 1e9, 1e9)
+}
+
+// At interpolates between the stops on either side of the pixel center in
+// straight alpha, as SVG does.
+func (g *gradient) At(_, y int) color.Color {
+	t := 0.0
+	if g.bottom > g.top {
+		t = min(max((float64(y)+0.5-float64(g.top))/float64(g.bottom-g.top), 0), 1)
+	}
+	pos := t * float64(len(g.stops)-1)
+	i := min(int(pos), len(g.stops)-2)
+	f := pos - float64(i)
+	a, b := g.stops[i], g.stops[i+1]
+	lerp := func(x, y uint8) uint8 { return uint8(math.Round(float64(x) + f*(float64(y)-float64(x)))) }
+	return color.NRGBA{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B), A: lerp(a.A, b.A)}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestParseGradient(t *testing.T) {
+	stops, err := parseGradient("#FFDD00, red,#00f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []color.NRGBA{{255, 221, 0, 255}, {255, 0, 0, 255}, {0, 0, 255, 255}}
+	if len(stops) != len(want) || stops[0] != want[0] || stops[1] != want[1] || stops[2] != want[2] {
+		t.Errorf("parseGradient = %v, want %v", stops, want)
+	}
+	for _, bad := range []string{"#FFDD00", "#FFDD00,nope", ""} {
+		if _, err := parseGradient(bad); err == nil {
+			t.Errorf("parseGradient(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestGradientAt(t *testing.T) {
+	g := &gradient{
+		stops: []color.NRGBA{{0, 0, 0, 255}, {200, 100, 0, 255}, {200, 100, 200, 255}},
+		top:   10, bottom: 110,
+	}
+	cases := []struct {
+		y    int
+		want color.NRGBA
+	}{
+		{-50, color.NRGBA{0, 0, 0, 255}},       // Held above the top
+		{34, color.NRGBA{98, 49, 0, 255}},      // Just short of halfway to the middle stop
+		{59, color.NRGBA{198, 99, 0, 255}},     // Just short of the middle stop
+		{84, color.NRGBA{200, 100, 98, 255}},   // Three quarters
+		{500, color.NRGBA{200, 100, 200, 255}}, // Held below the bottom
+	}
+	for _, tc := range cases {
+		if got := g.At(0, tc.y); got != tc.want {
+			t.Errorf("At(0, %d) = %v, want %v", tc.y, got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"io"
+	"math"
+
+	"golang.org/x/image/math/fixed"
+)
+
+// Fit modes accepted in Options.Fit
+const (
+	fitShrink = "shrink" // Reduce the font size until the caption fits (default)
+	fitError  = "error"  // Fail if the caption doesn't fit at its font size
+	fitClip   = "clip"   // Draw at the font size regardless, cutting off what overflows
+)
+
+// Fit results reported in captionLayout.FitResult
+const (
+	fitFits      = "fits"
+	fitShrunk    = "shrunk"
+	fitOverflows = "overflows"
+)
+
+// Output formats accepted in Options.Format
+const (
+	formatPNG = "png"
+	formatSVG = "svg" // Template embedded as a PNG, captions as text
+)
+
+// layout is the complete placement of everything drawn on the canvas. It is
+// computed once by computeLayout and consumed both by the renderer and by
+// -measure, so what is reported can never drift from what is drawn.
+type layout struct {
+	Width     int              `json:"width"`  // Output image width in pixels
+	Height    int              `json:"height"` // Output image height in pixels
+	Format    string           `json:"format"`
+	Template  pixelRect        `json:"template"`              // Where the (scaled) template is drawn
+	Overlays  []pixelRect      `json:"overlays,omitempty"`    // Where each overlay is drawn, possibly off-canvas
+	Bar       *pixelRect       `json:"caption_bar,omitempty"` // The added strip in caption-bar mode
+	Poster    *posterLayout    `json:"poster,omitempty"`      // The frame in demotivational mode
+	Captions  []captionLayout  `json:"captions"`
+	Bubbles   []bubbleLayout   `json:"bubbles,omitempty"` // Speech bubbles, drawn after the captions
+	Watermark *watermarkLayout `json:"watermark,omitempty"`
+}
+
+// captionLayout is the placement of one caption block.
+type captionLayout struct {
+	Area       *pixelRect   `json:"area"`      // The box the caption is placed in
+	FontSize   float64      `json:"font_size"` // Points, after any fitting
+	LineHeight int          `json:"line_height"`
+	Position   string       `json:"position"`
+	Align      string       `json:"align"`
+	Rotate     float64      `json:"rotate,omitempty"` // Degrees clockwise, applied around Block's center
+	Arc        float64      `json:"arc,omitempty"`    // Degrees each line is bent along, positive upwards
+	Lines      []lineLayout `json:"lines"`
+	Block      *pixelRect   `json:"block,omitempty"`    // Union of the lines' glyph boxes
+	TextBox    *pixelRect   `json:"text_box,omitempty"` // Present with -textbox
+	// Clamped reports that at least one line is wider than the image and
+	// was pinned to the left edge instead of centered.
+	Clamped bool `json:"clamped"`
+	// Fit is the -fit mode applied, and FitResult what came of it:
+	// fitFits at the box's font size, fitShrunk at a smaller one, or
+	// fitOverflows when the text runs out of the area anyway.
+	Fit       string `json:"fit"`
+	FitResult string `json:"fit_result"`
+	// TextHeight is the height the lines need, padding included, and
+	// AvailableHeight the height of the area.
+	TextHeight      int `json:"text_height"`
+	AvailableHeight int `json:"available_height"`
+
+	fill    color.NRGBA // Zero means fillColor
+	outline color.NRGBA // Zero means outlineColor
+	plain   bool        // Drawn in fill without an outline
+	margin  image.Point // The box padding around Block, sampled by -auto-color
+}
+
+// lineLayout is the placement of one caption line.
+type lineLayout struct {
+	Text     string     `json:"text"`
+	Width    int        `json:"width"`         // Glyph ink width in pixels
+	X        float64    `json:"x"`             // Pen start, may be fractional
+	Baseline int        `json:"baseline"`      // Baseline y
+	Box      *pixelRect `json:"box,omitempty"` // Glyph bounds; absent for blank lines
+	Clamped  bool       `json:"clamped,omitempty"`
+	// Condense is the horizontal scale applied to a line slightly too wide
+	// to fit, absent for lines drawn at their natural width.
+	Condense float64 `json:"condense,omitempty"`
+
+	pt    fixed.Point26_6 // Exact pen start used for drawing
+	ink   image.Rectangle // Glyph bounds at pt, before condensing
+	shift int             // Horizontal offset applied when compositing a condensed line
+}
+
+// pixelRect is a rectangle in output pixel coordinates, as reported in the
+// measure JSON.
+type pixelRect struct {
+	X int `json:"x"`
+	Y int `json:"y"`
+	W int `json:"w"`
+	H int `json:"h"`
+}
+
+// newPixelRect converts r, returning nil for an empty rectangle.
+func newPixelRect(r image.Rectangle) *pixelRect {
+	if r.Empty() {
+		return nil
+	}
+	return &pixelRect{X: r.Min.X, Y: r.Min.Y, W: r.Dx(), H: r.Dy()}
+}
+
+// rect converts back to an image.Rectangle; nil is the empty rectangle.
+func (r *pixelRect) rect() image.Rectangle {
+	if r == nil {
+		return image.Rectangle{}
+	}
+	return image.Rect(r.X, r.Y, r.X+r.W, r.Y+r.H)
+}
+
+// captionBox is a region of the canvas holding one caption. The ordinary
+// single caption is a box covering the whole canvas; -spec supplies
+// several.
+type captionBox struct {
+	Rect     image.Rectangle
+	Text     string
+	Position string      // Vertical anchoring: positionTop, positionMiddle or positionBottom
+	Align    string      // alignLeft, alignCenter or alignRight
+	Fill     color.NRGBA // Zero means fillColor
+	Plain    bool        // Draw the text in Fill without an outline
+	Size     float64     // Font size in points, the most the fit search tries
+	PadX     int         // Space kept clear inside Rect at the sides
+	PadY     int         // Space kept clear inside Rect at the top and bottom
+}
+
+// width returns the width available to the box's lines drawn in style,
+// less what their ends spill out on an arc.
+func (b captionBox) width(style textStyle) int {
+	w := b.Rect.Dx() - 2*(b.PadX+style.thickness)
+	return w - 2*arcSide(style, w)
+}
+
+// scaled returns the box with its rectangle, font size and padding
+// multiplied by scale, for -scale. Scale 0 means 1.
+func (b captionBox) scaled(scale float64) captionBox {
+	if scale == 0 {
+		return b
+	}
+	b.Rect = image.Rect(scalePx(b.Rect.Min.X, scale), scalePx(b.Rect.Min.Y, scale),
+		scalePx(b.Rect.Max.X, scale), scalePx(b.Rect.Max.Y, scale))
+	b.Size *= scale
+	b.PadX, b.PadY = scalePx(b.PadX, scale), scalePx(b.PadY, scale)
+	return b
+}
+
+// captionStyle returns the text style used for captions at size points,
+// which are already scaled; the pixel settings from opts are scaled here.
+func captionStyle(ttFont *typeface, size float64, opts Options) textStyle {
+	style := newTextStyle(ttFont, size)
+	style.hinting = hintingModes[opts.Hinting]
+	style.tracking = scalePx(opts.Tracking, opts.Scale)
+	style.metrics = opts.Metrics.scaled(opts.Scale)
+	style.outline = opts.OutlineStyle
+	style.thickness = captionThickness(opts)
+	style.faces = opts.faces
+	style.arc, _ = clampArc(opts.Arc)
+	return style
+}
+
+// captionThickness returns the caption outline width in pixels: in
+// proportion to -size, so that small captions keep their counters open,
+// and scaled, but never below one pixel.
+func captionThickness(opts Options) int {
+	size := cmp.Or(opts.Size, fontSize)
+	return max(1, scalePx(outlineThickness, cmp.Or(opts.Scale, 1)*size/fontSize))
+}
+
+// computeLayout places the template, captions and watermark for a template
+// scaled to tmpl, -scale included. Normally the caption is drawn on the
+// template itself; in caption-bar mode the canvas grows by a strip that
+// holds the caption, and in demotivational mode the template is framed on a
+// poster with the title and subtitle beneath.
+func computeLayout(tmpl image.Rectangle, ttFont *typeface, opts Options) (*layout, error) {
+	lay := &layout{Format: opts.Format}
+	switch opts.Format {
+	case "":
+		lay.Format = formatPNG
+	case formatPNG, formatSVG:
+	default:
+		return nil, fmt.Errorf("unknown output format %q (want png or svg)", opts.Format)
+	}
+	if opts.Arc != 0 {
+		opts.NoCondense = true // Glyphs on an arc are placed one by one, not squashed as a line
+	}
+	canvas := tmpl
+	boxes := opts.Boxes
+	switch {
+	case opts.Demotivational:
+		if opts.CaptionBar || len(boxes) > 0 {
+			return nil, errors.New("a demotivational poster cannot be combined with a caption bar or a spec")
+		}
+		var poster posterLayout
+		canvas, tmpl, poster, boxes = layoutPoster(tmpl, ttFont, opts)
+		lay.Poster = &poster
+	case len(boxes) > 0:
+		if opts.CaptionBar {
+			return nil, errors.New("a caption bar cannot be combined with a spec")
+		}
+		boxes = make([]captionBox, len(opts.Boxes))
+		for i, b := range opts.Boxes {
+			boxes[i] = b.scaled(opts.Scale)
+		}
+		if err := validateBoxes(boxes, tmpl); err != nil {
+			return nil, err
+		}
+	default:
+		// The single caption is the degenerate one-box spec: the whole
+		// image, centered, at the caption size
+		box := captionBox{
+			Text:     opts.Text,
+			Position: opts.Position,
+			Align:    alignCenter,
+			Size:     fontSize,
+			PadX:     paddingX,
+			PadY:     paddingY,
+		}
+		if opts.Size > 0 {
+			box.Size = opts.Size
+		}
+		if opts.Padding != nil {
+			box.PadX, box.PadY = *opts.Padding, *opts.Padding
+		}
+		box = box.scaled(opts.Scale)
+		box.Rect = tmpl
+		if opts.TextRect != "" {
+			r, err := textRect(tmpl, opts.TextRect, opts.Scale)
+			if err != nil {
+				return nil, err
+			}
+			box.Rect = r
+			if box.Position == "" {
+				box.Position = positionMiddle
+			}
+		}
+		if opts.Position == alignLeft || opts.Position == alignRight {
+			box.Position, box.Align = positionMiddle, opts.Position
+		}
+		if opts.TextRect == "" {
+			asked := box.Size
+			adaptToAspect(&box)
+			if opts.Size > 0 {
+				// Banners are sized from their height, but no larger than asked
+				box.Size = min(box.Size, asked)
+			}
+		}
+		if opts.CaptionBar {
+			// The bar fits the wrapped text with the padding above the
+			// first line's ascent and below the last line's descent.
+			style := captionStyle(ttFont, box.Size, opts)
+			lines, err := wrapCaption(box, style, !opts.NoCondense)
+			if err != nil {
+				return nil, err
+			}
+			height, err := linesHeight(style, lines)
+			if err != nil {
+				return nil, err
+			}
+			barHeight := 2*box.PadY + height
+			centered := barHeight < opts.minBarHeight
+			barHeight = max(barHeight, opts.minBarHeight)
+			canvas = image.Rect(0, 0, tmpl.Dx(), tmpl.Dy()+barHeight)
+			switch opts.CaptionBarPosition {
+			case positionTop, "":
+				box.Rect = image.Rect(0, 0, tmpl.Dx(), barHeight)
+				tmpl = tmpl.Add(image.Pt(0, barHeight))
+			case positionBottom:
+				box.Rect = image.Rect(0, tmpl.Dy(), tmpl.Dx(), canvas.Dy())
+			default:
+				return nil, fmt.Errorf("unknown caption bar position %q (want top or bottom)", opts.CaptionBarPosition)
+			}
+			lay.Bar = newPixelRect(box.Rect)
+			box.Position = positionTop // Within the bar
+			if centered {
+				box.Position = positionMiddle // Of a bar made taller for another frame
+			}
+			// Plain text reads best on the flat caption bar
+			box.Plain, box.Fill = true, opts.CaptionBarTextColor
+			if box.Fill == (color.NRGBA{}) {
+				box.Fill = defaultBarTextColor
+			}
+		}
+		boxes = []captionBox{box}
+	}
+	lay.Width, lay.Height = canvas.Dx(), canvas.Dy()
+	lay.Template = *newPixelRect(tmpl)
+	if len(opts.Overlays) > 0 {
+		overlays, err := layoutOverlays(tmpl, opts.Overlays, opts.Scale)
+		if err != nil {
+			return nil, err
+		}
+		lay.Overlays = overlays
+	}
+
+	for i, box := range boxes {
+		caption, err := layoutBox(box, ttFont, opts)
+		if err != nil {
+			if len(opts.Boxes) > 0 {
+				return nil, fmt.Errorf("box %d: %w", i+1, err)
+			}
+			return nil, err
+		}
+		if opts.TextRect != "" && caption.FitResult == fitOverflows && opts.Fit != fitClip {
+			return nil, fmt.Errorf("caption does not fit in text rectangle %q even at %gpt", opts.TextRect, caption.FontSize)
+		}
+		lay.Captions = append(lay.Captions, caption)
+	}
+	if len(opts.Bubbles) > 0 {
+		bubbles, err := layoutBubbles(canvas, tmpl, ttFont, opts)
+		if err != nil {
+			return nil, err
+		}
+		lay.Bubbles = bubbles
+	}
+
+	if opts.Watermark != "" {
+		wm, err := layoutWatermark(canvas, ttFont, opts)
+		if err != nil {
+			return nil, err
+		}
+		lay.Watermark = wm
+	}
+	return lay, nil
+}
+
+// textRect resolves a -text-rect for a template placed at tmpl, -scale
+// included. As with overlays, the coordinates refer to the template before
+// -scale; the rectangle must lie inside it.
+func textRect(tmpl image.Rectangle, spec string, scale float64) (image.Rectangle, error) {
+	if scale == 0 {
+		scale = 1
+	}
+	w, h := int(math.Round(float64(tmpl.Dx())/scale)), int(math.Round(float64(tmpl.Dy())/scale))
+	vals, err := parseCoords(spec, 4, w, h)
+	if err != nil {
+		return image.Rectangle{}, fmt.Errorf("text rectangle: %w", err)
+	}
+	x, y, rw, rh := vals[0], vals[1], vals[2], vals[3]
+	if rw <= 0 || rh <= 0 {
+		return image.Rectangle{}, fmt.Errorf("text rectangle %q: size %dx%d must be positive", spec, rw, rh)
+	}
+	if r := image.Rect(x, y, x+rw, y+rh); !r.In(image.Rect(0, 0, w, h)) {
+		return image.Rectangle{}, fmt.Errorf("text rectangle %q: %d,%d %dx%d lies outside the %dx%d template",
+			spec, x, y, rw, rh, w, h)
+	}
+	r := image.Rect(scalePx(x, scale), scalePx(y, scale), scalePx(x+rw, scale), scalePx(y+rh, scale))
+	return r.Add(tmpl.Min).Intersect(tmpl), nil
+}
+
+// bannerAspect is the aspect ratio (long side over short side) from which a
+// template counts as a banner or a strip, where the usual fixed caption
+// size is either far too small or far too wide.
+const bannerAspect = 4.0
+
+// adaptToAspect adjusts the single-caption box for extreme aspect ratios.
+// On a wide banner the caption is sized from the height, one line filling
+// it, and centered vertically unless a position was asked for; top and
+// bottom would be nearly the same place anyway. On a tall strip it keeps
+// the usual size but is allowed to shrink so single words fit the width.
+// The padding across the short side shrinks with it.
+// In both cases the fit search measures the real text, so a caption that
+// cannot be wrapped ends up as one line shrunk to fit.
+func adaptToAspect(box *captionBox) {
+	w, h := float64(box.Rect.Dx()), float64(box.Rect.Dy())
+	switch {
+	case w >= bannerAspect*h:
+		box.PadY = min(box.PadY, box.Rect.Dy()/16)
+		// At 72 dpi a point is a pixel; one em is the most a line can take
+		box.Size = max(h-2*float64(box.PadY), minFitSize)
+		if box.Position == "" {
+			box.Position = positionMiddle
+		}
+	case h >= bannerAspect*w:
+		box.PadX = min(box.PadX, box.Rect.Dx()/16)
+	}
+}
+
+// layoutBox wraps and places the caption of one box at the largest font
+// size, down from the box's, at which the wrapped text fits, or as the -fit
+// mode says when it doesn't fit at the box's size. The box's size is
+// first capped at the box height, as text one em taller than its box
+// can't fit in it.
+func layoutBox(box captionBox, ttFont *typeface, opts Options) (captionLayout, error) {
+	mode := opts.Fit
+	if mode == "" {
+		mode = fitShrink
+	}
+	condense := !opts.NoCondense
+	attempt := func(size float64) (style textStyle, lines []string, check fitCheck, err error) {
+		style = captionStyle(ttFont, size, opts)
+		if lines, err = wrapCaption(box, style, condense); err != nil {
+			return style, nil, fitCheck{}, err
+		}
+		check, err = box.fits(style, lines, condense)
+		return style, lines, check, err
+	}
+
+	size := capFontSize(box.Size, box.Rect.Dy())
+	if mode == fitShrink {
+		var err error
+		size, _, err = largestFit(size, func(size float64) (bool, error) {
+			_, _, check, err := attempt(size)
+			return check.ok(), err
+		})
+		if err != nil {
+			return captionLayout{}, err
+		}
+	}
+	style, lines, check, err := attempt(size)
+	if err != nil {
+		return captionLayout{}, err
+	}
+	if !check.ok() && mode == fitError {
+		return captionLayout{}, check.err()
+	}
+	cl, err := layoutCaption(box, style, lines, opts)
+	if err != nil {
+		return captionLayout{}, err
+	}
+	cl.Fit, cl.FitResult = mode, fitFits
+	switch {
+	case !check.ok():
+		cl.FitResult = fitOverflows
+	case size < box.Size:
+		cl.FitResult = fitShrunk
+	}
+	cl.TextHeight, cl.AvailableHeight = check.height, check.available
+	return cl, nil
+}
+
+// capFontSize returns size lowered in whole points until one em is no
+// taller than height pixels, but not below minFitSize, keeping the sizes
+// the fit search tries the same as from size itself.
+func capFontSize(size float64, height int) float64 {
+	if limit := max(float64(height)*72/dpi, minFitSize); size > limit {
+		size -= math.Ceil(size - limit)
+	}
+	return size
+}
+
+// largestFit returns the largest font size, from size down to minFitSize
+// in steps of one point, at which fits reports that the text fits, and
+// whether it fits at all; if it doesn't, the size is minFitSize. The
+// sizes are bisected rather than tried one by one, taking text that fits
+// at a size to fit at every smaller one too.
+func largestFit(size float64, fits func(size float64) (bool, error)) (float64, bool, error) {
+	steps := 0 // Of one point, to minFitSize
+	if size > minFitSize {
+		steps = int(math.Ceil(size - minFitSize))
+	}
+	at := func(step int) float64 { return max(size-float64(step), minFitSize) }
+	ok, err := fits(size)
+	if ok || err != nil || steps == 0 {
+		return size, ok, err
+	}
+	if ok, err := fits(at(steps)); !ok || err != nil {
+		return at(steps), false, err
+	}
+	lo, hi := 0, steps // Too big, and fits
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := fits(at(mid))
+		if err != nil {
+			return 0, false, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return at(hi), true, nil
+}
+
+// fitCheck is how wrapped lines compare with the space in their box.
+type fitCheck struct {
+	height, available int // Text height with padding, and the box height
+
+	// The first line too wide to fit even condensed, if any
+	wideLine            string
+	wideWidth, maxWidth int
+}
+
+func (c fitCheck) ok() bool {
+	return c.height <= c.available && c.wideLine == ""
+}
+
+// err describes why the text doesn't fit, for -fit error.
+func (c fitCheck) err() error {
+	if c.height > c.available {
+		return fmt.Errorf("caption does not fit: needs %dpx, have %dpx", c.height, c.available)
+	}
+	return fmt.Errorf("caption does not fit: %q needs %dpx, have %dpx", c.wideLine, c.wideWidth, c.maxWidth)
+}
+
+// fits checks whether lines drawn in style fit inside the box.
+func (b captionBox) fits(style textStyle, lines []string, condense bool) (fitCheck, error) {
+	height, err := linesHeight(style, lines)
+	if err != nil {
+		return fitCheck{}, err
+	}
+	check := fitCheck{height: 2*b.PadY + height, available: b.Rect.Dy()}
+	for _, line := range lines {
+		ext, err := style.measure(line)
+		if err != nil {
+			return fitCheck{}, fmt.Errorf("measuring text width: %w", err)
+		}
+		if ext.inkWidth() > b.width(style) && (!condense || condenseScale(ext.inkWidth(), b.width(style)) == 0) {
+			check.wideLine, check.wideWidth, check.maxWidth = line, ext.inkWidth(), b.width(style)
+			break
+		}
+	}
+	return check, nil
+}
+
+// textHeight returns the height of n lines in style, from the first line's
+// ascent to the last line's descent.
+func textHeight(style textStyle, n int) int {
+	ascent, descent := style.verticalMetrics()
+	return ascent + descent + (n-1)*style.lineHeight()
+}
+
+// linesHeight returns the height of lines in style, from the first line's
+// ascent to the last line's descent, with the room they need on an arc.
+func linesHeight(style textStyle, lines []string) (int, error) {
+	room, err := linesArcRoom(style, lines)
+	if err != nil {
+		return 0, err
+	}
+	return textHeight(style, len(lines)) + len(lines)*room.spacing(), nil
+}
+
+// wrapCaption wraps the box's text to its width. Lines that can be
+// condensed to fit are not broken.
+func wrapCaption(box captionBox, style textStyle, condense bool) ([]string, error) {
+	limit := box.width(style)
+	if condense {
+		limit = int(float64(limit) * (1 + maxCondense))
+	}
+	lines, err := wrapText(box.Text, limit, wrapWidth(style))
+	if err != nil {
+		return nil, fmt.Errorf("measuring text width: %w", err)
+	}
+	return lines, nil
+}
+
+// layoutCaption aligns each line horizontally within the box and stacks the
+// lines from its top or bottom edge, or around its middle.
+func layoutCaption(box captionBox, style textStyle, lines []string, opts Options) (captionLayout, error) {
+	// Lines on an arc are spaced further apart by the room they need
+	room, err := linesArcRoom(style, lines)
+	if err != nil {
+		return captionLayout{}, err
+	}
+	lineHeight := style.lineHeight() + room.spacing()
+	ascent, descent := style.verticalMetrics()
+	area := box.Rect
+	arc := style.arc
+
+	position := box.Position
+	if position == "" {
+		position = positionTop
+	}
+	var firstBaseline int
+	switch position {
+	case positionTop:
+		// baseline = top padding + font ascent
+		firstBaseline = area.Min.Y + box.PadY + ascent + room.rise
+	case positionMiddle:
+		height := textHeight(style, len(lines)) + len(lines)*room.spacing()
+		firstBaseline = area.Min.Y + (area.Dy()-height)/2 + ascent + room.rise
+	case positionBottom:
+		// The last baseline sits the font's descent above the bottom padding
+		// so descenders are not clipped; earlier lines stack upwards.
+		firstBaseline = area.Max.Y - box.PadY - descent - room.drop - (len(lines)-1)*lineHeight
+	default:
+		return captionLayout{}, fmt.Errorf("unknown caption position %q", position)
+	}
+
+	cl := captionLayout{
+		Area:       newPixelRect(area),
+		FontSize:   style.size,
+		LineHeight: lineHeight,
+		Position:   position,
+		Align:      box.Align,
+		Rotate:     normalizeDegrees(opts.Rotate),
+		Arc:        arc,
+		fill:       box.Fill,
+		plain:      box.Plain,
+		margin:     image.Pt(box.PadX, box.PadY),
+	}
+	if !box.Plain {
+		if cl.fill == (color.NRGBA{}) {
+			cl.fill = opts.Fill
+		}
+		cl.outline = opts.Outline
+	}
+	// Edges the ink is aligned to for left and right alignment
+	left, right := area.Min.X+box.PadX+style.thickness, area.Max.X-box.PadX-style.thickness
+
+	var block image.Rectangle
+	for i, line := range lines {
+		ext, err := style.measure(line)
+		if err != nil {
+			return captionLayout{}, fmt.Errorf("measuring text width: %w", err)
+		}
+
+		var scale float64
+		if !opts.NoCondense {
+			scale = condenseScale(ext.inkWidth(), box.width(style))
+		}
+		// Calculate starting X so the inked glyphs are centered, to
+		// sub-pixel precision
+		x, clamped := ext.centeredX(area.Dx())
+		if scale != 0 {
+			// Condensing fits the line, so center it even though its
+			// natural width would overflow
+			x, clamped = (fixed.I(area.Dx())-(ext.InkMax-ext.InkMin))/2-ext.InkMin, false
+		}
+		pt := fixed.Point26_6{X: fixed.I(area.Min.X) + x, Y: fixed.I(firstBaseline + i*lineHeight)}
+		ink := ext.inkRect(pt)
+		lineBox := ink
+		if scale != 0 {
+			lineBox = condenseRect(ink, scale)
+		}
+
+		// Left and right alignment move the finished line sideways. A
+		// condensed line is still drawn centered and shifted as it is
+		// composited.
+		shift := 0
+		if !lineBox.Empty() {
+			switch box.Align {
+			case alignLeft:
+				shift, clamped = left-lineBox.Min.X, false
+			case alignRight:
+				shift, clamped = right-lineBox.Max.X, false
+			}
+		}
+		lineBox = lineBox.Add(image.Pt(shift, 0))
+		if scale == 0 {
+			pt.X += fixed.I(shift)
+			ink, shift = lineBox, 0
+		}
+		l := lineLayout{
+			Text:     line,
+			Width:    lineBox.Dx(),
+			X:        float64(pt.X) / 64,
+			Baseline: pt.Y.Floor(),
+			Clamped:  clamped,
+			Condense: scale,
+			pt:       pt,
+			ink:      ink,
+			shift:    shift,
+		}
+		if arc != 0 && !lineBox.Empty() {
+			lineBox = bentRect(l, arc) // Where the ink ends up
+		}
+		l.Box = newPixelRect(lineBox)
+		block = block.Union(lineBox)
+		cl.Lines = append(cl.Lines, l)
+		cl.Clamped = cl.Clamped || clamped
+	}
+	cl.Block = newPixelRect(block)
+
+	if opts.TextBox && !block.Empty() {
+		// Pad beyond the glyphs so the outline sits comfortably inside
+		cl.TextBox = newPixelRect(block.Inset(-(scalePx(textBoxPadding, opts.Scale) + style.thickness)))
+	}
+	return cl, nil
+}
+
+// writeLayoutJSON writes lay as indented JSON for -measure.
+func writeLayoutJSON(w io.Writer, lay *layout) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(lay); err != nil {
+		return fmt.Errorf("writing layout JSON: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"image"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// TestMeasureMatchesRender checks that -measure reports the layout that is
+// actually drawn: every line's box lies inside the image and the box of the
+// rendered ink matches the reported caption block.
+func TestMeasureMatchesRender(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	// Two lines of the caption font don't fit the 270px test template
+	opts := Options{Text: "HI\nTHERE", Width: 960, Watermark: "@memegen", WatermarkSize: 48}
+
+	var jsonBuf bytes.Buffer
+	measureOpts := opts
+	measureOpts.Measure = true
+	if err := run(measureOpts, &jsonBuf, templateData, fontBytes); err != nil {
+		t.Fatalf("measure: %v", err)
+	}
+	var lay layout
+	if err := json.Unmarshal(jsonBuf.Bytes(), &lay); err != nil {
+		t.Fatalf("decoding layout JSON: %v\n%s", err, jsonBuf.Bytes())
+	}
+
+	if lay.Width != 960 || lay.Height != 540 || lay.Format != "png" {
+		t.Errorf("image = %dx%d %s, want 960x540 png", lay.Width, lay.Height, lay.Format)
+	}
+	if len(lay.Captions[0].Lines) != 2 {
+		t.Fatalf("got %d lines, want 2", len(lay.Captions[0].Lines))
+	}
+	bounds := image.Rect(0, 0, lay.Width, lay.Height)
+	for _, l := range lay.Captions[0].Lines {
+		if l.Box == nil || !l.Box.rect().In(bounds) {
+			t.Errorf("line %q: box %+v not inside the image", l.Text, l.Box)
+		}
+		if l.Clamped {
+			t.Errorf("line %q unexpectedly clamped", l.Text)
+		}
+	}
+	if got := lay.Captions[0].Lines[1].Baseline - lay.Captions[0].Lines[0].Baseline; got != lay.Captions[0].LineHeight {
+		t.Errorf("baseline step = %d, want line height %d", got, lay.Captions[0].LineHeight)
+	}
+	if lay.Watermark == nil || lay.Watermark.Shrunk {
+		t.Errorf("watermark = %+v, want an unshrunk placement", lay.Watermark)
+	}
+
+	// Render the caption alone and compare its ink with the reported block.
+	// The outline extends the ink by outlineThickness on every side.
+	captionOnly := opts
+	captionOnly.Watermark = ""
+	var pngBuf bytes.Buffer
+	if err := run(captionOnly, &pngBuf, templateData, fontBytes); err != nil {
+		t.Fatalf("render: %v", err)
+	}
+	img, err := png.Decode(&pngBuf)
+	if err != nil {
+		t.Fatalf("decoding render: %v", err)
+	}
+	var baseBuf bytes.Buffer
+	if err := run(Options{Width: 960}, &baseBuf, templateData, fontBytes); err != nil {
+		t.Fatalf("render without caption: %v", err)
+	}
+	base, err := png.Decode(&baseBuf)
+	if err != nil {
+		t.Fatalf("decoding render without caption: %v", err)
+	}
+	var ink image.Rectangle
+	for y := 0; y < lay.Height; y++ {
+		for x := 0; x < lay.Width; x++ {
+			if img.At(x, y) != base.At(x, y) {
+				ink = ink.Union(image.Rect(x, y, x+1, y+1))
+			}
+		}
+	}
+	block := lay.Captions[0].Block.rect().Inset(-outlineThickness)
+	if d := diffRect(ink, block); d > 1 {
+		t.Errorf("rendered ink %v differs from reported block %v by %dpx", ink, block, d)
+	}
+}
+
+// diffRect returns the largest distance between corresponding edges of a
+// and b.
+func diffRect(a, b image.Rectangle) int {
+	d := 0
+	for _, v := range []int{a.Min.X - b.Min.X, a.Min.Y - b.Min.Y, a.Max.X - b.Max.X, a.Max.Y - b.Max.Y} {
+		d = max(d, v, -v)
+	}
+	return d
+}
+
+// TestCaptionBarLayout checks that caption-bar mode grows the canvas by a
+// strip holding the whole caption and leaves the template untouched.
+func TestCaptionBarLayout(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	base, err := png.Decode(bytes.NewReader(templateData))
+	if err != nil {
+		t.Fatalf("decoding template: %v", err)
+	}
+
+	for _, position := range []string{positionTop, positionBottom} {
+		opts := Options{Text: "WHEN THE BAR FITS", CaptionBar: true, CaptionBarPosition: position}
+
+		var jsonBuf bytes.Buffer
+		measureOpts := opts
+		measureOpts.Measure = true
+		if err := run(measureOpts, &jsonBuf, templateData, fontBytes); err != nil {
+			t.Fatalf("%s: measure: %v", position, err)
+		}
+		var lay layout
+		if err := json.Unmarshal(jsonBuf.Bytes(), &lay); err != nil {
+			t.Fatalf("%s: decoding layout JSON: %v", position, err)
+		}
+		if lay.Bar == nil {
+			t.Fatalf("%s: no caption bar in layout", position)
+		}
+		bar, tmpl := lay.Bar.rect(), lay.Template.rect()
+		if lay.Width != 480 || lay.Height != 270+bar.Dy() || tmpl.Size() != image.Pt(480, 270) {
+			t.Errorf("%s: canvas %dx%d, template %v, bar %v", position, lay.Width, lay.Height, tmpl, bar)
+		}
+		if (position == positionTop) != (bar.Min.Y == 0) || bar.Overlaps(tmpl) {
+			t.Errorf("%s: bar %v misplaced relative to template %v", position, bar, tmpl)
+		}
+		if len(lay.Captions[0].Lines) < 2 {
+			t.Errorf("%s: caption not wrapped: %d line(s)", position, len(lay.Captions[0].Lines))
+		}
+		if !lay.Captions[0].Block.rect().In(bar) {
+			t.Errorf("%s: caption %v outside the bar %v", position, lay.Captions[0].Block, bar)
+		}
+
+		var pngBuf bytes.Buffer
+		if err := run(opts, &pngBuf, templateData, fontBytes); err != nil {
+			t.Fatalf("%s: render: %v", position, err)
+		}
+		img, err := png.Decode(&pngBuf)
+		if err != nil {
+			t.Fatalf("%s: decoding render: %v", position, err)
+		}
+		for y := 0; y < 270; y++ {
+			for x := 0; x < 480; x++ {
+				if got, want := img.At(x+tmpl.Min.X, y+tmpl.Min.Y), base.At(x, y); got != want {
+					t.Fatalf("%s: template pixel (%d,%d) changed: %v, want %v", position, x, y, got, want)
+				}
+			}
+		}
+	}
+}
+
+// TestAspectLayout pins the caption size and placement chosen for banner
+// (10:1) and strip (1:10) canvases.
+func TestAspectLayout(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	cases := []struct {
+		name     string
+		bounds   image.Rectangle
+		opts     Options
+		size     float64
+		position string
+		align    string
+		lines    int
+	}{
+		// One line sized from the height, centered vertically
+		{"banner", image.Rect(0, 0, 960, 96), Options{Text: "MOST WIDE BANNER"}, 64, positionMiddle, alignCenter, 1},
+		// Too long for one line at full height, and two lines can never fit
+		{"banner unwrappable", image.Rect(0, 0, 960, 96), Options{Text: "THIS CAPTION IS MUCH TOO LONG TO FIT ON A BANNER AT THE FULL HEIGHT"}, 52, positionMiddle, alignCenter, 1},
+		{"banner right", image.Rect(0, 0, 960, 96), Options{Text: "SALE", Position: alignRight}, 64, positionMiddle, alignRight, 1},
+		{"banner top", image.Rect(0, 0, 960, 96), Options{Text: "SALE", Position: positionTop}, 64, positionTop, alignCenter, 1},
+		// Shrunk until the longest word fits the width
+		{"strip", image.Rect(0, 0, 60, 600), Options{Text: "TALL AND NARROW"}, 23, positionTop, alignCenter, 3},
+		// Ordinary proportions keep the fixed size
+		{"ordinary", image.Rect(0, 0, 480, 270), Options{Text: "HI"}, fontSize, positionTop, alignCenter, 1},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			lay, err := computeLayout(tc.bounds, ttFont, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cl := lay.Captions[0]
+			if cl.FontSize != tc.size || cl.Position != tc.position || cl.Align != tc.align || len(cl.Lines) != tc.lines {
+				t.Errorf("got %vpt %s/%s with %d lines, want %vpt %s/%s with %d lines",
+					cl.FontSize, cl.Position, cl.Align, len(cl.Lines), tc.size, tc.position, tc.align, tc.lines)
+			}
+			if tc.name != "ordinary" && !cl.Block.rect().In(tc.bounds) {
+				t.Errorf("caption %+v does not fit the %v canvas", cl.Block, tc.bounds)
+			}
+		})
+	}
+}
+
+// TestFitModes checks what each -fit mode does with a caption too tall for
+// the default 270px caption area at the full font size.
+func TestFitModes(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	bounds := image.Rect(0, 0, 480, 270)
+	text := "ONE\nTWO\nTHREE"
+
+	lay, err := computeLayout(bounds, ttFont, Options{Text: text})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl := lay.Captions[0]
+	if cl.Fit != fitShrink || cl.FitResult != fitShrunk || cl.FontSize >= fontSize {
+		t.Errorf("shrink: got %s/%s at %vpt, want %s/%s below %vpt", cl.Fit, cl.FitResult, cl.FontSize, fitShrink, fitShrunk, fontSize)
+	}
+	if cl.TextHeight > cl.AvailableHeight || !cl.Block.rect().In(bounds) {
+		t.Errorf("shrink: %dpx of text in %dpx, block %+v", cl.TextHeight, cl.AvailableHeight, cl.Block)
+	}
+
+	lay, err = computeLayout(bounds, ttFont, Options{Text: text, Fit: fitClip})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cl = lay.Captions[0]
+	if cl.FitResult != fitOverflows || cl.FontSize != fontSize || cl.TextHeight <= cl.AvailableHeight {
+		t.Errorf("clip: got %s at %vpt with %dpx in %dpx, want %s at %vpt", cl.FitResult, cl.FontSize, cl.TextHeight, cl.AvailableHeight, fitOverflows, fontSize)
+	}
+
+	_, err = computeLayout(bounds, ttFont, Options{Text: text, Fit: fitError})
+	want := fmt.Sprintf("caption does not fit: needs %dpx, have %dpx", cl.TextHeight, cl.AvailableHeight)
+	if err == nil || err.Error() != want {
+		t.Errorf("error: got %v, want %q", err, want)
+	}
+
+	// A caption that fits is left alone in every mode
+	lay, err = computeLayout(bounds, ttFont, Options{Text: "HI", Fit: fitError})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl := lay.Captions[0]; cl.FitResult != fitFits || cl.FontSize != fontSize {
+		t.Errorf("fits: got %s at %vpt, want %s at %vpt", cl.FitResult, cl.FontSize, fitFits, fontSize)
+	}
+}
+
+// TestLargestFit checks that the bisection finds the size a scan down in
+// one point steps would, in a handful of tries.
+func TestLargestFit(t *testing.T) {
+	cases := []struct {
+		from, limit float64 // Text fits at sizes up to limit
+		want        float64
+		ok          bool
+	}{
+		{144, 200, 144, true},
+		{144, 100, 100, true},
+		{144, 99.5, 99, true},
+		{144.5, 100, 99.5, true},
+		{144, 8, 8, true},
+		{144, 7, 8, false},
+		{8, 7, 8, false},
+		{5, 10, 5, true},
+		{1e9, 300, 300, true},
+	}
+	for _, tc := range cases {
+		tries := 0
+		got, ok, err := largestFit(tc.from, func(size float64) (bool, error) {
+			tries++
+			return size <= tc.limit, nil
+		})
+		if err != nil || got != tc.want || ok != tc.ok {
+			t.Errorf("from %v fitting up to %v: got %v, %v, %v, want %v, %v", tc.from, tc.limit, got, ok, err, tc.want, tc.ok)
+		}
+		if tries > 40 {
+			t.Errorf("from %v fitting up to %v: %d tries", tc.from, tc.limit, tries)
+		}
+	}
+}
+
+// TestHugeSize checks that the largest -size is laid out promptly, at a
+// size that fits.
+func TestHugeSize(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lay, err := computeLayout(image.Rect(0, 0, 480, 270), ttFont, Options{Text: "HI", Size: maxFontSize})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cl := lay.Captions[0]; cl.FitResult != fitShrunk || cl.FontSize > 270 {
+		t.Errorf("got %s at %vpt, want shrunk to fit 270px", cl.FitResult, cl.FontSize)
+	}
+}
+
+// TestTextRect checks that -text-rect confines the caption to its
+// rectangle, wrapping and shrinking it there, and rejects rectangles off
+// the template or too small for the caption.
+func TestTextRect(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	bounds := image.Rect(0, 0, 480, 270)
+	text := "ONE DOES NOT SIMPLY WALK INTO MORDOR"
+
+	for _, tc := range []struct {
+		name string
+		opts Options
+		want image.Rectangle
+	}{
+		{"pixels", Options{Text: text, TextRect: "240,135,220,120"}, image.Rect(240, 135, 460, 255)},
+		{"expression", Options{Text: text, TextRect: "@w/2,0,w/2,h/2", Position: alignLeft}, image.Rect(240, 0, 480, 135)},
+		{"scaled", Options{Text: text, TextRect: "240,135,220,120", Scale: 2}, image.Rect(480, 270, 920, 510)},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			b := bounds
+			if tc.opts.Scale != 0 {
+				b = image.Rect(0, 0, scalePx(b.Dx(), tc.opts.Scale), scalePx(b.Dy(), tc.opts.Scale))
+			}
+			lay, err := computeLayout(b, ttFont, tc.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			cl := lay.Captions[0]
+			if got := cl.Area.rect(); got != tc.want {
+				t.Errorf("area %v, want %v", got, tc.want)
+			}
+			if len(cl.Lines) < 2 || cl.FitResult != fitShrunk || !cl.Block.rect().In(tc.want) {
+				t.Errorf("got %d lines, %s, block %+v; want wrapped and shrunk inside %v", len(cl.Lines), cl.FitResult, cl.Block, tc.want)
+			}
+			if cl.Position != positionMiddle {
+				t.Errorf("position %s, want middle", cl.Position)
+			}
+		})
+	}
+
+	for _, tc := range []struct{ rect, want string }{
+		{"400,135,220,120", "lies outside the 480x270 template"},
+		{"10,10,0,50", "must be positive"},
+		{"10,10,50", "want 4 comma-separated values"},
+		{"240,135,20,12", "does not fit"},
+	} {
+		_, err := computeLayout(bounds, ttFont, Options{Text: text, TextRect: tc.rect})
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("-text-rect %s: got %v, want an error containing %q", tc.rect, err, tc.want)
+		}
+	}
+	if _, err := computeLayout(bounds, ttFont, Options{Text: text, TextRect: "240,135,20,12", Fit: fitClip}); err != nil {
+		t.Errorf("-fit clip: %v", err)
+	}
+}
+
+// TestScaleLayout checks that -scale multiplies the output size exactly and
+// lays the caption out as the unscaled one, only larger.
+func TestScaleLayout(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	measure := func(opts Options) *layout {
+		t.Helper()
+		opts.Measure = true
+		var buf bytes.Buffer
+		if err := run(opts, &buf, templateData, fontBytes); err != nil {
+			t.Fatalf("measure %+v: %v", opts, err)
+		}
+		var lay layout
+		if err := json.Unmarshal(buf.Bytes(), &lay); err != nil {
+			t.Fatalf("decoding layout JSON: %v", err)
+		}
+		return &lay
+	}
+
+	base := measure(Options{Text: "HI THERE"})
+	double := measure(Options{Text: "HI THERE", Scale: 2})
+	if double.Width != 2*base.Width || double.Height != 2*base.Height {
+		t.Errorf("scale 2: image %dx%d, want %dx%d", double.Width, double.Height, 2*base.Width, 2*base.Height)
+	}
+	b, d := base.Captions[0], double.Captions[0]
+	if d.FontSize != 2*b.FontSize || d.LineHeight != 2*b.LineHeight {
+		t.Errorf("scale 2: font size %v, line height %d; want %v, %d", d.FontSize, d.LineHeight, 2*b.FontSize, 2*b.LineHeight)
+	}
+	// Hinting rounds differently at the two sizes, so allow a little slack
+	want := b.Block.rect()
+	want = image.Rect(2*want.Min.X, 2*want.Min.Y, 2*want.Max.X, 2*want.Max.Y)
+	if diff := diffRect(d.Block.rect(), want); diff > 4 {
+		t.Errorf("scale 2: block %v, want about %v", d.Block.rect(), want)
+	}
+
+	// Each dimension is rounded to the nearest pixel
+	if lay := measure(Options{Text: "HI", Width: 241, Scale: 1.5}); lay.Width != 362 || lay.Height != 204 {
+		t.Errorf("241x136 at scale 1.5: image %dx%d, want 362x204", lay.Width, lay.Height)
+	}
+}
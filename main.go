@@ -2,18 +2,23 @@ package main
 
 import (
 	"bytes"
+	"context"
 	_ "embed"
+	"errors"
+	"flag"
 	"fmt"
 	"image"
-	"image/draw"
+	"image/color"
 	"image/png"
 	"io"
+	"net/url"
 	"os"
+	"os/signal"
+	"runtime"
+	"strconv"
 	"strings"
-
-	"github.com/golang/freetype"
-	"github.com/golang/freetype/truetype"
-	"golang.org/x/image/font"
+	"syscall"
+	"time"
 )
 
 //go:embed template.png
@@ -26,44 +31,933 @@ const (
 	dpi              = 72.0  // Screen DPI
 	fontSize         = 144.0 // Font size in points
 	paddingY         = 20    // Padding from the top edge
+	paddingX         = 20    // Padding from the side edges when wrapping
 	outlineThickness = 2     // Outline width in pixels
+	textBoxPadding   = 12    // Text box padding around the glyphs in pixels
+	textBoxRadius    = 16    // Text box corner radius in pixels
 )
 
+// exitHalted is the exit status when -halt-file is present, distinct from
+// the generic failure status 1 so wrapper scripts can tell the two apart.
+const exitHalted = 7
+
 // Define colors
 var (
 	fillColor    = image.Black // Color for the text fill
 	outlineColor = image.White // Color for the text outline
+
+	defaultTextBoxColor = color.NRGBA{A: 128} // 50% black
+	defaultBarColor     = color.NRGBA{R: 255, G: 255, B: 255, A: 255}
+	defaultBarTextColor = color.NRGBA{A: 255}
+)
+
+// Caption positions accepted in Options.Position
+const (
+	positionTop    = "top"    // Caption block hangs from the top edge
+	positionMiddle = "middle" // Caption block is centered vertically (spec boxes)
+	positionBottom = "bottom" // Caption block sits on the bottom edge
+)
+
+// Horizontal alignments of spec boxes
+const (
+	alignLeft   = "left"
+	alignCenter = "center"
+	alignRight  = "right"
 )
 
+// minFitSize is the smallest font size, in points, spec boxes shrink to.
+const minFitSize = 8.0
+
+// maxFontSize is the largest font size, in points, the options accept.
+// Captions are also capped at the height of their box.
+const maxFontSize = 10000.0
+
+// Options controls what run() draws onto the template.
+type Options struct {
+	Text string // Caption text, drawn as given; "\n" starts a new line
+	// Position is positionTop, positionMiddle or positionBottom, or alignLeft
+	// or alignRight for a vertically centered caption aligned to that side.
+	// Empty means top, or middle on banner-shaped templates.
+	Position string
+
+	// Boxes replaces Text and Position with several independently fitted
+	// captions, as read from a -spec file
+	Boxes []captionBox
+
+	Watermark       string  // Optional credit line drawn small in a corner
+	WatermarkCorner string  // tl, tr, bl or br; empty means br
+	WatermarkSize   float64 // Watermark font size in points; 0 means default
+
+	// Width and Height scale the template before the text is drawn, so the
+	// caption is rasterized at the output resolution and stays crisp. Zero
+	// keeps the template's size; giving only one preserves the aspect ratio.
+	Width  int
+	Height int
+
+	// Scale renders everything this many times larger, for high-resolution
+	// assets: the output is Scale times the (resized) template, and the font
+	// sizes, padding, outline, tracking and spec boxes grow with it, so the
+	// text is rasterized natively. 0 means 1.
+	Scale float64
+
+	Tracking int // Extra pixels between caption glyphs, may be negative
+
+	// Size is the caption font size in points, the largest the fit search
+	// tries; 0 means fontSize. Padding is the space in pixels kept clear
+	// between the caption and the image edges; nil means paddingX and
+	// paddingY. Neither applies to -spec boxes, which have their own.
+	Size    float64
+	Padding *int
+
+	// TextRect, "x,y,w,h" in any of the forms parseCoords accepts, confines
+	// the single caption to that rectangle of the template at its
+	// -width/-height size, centered in it unless Position says otherwise.
+	// Unless Fit is fitClip, a caption that doesn't fit even at minFitSize
+	// is an error.
+	TextRect string
+
+	// Fit says what to do with a caption too big for its area: fitShrink
+	// (the default if empty), fitError or fitClip.
+	Fit string
+
+	// Hinting is hintingFull (the default if empty), hintingVertical or
+	// hintingNone, for both measuring and drawing text.
+	Hinting string
+
+	// Fill and Outline are the caption text and outline colors; zero means
+	// black text with a white outline. A -spec box's own fill wins over
+	// Fill. AutoColor picks whichever of black on white or white on black
+	// stands out from the background behind each caption, for the colors
+	// not given.
+	Fill      color.NRGBA
+	Outline   color.NRGBA
+	AutoColor bool
+
+	// FillOpacity and OutlineOpacity, from 0 to 1, make the caption text
+	// and its outline partially transparent; nil means 1. The text box and
+	// the watermark are unaffected.
+	FillOpacity    *float64
+	OutlineOpacity *float64
+
+	// OutlineStyle is outlineStamp (the default if empty) or outlineStroke,
+	// which draws smoother outlines from the glyph shapes.
+	OutlineStyle string
+
+	// Rotate tilts the caption clockwise by this many degrees around the
+	// center of the text block. 0 draws the caption directly.
+	Rotate float64
+
+	// Arc bends each caption line along a circular arc spanning this many
+	// degrees, up to ±maxArc: positive arches upwards, negative sags. 0
+	// draws straight lines.
+	Arc float64
+
+	Metrics metricsOverride // Corrections for fonts with wrong ascent/descent
+
+	// Filters are applied in order to the template before the captions
+	// are drawn, to tone it down or change its look.
+	Filters []imageFilter
+
+	// Overlays are composited in order onto the filtered template, before
+	// the captions are drawn. Their images must be loaded.
+	Overlays []overlay
+
+	// FillGradient, two or more colors, fills the caption text with a
+	// vertical gradient over the caption block instead of a flat color.
+	// Caption bars keep their plain text color.
+	FillGradient []color.NRGBA
+
+	// TextBox draws a rounded rectangle in TextBoxColor behind the caption
+	// block, for readability on busy templates.
+	TextBox      bool
+	TextBoxColor color.NRGBA // Zero means 50% black
+
+	// CaptionBar draws the caption in plain text on a strip added above or
+	// below the template instead of on the template itself.
+	CaptionBar          bool
+	CaptionBarPosition  string      // positionTop (default) or positionBottom
+	CaptionBarColor     color.NRGBA // Zero means white
+	CaptionBarTextColor color.NRGBA // Zero means black
+
+	// Demotivational frames the template on a black poster with Title, and
+	// Subtitle if not empty, in plain white beneath it, instead of drawing
+	// a caption on the template.
+	Demotivational bool
+	Title          string
+	Subtitle       string
+
+	// Bubbles are speech bubbles drawn after the captions, in order, each
+	// with its tail pointing at its coordinates. BubbleShape is bubbleRect
+	// (the default if empty) or bubbleEllipse, and BubbleSize the largest
+	// font size of their text in points; 0 means defaultBubbleSize.
+	Bubbles     []bubble
+	BubbleShape string
+	BubbleSize  float64
+
+	// FrameTexts, two or more, make an animated PNG with a frame per entry,
+	// captioned with it instead of Text; an empty entry is a frame without
+	// a caption. Each frame shows for FrameDelay (0 means 500ms), and the
+	// animation plays Loops times, 0 for forever.
+	FrameTexts []string
+	FrameDelay time.Duration
+	Loops      int
+
+	// Unique perturbs a few pixels outside the caption, seeded by
+	// UniqueSeed, so each seed yields a different file
+	Unique     bool
+	UniqueSeed uint64
+
+	// NoCondense always wraps lines that are too wide instead of squashing
+	// the ones that are only slightly too wide
+	NoCondense bool
+
+	// PNG output records the caption and TemplateName (empty for the
+	// built-in template) in text chunks unless NoMetadata is set.
+	TemplateName string
+	NoMetadata   bool
+
+	// Captions are cleaned up before layout: NFC-normalized unless
+	// NoNormalize is set, without control and format characters unless
+	// KeepControls is, and with runs of whitespace collapsed unless
+	// KeepSpaces is. MaxLength is the longest caption in characters; 0
+	// means defaultMaxLength and a negative value no limit.
+	NoNormalize  bool
+	KeepControls bool
+	KeepSpaces   bool
+	MaxLength    int
+
+	faces *faceCache // Set by the resources rendering, to share their faces
+
+	// minBarHeight is the least height of the caption bar in pixels, so
+	// that the frames of an animation share the tallest one's
+	minBarHeight int
+
+	// TextInput is the caption as given, before uppercasing, for sidecars;
+	// empty if it is the caption as drawn.
+	TextInput string
+
+	Format string // formatPNG (default) or formatSVG
+	Encode string // Optional text encoding of the output: encodeBase64 or encodeDataURI
+
+	// Measure makes run() write the computed layout as JSON instead of
+	// rendering a PNG.
+	Measure bool
+
+	// Log, if set, receives diagnostics such as the -auto-color choices.
+	Log io.Writer
+
+	// Warnings, if set, receives warnings such as about upscaling the
+	// template, instead of standard error.
+	Warnings io.Writer
+}
+
+// usage prints usage instructions to standard error.
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: %s [flags] \"<text>\" [output.png]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] -srt subs.srt (-at HH:MM:SS,mmm | -srt-index N) [output.png]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] -batch captions.txt [output-dir]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] -demotivational -title \"<text>\" [-subtitle \"<text>\"] [output.png]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s [flags] -panel a.png:\"<text>\" -panel b.png:\"<text>\" ... [output.png]\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "       %s dedupe [flags] add|check file.png\n", os.Args[0])
+	fmt.Fprintf(os.Stderr, "  <text>: The text to draw on the image, or - to read it from stdin.\n")
+	fmt.Fprintf(os.Stderr, "  [output.png]: Optional output PNG filename. If omitted, writes PNG to stdout.\n")
+	fmt.Fprintf(os.Stderr, "  Several output files may be given; each one's outcome is reported, and the\n")
+	fmt.Fprintf(os.Stderr, "  exit status is %d if only some could be written.\n", exitPartialFailure)
+	fmt.Fprintf(os.Stderr, "  With -post and no output file, the image is only uploaded, not written to stdout.\n")
+	fmt.Fprintf(os.Stderr, "  With -measure the layout is written as JSON instead of the PNG.\n")
+	fmt.Fprintf(os.Stderr, "Flags:\n")
+	flag.PrintDefaults()
+}
+
 // main handles command-line argument parsing, calls the core run function,
 // and manages program exit status based on errors.
 func main() {
-	if len(os.Args) < 2 || os.Args[1] == "" {
-		// Print usage instructions to standard error
-		fmt.Fprintf(os.Stderr, "Usage: %s \"<text>\" [output.png]\n", os.Args[0])
-		fmt.Fprintf(os.Stderr, "  <text>: The text to draw on the image.\n")
-		fmt.Fprintf(os.Stderr, "  [output.png]: Optional output PNG filename. If omitted, writes PNG to stdout.\n")
-		os.Exit(1) // Exit with error status 1
+	// Subcommands are dispatched before the caption flags are parsed
+	if len(os.Args) > 1 && os.Args[1] == "dedupe" {
+		status, err := runDedupe(os.Args[2:], os.Stdout)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		}
+		os.Exit(status)
+	}
+
+	templatePath := flag.String("template", "", "PNG, JPEG or GIF image to caption instead of the built-in template, or - for stdin")
+	fontPath := flag.String("font", "", "TrueType font (.ttf, .otf with TrueType outlines, or .ttc) to use instead of the built-in one")
+	fontIndex := flag.Int("font-index", 0, "With -font: which font of a .ttc collection to use, counting from 0")
+	srtPath := flag.String("srt", "", "SubRip (.srt) file to take a bottom caption from")
+	srtAt := flag.String("at", "", "With -srt: timestamp of the cue to render (HH:MM:SS,mmm)")
+	srtIndex := flag.Int("srt-index", 0, "With -srt: number of the cue to render, instead of -at")
+	watermark := flag.String("watermark", "", "Small credit line (e.g. \"@myhandle\") drawn in a corner, not uppercased")
+	watermarkCorner := flag.String("watermark-corner", defaultWatermarkCorner, "Corner for the watermark: tl, tr, bl or br")
+	watermarkSize := flag.Float64("watermark-size", defaultWatermarkSize, "Watermark font size in points")
+	width := flag.Int("width", 0, "Scale the template to this width before drawing text (keeps aspect ratio if -height is unset)")
+	tracking := flag.Int("tracking", 0, "Letter spacing in pixels added between caption glyphs (may be negative)")
+	height := flag.Int("height", 0, "Scale the template to this height before drawing text (keeps aspect ratio if -width is unset)")
+	scale := flag.Float64("scale", 1, "Render at this multiple of the template size (e.g. 2 for retina assets), text included")
+	size := flag.Float64("size", fontSize, "Caption font size in points (the caption still shrinks if it doesn't fit)")
+	padding := flag.Int("padding", paddingY, "Space in pixels between the caption and the image edges")
+	hinting := flag.String("hinting", hintingFull, "Glyph hinting: none (true to the font's shapes, best at large sizes), vertical or full")
+	fill := flag.String("fill", "", "Caption text color (default black)")
+	outline := flag.String("outline", "", "Caption outline color (default white)")
+	autoColor := flag.Bool("auto-color", false, "Pick black text on white or white on black, whichever stands out from the background behind the caption")
+	opacity := flag.Float64("opacity", 1, "Caption opacity from 0 (invisible) to 1, for the fill and the outline")
+	fillOpacity := flag.Float64("fill-opacity", 1, "Caption fill opacity, instead of -opacity")
+	outlineOpacity := flag.Float64("outline-opacity", 1, "Caption outline opacity, instead of -opacity")
+	outlineStyle := flag.String("outline-style", outlineStamp, "How to draw the text outline: stamp (fast) or stroke (smooth, from the glyph shapes)")
+	rotate := flag.Float64("rotate", 0, "Tilt the caption clockwise by this many degrees (negative for counter-clockwise)")
+	arc := flag.Float64("arc", 0, "Bend each caption line along an arc spanning this many degrees, up to 180 (positive curves upward, negative downward)")
+	metrics := flag.String("metrics-override", "", "Override font metrics used for placement, e.g. ascent=0.78,descent=0.22 (fractions of em, or px)")
+	textBox := flag.Bool("textbox", false, "Draw a rounded box behind the caption for readability")
+	filters := flag.String("filter", "", "Filters applied to the template in order, e.g. grayscale,brightness=-20: grayscale, sepia, invert, brightness=N, contrast=N (N in percent)")
+	var overlays overlayFlags
+	flag.Var(&overlays, "overlay", "Composite an image onto the template before the caption, as path.png@x,y[,scale] (repeatable, drawn in order)")
+	fillGradient := flag.String("fill-gradient", "", "Fill the caption with a vertical gradient through these colors, top to bottom, e.g. #FFDD00,#FF3300")
+	textBoxColor := flag.String("textbox-color", "#00000080", "Text box color as #RRGGBBAA (alpha included)")
+	position := flag.String("position", "", "Caption placement: top, middle, bottom, left or right (default top, middle on banners)")
+	var panels panelFlags
+	flag.Var(&panels, "panel", "Stack this template with its caption, as template.png:caption, under the previous panels (repeatable)")
+	panelPosition := flag.String("panel-position", positionTop, "With -panel: caption position in each panel, top, middle or bottom, or a comma-separated list with one per panel")
+	panelSeparator := flag.Int("panel-separator", defaultPanelSeparator, "With -panel: thickness in pixels of the line between panels, 0 for none")
+	panelSeparatorColor := flag.String("panel-separator-color", "black", "With -panel: color of the line between panels")
+	specPath := flag.String("spec", "", "JSON file describing several text boxes to fill instead of a single caption")
+	captionBar := flag.Bool("caption-bar", false, "Put the caption in plain text on a strip added above the template instead of on the image")
+	captionBarPosition := flag.String("caption-bar-position", positionTop, "With -caption-bar: put the strip at the top or bottom")
+	captionBarColor := flag.String("caption-bar-color", "white", "With -caption-bar: strip color")
+	captionBarTextColor := flag.String("caption-bar-text-color", "black", "With -caption-bar: caption color")
+	demotivational := flag.Bool("demotivational", false, "Make a demotivational poster: the template framed on black with -title and -subtitle beneath")
+	title := flag.String("title", "", "With -demotivational: the large title, uppercased")
+	subtitle := flag.String("subtitle", "", "With -demotivational: the smaller line under the title, drawn as given")
+	textRect := flag.String("text-rect", "", "Fit the caption inside this rectangle of the template, as x,y,w,h (e.g. 40,300,560,150 or @w/2,0,w/2,h)")
+	var bubbles bubbleFlags
+	flag.Var(&bubbles, "bubble", "Draw a speech bubble with its tail pointing at x,y, as \"text@x,y\", instead of the caption (repeatable, drawn in order)")
+	bubbleShape := flag.String("bubble-shape", bubbleRect, "With -bubble: rect (rounded) or ellipse")
+	bubbleSize := flag.Float64("bubble-size", defaultBubbleSize, "With -bubble: font size in points (the text still shrinks if the bubble doesn't fit)")
+	var frameTexts frameTextFlags
+	flag.Var(&frameTexts, "frame-text", "Make an animated PNG with a frame captioned with this text, instead of the caption argument (repeatable, shown in order)")
+	frames := flag.Int("frames", 0, "Make an animated PNG of this many frames: the caption blinking, or the -frame-text captions cycled")
+	frameDelay := flag.Duration("frame-delay", defaultFrameDelay, "With -frames or -frame-text: how long each frame shows")
+	loops := flag.Int("loop", 0, "With -frames or -frame-text: how many times the animation plays, 0 for forever")
+	fit := flag.String("fit", fitShrink, "When the caption doesn't fit: shrink the font, error out, or clip the overflow")
+	noCondense := flag.Bool("no-condense", false, "Wrap caption lines that are slightly too wide instead of squashing them horizontally")
+	noNormalize := flag.Bool("no-normalize", false, "Don't NFC-normalize captions (which composes decomposed accents)")
+	keepControls := flag.Bool("keep-controls", false, "Keep control characters and invisible format characters such as zero-width joiners in captions")
+	keepSpaces := flag.Bool("keep-spaces", false, "Keep runs of spaces in captions instead of collapsing them into one")
+	maxLength := flag.Int("max-length", defaultMaxLength, "Longest caption accepted, in characters, 0 for no limit")
+	unique := flag.Bool("unique", false, "Imperceptibly perturb a few pixels outside the caption so each run produces a different file")
+	uniqueSeed := flag.Uint64("unique-seed", 0, "With -unique: seed selecting the perturbed pixels (default: current time)")
+	format := flag.String("format", formatPNG, "Output format: png, or svg with the caption as editable text")
+	encode := flag.String("encode", "", "Write the image as text: base64, or datauri (data:image/png;base64,...)")
+	postURL := flag.String("post", "", "POST the image as multipart/form-data to this URL (e.g. a chat webhook) and report the response")
+	postField := flag.String("post-field-name", defaultPostField, "With -post: form field name for the image")
+	var postFields formFields
+	flag.Var(&postFields, "post-field", "With -post: extra form field as key=value (repeatable)")
+	postTimeout := flag.Duration("post-timeout", defaultPostTimeout, "With -post: give up if the upload hasn't completed in this long")
+	preview := flag.Bool("preview", false, "Also show the image in the terminal (on stderr when stdout carries the PNG or a -porcelain report)")
+	previewProtocol := flag.String("preview-protocol", previewAuto, "With -preview: iterm, sixel, blocks (Unicode half blocks), or auto to detect from TERM/TERM_PROGRAM")
+	batchPath := flag.String("batch", "", "Render one meme per line of this file into the output directory (default .) as meme-NNN.png")
+	jobs := flag.Int("jobs", runtime.NumCPU(), "With -batch: number of memes rendered in parallel")
+	skipDuplicates := flag.Bool("skip-duplicates", false, "With -batch: skip memes identical to one in the dedupe index, and add the ones written to it")
+	dedupeIndex := flag.String("dedupe-index", defaultDedupeIndex, "With -skip-duplicates: dedupe index file to read and update")
+	porcelain := flag.Bool("porcelain", false, "Report the outcome for each output file as JSON on stdout")
+	writeSidecars := flag.Bool("sidecar", false, "Also write <output>.json describing each output: caption, template, font, layout and SHA-256")
+	measure := flag.Bool("measure", false, "Print the computed layout as JSON instead of rendering a PNG")
+	haltFile := flag.String("halt-file", "", "If this file exists at startup, or appears between -batch items, stop and exit with status 7")
+	configPath := flag.String("config", "", "JSON file of flag defaults (default: memegen/config.json in the user config directory, if present)")
+	outPattern := flag.String("out", "", "Output file name pattern instead of output arguments, e.g. memes/{slug}-{n}.png ({slug}: caption, {n}: counter avoiding existing files, {date}: YYYYMMDD)")
+	force := flag.Bool("force", false, "Overwrite output files that already exist")
+	noMetadata := flag.Bool("no-metadata", false, "Don't record the caption and template name in PNG text chunks")
+	showMetadata := flag.String("show-metadata", "", "Print the text metadata of this PNG file, then exit")
+	verbose := flag.Bool("verbose", false, "Explain choices made while rendering, such as the -auto-color colors, on stderr")
+	printCfg := flag.Bool("print-config", false, "Print the effective configuration and where each value came from, then exit")
+	flag.Usage = usage
+	flag.Parse()
+	args := flag.Args()
+
+	// Config values fill in flags not given on the command line. Only an
+	// explicitly named config file has to exist.
+	cfgFile, cfgRequired := *configPath, *configPath != ""
+	if !cfgRequired {
+		cfgFile = defaultConfigPath()
+	}
+	cfg, err := loadConfig(cfgFile, cfgRequired)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+	sources, warnings, err := applyConfig(flag.CommandLine, cfg)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", cfgFile, err)
+		os.Exit(1)
+	}
+	if err := applyFontMetrics(flag.CommandLine, cfg, sources, *fontPath); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s: %v\n", cfgFile, err)
+		os.Exit(1)
+	}
+	for _, w := range warnings {
+		fmt.Fprintf(os.Stderr, "Warning: %s: %s\n", cfgFile, w)
+	}
+	if *printCfg {
+		if err := printConfig(os.Stdout, flag.CommandLine, sources); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+	if *showMetadata != "" {
+		if err := printPNGMetadata(os.Stdout, *showMetadata); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Scheduled jobs can be stopped by creating the halt file, without
+	// touching the crontab. A single stat is all this costs.
+	if haltRequested(*haltFile) {
+		fmt.Fprintf(os.Stderr, "Halted: %s exists, not rendering\n", *haltFile)
+		os.Exit(exitHalted)
+	}
+
+	// Problems with the options are collected and reported together
+	var invalid ValidationError
+	metricsOverride, err := parseMetricsOverride(*metrics)
+	invalid.addErr("metrics-override", *metrics, err)
+	validateZeroFlags(&invalid, *size, *bubbleSize, *scale, *watermarkSize)
+	if *jobs < 1 {
+		invalid.add("jobs", strconv.Itoa(*jobs), "must be at least 1", "use 1 to render one meme at a time")
+	}
+	boxColor, err := parseColor(*textBoxColor)
+	invalid.addErr("textbox-color", *textBoxColor, err)
+	barColor, err := parseColor(*captionBarColor)
+	invalid.addErr("caption-bar-color", *captionBarColor, err)
+	barTextColor, err := parseColor(*captionBarTextColor)
+	invalid.addErr("caption-bar-text-color", *captionBarTextColor, err)
+	var fillC, outlineC color.NRGBA
+	if *fill != "" {
+		fillC, err = parseColor(*fill)
+		invalid.addErr("fill", *fill, err)
+	}
+	if *outline != "" {
+		outlineC, err = parseColor(*outline)
+		invalid.addErr("outline", *outline, err)
+	}
+	var overlayList []overlay
+	for _, spec := range overlays {
+		o, err := parseOverlay(spec)
+		invalid.addErr("overlay", spec, err)
+		overlayList = append(overlayList, o)
+	}
+	var bubbleList []bubble
+	for _, spec := range bubbles {
+		b, err := parseBubble(spec)
+		invalid.addErr("bubble", spec, err)
+		bubbleList = append(bubbleList, b)
+	}
+	var panelPositions []string
+	if len(panels) > 0 {
+		panelPositions, err = parsePanelPositions(*panelPosition, len(panels))
+		invalid.addErr("panel-position", *panelPosition, err)
+	}
+	if *panelSeparator < 0 {
+		invalid.add("panel-separator", strconv.Itoa(*panelSeparator), "must not be negative", "use 0 for none")
+	}
+	if *maxLength < 0 {
+		invalid.add("max-length", strconv.Itoa(*maxLength), "must not be negative", "use 0 for no limit")
+	}
+	if *maxLength == 0 {
+		*maxLength = -1 // Options.MaxLength's no limit
+	}
+	if *frames < 0 {
+		invalid.add("frames", strconv.Itoa(*frames), "must not be negative", "use 2 or more to animate")
+	}
+	separatorColor, err := parseColor(*panelSeparatorColor)
+	invalid.addErr("panel-separator-color", *panelSeparatorColor, err)
+	var filterList []imageFilter
+	if *filters != "" {
+		filterList, err = parseFilters(*filters)
+		invalid.addErr("filter", *filters, err)
+	}
+	var namer *outputNamer
+	if *outPattern != "" {
+		namer, err = newOutputNamer(*outPattern, time.Now())
+		invalid.addErr("out", *outPattern, err)
+	}
+	var gradientStops []color.NRGBA
+	if *fillGradient != "" {
+		gradientStops, err = parseGradient(*fillGradient)
+		invalid.addErr("fill-gradient", *fillGradient, err)
+	}
+
+	// -fill-opacity and -outline-opacity default to -opacity
+	if sources["fill-opacity"] == sourceDefault {
+		*fillOpacity = *opacity
+	}
+	if sources["outline-opacity"] == sourceDefault {
+		*outlineOpacity = *opacity
+	}
+
+	// Seeding from the clock keeps run() itself deterministic
+	if *unique && *uniqueSeed == 0 {
+		*uniqueSeed = uint64(time.Now().UnixNano())
+	}
+
+	opts := Options{
+		Position:            *position,
+		Watermark:           *watermark,
+		WatermarkCorner:     *watermarkCorner,
+		WatermarkSize:       *watermarkSize,
+		Width:               *width,
+		Height:              *height,
+		Scale:               *scale,
+		Size:                *size,
+		Padding:             padding,
+		Tracking:            *tracking,
+		Hinting:             *hinting,
+		Fill:                fillC,
+		Outline:             outlineC,
+		AutoColor:           *autoColor,
+		FillOpacity:         fillOpacity,
+		OutlineOpacity:      outlineOpacity,
+		OutlineStyle:        *outlineStyle,
+		Rotate:              *rotate,
+		Arc:                 *arc,
+		Metrics:             metricsOverride,
+		TextBox:             *textBox,
+		TextBoxColor:        boxColor,
+		FillGradient:        gradientStops,
+		Filters:             filterList,
+		Overlays:            overlayList,
+		CaptionBar:          *captionBar,
+		CaptionBarPosition:  *captionBarPosition,
+		CaptionBarColor:     barColor,
+		CaptionBarTextColor: barTextColor,
+		Demotivational:      *demotivational,
+		Title:               strings.ToUpper(*title),
+		Subtitle:            *subtitle,
+		TextRect:            *textRect,
+		Fit:                 *fit,
+		NoCondense:          *noCondense,
+		NoNormalize:         *noNormalize,
+		KeepControls:        *keepControls,
+		KeepSpaces:          *keepSpaces,
+		MaxLength:           *maxLength,
+		Bubbles:             bubbleList,
+		BubbleShape:         *bubbleShape,
+		BubbleSize:          *bubbleSize,
+		FrameDelay:          *frameDelay,
+		Loops:               *loops,
+		Unique:              *unique,
+		UniqueSeed:          *uniqueSeed,
+		TemplateName:        templateName(*templatePath),
+		NoMetadata:          *noMetadata,
+		Format:              *format,
+		Encode:              *encode,
+		Measure:             *measure,
+	}
+	if *verbose {
+		opts.Log = os.Stderr
+	}
+	if verr, ok := validateOptions(opts).(*ValidationError); ok {
+		invalid.Issues = append(invalid.Issues, verr.Issues...)
+	}
+	if len(invalid.Issues) > 0 {
+		fmt.Fprintf(os.Stderr, "Error: invalid options:\n")
+		for _, line := range strings.Split(invalid.Error(), "\n") {
+			fmt.Fprintf(os.Stderr, "  %s\n", line)
+		}
+		os.Exit(1)
+	}
+	// Only a valid -arc is clamped, so that NaN is reported as invalid
+	// rather than warned about
+	if a, clamped := clampArc(opts.Arc); clamped {
+		fmt.Fprintf(os.Stderr, "Warning: -arc %g is beyond a semicircle, using %g\n", opts.Arc, a)
+		opts.Arc = a
+	}
+	// The template defaults to the embedded one
+	templateData := templateImageBytes
+	if *templatePath != "" {
+		captionFromArgs := *srtPath == "" && *specPath == "" && *batchPath == ""
+		if *templatePath == stdinPath && captionFromArgs && len(args) > 0 && args[0] == stdinPath {
+			fmt.Fprintf(os.Stderr, "Error: the template and the caption can't both come from stdin; give the caption as an argument\n")
+			os.Exit(1)
+		}
+		templateData, err = readTemplate(*templatePath, os.Stdin)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+	}
+
+	if err := loadOverlays(opts.Overlays); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+
+	fontData := fontBytes
+	if *fontPath != "" || *fontIndex != 0 {
+		if *fontPath == "" {
+			fmt.Fprintf(os.Stderr, "Error: -font-index needs -font\n")
+			os.Exit(1)
+		}
+		fontData, err = loadFont(*fontPath, *fontIndex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: -font %s: %v\n", *fontPath, err)
+			os.Exit(1)
+		}
+	}
+
+	switch {
+	case *srtPath != "" && *specPath != "":
+		fmt.Fprintf(os.Stderr, "Error: -srt and -spec cannot be combined\n")
+		os.Exit(1)
+	case *demotivational:
+		// The texts come from -title and -subtitle; the positional
+		// arguments are all output files
+		if *srtPath != "" || *specPath != "" || *batchPath != "" || len(panels) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: -demotivational cannot be combined with -srt, -spec, -batch or -panel\n")
+			os.Exit(1)
+		}
+		opts.TextInput = strings.TrimSuffix(*title+"\n"+*subtitle, "\n")
+	case len(panels) > 0:
+		// The panels are stacked into one template with a caption box per
+		// panel; the positional arguments are all output files
+		if *srtPath != "" || *specPath != "" || *batchPath != "" || *templatePath != "" || *height != 0 || *captionBar {
+			fmt.Fprintf(os.Stderr, "Error: -panel cannot be combined with -srt, -spec, -batch, -template, -height or -caption-bar\n")
+			os.Exit(1)
+		}
+		imgs, err := loadPanelImages(panels)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		stacked, rects := stackPanels(imgs, *width, *panelSeparator, separatorColor)
+		// run() takes the template encoded; skip compressing it
+		var buf bytes.Buffer
+		enc := png.Encoder{CompressionLevel: png.NoCompression}
+		if err := enc.Encode(&buf, stacked); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: stacking panels: %v\n", err)
+			os.Exit(1)
+		}
+		templateData = buf.Bytes()
+		texts := make([]string, len(panels))
+		for i := range panels {
+			texts[i] = panels[i].Text
+			panels[i].Text = strings.ToUpper(panels[i].Text)
+		}
+		opts.TextInput = strings.Join(texts, "\n")
+		opts.Boxes = panelBoxes(panels, rects, panelPositions, *panelSeparator, opts)
+		opts.Width = 0 // Already applied while stacking
+		opts.TemplateName = panelTemplateName(panels)
+	case *batchPath != "":
+		// Every line is a caption; the only positional argument left is
+		// the output directory
+		if *srtPath != "" || *specPath != "" || *measure || *encode != "" || *postURL != "" || *preview || *frames != 0 || len(frameTexts) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: -batch cannot be combined with -srt, -spec, -measure, -encode, -post, -preview, -frames or -frame-text\n")
+			os.Exit(1)
+		}
+		if len(args) > 1 || (namer != nil && len(args) > 0) {
+			fmt.Fprintf(os.Stderr, "Error: -batch takes at most one argument, the output directory, and none with -out\n")
+			os.Exit(1)
+		}
+		dir := "."
+		if len(args) == 1 {
+			dir = args[0]
+		}
+		captions, err := readBatchCaptions(*batchPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		// Created up front, as -out does, rather than failing every meme
+		if err := os.MkdirAll(dir, 0o777); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: creating output directory: %v\n", err)
+			os.Exit(1)
+		}
+		res, err := loadResources(templateData, fontData)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		var dedupe *batchDedupe
+		if *skipDuplicates {
+			if dedupe, err = loadBatchDedupe(*dedupeIndex); err != nil {
+				fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+				os.Exit(1)
+			}
+		}
+		res.canvases = newCanvasCache() // Every caption goes on the same background
+		res.faces = newFaceCache(res.font)
+		name := batchFileNames(dir, "."+*format, len(captions))
+		if namer != nil {
+			name = func(_ int, caption string) (string, error) { return namer.name(caption) }
+		}
+		// Warnings and diagnostics go through the progress line so as not
+		// to tear it
+		p := newProgress(os.Stderr, isTerminal(os.Stderr), len(captions))
+		opts.Warnings = p
+		if opts.Log != nil {
+			opts.Log = p
+		}
+		// Ctrl-C stops the renders in progress; finished files stay
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		result := runBatch(len(captions), *jobs, *haltFile, p, renderBatchJob(ctx, res, opts, captions, name, *force, *writeSidecars, dedupe))
+		stop()
+		p.finish()
+		if dedupe != nil {
+			if err := dedupe.save(); err != nil {
+				result.add(*dedupeIndex, 0, err)
+			}
+		}
+		if err := result.print(os.Stdout, *porcelain); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: writing report: %v\n", err)
+		}
+		if result.Halted {
+			fmt.Fprintf(os.Stderr, "Halted: %s appeared, %d of %d memes not rendered\n", *haltFile, result.Skipped, len(captions))
+		}
+		os.Exit(result.exitCode())
+	case *specPath != "":
+		// Captions come from the spec, leaving only the output filename
+		boxes, err := loadSpec(*specPath)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		texts := make([]string, len(boxes))
+		for i := range boxes {
+			texts[i] = boxes[i].Text
+			boxes[i].Text = strings.ToUpper(boxes[i].Text)
+		}
+		opts.Boxes = boxes
+		opts.TextInput = strings.Join(texts, "\n")
+		opts.Size = 0 // Boxes have their own sizes, and outlines to match the default
+	case *srtPath != "":
+		// The caption comes from the subtitle file, so the only positional
+		// argument left is the optional output filename.
+		text, err := srtCaption(*srtPath, *srtAt, *srtIndex)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		opts.Text, opts.TextInput = strings.ToUpper(text), text
+		if opts.Position == "" {
+			opts.Position = positionBottom // Where subtitles belong
+		}
+	case len(bubbles) > 0:
+		// The bubbles take the place of the caption; the positional
+		// arguments are all output files
+		if *srtPath != "" || *specPath != "" || len(frameTexts) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: -bubble cannot be combined with -srt, -spec or -frame-text\n")
+			os.Exit(1)
+		}
+		texts := make([]string, len(opts.Bubbles))
+		for i := range opts.Bubbles {
+			texts[i] = opts.Bubbles[i].Text
+			opts.Bubbles[i].Text = strings.ToUpper(opts.Bubbles[i].Text)
+		}
+		opts.TextInput = strings.Join(texts, "\n")
+	case len(frameTexts) > 0:
+		// The captions come from the flags; the positional arguments are
+		// all output files
+		if *srtPath != "" || *specPath != "" {
+			fmt.Fprintf(os.Stderr, "Error: -frame-text cannot be combined with -srt or -spec\n")
+			os.Exit(1)
+		}
+		opts.TextInput = strings.Join(frameTexts, "\n")
+		for i := range frameTexts {
+			frameTexts[i] = strings.ToUpper(frameTexts[i])
+		}
+		opts.Text = frameTexts[0]
+	default:
+		if len(args) < 1 || args[0] == "" {
+			flag.Usage()
+			os.Exit(1) // Exit with error status 1
+		}
+		text := args[0]
+		if text == stdinPath {
+			data, err := io.ReadAll(os.Stdin)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "Error: reading caption from stdin: %v\n", err)
+				os.Exit(1)
+			}
+			text = strings.TrimRight(string(data), "\r\n")
+		}
+		opts.Text, opts.TextInput = strings.ToUpper(text), text
+		args = args[1:]
+	}
+
+	if *frames != 0 || len(frameTexts) > 0 {
+		opts.FrameTexts = animationFrames(opts.Text, frameTexts, *frames)
+	}
+
+	// Any further arguments are output files; several may be given
+	outputs := args
+	if namer != nil {
+		if len(outputs) > 0 {
+			fmt.Fprintf(os.Stderr, "Error: -out cannot be combined with output file arguments\n")
+			os.Exit(1)
+		}
+		name, err := namer.name(captionText(opts))
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		outputs = []string{name}
+	}
+	for i, name := range outputs {
+		// Simple check and warning for a missing extension. Measurements
+		// and encoded images are text, so the name is left alone for them.
+		ext := "." + *format
+		if !*measure && *encode == "" && !strings.HasSuffix(strings.ToLower(name), ext) {
+			fmt.Fprintf(os.Stderr, "Warning: Output filename '%s' does not end with %s. Appending %s\n", name, ext, ext)
+			outputs[i] = name + ext
+		}
+	}
+
+	// A piped-in template says nothing about what should come out, so
+	// an image on stdout needs an explicit format
+	if *templatePath == stdinPath && len(outputs) == 0 && *postURL == "" && !*measure && sources["format"] == sourceDefault {
+		fmt.Fprintf(os.Stderr, "Error: with -template -, give an output file or an explicit -format\n")
+		os.Exit(1)
+	}
+
+	if *postURL != "" {
+		if u, err := url.Parse(*postURL); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			fmt.Fprintf(os.Stderr, "Error: -post needs an http or https URL\n")
+			os.Exit(1)
+		}
+		if *measure || *encode != "" {
+			fmt.Fprintf(os.Stderr, "Error: -post uploads the image; it can't be combined with -measure or -encode\n")
+			os.Exit(1)
+		}
+	}
+
+	if *preview {
+		switch {
+		case *measure || *encode != "" || *format != formatPNG:
+			fmt.Fprintf(os.Stderr, "Error: -preview shows PNG images; it can't be combined with -measure, -encode or -format %s\n", *format)
+			os.Exit(1)
+		case *previewProtocol != previewAuto && *previewProtocol != previewITerm &&
+			*previewProtocol != previewSixel && *previewProtocol != previewBlocks:
+			fmt.Fprintf(os.Stderr, "Error: unknown -preview-protocol %q (want auto, iterm, sixel or blocks)\n", *previewProtocol)
+			os.Exit(1)
+		}
+	}
+	// previewTo shows the rendered image if -preview asked for it, on
+	// stderr when stdout is taken by the image or a machine-read report.
+	previewTo := func(encoded []byte, stdoutTaken bool) {
+		if !*preview {
+			return
+		}
+		var w io.Writer = os.Stdout
+		if stdoutTaken {
+			w = os.Stderr
+		}
+		if err := showPreview(w, *previewProtocol, encoded); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		}
+	}
+
+	if *writeSidecars && (len(outputs) == 0 || *measure) {
+		fmt.Fprintf(os.Stderr, "Error: -sidecar describes output files; it needs one and can't be combined with -measure\n")
+		os.Exit(1)
+	}
+
+	if len(outputs) > 1 || *porcelain || *postURL != "" {
+		if len(outputs) == 0 && *porcelain && *postURL == "" {
+			fmt.Fprintf(os.Stderr, "Error: -porcelain needs an output file, stdout carries the report\n")
+			os.Exit(1)
+		}
+		// Render once, then deliver to every destination and report each
+		var buf bytes.Buffer
+		var sc *sidecar
+		if *writeSidecars {
+			sc, err = runSidecar(opts, &buf, templateData, fontData)
+		} else {
+			err = run(opts, &buf, templateData, fontData)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(1)
+		}
+		previewTo(buf.Bytes(), *porcelain)
+		result := writeFiles(buf.Bytes(), outputs, *force, sc)
+		if *postURL != "" {
+			response, err := postImage(postRequest{
+				URL:      *postURL,
+				Field:    *postField,
+				Filename: "meme." + *format,
+				MIMEType: formatMIMETypes[*format],
+				Fields:   postFields,
+				Timeout:  *postTimeout,
+			}, buf.Bytes())
+			sent := int64(buf.Len())
+			if response == "" {
+				sent = 0 // No answer, so nothing is known to have arrived
+			}
+			result.addUpload(redactURL(*postURL), sent, response, err)
+		}
+		if err := result.print(os.Stdout, *porcelain); err != nil {
+			fmt.Fprintf(os.Stderr, "Error: writing report: %v\n", err)
+		}
+		os.Exit(result.exitCode())
 	}
 
-	memeText := strings.ToUpper(os.Args[1])
 	outputFilename := ""
-	if len(os.Args) > 2 {
-		outputFilename = os.Args[2]
-		// Simple check and warning for non-PNG extension
-		if !strings.HasSuffix(strings.ToLower(outputFilename), ".png") {
-			fmt.Fprintf(os.Stderr, "Warning: Output filename '%s' does not end with .png. Appending .png\n", outputFilename)
-			outputFilename += ".png"
+	if len(outputs) > 0 {
+		outputFilename = outputs[0]
+	}
+
+	// Determine the output destination
+	var destWriter io.Writer = os.Stdout // Default to standard output
+	var outFile *outputFile
+	if outputFilename != "" {
+		outFile, err = createOutput(outputFilename, *force)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(exitCategory(err))
 		}
+		destWriter = outFile
+	}
+
+	// A reader closing stdout early (e.g. "| head -c 100") is not a failure
+	// from the user's point of view. Ignoring SIGPIPE turns the signal into
+	// an EPIPE write error that we can recognise and suppress below.
+	if outputFilename == "" {
+		signal.Ignore(syscall.SIGPIPE)
+	}
+
+	// Keep a copy of the image for the preview
+	var rendered bytes.Buffer
+	if *preview {
+		destWriter = io.MultiWriter(destWriter, &rendered)
 	}
 
 	// Execute the main application logic
-	err := run(memeText, outputFilename)
+	var sc *sidecar
+	if *writeSidecars {
+		sc, err = runSidecar(opts, destWriter, templateData, fontData)
+	} else {
+		err = run(opts, destWriter, templateData, fontData)
+	}
+	err = suppressBrokenPipe(err, outputFilename == "")
+	if outFile != nil {
+		// Only a complete image replaces what was there
+		if err != nil {
+			outFile.discard()
+		} else {
+			err = outFile.commit()
+		}
+	}
+	if err == nil && sc != nil {
+		err = writeSidecar(outputFilename, sc, *force)
+	}
 	if err != nil {
-		// Print any error returned by run() to standard error
+		// Print any error returned by run() to standard error, exiting
+		// with the same status as for several outputs
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1) // Exit with error status 1
+		os.Exit(exitCategory(err))
 	}
+	previewTo(rendered.Bytes(), outputFilename == "")
 
 	// If writing to a file and successful, print a confirmation message
 	if outputFilename != "" {
@@ -74,135 +968,286 @@ func main() {
 }
 
 // run encapsulates the core logic of loading resources, generating the image,
-// and writing the output. It returns an error if any step fails.
-func run(memeText, outputFilename string) error {
-	// --- 1. Determine Output Destination ---
-	var destWriter io.Writer = os.Stdout // Default to standard output
-	if outputFilename != "" {
-		outFile, err := os.Create(outputFilename)
-		if err != nil {
-			return fmt.Errorf("creating output file '%s': %w", outputFilename, err)
-		}
-		defer outFile.Close() // Ensure file is closed when function returns
-		destWriter = outFile
+// and writing the output. The template image and font are passed in as raw
+// bytes and the PNG is written to destWriter, so callers (and tests) decide
+// where resources come from and where the result goes. It returns an error
+// if any step fails.
+func run(opts Options, destWriter io.Writer, templateData, fontData []byte) error {
+	res, err := loadResources(templateData, fontData)
+	if err != nil {
+		return err
 	}
+	return res.render(opts, destWriter)
+}
+
+// resources are the decoded template and parsed font a meme is rendered
+// from. They are only read while rendering, so one set can be shared by
+// concurrent renders; each render draws on a canvas of its own, with its
+// own freetype contexts.
+type resources struct {
+	template image.Image
+	font     *typeface
+	fontData []byte // Raw font, embedded in SVG output
 
-	// --- 2. Load Template Image ---
-	imgReader := bytes.NewReader(templateImageBytes)
+	// canvases, if set, reuses backgrounds and canvases across renders
+	canvases *canvasCache
+	// faces, if set, reuses font faces across renders
+	faces *faceCache
+}
+
+// loadResources decodes the template image and parses the font.
+func loadResources(templateData, fontData []byte) (*resources, error) {
+	// --- 1. Load Template Image ---
+	imgReader := bytes.NewReader(templateData)
 	baseImg, _, err := image.Decode(imgReader) // Format is not used, ignore it
+	if errors.Is(err, image.ErrFormat) {
+		return nil, fmt.Errorf("decoding template image: %w (want PNG, JPEG or GIF)", err)
+	}
 	if err != nil {
-		return fmt.Errorf("decoding template image: %w", err)
+		return nil, fmt.Errorf("decoding template image: %w", err)
 	}
 
-	// --- 3. Load Font ---
-	ttFont, err := freetype.ParseFont(fontBytes)
+	// --- 2. Load Font ---
+	ttFont, err := parseTypeface(fontData)
 	if err != nil {
-		return fmt.Errorf("parsing font: %w", err)
+		return nil, fmt.Errorf("parsing font: %w", err)
 	}
+	return &resources{template: baseImg, font: ttFont, fontData: fontData}, nil
+}
 
-	// --- 4. Prepare Drawing Canvas ---
-	bounds := baseImg.Bounds()
-	// Create a new RGBA image to draw on. This ensures we have an image
-	// type that supports setting individual pixel colors.
-	rgbaImg := image.NewRGBA(bounds)
-	draw.Draw(rgbaImg, bounds, baseImg, image.Point{}, draw.Src)
-
-	// --- 5. Setup Text Drawing Context ---
-	c := freetype.NewContext()
-	c.SetDPI(dpi)
-	c.SetFont(ttFont)
-	c.SetFontSize(fontSize)
-	c.SetClip(rgbaImg.Bounds())
-	c.SetDst(rgbaImg)
-	c.SetHinting(font.HintingFull) // Improve font rendering quality
-
-	// --- 6. Calculate Text Position (Centered, near TOP) ---
-	textWidth, err := measureString(ttFont, fontSize, dpi, font.HintingFull, memeText)
+// render draws the meme described by opts and writes it to destWriter.
+func (res *resources) render(opts Options, destWriter io.Writer) error {
+	return res.renderContext(context.Background(), opts, destWriter)
+}
+
+// renderContext is render, giving up with ctx's error once ctx is done: it
+// checks between the stages and before each caption line, and fails writes
+// to destWriter from then on. Nothing is written if ctx is done before
+// encoding starts.
+func (res *resources) renderContext(ctx context.Context, opts Options, destWriter io.Writer) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	destWriter = contextWriter{ctx, destWriter}
+	opts, err := sanitizeCaptions(opts)
+	if err != nil {
+		return err
+	}
+	if err := validateOptions(opts); err != nil {
+		return err
+	}
+	// --- 3. Compute Layout ---
+	lay, err := res.layout(opts)
+	if err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if opts.Measure {
+		// Report where everything would go instead of drawing it
+		return writeLayoutJSON(destWriter, lay)
+	}
+	if len(opts.FrameTexts) > 1 {
+		return res.renderAnimation(ctx, opts, destWriter)
+	}
+	out, finishOutput, err := encodeOutput(destWriter, opts.Encode, lay.Format)
 	if err != nil {
-		return fmt.Errorf("measuring text width: %w", err)
+		return err
 	}
 
-	imageWidth := bounds.Dx()
-	// Calculate starting X for centered text
-	startX := (imageWidth - textWidth) / 2
-	if startX < 0 {
-		startX = 0 // Prevent negative start X if text is wider than image
+	// --- 4. Prepare Drawing Canvas ---
+	rgbaImg, release := res.canvas(lay, opts)
+	defer release()
+	if opts.AutoColor {
+		// Sampled from the finished background, where the text will go
+		chooseAutoColors(lay, rgbaImg, opts)
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	if lay.Format == formatSVG {
+		// Captions and watermark become SVG text on top of the canvas
+		if opts.Unique {
+			perturbUnique(rgbaImg, opts.UniqueSeed, captionRegions(lay, opts))
+		}
+		if err := writeSVG(out, lay, rgbaImg, res.fontData, opts); err != nil {
+			return err
+		}
+		return finishOutput()
 	}
 
-	// Calculate starting Y (baseline position) near the TOP
-	// baseline = top padding + approximate font ascent
-	// Using fontSize * dpi / 72.0 provides a reasonable pixel height estimate.
-	startY := paddingY + int(c.PointToFixed(fontSize)>>6) // More accurate ascent calculation
+	// --- 5.-7. Draw the Captions, Watermark and Perturbation ---
+	if err := res.drawForeground(ctx, rgbaImg, lay, opts); err != nil {
+		return err
+	}
+	if err := ctx.Err(); err != nil {
+		return err
+	}
 
-	pt := freetype.Pt(startX, startY) // Baseline point for the main text
+	// --- 8. Encode and Output PNG ---
+	// Use the destination writer supplied by the caller (stdout or file),
+	// through any text encoding
+	if !opts.NoMetadata {
+		out = newMetadataWriter(out, pngMetadata(opts))
+	}
+	if err := png.Encode(out, rgbaImg); err != nil {
+		// Broken pipes are reported like any other write error; whether they
+		// matter is decided by the caller, which knows what destWriter is.
+		return fmt.Errorf("encoding or writing PNG: %w", err)
+	}
 
-	// --- 7. Draw the Text with Outline ---
+	// If we reached here, all steps were successful
+	return finishOutput()
+}
 
-	// Define offsets for the 8 directions around the center for the outline
-	offsets := []image.Point{
-		{-outlineThickness, -outlineThickness}, {0, -outlineThickness}, {outlineThickness, -outlineThickness},
-		{-outlineThickness, 0} /* {0, 0} is the center, skip */, {outlineThickness, 0},
-		{-outlineThickness, outlineThickness}, {0, outlineThickness}, {outlineThickness, outlineThickness},
+// layout computes the layout of the meme described by opts, for the
+// template scaled as opts says.
+func (res *resources) layout(opts Options) (*layout, error) {
+	srcBounds := res.template.Bounds()
+	outW, outH, err := targetSize(srcBounds.Dx(), srcBounds.Dy(), opts.Width, opts.Height)
+	if err != nil {
+		return nil, err
+	}
+	outW, outH, err = scaleSize(outW, outH, opts.Scale)
+	if err != nil {
+		return nil, err
 	}
+	opts.faces = res.faces
+	return computeLayout(image.Rect(0, 0, outW, outH), res.font, opts)
+}
 
-	// Draw outline parts first
-	c.SetSrc(outlineColor) // Set color to black for outline
-	for _, offset := range offsets {
-		offsetPt := freetype.Pt(startX+offset.X, startY+offset.Y)
-		_, err = c.DrawString(memeText, offsetPt)
-		if err != nil {
-			// Return error if any part of the outline fails to draw
-			return fmt.Errorf("drawing outline part at offset %v: %w", offset, err)
+// drawForeground draws everything laid out in lay over the background on
+// dst: the captions, then the watermark, then the -unique perturbation.
+func (res *resources) drawForeground(ctx context.Context, dst *image.RGBA, lay *layout, opts Options) error {
+	// --- 5. Draw the Captions with Outline ---
+	for _, cl := range lay.Captions {
+		if err := drawCaption(ctx, dst, res.font, cl, opts); err != nil {
+			return err
 		}
 	}
 
-	// Draw main text (fill) on top
-	c.SetSrc(fillColor)                 // Set color to white for fill
-	_, err = c.DrawString(memeText, pt) // Draw at the final baseline position
-	if err != nil {
-		// Return error if the main text fill fails to draw
-		return fmt.Errorf("drawing main text fill: %w", err)
+	// --- 5b. Draw the Speech Bubbles ---
+	for _, b := range lay.Bubbles {
+		drawBubble(dst, b)
+		if err := drawCaption(ctx, dst, res.font, b.Caption, opts); err != nil {
+			return err
+		}
 	}
 
-	// --- 8. Encode and Output PNG ---
-	// Use the determined destination writer (stdout or file)
-	err = png.Encode(destWriter, rgbaImg)
-	if err != nil {
-		// Check specifically for broken pipe when writing to stdout, which can be normal
-		// if the reading end of the pipe closes early.
-		if opErr, ok := err.(*os.PathError); ok && opErr.Op == "write" && destWriter == os.Stdout {
-			// Suppress broken pipe errors when writing to stdout, but still return it
-			// as something did technically go wrong. Caller (main) ignores it if needed.
-			// Or we could return nil here if we consider broken pipe non-fatal.
-			// Let's return the error for now, main will exit non-zero.
-			return fmt.Errorf("writing PNG to stdout: %w", err)
-		}
-		// For other file write errors or general encoding errors
-		return fmt.Errorf("encoding or writing PNG: %w", err)
+	// --- 6. Draw the Watermark ---
+	// The watermark is placed independently of the caption layout above
+	if lay.Watermark != nil {
+		if err := drawWatermark(dst, res.font, lay.Watermark, opts); err != nil {
+			return err
+		}
 	}
 
-	// If we reached here, all steps were successful
+	// --- 7. Make the File Unique ---
+	// Last, so no later stage can undo or disturb the perturbation
+	if opts.Unique {
+		perturbUnique(dst, opts.UniqueSeed, captionRegions(lay, opts))
+	}
 	return nil
 }
 
-// measureString calculates the width of a string in pixels when rendered
-// with the specified font properties.
-func measureString(fnt *truetype.Font, size, dpi float64, hinting font.Hinting, text string) (int, error) {
-	// Create a font face with the given options
-	face := truetype.NewFace(fnt, &truetype.Options{
-		Size:    size,
-		DPI:     dpi,
-		Hinting: hinting,
-	})
+// drawCaption draws a laid-out caption onto dst: the optional text box
+// first, then each line with its outline, or in plain color for captions on
+// a caption bar or poster. A rotated caption is drawn onto a transparent
+// layer and composited once complete, as is a partially transparent one;
+// otherwise text goes straight onto the canvas.
+func drawCaption(ctx context.Context, dst *image.RGBA, ttFont *typeface, cl captionLayout, opts Options) error {
+	textDst := dst
+	if cl.Rotate != 0 {
+		textDst = image.NewRGBA(dst.Bounds())
+	}
+
+	if cl.TextBox != nil {
+		boxColor := opts.TextBoxColor
+		if boxColor == (color.NRGBA{}) {
+			boxColor = defaultTextBoxColor
+		}
+		fillRoundedRect(textDst, cl.TextBox.rect(), scalePx(textBoxRadius, opts.Scale), boxColor)
+	}
 
-	// Measure the string using the font face
-	// font.MeasureString returns width in 26.6 fixed-point units
-	widthInFixedPoint := font.MeasureString(face, text)
+	var fill image.Image = fillColor
+	if cl.fill != (color.NRGBA{}) {
+		fill = image.NewUniform(cl.fill)
+	}
+	if len(opts.FillGradient) > 0 && cl.Block != nil {
+		fill = &gradient{stops: opts.FillGradient, top: cl.Block.Y, bottom: cl.Block.Y + cl.Block.H}
+	}
+	var outline image.Image = outlineColor
+	if cl.outline != (color.NRGBA{}) {
+		outline = image.NewUniform(cl.outline)
+	}
+	drawLine := func(p *textPainter, l lineLayout) error {
+		p.outlineSrc = outline
+		return p.drawOutlined(l.Text, l.pt, fill)
+	}
+	if cl.plain {
+		drawLine = func(p *textPainter, l lineLayout) error {
+			return p.drawPlain(l.Text, l.pt, cl.fill)
+		}
+	}
 
-	// Convert fixed-point to integer pixels (shift right by 6 bits)
-	widthInPixels := int(widthInFixedPoint >> 6)
+	style := captionStyle(ttFont, cl.FontSize, opts)
+	drawText := func(dst *image.RGBA, fillOnly bool) error {
+		draw := func(p *textPainter, l lineLayout) error {
+			p.fillOnly = p.fillOnly || fillOnly
+			return drawLine(p, l)
+		}
+		painter := newTextPainter(dst, style)
+		for _, l := range cl.Lines {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			var err error
+			switch {
+			case cl.Arc != 0:
+				// Plain text and lone fills have no outline to complete first
+				err = drawArc(dst, style, l, cl.Arc, !cl.plain && !fillOnly, draw)
+			case l.Condense != 0:
+				err = drawCondensed(dst, style, l, draw)
+			default:
+				err = draw(painter, l)
+			}
+			if err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	fillOpacity, outlineOpacity := opacities(opts)
+	if opaque(fillOpacity, outlineOpacity) {
+		if err := drawText(textDst, false); err != nil {
+			return err
+		}
+	} else {
+		full, fillLayer := image.NewRGBA(textDst.Bounds()), image.NewRGBA(textDst.Bounds())
+		if err := drawText(full, false); err != nil {
+			return err
+		}
+		if err := drawText(fillLayer, true); err != nil {
+			return err
+		}
+		compositeOpacity(textDst, full, fillLayer, fillOpacity, outlineOpacity)
+	}
 
-	// face.Close() // truetype.NewFace doesn't require explicit closing
+	if cl.Rotate != 0 {
+		compositeRotated(dst, textDst, cl.Rotate)
+	}
+	return nil
+}
 
-	return widthInPixels, nil
+// suppressBrokenPipe returns nil if err is caused by a broken pipe (EPIPE
+// anywhere in the wrapped chain) and the output is going to stdout. Broken
+// pipes on real output files are still errors, so err is returned unchanged
+// when toStdout is false.
+func suppressBrokenPipe(err error, toStdout bool) error {
+	if err != nil && toStdout && errors.Is(err, syscall.EPIPE) {
+		return nil
+	}
+	return err
 }
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// update regenerates the golden files instead of comparing against them:
+//
+//	go test -run TestGolden -update
+var update = flag.Bool("update", false, "update golden files")
+
+// loadTestTemplate returns the small template used by the rendering tests.
+func loadTestTemplate(t testing.TB) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", "template.png"))
+	if err != nil {
+		t.Fatalf("reading test template: %v", err)
+	}
+	return data
+}
+
+// TestGolden renders a set of captions against the test template and compares
+// the result pixel-exact with the committed golden PNGs.
+func TestGolden(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	noPadding := 0
+	half, quarter, one := 0.5, 0.25, 1.0
+
+	cases := []struct {
+		name string
+		opts Options
+	}{
+		{name: "short", opts: Options{Text: "HI"}},
+		{name: "long", opts: Options{Text: "ONE DOES NOT SIMPLY WALK INTO MORDOR"}},
+		{name: "empty-ish", opts: Options{Text: " "}},
+		{name: "unicode", opts: Options{Text: "ÆØÅ ÜBER"}},
+		{name: "resized", opts: Options{Text: "HI", Width: 240}},
+		{name: "scale-2", opts: Options{Text: "CRISP AT 2X", Width: 240, Scale: 2, TextBox: true}},
+		{name: "size-small", opts: Options{Text: "SMALL CLEARLY READABLE TEXT", Size: 36, Padding: &noPadding}},
+		{name: "size-tiny", opts: Options{Text: "TINY BUT STILL OUTLINED", Size: 16, Scale: 2}},
+		{name: "fill-gradient", opts: Options{Text: "RETRO\nWAVE", FillGradient: []color.NRGBA{{255, 221, 0, 255}, {255, 51, 0, 255}}}},
+		{name: "fill-gradient-stroke", opts: Options{
+			Text:         "THREE STOPS",
+			FillGradient: []color.NRGBA{{0, 255, 255, 255}, {255, 0, 255, 255}, {255, 255, 0, 255}},
+			OutlineStyle: outlineStroke,
+		}},
+		{name: "filter", opts: Options{Text: "TONED DOWN", Filters: []imageFilter{{Name: filterGrayscale}, {Name: filterBrightness, Amount: -30}}}},
+		{name: "overlay", opts: Options{Text: "STONKS", Overlays: []overlay{
+			{Path: "sticker.png", At: "20,150", Image: testSticker(80)},
+			{Path: "sticker.png", At: "@w-60,h-60", Scale: 1.5, Image: testSticker(80)}, // Partly off-canvas
+		}}},
+		{name: "hinting-full", opts: Options{Text: "WOW SUCH HINTING", Hinting: hintingFull}},
+		{name: "hinting-none", opts: Options{Text: "WOW SUCH HINTING", Hinting: hintingNone}},
+		{name: "tracking-wide", opts: Options{Text: "HI THERE", Tracking: 12}},
+		{name: "tracking-tight", opts: Options{Text: "HI THERE", Tracking: -6}},
+		{name: "outline-stroke", opts: Options{Text: "SMOOTH OUTLINES", OutlineStyle: outlineStroke}},
+		{name: "outline-stroke-tight", opts: Options{Text: "AVOWAL", Tracking: -14, OutlineStyle: outlineStroke}},
+		{name: "rotate-tilt", opts: Options{Text: "STONKS", Rotate: -8}},
+		{name: "rotate-90", opts: Options{Text: "STONKS", Rotate: 90}},
+		{name: "fill-outline", opts: Options{Text: "CUSTOM COLORS", Fill: color.NRGBA{255, 221, 0, 255}, Outline: color.NRGBA{40, 0, 80, 255}}},
+		{name: "auto-color", opts: Options{Text: "AUTO\nCONTRAST", Position: positionMiddle, AutoColor: true}},
+		{name: "opacity", opts: Options{Text: "SEE THROUGH", FillOpacity: &half, OutlineOpacity: &half}},
+		{name: "fill-opacity", opts: Options{Text: "HOLLOW", FillOpacity: &quarter, OutlineOpacity: &one}},
+		{name: "textbox", opts: Options{Text: "HI", TextBox: true}},
+		{name: "textbox-multiline", opts: Options{
+			Text:         "QUITE\nJUSTIFIED",
+			Position:     positionBottom,
+			TextBox:      true,
+			TextBoxColor: color.NRGBA{R: 255, G: 255, A: 160},
+		}},
+		{name: "caption-bar", opts: Options{Text: "WHEN THE BAR FITS", CaptionBar: true}},
+		{name: "caption-bar-bottom", opts: Options{
+			Text:                "HI",
+			CaptionBar:          true,
+			CaptionBarPosition:  positionBottom,
+			CaptionBarColor:     color.NRGBA{R: 30, G: 30, B: 30, A: 255},
+			CaptionBarTextColor: color.NRGBA{R: 255, G: 255, A: 255},
+		}},
+		{name: "demotivational", opts: Options{Demotivational: true, Title: "TEAMWORK", Subtitle: "None of us is as dumb as all of us."}},
+		{name: "spec", opts: Options{Boxes: []captionBox{
+			{Rect: image.Rect(240, 0, 480, 135), Text: "READING THE DOCS", Position: positionMiddle, Align: alignCenter, Size: fontSize},
+			{Rect: image.Rect(240, 135, 480, 270), Text: "ASKING IN CHAT", Position: positionMiddle, Align: alignLeft,
+				Fill: color.NRGBA{R: 200, A: 255}, Size: 48},
+		}}},
+		{name: "text-rect", opts: Options{Text: "RECTANGLES ARE THE BEST SHAPE", TextRect: "250,140,210,110", TextBox: true}},
+		{name: "bubbles", opts: Options{Bubbles: []bubble{
+			{Text: "I AM A BUBBLE WITH QUITE A LOT OF TEXT", At: "120,220"},
+			{Text: "ME TOO", At: "@w-80,40"},
+		}}},
+		{name: "bubbles-ellipse", opts: Options{BubbleShape: bubbleEllipse, Bubbles: []bubble{
+			{Text: "ROUND", At: "60,60"},
+			{Text: "AND SPEAKING TO THE RIGHT", At: "470,250"},
+		}}},
+		{name: "arc-up", opts: Options{Text: "ARCS ARE GREAT", Arc: 40}},
+		{name: "arc-down", opts: Options{Text: "SAD BOTTOM TEXT", Arc: -50, Position: positionBottom, OutlineStyle: outlineStroke}},
+		{name: "banner", opts: Options{Text: "MOST WIDE BANNER", Width: 960, Height: 96}},
+		{name: "banner-right", opts: Options{Text: "SALE", Width: 960, Height: 96, Position: alignRight}},
+		{name: "strip", opts: Options{Text: "TALL AND NARROW", Width: 60, Height: 600}},
+		{name: "watermark", opts: Options{Text: "HI", Watermark: "@memegen"}},
+		{name: "watermark-shrunk", opts: Options{
+			Text:            "HI",
+			Watermark:       "a watermark far too wide to fit on the test template",
+			WatermarkCorner: "tl",
+			WatermarkSize:   48,
+		}},
+	}
+
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			if err := run(tc.opts, &buf, templateData, fontBytes); err != nil {
+				t.Fatalf("run(%+v): %v", tc.opts, err)
+			}
+			compareGolden(t, filepath.Join("testdata", "golden", tc.name+".png"), buf.Bytes())
+		})
+	}
+}
+
+// compareGolden decodes got and compares it with the golden PNG at path. With
+// -update the golden file is rewritten instead.
+func compareGolden(t *testing.T, path string, got []byte) {
+	t.Helper()
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatalf("creating golden dir: %v", err)
+		}
+		if err := os.WriteFile(path, got, 0o644); err != nil {
+			t.Fatalf("writing golden file: %v", err)
+		}
+		return
+	}
+
+	wantData, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("reading golden file (run with -update to create it): %v", err)
+	}
+	want, err := png.Decode(bytes.NewReader(wantData))
+	if err != nil {
+		t.Fatalf("decoding golden file %s: %v", path, err)
+	}
+	gotImg, err := png.Decode(bytes.NewReader(got))
+	if err != nil {
+		t.Fatalf("decoding rendered PNG: %v", err)
+	}
+	if err := diffImages(want, gotImg); err != nil {
+		t.Errorf("%s: %v", path, err)
+	}
+}
+
+// diffImages returns nil if a and b are pixel-identical, otherwise an error
+// describing the size mismatch or the first differing pixel.
+func diffImages(want, got image.Image) error {
+	if want.Bounds() != got.Bounds() {
+		return fmt.Errorf("bounds differ: want %v, got %v", want.Bounds(), got.Bounds())
+	}
+	b := want.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			wr, wg, wb, wa := want.At(x, y).RGBA()
+			gr, gg, gb, ga := got.At(x, y).RGBA()
+			if wr != gr || wg != gg || wb != gb || wa != ga {
+				return fmt.Errorf("first differing pixel at (%d,%d): want %v, got %v",
+					x, y, want.At(x, y), got.At(x, y))
+			}
+		}
+	}
+	return nil
+}
+
+// TestBrokenPipe renders into a pipe whose read end is already closed and
+// checks that the resulting EPIPE is suppressed for stdout only.
+func TestBrokenPipe(t *testing.T) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatalf("creating pipe: %v", err)
+	}
+	r.Close()
+	defer w.Close()
+
+	err = run(Options{Text: "HI"}, w, loadTestTemplate(t), fontBytes)
+	if err == nil {
+		t.Fatal("expected a write error on a pipe with no reader")
+	}
+	if got := suppressBrokenPipe(err, true); got != nil {
+		t.Errorf("broken pipe on stdout not suppressed: %v", got)
+	}
+	if got := suppressBrokenPipe(err, false); got == nil {
+		t.Error("broken pipe on an output file must remain an error")
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+
+	"golang.org/x/image/font"
+)
+
+// metricValue is an optional override of a single vertical font metric,
+// either as a fraction of the em size or in absolute pixels.
+type metricValue struct {
+	Set    bool
+	Value  float64
+	Pixels bool // Value is in pixels rather than ems
+}
+
+// resolve returns the metric in pixels for a font whose em is emPx pixels.
+func (v metricValue) resolve(emPx float64) int {
+	if v.Pixels {
+		return int(math.Round(v.Value))
+	}
+	return int(math.Ceil(v.Value * emPx))
+}
+
+// metricsOverride replaces the ascent and/or descent a font reports. Many
+// free meme fonts ship with wrong values, which puts baselines visibly off.
+// Overrides only affect placement; glyphs are rendered unchanged.
+type metricsOverride struct {
+	Ascent  metricValue
+	Descent metricValue
+}
+
+// scaled returns the override with pixel values multiplied by scale, for
+// rendering at a higher resolution. Fractions of em scale with the font.
+func (m metricsOverride) scaled(scale float64) metricsOverride {
+	for _, v := range []*metricValue{&m.Ascent, &m.Descent} {
+		if v.Pixels && scale != 0 {
+			v.Value *= scale
+		}
+	}
+	return m
+}
+
+// String formats the override in the syntax parseMetricsOverride accepts.
+func (m metricsOverride) String() string {
+	var parts []string
+	for _, f := range []struct {
+		name string
+		v    metricValue
+	}{{"ascent", m.Ascent}, {"descent", m.Descent}} {
+		if !f.v.Set {
+			continue
+		}
+		s := strconv.FormatFloat(f.v.Value, 'g', -1, 64)
+		if f.v.Pixels {
+			s += "px"
+		}
+		parts = append(parts, f.name+"="+s)
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseMetricsOverride parses a list such as "ascent=0.78,descent=0.22" or
+// "descent=30px". Values without a unit are fractions of the em size.
+func parseMetricsOverride(s string) (metricsOverride, error) {
+	var m metricsOverride
+	if s == "" {
+		return m, nil
+	}
+	for _, item := range strings.Split(s, ",") {
+		key, val, ok := strings.Cut(strings.TrimSpace(item), "=")
+		if !ok {
+			return m, fmt.Errorf("metrics override %q: want key=value", item)
+		}
+		var v metricValue
+		num, isPx := strings.CutSuffix(val, "px")
+		f, err := strconv.ParseFloat(num, 64)
+		if err != nil || f < 0 || math.IsInf(f, 0) {
+			return m, fmt.Errorf("metrics override %q: bad value %q (want a fraction of em, or pixels with a px suffix)", item, val)
+		}
+		v = metricValue{Set: true, Value: f, Pixels: isPx}
+		switch key {
+		case "ascent":
+			m.Ascent = v
+		case "descent":
+			m.Descent = v
+		default:
+			return m, fmt.Errorf("metrics override %q: unknown metric %q (want ascent or descent)", item, key)
+		}
+	}
+	return m, nil
+}
+
+// verticalMetrics returns the ascent and descent in pixels used to place
+// baselines for fnt at size points. Without an override the ascent is one
+// em, the classic caption placement (all-caps meme fonts rarely reach the
+// reported ascent, which leaves room for accents), and the descent is the
+// font's own, from a face in faces if not nil.
+func verticalMetrics(fnt *typeface, size float64, hinting font.Hinting, override metricsOverride, faces *faceCache) (ascent, descent int) {
+	emPx := size * dpi / 72.0
+	ascent = int(emPx)
+	if override.Ascent.Set {
+		ascent = override.Ascent.resolve(emPx)
+	}
+	if override.Descent.Set {
+		descent = override.Descent.resolve(emPx)
+	} else if faces != nil {
+		descent = faces.metrics(size, dpi, hinting).Descent.Ceil()
+	} else {
+		face := fnt.newFace(size, dpi, hinting)
+		descent = face.Metrics().Descent.Ceil()
+	}
+	return ascent, descent
+}
@@ -0,0 +1,101 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"image"
+	"image/png"
+	"testing"
+)
+
+func TestParseMetricsOverride(t *testing.T) {
+	m, err := parseMetricsOverride("ascent=0.78, descent=30px")
+	if err != nil {
+		t.Fatalf("parseMetricsOverride: %v", err)
+	}
+	want := metricsOverride{
+		Ascent:  metricValue{Set: true, Value: 0.78},
+		Descent: metricValue{Set: true, Value: 30, Pixels: true},
+	}
+	if m != want {
+		t.Errorf("got %+v, want %+v", m, want)
+	}
+	if got := m.String(); got != "ascent=0.78,descent=30px" {
+		t.Errorf("String() = %q", got)
+	}
+
+	for _, bad := range []string{"ascent", "ascent=big", "ascent=-1", "leading=0.2"} {
+		if _, err := parseMetricsOverride(bad); err == nil {
+			t.Errorf("parseMetricsOverride(%q) accepted", bad)
+		}
+	}
+}
+
+// hheaOffset returns the offset of the hhea table in a TrueType file.
+func hheaOffset(t *testing.T, ttf []byte) int {
+	t.Helper()
+	numTables := int(binary.BigEndian.Uint16(ttf[4:]))
+	for i := 0; i < numTables; i++ {
+		entry := ttf[12+16*i:]
+		if string(entry[:4]) == "hhea" {
+			return int(binary.BigEndian.Uint32(entry[8:]))
+		}
+	}
+	t.Fatal("font has no hhea table")
+	return 0
+}
+
+// lowestInkRow renders a bottom caption and returns the last row containing
+// non-transparent pixels.
+func lowestInkRow(t *testing.T, opts Options, fontData []byte) int {
+	t.Helper()
+	var tmpl, out bytes.Buffer
+	if err := png.Encode(&tmpl, image.NewNRGBA(image.Rect(0, 0, 400, 300))); err != nil {
+		t.Fatal(err)
+	}
+	opts.Position = positionBottom
+	if err := run(opts, &out, tmpl.Bytes(), fontData); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	img, err := png.Decode(&out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := img.Bounds()
+	for y := b.Max.Y - 1; y >= b.Min.Y; y-- {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+				return y
+			}
+		}
+	}
+	t.Fatal("nothing drawn")
+	return 0
+}
+
+// TestMetricsOverrideRestoresPadding uses a copy of the embedded font with a
+// deliberately wrong descent and checks that overriding it with the true
+// value restores the original bottom padding.
+func TestMetricsOverrideRestoresPadding(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	off := hheaOffset(t, fontBytes)
+	trueDescender := -int16(binary.BigEndian.Uint16(fontBytes[off+6:])) // Stored negative
+	unitsPerEm := float64(ttFont.tt.FUnitsPerEm())
+
+	broken := bytes.Clone(fontBytes)
+	binary.BigEndian.PutUint16(broken[off+6:], uint16(-int16(unitsPerEm))) // One full em
+
+	opts := Options{Text: "HI"}
+	want := lowestInkRow(t, opts, fontBytes)
+	if got := lowestInkRow(t, opts, broken); want-got < 50 {
+		t.Fatalf("broken font moved the caption only %dpx, fixture is not broken enough", want-got)
+	}
+
+	opts.Metrics = metricsOverride{Descent: metricValue{Set: true, Value: float64(trueDescender) / unitsPerEm}}
+	if got := lowestInkRow(t, opts, broken); got < want-1 || got > want+1 {
+		t.Errorf("with override the caption ends at row %d, want %d±1", got, want)
+	}
+}
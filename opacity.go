@@ -0,0 +1,63 @@
+package main
+
+import (
+	"image"
+	"math"
+)
+
+// Partially transparent captions can't be drawn straight onto the canvas:
+// the eight outline stamps overlap, and each would darken the canvas again
+// where they do. Instead the caption is drawn at full opacity onto a layer,
+// once complete and once as the fill alone, and the two are combined with
+// the requested opacities and composited in one go. Where the fill covers
+// the outline only the fill shows, as it does when drawn opaque.
+
+// opacities returns the fill and outline opacities of opts, 1 if unset.
+func opacities(opts Options) (fill, outline float64) {
+	fill, outline = 1, 1
+	if opts.FillOpacity != nil {
+		fill = *opts.FillOpacity
+	}
+	if opts.OutlineOpacity != nil {
+		outline = *opts.OutlineOpacity
+	}
+	return fill, outline
+}
+
+// opaque reports whether both opacities are 1, in which case the caption
+// is drawn directly, exactly as without -opacity.
+func opaque(fillOpacity, outlineOpacity float64) bool {
+	return fillOpacity == 1 && outlineOpacity == 1
+}
+
+// compositeOpacity composites a caption over dst, given the caption drawn
+// complete (full) and as its fill only (fill), both on transparent layers
+// the size of dst. In premultiplied terms full is fill over the outline, so
+// the visible outline is full minus fill, and the result is
+//
+//	fill*fillOpacity + (full-fill)*outlineOpacity
+//
+// composited over dst. With equal opacities that is simply full at that
+// opacity.
+func compositeOpacity(dst, full, fill *image.RGBA, fillOpacity, outlineOpacity float64) {
+	r := dst.Bounds().Intersect(full.Bounds())
+	for y := r.Min.Y; y < r.Max.Y; y++ {
+		d := dst.Pix[dst.PixOffset(r.Min.X, y):]
+		a := full.Pix[full.PixOffset(r.Min.X, y):]
+		f := fill.Pix[fill.PixOffset(r.Min.X, y):]
+		for i := 0; i < 4*r.Dx(); i += 4 {
+			if a[i+3] == 0 {
+				continue // Nothing drawn here
+			}
+			var px [4]float64
+			for c := range px {
+				outline := max(float64(a[i+c])-float64(f[i+c]), 0)
+				px[c] = float64(f[i+c])*fillOpacity + outline*outlineOpacity
+			}
+			keep := 1 - px[3]/255
+			for c := range px {
+				d[i+c] = uint8(min(math.Round(px[c]+float64(d[i+c])*keep), 255))
+			}
+		}
+	}
+}
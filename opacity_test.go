@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"testing"
+)
+
+func TestOpacityOneIsUnchanged(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	render := func(opts Options) []byte {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := run(opts, &buf, templateData, fontBytes); err != nil {
+			t.Fatalf("run: %v", err)
+		}
+		return buf.Bytes()
+	}
+	one := 1.0
+	want := render(Options{Text: "WOW SUCH OPAQUE", OutlineStyle: outlineStroke})
+	got := render(Options{Text: "WOW SUCH OPAQUE", OutlineStyle: outlineStroke, FillOpacity: &one, OutlineOpacity: &one})
+	if !bytes.Equal(got, want) {
+		t.Error("opacity 1 changed the output")
+	}
+}
+
+func TestCompositeOpacity(t *testing.T) {
+	layer := func(c color.RGBA) *image.RGBA {
+		img := image.NewRGBA(image.Rect(0, 0, 1, 1))
+		img.SetRGBA(0, 0, c)
+		return img
+	}
+	white, black, clear := color.RGBA{255, 255, 255, 255}, color.RGBA{0, 0, 0, 255}, color.RGBA{}
+	gray := color.RGBA{100, 100, 100, 255}
+	cases := []struct {
+		name                        string
+		full, fill                  color.RGBA
+		fillOpacity, outlineOpacity float64
+		want                        color.RGBA
+	}{
+		{"fill at half", white, white, 0.5, 0, color.RGBA{178, 178, 178, 255}},
+		{"fill hides the outline", white, white, 1, 0.5, white},
+		{"outline at half", black, clear, 1, 0.5, color.RGBA{50, 50, 50, 255}},
+		{"outline hidden", black, clear, 1, 0, gray},
+		{"nothing drawn", clear, clear, 0.5, 0.5, gray},
+	}
+	for _, tc := range cases {
+		dst := layer(gray)
+		compositeOpacity(dst, layer(tc.full), layer(tc.fill), tc.fillOpacity, tc.outlineOpacity)
+		if got := dst.RGBAAt(0, 0); got != tc.want {
+			t.Errorf("%s: got %v, want %v", tc.name, got, tc.want)
+		}
+	}
+}
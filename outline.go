@@ -0,0 +1,271 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+	"golang.org/x/image/vector"
+)
+
+// Outline styles accepted in Options.OutlineStyle
+const (
+	outlineStamp  = "stamp"  // Text stamped at eight offsets: fast, lumpy when thick
+	outlineStroke = "stroke" // Glyph paths stroked: smooth and even
+)
+
+// flattenTolerance is the maximum distance in pixels between a glyph's
+// curves and the line segments approximating them.
+const flattenTolerance = 0.1
+
+// vec is a point in pixel space.
+type vec struct{ x, y float64 }
+
+// drawStroked draws text with its baseline starting at pt like
+// drawOutlined, but builds the outline from the glyph shapes: the glyph
+// contours are flattened to polygons, the outline mask is everything
+// within the style's outline thickness of a contour (plus the glyphs themselves), and
+// the fill mask is the polygons rasterised. Both masks cover the whole
+// line, so overlapping glyphs are merged before compositing, without
+// seams.
+func (p *textPainter) drawStroked(text string, pt fixed.Point26_6, fill image.Image) error {
+	ext, err := p.measure(text)
+	if err != nil {
+		return err
+	}
+	r := ext.inkRect(pt).Inset(-(p.thickness + 1)).Intersect(p.dst.Bounds())
+	if r.Empty() {
+		return nil
+	}
+	contours := p.contours(text, pt, r.Min)
+
+	fillMask := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+	z := vector.NewRasterizer(r.Dx(), r.Dy())
+	z.DrawOp = draw.Src
+	for _, c := range contours {
+		z.MoveTo(float32(c[0].x), float32(c[0].y))
+		for _, v := range c[1:] {
+			z.LineTo(float32(v.x), float32(v.y))
+		}
+		z.ClosePath()
+	}
+	z.Draw(fillMask, fillMask.Bounds(), image.Opaque, image.Point{})
+
+	if !p.fillOnly {
+		outlineMask := image.NewAlpha(fillMask.Rect)
+		copy(outlineMask.Pix, fillMask.Pix)
+		for _, c := range contours {
+			strokePolygon(outlineMask, c, float64(p.thickness))
+		}
+		draw.DrawMask(p.dst, r, p.outlineSrc, image.Point{}, outlineMask, image.Point{}, draw.Over)
+	}
+	draw.DrawMask(p.dst, r, fill, r.Min, fillMask, image.Point{}, draw.Over)
+	return nil
+}
+
+// contours returns the flattened, closed contours of text drawn with its
+// baseline starting at pt, in pixels relative to origin.
+func (p *textPainter) contours(text string, pt fixed.Point26_6, origin image.Point) [][]vec {
+	if p.font.cff != nil {
+		return p.contoursCFF(text, pt, origin)
+	}
+	scale := fixed.Int26_6(p.size * dpi * (64.0 / 72.0)) // As in layoutLine
+	offsets, _ := layoutLine(p.font, p.size, dpi, p.hinting, p.tracking, text)
+
+	var (
+		glyph    truetype.GlyphBuf
+		contours [][]vec
+	)
+	for i, r := range []rune(text) {
+		if err := glyph.Load(p.font.tt, scale, p.font.tt.Index(r), p.hinting); err != nil {
+			continue // As drawString, which skips glyphs it can't load
+		}
+		x0, y0 := pt.X+offsets[i], pt.Y
+		toPixels := func(gp truetype.Point) vec {
+			// Glyph points are y-up, relative to the pen position
+			return vec{
+				x: float64(x0+gp.X)/64 - float64(origin.X),
+				y: float64(y0-gp.Y)/64 - float64(origin.Y),
+			}
+		}
+		start := 0
+		for _, end := range glyph.Ends {
+			if c := flattenContour(glyph.Points[start:end], toPixels); len(c) > 2 {
+				contours = append(contours, c)
+			}
+			start = end
+		}
+	}
+	return contours
+}
+
+// contoursCFF is contours for a font with CFF outlines, whose glyphs are
+// paths of lines and cubic curves rather than TrueType points.
+func (p *textPainter) contoursCFF(text string, pt fixed.Point26_6, origin image.Point) [][]vec {
+	ppem := cffScale(p.size, dpi) // As in layoutLineCFF
+	offsets, _ := layoutLine(p.font, p.size, dpi, p.hinting, p.tracking, text)
+
+	var (
+		buf      sfnt.Buffer
+		contours [][]vec
+	)
+	for i, r := range []rune(text) {
+		index, err := p.font.cff.GlyphIndex(&buf, r)
+		if err != nil || index == 0 {
+			continue // As drawGlyph, which skips runes without a glyph
+		}
+		segments, err := p.font.cff.LoadGlyph(&buf, index, ppem, nil)
+		if err != nil {
+			continue
+		}
+		x0, y0 := pt.X+offsets[i], pt.Y
+		toPixels := func(gp fixed.Point26_6) vec {
+			// Segments are y-down already, relative to the pen position
+			return vec{
+				x: float64(x0+gp.X)/64 - float64(origin.X),
+				y: float64(y0+gp.Y)/64 - float64(origin.Y),
+			}
+		}
+		var c []vec
+		for _, seg := range segments {
+			switch seg.Op {
+			case sfnt.SegmentOpMoveTo:
+				if len(c) > 2 {
+					contours = append(contours, c)
+				}
+				c = []vec{toPixels(seg.Args[0])}
+			case sfnt.SegmentOpLineTo:
+				c = append(c, toPixels(seg.Args[0]))
+			case sfnt.SegmentOpQuadTo:
+				c = appendQuad(c, c[len(c)-1], toPixels(seg.Args[0]), toPixels(seg.Args[1]))
+			case sfnt.SegmentOpCubeTo:
+				c = appendCubic(c, c[len(c)-1], toPixels(seg.Args[0]), toPixels(seg.Args[1]), toPixels(seg.Args[2]))
+			}
+		}
+		if len(c) > 2 {
+			contours = append(contours, c)
+		}
+	}
+	return contours
+}
+
+// flattenContour converts a TrueType contour of on-curve points and
+// quadratic control points into a closed polygon.
+func flattenContour(pts []truetype.Point, toPixels func(truetype.Point) vec) []vec {
+	type node struct {
+		v  vec
+		on bool
+	}
+	// Two control points in a row imply an on-curve point midway
+	var nodes []node
+	for i, p := range pts {
+		on := p.Flags&0x01 != 0
+		nodes = append(nodes, node{toPixels(p), on})
+		next := pts[(i+1)%len(pts)]
+		if !on && next.Flags&0x01 == 0 {
+			a, b := toPixels(p), toPixels(next)
+			nodes = append(nodes, node{vec{(a.x + b.x) / 2, (a.y + b.y) / 2}, true})
+		}
+	}
+	first := -1
+	for i, n := range nodes {
+		if n.on {
+			first = i
+			break
+		}
+	}
+	if first < 0 {
+		return nil
+	}
+	nodes = append(nodes[first:], nodes[:first]...)
+
+	poly := []vec{nodes[0].v}
+	for i := 1; i <= len(nodes); {
+		n := nodes[i%len(nodes)]
+		if n.on {
+			poly = append(poly, n.v)
+			i++
+			continue
+		}
+		// A control point is always followed by an on-curve point
+		poly = appendQuad(poly, poly[len(poly)-1], n.v, nodes[(i+1)%len(nodes)].v)
+		i += 2
+	}
+	return poly
+}
+
+// appendQuad appends the quadratic Bézier curve from p0 through control
+// point c to p2, excluding p0, as line segments within flattenTolerance.
+func appendQuad(poly []vec, p0, c, p2 vec) []vec {
+	// The deviation of n chords from the curve is at most |p0-2c+p2|/(8n²)
+	dd := math.Hypot(p0.x-2*c.x+p2.x, p0.y-2*c.y+p2.y)
+	n := int(math.Ceil(math.Sqrt(dd / (8 * flattenTolerance))))
+	n = min(max(n, 1), 64)
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		poly = append(poly, vec{
+			x: u*u*p0.x + 2*u*t*c.x + t*t*p2.x,
+			y: u*u*p0.y + 2*u*t*c.y + t*t*p2.y,
+		})
+	}
+	return poly
+}
+
+// appendCubic appends the cubic Bézier curve from p0 through control
+// points c1 and c2 to p3, excluding p0, as line segments within
+// flattenTolerance.
+func appendCubic(poly []vec, p0, c1, c2, p3 vec) []vec {
+	// The deviation of n chords from the curve is at most 3/4 of the
+	// largest second difference of the control points over n²
+	dd := max(math.Hypot(p0.x-2*c1.x+c2.x, p0.y-2*c1.y+c2.y), math.Hypot(c1.x-2*c2.x+p3.x, c1.y-2*c2.y+p3.y))
+	n := int(math.Ceil(math.Sqrt(3 * dd / (4 * flattenTolerance))))
+	n = min(max(n, 1), 64)
+	for i := 1; i <= n; i++ {
+		t := float64(i) / float64(n)
+		u := 1 - t
+		poly = append(poly, vec{
+			x: u*u*u*p0.x + 3*u*u*t*c1.x + 3*u*t*t*c2.x + t*t*t*p3.x,
+			y: u*u*u*p0.y + 3*u*u*t*c1.y + 3*u*t*t*c2.y + t*t*t*p3.y,
+		})
+	}
+	return poly
+}
+
+// strokePolygon adds a round-joined stroke extending width pixels either
+// side of the closed polygon poly to mask. Coverage is computed from each
+// pixel center's distance to the nearest edge, which anti-aliases the
+// stroke exactly; overlapping parts are merged by taking the maximum.
+func strokePolygon(mask *image.Alpha, poly []vec, width float64) {
+	b := mask.Bounds()
+	reach := width + 0.5
+	for i := range poly {
+		a, c := poly[i], poly[(i+1)%len(poly)]
+		x0 := max(int(math.Floor(min(a.x, c.x)-reach)), b.Min.X)
+		x1 := min(int(math.Ceil(max(a.x, c.x)+reach)), b.Max.X)
+		y0 := max(int(math.Floor(min(a.y, c.y)-reach)), b.Min.Y)
+		y1 := min(int(math.Ceil(max(a.y, c.y)+reach)), b.Max.Y)
+		for y := y0; y < y1; y++ {
+			for x := x0; x < x1; x++ {
+				d := segmentDistance(vec{float64(x) + 0.5, float64(y) + 0.5}, a, c)
+				cov := min(max(reach-d, 0), 1)
+				if alpha := uint8(cov*255 + 0.5); alpha > mask.Pix[mask.PixOffset(x, y)] {
+					mask.Pix[mask.PixOffset(x, y)] = alpha
+				}
+			}
+		}
+	}
+}
+
+// segmentDistance returns the distance from p to the line segment a-b.
+func segmentDistance(p, a, b vec) float64 {
+	dx, dy := b.x-a.x, b.y-a.y
+	t := 0.0
+	if l2 := dx*dx + dy*dy; l2 > 0 {
+		t = min(max(((p.x-a.x)*dx+(p.y-a.y)*dy)/l2, 0), 1)
+	}
+	return math.Hypot(p.x-(a.x+t*dx), p.y-(a.y+t*dy))
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"image"
+	"math"
+	"testing"
+
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestFlattenContour(t *testing.T) {
+	ident := func(p truetype.Point) vec { return vec{float64(p.X), float64(p.Y)} }
+	on, off := uint32(1), uint32(0)
+
+	square := []truetype.Point{{X: 0, Y: 0, Flags: on}, {X: 10, Y: 0, Flags: on}, {X: 10, Y: 10, Flags: on}, {X: 0, Y: 10, Flags: on}}
+	if got := flattenContour(square, ident); len(got) != 5 || got[0] != got[4] {
+		t.Errorf("square flattened to %v, want the four corners closed", got)
+	}
+
+	// Four control points only: a rounded contour through the implied
+	// midpoints, between 50 (the midpoints) and 53 (the curve middles) from
+	// the center
+	var ring []truetype.Point
+	for _, p := range [][2]fixed.Int26_6{{0, 100}, {100, 100}, {100, 0}, {0, 0}} {
+		ring = append(ring, truetype.Point{X: p[0], Y: p[1], Flags: off})
+	}
+	got := flattenContour(ring, ident)
+	if len(got) < 9 {
+		t.Fatalf("off-curve ring flattened to only %d points", len(got))
+	}
+	for _, v := range got {
+		if d := math.Hypot(v.x-50, v.y-50); d < 49.9 || d > 53.1 {
+			t.Errorf("point %v is %.1f from the center, not on the rounded contour", v, d)
+		}
+	}
+}
+
+// TestStrokeEvenWidth strokes a diagonal edge and checks that the outline
+// is equally thick along it, which stamped offsets are not.
+func TestStrokeEvenWidth(t *testing.T) {
+	mask := image.NewAlpha(image.Rect(0, 0, 60, 60))
+	strokePolygon(mask, []vec{{10, 10}, {50, 50}}, 3)
+
+	// Coverage summed across the stroke, perpendicular to it, at several
+	// points along the edge
+	var widths []float64
+	for _, s := range []float64{20, 25, 30, 35, 40} {
+		sum := 0.0
+		for k := -8.0; k <= 8; k += 0.25 {
+			x, y := s+k/math.Sqrt2, s-k/math.Sqrt2
+			sum += float64(mask.AlphaAt(int(x), int(y)).A) / 255 * 0.25
+		}
+		widths = append(widths, sum)
+	}
+	for _, w := range widths {
+		if math.Abs(w-widths[0]) > 0.3 || math.Abs(w-6) > 1 {
+			t.Errorf("stroke widths along the edge %.2f, want an even 6", widths)
+			break
+		}
+	}
+}
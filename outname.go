@@ -0,0 +1,136 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// Placeholders expanded in -out patterns
+const (
+	placeholderSlug = "{slug}" // The caption as a filesystem-safe slug
+	placeholderN    = "{n}"    // The first counter from 1 that gives an unused name
+	placeholderDate = "{date}" // The date as YYYYMMDD
+)
+
+// maxSlugLen is the most bytes a caption slug may take.
+const maxSlugLen = 60
+
+// maxOutputN is the highest {n} tried before giving up on finding a free
+// name.
+const maxOutputN = 100000
+
+// fallbackSlug stands in for captions with nothing usable in a file name.
+const fallbackSlug = "meme"
+
+var placeholderRe = regexp.MustCompile(`\{[^{}]*\}`)
+
+// outputNamer expands an -out pattern into output paths. It is safe for
+// concurrent use: names handed out for {n} are remembered, so parallel
+// batch jobs with the same caption get distinct files.
+type outputNamer struct {
+	pattern string
+	date    string
+
+	mu      sync.Mutex
+	claimed map[string]bool
+}
+
+// newOutputNamer checks pattern for unknown placeholders and returns a
+// namer expanding {date} to the date of now.
+func newOutputNamer(pattern string, now time.Time) (*outputNamer, error) {
+	for _, p := range placeholderRe.FindAllString(pattern, -1) {
+		switch p {
+		case placeholderSlug, placeholderN, placeholderDate:
+		default:
+			return nil, fmt.Errorf("unknown placeholder %s in %q (want {slug}, {n} or {date})", p, pattern)
+		}
+	}
+	return &outputNamer{pattern: pattern, date: now.Format("20060102"), claimed: make(map[string]bool)}, nil
+}
+
+// name returns the output path for caption, creating its directory. With
+// {n} in the pattern the path is one not already taken.
+func (o *outputNamer) name(caption string) (string, error) {
+	base := strings.NewReplacer(placeholderSlug, slugify(caption), placeholderDate, o.date).Replace(o.pattern)
+	path := base
+	if strings.Contains(base, placeholderN) {
+		o.mu.Lock()
+		defer o.mu.Unlock()
+		for n := 1; ; n++ {
+			if n > maxOutputN {
+				return "", fmt.Errorf("no free output name for %q up to {n} = %d", base, maxOutputN)
+			}
+			path = strings.ReplaceAll(base, placeholderN, strconv.Itoa(n))
+			if o.claimed[path] {
+				continue
+			}
+			_, err := os.Lstat(path)
+			if errors.Is(err, fs.ErrNotExist) {
+				o.claimed[path] = true
+				break
+			}
+			if err != nil {
+				return "", fmt.Errorf("checking output file: %w", err)
+			}
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o777); err != nil {
+		return "", fmt.Errorf("creating output directory: %w", err)
+	}
+	return path, nil
+}
+
+// slugify turns caption into a lowercase file name part: letters and digits
+// are kept, accented Latin letters lose their accents, and everything else
+// becomes a single dash. It is at most maxSlugLen bytes and never empty.
+func slugify(caption string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(caption) {
+		if t, ok := transliterations[r]; ok {
+			b.WriteString(t)
+			dash = false
+			continue
+		}
+		if r < unicode.MaxASCII && (unicode.IsLetter(r) || unicode.IsDigit(r)) {
+			b.WriteRune(r)
+			dash = false
+			continue
+		}
+		if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+	slug := b.String()
+	if len(slug) > maxSlugLen {
+		slug = slug[:maxSlugLen] // All ASCII, so any cut is between characters
+	}
+	if slug = strings.Trim(slug, "-"); slug == "" {
+		return fallbackSlug
+	}
+	return slug
+}
+
+// transliterations spell accented Latin letters in ASCII. Other scripts
+// are dropped from slugs.
+var transliterations = map[rune]string{
+	'à': "a", 'á': "a", 'â': "a", 'ã': "a", 'ä': "a", 'å': "a", 'ā': "a", 'ą': "a",
+	'æ': "ae", 'ç': "c", 'ć': "c", 'č': "c", 'ď': "d", 'đ': "d", 'ð': "d",
+	'è': "e", 'é': "e", 'ê': "e", 'ë': "e", 'ē': "e", 'ę': "e", 'ě': "e",
+	'ì': "i", 'í': "i", 'î': "i", 'ï': "i", 'ī': "i", 'ł': "l",
+	'ñ': "n", 'ń': "n", 'ň': "n",
+	'ò': "o", 'ó': "o", 'ô': "o", 'õ': "o", 'ö': "o", 'ø': "o", 'ō': "o", 'ő': "o", 'œ': "oe",
+	'ř': "r", 'ś': "s", 'š': "s", 'ß': "ss", 'ť': "t", 'þ': "th",
+	'ù': "u", 'ú': "u", 'û': "u", 'ü': "u", 'ū': "u", 'ů': "u", 'ű': "u",
+	'ý': "y", 'ÿ': "y", 'ź': "z", 'ż': "z", 'ž': "z",
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSlugify(t *testing.T) {
+	cases := []struct{ caption, want string }{
+		{"ONE DOES NOT SIMPLY", "one-does-not-simply"},
+		{"  Wait... what?!  ", "wait-what"},
+		{"ÆØÅ ÜBER straße", "aeoa-uber-strasse"},
+		{"日本語 meme 2", "meme-2"}, // Other scripts are dropped
+		{"日本語", fallbackSlug},
+		{"", fallbackSlug},
+		{"???", fallbackSlug},
+		{strings.Repeat("ab ", 40), strings.TrimSuffix(strings.Repeat("ab-", 20), "-")},
+	}
+	for _, tc := range cases {
+		got := slugify(tc.caption)
+		if got != tc.want {
+			t.Errorf("slugify(%q) = %q, want %q", tc.caption, got, tc.want)
+		}
+		if len(got) > maxSlugLen {
+			t.Errorf("slugify(%q) is %d bytes, want at most %d", tc.caption, len(got), maxSlugLen)
+		}
+	}
+}
+
+func TestOutputNamer(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2026, 3, 7, 12, 0, 0, 0, time.UTC)
+	namer, err := newOutputNamer(filepath.Join(dir, "memes", "{date}", "{slug}-{n}.png"), now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sub := filepath.Join(dir, "memes", "20260307")
+
+	// The directory is created; an existing file is skipped, and so is a
+	// name already handed out but not yet written
+	first, err := namer.name("HELLO WORLD")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(sub, "hello-world-1.png"); first != want {
+		t.Errorf("first name %s, want %s", first, want)
+	}
+	if err := os.WriteFile(filepath.Join(sub, "hello-world-2.png"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	second, err := namer.name("Hello, world!")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := filepath.Join(sub, "hello-world-3.png"); second != want {
+		t.Errorf("second name %s, want %s", second, want)
+	}
+}
+
+func TestOutputNamerUnknownPlaceholder(t *testing.T) {
+	if _, err := newOutputNamer("{slug}-{time}.png", time.Now()); err == nil || !strings.Contains(err.Error(), "{time}") {
+		t.Errorf("got %v, want an error naming {time}", err)
+	}
+}
+
+// TestOutputNamerNotADirectory checks that a path that can't be checked
+// is an error rather than a reason to try the next {n} forever.
+func TestOutputNamerNotADirectory(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "notadir"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	namer, err := newOutputNamer(filepath.Join(dir, "notadir", "{slug}-{n}.png"), time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := namer.name("hi"); err == nil {
+		t.Errorf("got %s, want an error", name)
+	}
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"text/tabwriter"
+)
+
+// exitPartialFailure is the exit status when some outputs of a run were
+// written and others failed.
+const exitPartialFailure = 6
+
+// Exit statuses when every output of a run failed, by the category of the
+// first failure. Other failures, such as a caption that doesn't render,
+// exit with 1.
+const (
+	exitOutputExists = 4 // An output file exists and -force wasn't given
+	exitWriteFailed  = 5 // An output file couldn't be created or written
+	exitUploadFailed = 8 // The -post upload failed or was refused
+)
+
+// Artifact statuses reported in a multiResult
+const (
+	statusOK        = "ok"
+	statusFailed    = "failed"
+	statusDuplicate = "duplicate" // Skipped by -skip-duplicates, which is no failure
+)
+
+// artifact is the outcome of delivering one output of a run.
+type artifact struct {
+	Destination string `json:"destination"`
+	Status      string `json:"status"`             // statusOK, statusFailed or statusDuplicate
+	Bytes       int64  `json:"bytes"`              // Bytes delivered, which may be partial on failure
+	Response    string `json:"response,omitempty"` // What the destination answered, for uploads, or what duplicates were skipped
+	Error       string `json:"error,omitempty"`
+	code        int    // Exit status for the failure, by exitCategory
+}
+
+// multiResult collects the outcomes of a run that delivers the image to
+// several destinations, so that one failure doesn't hide the others'
+// success.
+type multiResult struct {
+	Artifacts []artifact `json:"artifacts"`
+	Halted    bool       `json:"halted,omitempty"`  // Stopped early by -halt-file
+	Skipped   int        `json:"skipped,omitempty"` // Outputs not attempted because of the halt
+}
+
+// add records the outcome of delivering n bytes to dest. An error wrapping
+// errDuplicate records a skipped duplicate.
+func (r *multiResult) add(dest string, n int64, err error) {
+	a := artifact{Destination: dest, Status: statusOK, Bytes: n}
+	switch {
+	case errors.Is(err, errDuplicate):
+		a.Status, a.Response = statusDuplicate, err.Error()
+	case err != nil:
+		a.Status, a.Error, a.code = statusFailed, err.Error(), exitCategory(err)
+	}
+	r.Artifacts = append(r.Artifacts, a)
+}
+
+// addUpload records the outcome of uploading n bytes to dest, along with
+// the destination's response.
+func (r *multiResult) addUpload(dest string, n int64, response string, err error) {
+	r.add(dest, n, err)
+	a := &r.Artifacts[len(r.Artifacts)-1]
+	a.Response = response
+	if err != nil {
+		a.code = exitUploadFailed
+	}
+}
+
+// exitCategory returns the exit status for a failure to deliver an output:
+// exitOutputExists or exitWriteFailed for output files, and 1 for anything
+// else.
+func exitCategory(err error) int {
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, errOutputExists):
+		return exitOutputExists
+	case errors.As(err, &pathErr):
+		return exitWriteFailed
+	default:
+		return 1
+	}
+}
+
+// writeFiles writes data to each of paths, replacing existing files only
+// if force is set, and sc, if not nil, to the sidecar file of each one
+// written.
+func writeFiles(data []byte, paths []string, force bool, sc *sidecar) *multiResult {
+	r := &multiResult{}
+	for _, path := range paths {
+		n, err := writeFile(path, data, force)
+		if err == nil && sc != nil {
+			err = writeSidecar(path, sc, force)
+		}
+		r.add(path, n, err)
+	}
+	return r
+}
+
+// writeFile writes data to path with createOutput, returning how much was
+// written.
+func writeFile(path string, data []byte, force bool) (int64, error) {
+	f, err := createOutput(path, force)
+	if err != nil {
+		return 0, err
+	}
+	n, err := f.Write(data)
+	if err != nil {
+		f.discard()
+		return int64(n), fmt.Errorf("writing output file: %w", err)
+	}
+	if err := f.commit(); err != nil {
+		return int64(n), err
+	}
+	return int64(n), nil
+}
+
+// exitCode returns the process exit status for the result: exitHalted
+// when the run was halted, 0 when every artifact was delivered or skipped
+// as a duplicate, exitPartialFailure when only some were, and the category
+// of the first failure when none were.
+func (r *multiResult) exitCode() int {
+	if r.Halted {
+		return exitHalted
+	}
+	failed, first := 0, 0
+	for _, a := range r.Artifacts {
+		if a.Status == statusFailed {
+			if failed == 0 {
+				first = a.code
+			}
+			failed++
+		}
+	}
+	switch {
+	case failed == 0:
+		return 0
+	case failed < len(r.Artifacts):
+		return exitPartialFailure
+	default:
+		return max(first, 1)
+	}
+}
+
+// print writes the result as a table, or as JSON for porcelain output.
+func (r *multiResult) print(w io.Writer, porcelain bool) error {
+	if porcelain {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(r)
+	}
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "DESTINATION\tSTATUS\tBYTES\tDETAIL")
+	for _, a := range r.Artifacts {
+		detail := a.Error
+		if detail == "" {
+			detail = a.Response
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%s\n", a.Destination, a.Status, a.Bytes, detail)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	if r.Halted {
+		_, err := fmt.Fprintf(w, "Halted with %d not started\n", r.Skipped)
+		return err
+	}
+	return nil
+}
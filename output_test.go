@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteFilesPartialFailure(t *testing.T) {
+	dir := t.TempDir()
+	good := filepath.Join(dir, "good.png")
+	bad := filepath.Join(dir, "missing", "bad.png") // Parent doesn't exist
+	data := []byte("not really a png")
+
+	result := writeFiles(data, []string{good, bad}, false, nil)
+	if code := result.exitCode(); code != exitPartialFailure {
+		t.Errorf("exit code = %d, want %d", code, exitPartialFailure)
+	}
+	if len(result.Artifacts) != 2 {
+		t.Fatalf("got %d artifacts, want 2", len(result.Artifacts))
+	}
+	ok, failed := result.Artifacts[0], result.Artifacts[1]
+	if ok.Destination != good || ok.Status != statusOK || ok.Bytes != int64(len(data)) || ok.Error != "" {
+		t.Errorf("good artifact = %+v", ok)
+	}
+	if failed.Destination != bad || failed.Status != statusFailed || failed.Bytes != 0 || failed.Error == "" {
+		t.Errorf("failed artifact = %+v", failed)
+	}
+	if got, err := os.ReadFile(good); err != nil || !bytes.Equal(got, data) {
+		t.Errorf("good file = %q, %v", got, err)
+	}
+
+	var table bytes.Buffer
+	if err := result.print(&table, false); err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(table.String()), "\n")
+	if len(lines) != 3 || !strings.HasPrefix(lines[0], "DESTINATION") ||
+		!strings.Contains(lines[1], "ok") || !strings.Contains(lines[2], "failed") {
+		t.Errorf("table:\n%s", table.String())
+	}
+
+	var porcelain bytes.Buffer
+	if err := result.print(&porcelain, true); err != nil {
+		t.Fatal(err)
+	}
+	var decoded multiResult
+	if err := json.Unmarshal(porcelain.Bytes(), &decoded); err != nil {
+		t.Fatalf("decoding porcelain output: %v", err)
+	}
+	if len(decoded.Artifacts) != 2 || decoded.Artifacts[1].Error != failed.Error {
+		t.Errorf("porcelain round trip = %+v", decoded)
+	}
+}
+
+func TestMultiResultExitCode(t *testing.T) {
+	exists := fmt.Errorf("output file x.png %w (use -force to overwrite)", errOutputExists)
+	denied := fmt.Errorf("creating output file: %w", &fs.PathError{Op: "open", Path: "x.png", Err: fs.ErrPermission})
+	render := errors.New("caption does not fit")
+	cases := []struct {
+		errs    []error // Each artifact's error, nil if it was delivered
+		uploads []error // Then each upload's
+		want    int
+	}{
+		{[]error{nil}, nil, 0},
+		{[]error{nil, nil}, []error{nil}, 0},
+		{[]error{nil, denied}, nil, exitPartialFailure},
+		{[]error{exists}, []error{nil}, exitPartialFailure},
+		{[]error{render, render}, nil, 1},
+		{[]error{exists, denied}, nil, exitOutputExists},
+		{[]error{denied, exists}, nil, exitWriteFailed},
+		{[]error{render, exists}, nil, 1},
+		{nil, []error{errors.New("posting image: HTTP 500")}, exitUploadFailed},
+		{[]error{denied}, []error{errors.New("posting image: HTTP 500")}, exitWriteFailed},
+	}
+	for _, tc := range cases {
+		r := &multiResult{}
+		for _, err := range tc.errs {
+			r.add("x.png", 1, err)
+		}
+		for _, err := range tc.uploads {
+			r.addUpload("https://example.com/", 1, "", err)
+		}
+		if got := r.exitCode(); got != tc.want {
+			t.Errorf("failures %v, uploads %v: exit code %d, want %d", tc.errs, tc.uploads, got, tc.want)
+		}
+	}
+}
+
+// TestWriteFilesExitCategory checks the categories of real output file
+// failures.
+func TestWriteFilesExitCategory(t *testing.T) {
+	dir := t.TempDir()
+	existing := filepath.Join(dir, "existing.png")
+	if err := os.WriteFile(existing, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if code := writeFiles([]byte("png"), []string{existing}, false, nil).exitCode(); code != exitOutputExists {
+		t.Errorf("existing file: exit code %d, want %d", code, exitOutputExists)
+	}
+	missing := filepath.Join(dir, "missing", "bad.png")
+	if code := writeFiles([]byte("png"), []string{missing}, false, nil).exitCode(); code != exitWriteFailed {
+		t.Errorf("missing directory: exit code %d, want %d", code, exitWriteFailed)
+	}
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+	"golang.org/x/image/math/f64"
+)
+
+// maxOverlayScale is the largest scale an -overlay may be given. An
+// overlay blown up further is a blur the size of a building.
+const maxOverlayScale = 100.0
+
+// An overlay is an image, such as a logo or an emoji cutout, composited
+// onto the template before the captions are drawn. -overlay gives one as
+//
+//	sticker.png@x,y[,scale]
+//
+// x,y is where its top-left corner goes, in any of the coordinate forms
+// parsePoint accepts, relative to the template at its -width/-height size.
+// The overlay may hang partly or wholly off the template. scale resizes it
+// first; -scale applies on top, to the position as well.
+
+// overlay is one -overlay. Image is nil until loadOverlays has run.
+type overlay struct {
+	Path  string
+	At    string  // Coordinates of the top-left corner, resolved at layout
+	Scale float64 // 0 means 1
+	Image image.Image
+}
+
+// String returns o as written on the command line.
+func (o overlay) String() string {
+	s := o.Path + "@" + o.At
+	if o.Scale != 0 {
+		s += "," + strconv.FormatFloat(o.Scale, 'g', -1, 64)
+	}
+	return s
+}
+
+// overlayFlags collects repeated -overlay flags as given, in order. They
+// are parsed with parseOverlay along with the other options, so that a bad
+// one is reported with the rest. It implements flag.Value.
+type overlayFlags []string
+
+// String formats the overlays as a space-separated list.
+func (f *overlayFlags) String() string {
+	return strings.Join(*f, " ")
+}
+
+// Set adds an overlay given as path@x,y[,scale].
+func (f *overlayFlags) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+// cutAt splits s of the form name@coordinates. The name may itself contain
+// '@' (as in logo@2x.png): the coordinates start after the last one, or the
+// last two for an expression such as sticker.png@@w-100,h-100.
+func cutAt(s string) (name, at string, ok bool) {
+	i := strings.LastIndex(s, "@")
+	if i > 0 && s[i-1] == '@' {
+		i--
+	}
+	if i <= 0 {
+		return "", "", false
+	}
+	return s[:i], s[i+1:], true
+}
+
+// parseOverlay parses path@x,y[,scale], split by cutAt.
+func parseOverlay(s string) (overlay, error) {
+	path, at, ok := cutAt(s)
+	if !ok {
+		return overlay{}, fmt.Errorf("overlay %q: want path@x,y or path@x,y,scale", s)
+	}
+	o := overlay{Path: path, At: at}
+	parts := strings.Split(o.At, ",")
+	switch len(parts) {
+	case 2:
+	case 3:
+		scale, err := strconv.ParseFloat(strings.TrimSpace(parts[2]), 64)
+		if err != nil || !(scale > 0) || math.IsInf(scale, 0) {
+			return overlay{}, fmt.Errorf("overlay %q: scale %q must be a positive number", s, parts[2])
+		}
+		if scale > maxOverlayScale {
+			return overlay{}, fmt.Errorf("overlay %q: scale %q must be at most %g", s, parts[2], maxOverlayScale)
+		}
+		o.At, o.Scale = strings.Join(parts[:2], ","), scale
+	default:
+		return overlay{}, fmt.Errorf("overlay %q: want path@x,y or path@x,y,scale", s)
+	}
+	return o, nil
+}
+
+// loadOverlays reads and decodes the image of each overlay. PNG keeps its
+// transparency; JPEG and GIF work too.
+func loadOverlays(overlays []overlay) error {
+	for i := range overlays {
+		o := &overlays[i]
+		data, err := os.ReadFile(o.Path)
+		if err != nil {
+			return fmt.Errorf("reading overlay: %w", err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("decoding overlay %s: %w", o.Path, err)
+		}
+		o.Image = img
+	}
+	return nil
+}
+
+// layoutOverlays returns where each overlay is drawn for a template placed
+// at tmpl on the canvas, at the output scale. The rectangles may extend
+// beyond the canvas.
+func layoutOverlays(tmpl image.Rectangle, overlays []overlay, scale float64) ([]pixelRect, error) {
+	if scale == 0 {
+		scale = 1
+	}
+	// Coordinates refer to the template as it is before -scale
+	w, h := int(math.Round(float64(tmpl.Dx())/scale)), int(math.Round(float64(tmpl.Dy())/scale))
+	rects := make([]pixelRect, len(overlays))
+	for i, o := range overlays {
+		if o.Image == nil {
+			return nil, fmt.Errorf("overlay %d: %s is not loaded", i+1, o.Path)
+		}
+		pt, err := parsePoint(o.At, w, h)
+		if err != nil {
+			return nil, fmt.Errorf("overlay %d: %w", i+1, err)
+		}
+		factor := scale
+		if o.Scale != 0 {
+			factor *= o.Scale
+		}
+		size := o.Image.Bounds().Size()
+		// Not scaleSize: an overlay may be far larger than any output,
+		// since only the part on the canvas is drawn
+		dw, dh := max(scalePx(size.X, factor), 1), max(scalePx(size.Y, factor), 1)
+		at := tmpl.Min.Add(image.Pt(scalePx(pt.X, scale), scalePx(pt.Y, scale)))
+		rects[i] = pixelRect{X: at.X, Y: at.Y, W: dw, H: dh}
+	}
+	return rects, nil
+}
+
+// drawOverlays composites each overlay over dst at its rectangle, in
+// order, resampling those drawn at another size. Whatever falls outside dst
+// is clipped.
+func drawOverlays(dst *image.RGBA, rects []pixelRect, overlays []overlay) {
+	for i, o := range overlays {
+		r, src := rects[i].rect(), o.Image
+		sr := src.Bounds()
+		switch {
+		case r.Size() == sr.Size():
+			draw.Draw(dst, r, src, sr.Min, draw.Over)
+		case r.In(dst.Bounds()):
+			xdraw.CatmullRom.Scale(dst, r, src, sr, xdraw.Over, nil)
+		default:
+			// Scale works through buffers as large as all of r, which
+			// for an overlay blown up past the canvas can be far more
+			// than the memory there is. The same mapping as a transform
+			// only visits the pixels inside dst.
+			xdraw.CatmullRom.Transform(dst, scaleAff(r, sr), src, sr, xdraw.Over, nil)
+		}
+	}
+}
+
+// scaleAff returns the transform from source to destination coordinates
+// that maps sr onto dr.
+func scaleAff(dr, sr image.Rectangle) f64.Aff3 {
+	kx := float64(dr.Dx()) / float64(sr.Dx())
+	ky := float64(dr.Dy()) / float64(sr.Dy())
+	return f64.Aff3{
+		kx, 0, float64(dr.Min.X) - kx*float64(sr.Min.X),
+		0, ky, float64(dr.Min.Y) - ky*float64(sr.Min.Y),
+	}
+}
+
+// overlayKey identifies the overlays drawn into a background: which images,
+// and where.
+func overlayKey(rects []pixelRect, overlays []overlay) string {
+	var b strings.Builder
+	for i, o := range overlays {
+		fmt.Fprintf(&b, "%p@%v;", o.Image, rects[i])
+	}
+	return b.String()
+}
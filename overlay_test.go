@@ -0,0 +1,135 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"testing"
+)
+
+// testSticker returns a size×size sticker: an opaque red disc on a
+// transparent background, with a soft half-transparent rim.
+func testSticker(size int) image.Image {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	c := float64(size) / 2
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			dx, dy := float64(x)+0.5-c, float64(y)+0.5-c
+			switch d := dx*dx + dy*dy; {
+			case d < c*c*0.6:
+				img.SetNRGBA(x, y, color.NRGBA{R: 220, G: 20, B: 20, A: 255})
+			case d < c*c:
+				img.SetNRGBA(x, y, color.NRGBA{R: 255, G: 255, B: 255, A: 128})
+			}
+		}
+	}
+	return img
+}
+
+func TestParseOverlay(t *testing.T) {
+	cases := []struct {
+		spec string
+		want overlay
+	}{
+		{"sticker.png@10,20", overlay{Path: "sticker.png", At: "10,20"}},
+		{"sticker.png@-30,50%,0.5", overlay{Path: "sticker.png", At: "-30,50%", Scale: 0.5}},
+		{"logo@2x.png@0,0", overlay{Path: "logo@2x.png", At: "0,0"}},
+		{"arrow.png@@w-100,h/2,2", overlay{Path: "arrow.png", At: "@w-100,h/2", Scale: 2}},
+	}
+	for _, tc := range cases {
+		got, err := parseOverlay(tc.spec)
+		if err != nil {
+			t.Errorf("parseOverlay(%q): %v", tc.spec, err)
+			continue
+		}
+		if got != tc.want {
+			t.Errorf("parseOverlay(%q) = %+v, want %+v", tc.spec, got, tc.want)
+		}
+		if got.String() != tc.spec {
+			t.Errorf("%+v formats as %q, want %q", got, got.String(), tc.spec)
+		}
+	}
+
+	for _, spec := range []string{"sticker.png", "@10,10", "sticker.png@10", "sticker.png@1,2,3,4", "sticker.png@1,2,0", "sticker.png@1,2,big", "sticker.png@1,2,1e6"} {
+		if _, err := parseOverlay(spec); err == nil {
+			t.Errorf("parseOverlay(%q) accepted", spec)
+		}
+	}
+}
+
+// TestOverlaysOffCanvas checks that overlays hanging off any edge, or lying
+// entirely outside the canvas, are clipped rather than causing a panic.
+func TestOverlaysOffCanvas(t *testing.T) {
+	sticker := testSticker(40)
+	tmpl := image.Rect(0, 30, 100, 130) // Below a 30px caption bar
+	var overlays []overlay
+	for _, at := range []string{"-20,-20", "80,80", "-1000,50", "50,100000", "@w-10,h-10"} {
+		overlays = append(overlays, overlay{Path: "sticker.png", At: at, Image: sticker})
+	}
+	overlays = append(overlays, overlay{Path: "sticker.png", At: "90,-5", Scale: 2.5, Image: sticker})
+
+	rects, err := layoutOverlays(tmpl, overlays, 0)
+	if err != nil {
+		t.Fatalf("layoutOverlays: %v", err)
+	}
+	if got, want := rects[0].rect(), image.Rect(-20, 10, 20, 50); got != want {
+		t.Errorf("first overlay at %v, want %v relative to the template", got, want)
+	}
+	if got := rects[5].rect().Size(); got != image.Pt(100, 100) {
+		t.Errorf("scaled overlay is %v, want 100x100", got)
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, 100, 130))
+	drawOverlays(dst, rects, overlays)
+	if got := dst.RGBAAt(0, 30); got.R != 220 || got.A != 255 {
+		t.Errorf("top-left corner = %v, want the sticker's red", got)
+	}
+}
+
+// TestOverlayScale checks that -scale grows overlays and their positions.
+func TestOverlayScale(t *testing.T) {
+	overlays := []overlay{{Path: "sticker.png", At: "10,20", Scale: 0.5, Image: testSticker(40)}}
+	rects, err := layoutOverlays(image.Rect(0, 0, 960, 540), overlays, 2)
+	if err != nil {
+		t.Fatalf("layoutOverlays: %v", err)
+	}
+	if got, want := rects[0].rect(), image.Rect(20, 40, 60, 80); got != want {
+		t.Errorf("overlay at %v, want %v", got, want)
+	}
+}
+
+// TestOverlayHugeScale checks that an overlay scaled far past the canvas is
+// drawn by visiting only the canvas, and that an overlay hanging off it is
+// drawn as it would be on a larger canvas.
+func TestOverlayHugeScale(t *testing.T) {
+	sticker := testSticker(40)
+	overlays := []overlay{{Path: "sticker.png", At: "-2000000,-2000000", Scale: 1e5, Image: sticker}}
+	rects, err := layoutOverlays(image.Rect(0, 0, 100, 100), overlays, 0)
+	if err != nil {
+		t.Fatalf("layoutOverlays: %v", err)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	drawOverlays(dst, rects, overlays)
+	if got := dst.RGBAAt(50, 50); got.R != 220 || got.A != 255 {
+		t.Errorf("centre = %v, want the sticker's red", got)
+	}
+
+	overlays = []overlay{{Path: "sticker.png", At: "-30,40", Scale: 2.5, Image: sticker}}
+	rects, err = layoutOverlays(image.Rect(0, 0, 100, 100), overlays, 0)
+	if err != nil {
+		t.Fatalf("layoutOverlays: %v", err)
+	}
+	clipped := image.NewRGBA(image.Rect(0, 0, 100, 100))
+	drawOverlays(clipped, rects, overlays)
+	whole := image.NewRGBA(image.Rect(-30, 0, 100, 140))
+	drawOverlays(whole, rects, overlays)
+	for y := 0; y < 100; y++ {
+		for x := 0; x < 100; x++ {
+			a, b := clipped.At(x, y), whole.At(x, y)
+			ar, ag, ab, aa := a.RGBA()
+			br, bg, bb, ba := b.RGBA()
+			if max(absDiff(ar, br), absDiff(ag, bg), absDiff(ab, bb), absDiff(aa, ba)) > 2*0x101 {
+				t.Fatalf("at %d,%d clipped overlay is %v, want about %v", x, y, a, b)
+			}
+		}
+	}
+}
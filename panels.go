@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Stacked-panel memes, such as "expanding brain", are several templates
+// stacked top to bottom, each with its own caption:
+//
+//	memegen -panel small.png:"using tabs" -panel big.png:"using spaces" out.png
+//
+// The panels are scaled to a common width and composed into one template,
+// and each caption becomes a text box covering its panel, fitted like the
+// boxes of a -spec file.
+
+const defaultPanelSeparator = 4 // Separator line thickness in pixels
+
+// panel is one -panel: a template image and the caption drawn on it.
+type panel struct {
+	Path string
+	Text string
+}
+
+// panelFlags collects repeated -panel flags, in order. It implements
+// flag.Value.
+type panelFlags []panel
+
+// String formats the panels as a space-separated list.
+func (f *panelFlags) String() string {
+	parts := make([]string, len(*f))
+	for i, p := range *f {
+		parts[i] = p.Path + ":" + p.Text
+	}
+	return strings.Join(parts, " ")
+}
+
+// Set adds a panel given as path:caption. The caption is everything after
+// the first colon and may be empty.
+func (f *panelFlags) Set(s string) error {
+	path, text, ok := strings.Cut(s, ":")
+	if !ok || path == "" {
+		return fmt.Errorf("panel %q: want template.png:caption", s)
+	}
+	*f = append(*f, panel{Path: path, Text: text})
+	return nil
+}
+
+// parsePanelPositions parses -panel-position for n panels: a single
+// position for all of them, or a comma-separated list with one per panel.
+func parsePanelPositions(s string, n int) ([]string, error) {
+	positions := strings.Split(s, ",")
+	if len(positions) == 1 {
+		positions = slices.Repeat(positions, n)
+	}
+	if len(positions) != n {
+		return nil, fmt.Errorf("got %d positions for %d panels (give one, or one per panel)", len(positions), n)
+	}
+	for i, p := range positions {
+		p = strings.TrimSpace(p)
+		switch p {
+		case positionTop, positionMiddle, positionBottom:
+		default:
+			return nil, fmt.Errorf("unknown position %q (want top, middle or bottom)", p)
+		}
+		positions[i] = p
+	}
+	return positions, nil
+}
+
+// loadPanelImages reads and decodes the template of each panel.
+func loadPanelImages(panels []panel) ([]image.Image, error) {
+	imgs := make([]image.Image, len(panels))
+	for i, p := range panels {
+		data, err := os.ReadFile(p.Path)
+		if err != nil {
+			return nil, fmt.Errorf("panel %d: reading template: %w", i+1, err)
+		}
+		img, _, err := image.Decode(bytes.NewReader(data))
+		if err != nil {
+			return nil, fmt.Errorf("panel %d: decoding %s: %w", i+1, p.Path, err)
+		}
+		imgs[i] = img
+	}
+	return imgs, nil
+}
+
+// stackPanels scales imgs to a common width, the widest of them unless
+// width is set, and stacks them top to bottom. The height is the sum of the
+// scaled panel heights: a separator line of the given thickness is drawn
+// across each boundary, over the edges of the panels on both sides. It
+// returns the stacked image and each panel's rectangle in it.
+func stackPanels(imgs []image.Image, width, separator int, sepColor color.NRGBA) (*image.RGBA, []image.Rectangle) {
+	if width == 0 {
+		for _, img := range imgs {
+			width = max(width, img.Bounds().Dx())
+		}
+	}
+	rects := make([]image.Rectangle, len(imgs))
+	y := 0
+	for i, img := range imgs {
+		b := img.Bounds()
+		_, h, _ := targetSize(b.Dx(), b.Dy(), width, 0)
+		rects[i] = image.Rect(0, y, width, y+h)
+		y += h
+	}
+
+	stacked := image.NewRGBA(image.Rect(0, 0, width, y))
+	for i, img := range imgs {
+		if rects[i].Size() == img.Bounds().Size() {
+			draw.Draw(stacked, rects[i], img, img.Bounds().Min, draw.Src)
+		} else {
+			scaleInto(stacked, rects[i], img)
+		}
+	}
+	if separator > 0 {
+		for _, r := range rects[1:] {
+			top := r.Min.Y - separator/2
+			line := image.Rect(0, top, width, top+separator)
+			draw.Draw(stacked, line, image.NewUniform(sepColor), image.Point{}, draw.Over)
+		}
+	}
+	return stacked, rects
+}
+
+// panelBoxes returns the caption box of each panel, placed in rects at
+// positions, with the caption size and padding of opts. The padding keeps
+// the captions clear of half a separator too.
+func panelBoxes(panels []panel, rects []image.Rectangle, positions []string, separator int, opts Options) []captionBox {
+	size, pad := fontSize, paddingY
+	if opts.Size > 0 {
+		size = opts.Size
+	}
+	if opts.Padding != nil {
+		pad = *opts.Padding
+	}
+	boxes := make([]captionBox, len(panels))
+	for i, p := range panels {
+		boxes[i] = captionBox{
+			Rect:     rects[i],
+			Text:     p.Text,
+			Position: positions[i],
+			Align:    alignCenter,
+			Size:     size,
+			PadX:     pad,
+			PadY:     pad + (separator+1)/2,
+		}
+	}
+	return boxes
+}
+
+// panelTemplateName returns the template names of panels for the PNG
+// metadata, comma-separated.
+func panelTemplateName(panels []panel) string {
+	names := make([]string, len(panels))
+	for i, p := range panels {
+		names[i] = filepath.Base(p.Path)
+	}
+	return strings.Join(names, ",")
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/png"
+	"path/filepath"
+	"testing"
+)
+
+func TestPanelFlags(t *testing.T) {
+	var f panelFlags
+	for _, s := range []string{"a.png:SMALL BRAIN", "b.png:", "c.png:NOTE: COLONS STAY"} {
+		if err := f.Set(s); err != nil {
+			t.Errorf("Set(%q): %v", s, err)
+		}
+	}
+	want := panelFlags{{"a.png", "SMALL BRAIN"}, {"b.png", ""}, {"c.png", "NOTE: COLONS STAY"}}
+	if len(f) != len(want) {
+		t.Fatalf("got %d panels, want %d", len(f), len(want))
+	}
+	for i := range want {
+		if f[i] != want[i] {
+			t.Errorf("panel %d = %+v, want %+v", i+1, f[i], want[i])
+		}
+	}
+	for _, s := range []string{"a.png", ":caption"} {
+		if err := f.Set(s); err == nil {
+			t.Errorf("Set(%q) accepted", s)
+		}
+	}
+}
+
+func TestParsePanelPositions(t *testing.T) {
+	got, err := parsePanelPositions("middle", 3)
+	if err != nil || len(got) != 3 || got[2] != positionMiddle {
+		t.Errorf("one position for three panels = %v, %v", got, err)
+	}
+	got, err = parsePanelPositions("top, bottom", 2)
+	if err != nil || got[0] != positionTop || got[1] != positionBottom {
+		t.Errorf("a position per panel = %v, %v", got, err)
+	}
+	for _, s := range []string{"top,middle", "center"} {
+		if _, err := parsePanelPositions(s, 3); err == nil {
+			t.Errorf("%q for 3 panels accepted", s)
+		}
+	}
+}
+
+// TestStackPanels checks that panels are scaled to the widest, stacked to
+// the sum of their heights, and separated by a line over the boundary.
+func TestStackPanels(t *testing.T) {
+	narrow := image.NewRGBA(image.Rect(0, 0, 100, 50))
+	wide := image.NewRGBA(image.Rect(0, 0, 200, 60))
+	red := color.NRGBA{R: 255, A: 255}
+	stacked, rects := stackPanels([]image.Image{narrow, wide}, 0, 4, red)
+
+	if got, want := stacked.Bounds(), image.Rect(0, 0, 200, 160); got != want {
+		t.Errorf("stacked bounds %v, want %v", got, want)
+	}
+	if want := []image.Rectangle{image.Rect(0, 0, 200, 100), image.Rect(0, 100, 200, 160)}; rects[0] != want[0] || rects[1] != want[1] {
+		t.Errorf("panel rects %v, want %v", rects, want)
+	}
+	for _, y := range []int{98, 101} {
+		if got := stacked.RGBAAt(50, y); got != (color.RGBA{R: 255, A: 255}) {
+			t.Errorf("y=%d: %v, want the separator", y, got)
+		}
+	}
+	if got := stacked.RGBAAt(50, 97); got.R != 0 {
+		t.Errorf("y=97: %v, want the panel", got)
+	}
+
+	if stacked, _ := stackPanels([]image.Image{narrow, wide}, 300, 0, red); stacked.Bounds() != image.Rect(0, 0, 300, 240) {
+		t.Errorf("explicit width: bounds %v, want 300x240", stacked.Bounds())
+	}
+}
+
+func TestPanelsGolden(t *testing.T) {
+	tmpl, err := png.Decode(bytes.NewReader(loadTestTemplate(t)))
+	if err != nil {
+		t.Fatalf("decoding template: %v", err)
+	}
+	small := image.NewRGBA(image.Rect(0, 0, 240, 90))
+	scaleInto(small, small.Bounds(), tmpl)
+
+	panels := []panel{{Text: "SMALL BRAIN"}, {Text: "GALAXY BRAIN"}}
+	stacked, rects := stackPanels([]image.Image{tmpl, small}, 0, defaultPanelSeparator, color.NRGBA{A: 255})
+	var templateData bytes.Buffer
+	if err := png.Encode(&templateData, stacked); err != nil {
+		t.Fatalf("encoding stacked panels: %v", err)
+	}
+	opts := Options{Boxes: panelBoxes(panels, rects, []string{positionTop, positionMiddle}, defaultPanelSeparator, Options{})}
+
+	var buf bytes.Buffer
+	if err := run(opts, &buf, templateData.Bytes(), fontBytes); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	compareGolden(t, filepath.Join("testdata", "golden", "panels.png"), buf.Bytes())
+}
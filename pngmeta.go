@@ -0,0 +1,292 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode/utf8"
+)
+
+// Keywords of the text chunks written into PNG output
+const (
+	metaKeyText     = "memegen:text"
+	metaKeyTemplate = "memegen:template"
+)
+
+// builtinTemplateName is recorded as the template when none was given.
+const builtinTemplateName = "built-in"
+
+// pngSignature starts every PNG file.
+const pngSignature = "\x89PNG\r\n\x1a\n"
+
+// maxPNGChunkLength is the longest chunk the PNG specification allows.
+const maxPNGChunkLength = 1<<31 - 1
+
+// pngText is one key/value pair of PNG text metadata.
+type pngText struct {
+	Key, Value string
+}
+
+// templateName returns the name recorded for the template at path: its
+// file name, "stdin", or empty for the built-in template.
+func templateName(path string) string {
+	switch path {
+	case "":
+		return ""
+	case stdinPath:
+		return "stdin"
+	}
+	return filepath.Base(path)
+}
+
+// captionText returns the caption of a render with opts, spec boxes and
+// the different captions of an animation one per line, or a poster's title
+// and subtitle.
+func captionText(opts Options) string {
+	if opts.Demotivational {
+		return strings.TrimSuffix(opts.Title+"\n"+opts.Subtitle, "\n")
+	}
+	if len(opts.FrameTexts) > 0 {
+		var texts []string
+		for _, t := range opts.FrameTexts {
+			if t != "" && !slices.Contains(texts, t) {
+				texts = append(texts, t)
+			}
+		}
+		return strings.Join(texts, "\n")
+	}
+	if len(opts.Boxes) == 0 {
+		return opts.Text
+	}
+	texts := make([]string, len(opts.Boxes))
+	for i, b := range opts.Boxes {
+		texts[i] = b.Text
+	}
+	return strings.Join(texts, "\n")
+}
+
+// pngMetadata returns the text chunks recorded for a render with opts.
+func pngMetadata(opts Options) []pngText {
+	text := captionText(opts)
+	template := opts.TemplateName
+	if template == "" {
+		template = builtinTemplateName
+	}
+	return []pngText{{metaKeyText, text}, {metaKeyTemplate, template}}
+}
+
+// encodeTextChunk returns t as a complete PNG chunk: tEXt if the value is
+// Latin-1, as that chunk requires, and uncompressed UTF-8 iTXt otherwise.
+func encodeTextChunk(t pngText) []byte {
+	var data bytes.Buffer
+	data.WriteString(t.Key)
+	data.WriteByte(0)
+	typ := "tEXt"
+	if latin1, ok := toLatin1(t.Value); ok {
+		data.Write(latin1)
+	} else {
+		// No compression, no language tag, no translated keyword
+		typ = "iTXt"
+		data.Write([]byte{0, 0, 0, 0})
+		data.WriteString(t.Value)
+	}
+	return pngChunk(typ, data.Bytes())
+}
+
+// pngChunk frames data as a PNG chunk of type typ.
+func pngChunk(typ string, data []byte) []byte {
+	chunk := make([]byte, 8, 12+len(data))
+	binary.BigEndian.PutUint32(chunk, uint32(len(data)))
+	copy(chunk[4:], typ)
+	chunk = append(chunk, data...)
+	return binary.BigEndian.AppendUint32(chunk, crc32.ChecksumIEEE(chunk[4:]))
+}
+
+// toLatin1 converts s to ISO 8859-1, reporting false if it has characters
+// outside it.
+func toLatin1(s string) ([]byte, bool) {
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xff {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+// pngHeaderLen is the length of the PNG signature and the IHDR chunk,
+// which always comes first and always holds 13 bytes.
+const pngHeaderLen = len(pngSignature) + 12 + 13
+
+// metadataWriter inserts text chunks into a PNG stream written through it,
+// right after the IHDR chunk, passing everything else through unchanged.
+type metadataWriter struct {
+	w      io.Writer
+	chunks []byte // Encoded text chunks, nil once written
+	head   []byte // The start of the stream while it is shorter than the header
+}
+
+// newMetadataWriter returns a writer adding texts to the PNG written to w.
+func newMetadataWriter(w io.Writer, texts []pngText) *metadataWriter {
+	var chunks []byte
+	for _, t := range texts {
+		chunks = append(chunks, encodeTextChunk(t)...)
+	}
+	return &metadataWriter{w: w, chunks: chunks}
+}
+
+func (m *metadataWriter) Write(p []byte) (int, error) {
+	if m.chunks == nil {
+		return m.w.Write(p)
+	}
+	need := pngHeaderLen - len(m.head)
+	if len(p) < need {
+		m.head = append(m.head, p...)
+		return len(p), nil
+	}
+	head := append(m.head, p[:need]...)
+	if string(head[12:16]) != "IHDR" {
+		return 0, errors.New("adding PNG metadata: stream does not start with IHDR")
+	}
+	out := append(append(head, m.chunks...), p[need:]...)
+	m.chunks, m.head = nil, nil
+	if _, err := m.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// readPNGText returns the text metadata of the PNG read from r: tEXt,
+// zTXt and iTXt chunks, in file order.
+func readPNGText(r io.Reader) ([]pngText, error) {
+	sig := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(r, sig); err != nil || string(sig) != pngSignature {
+		return nil, errors.New("not a PNG file")
+	}
+	var texts []pngText
+	for {
+		var hdr [8]byte
+		if _, err := io.ReadFull(r, hdr[:]); err != nil {
+			return nil, fmt.Errorf("reading PNG chunk: %w", err)
+		}
+		typ := string(hdr[4:])
+		if typ == "IEND" {
+			return texts, nil
+		}
+		length := binary.BigEndian.Uint32(hdr[:4])
+		if length > maxPNGChunkLength {
+			return nil, fmt.Errorf("reading PNG %s chunk: length %d is over the limit of %d", typ, length, maxPNGChunkLength)
+		}
+		n := int64(length) + 4 // With the CRC
+		switch typ {
+		case "tEXt", "zTXt", "iTXt":
+			// Read what is there rather than allocate what the length
+			// claims, which a truncated or hostile file makes up to 2GB
+			data, err := io.ReadAll(io.LimitReader(r, n))
+			if err == nil && int64(len(data)) < n {
+				err = io.ErrUnexpectedEOF
+			}
+			if err != nil {
+				return nil, fmt.Errorf("reading PNG %s chunk: %w", typ, err)
+			}
+			t, err := decodeTextChunk(typ, data[:length])
+			if err != nil {
+				return nil, fmt.Errorf("reading PNG %s chunk: %w", typ, err)
+			}
+			texts = append(texts, t)
+		default:
+			if _, err := io.CopyN(io.Discard, r, n); err != nil {
+				if err == io.EOF {
+					err = io.ErrUnexpectedEOF
+				}
+				return nil, fmt.Errorf("reading PNG %s chunk: %w", typ, err)
+			}
+		}
+	}
+}
+
+// decodeTextChunk decodes the data of a tEXt, zTXt or iTXt chunk.
+func decodeTextChunk(typ string, data []byte) (pngText, error) {
+	key, rest, ok := bytes.Cut(data, []byte{0})
+	if !ok {
+		return pngText{}, errors.New("keyword is not terminated")
+	}
+	t := pngText{Key: latin1String(key)}
+	compressed := false
+	switch typ {
+	case "tEXt":
+		t.Value = latin1String(rest)
+		return t, nil
+	case "zTXt":
+		if len(rest) < 1 {
+			return pngText{}, errors.New("truncated")
+		}
+		compressed, rest = true, rest[1:]
+	case "iTXt":
+		if len(rest) < 2 {
+			return pngText{}, errors.New("truncated")
+		}
+		compressed = rest[0] == 1
+		// Skip the compression method, language tag and translated keyword
+		rest = rest[2:]
+		for range 2 {
+			if _, rest, ok = bytes.Cut(rest, []byte{0}); !ok {
+				return pngText{}, errors.New("truncated")
+			}
+		}
+	}
+	if compressed {
+		zr, err := zlib.NewReader(bytes.NewReader(rest))
+		if err != nil {
+			return pngText{}, err
+		}
+		if rest, err = io.ReadAll(zr); err != nil {
+			return pngText{}, err
+		}
+	}
+	if typ == "zTXt" {
+		t.Value = latin1String(rest)
+	} else if t.Value = string(rest); !utf8.ValidString(t.Value) {
+		return pngText{}, errors.New("text is not UTF-8")
+	}
+	return t, nil
+}
+
+// printPNGMetadata writes the text metadata of the PNG file at path to w,
+// one "key: value" per line.
+func printPNGMetadata(w io.Writer, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("reading metadata: %w", err)
+	}
+	defer f.Close()
+	texts, err := readPNGText(bufio.NewReader(f))
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	for _, t := range texts {
+		if _, err := fmt.Fprintf(w, "%s: %s\n", t.Key, t.Value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// latin1String converts ISO 8859-1 bytes to a string.
+func latin1String(b []byte) string {
+	r := make([]rune, len(b))
+	for i, c := range b {
+		r[i] = rune(c)
+	}
+	return string(r)
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"image/png"
+	"testing"
+)
+
+func TestPNGMetadataRoundTrip(t *testing.T) {
+	for _, text := range []string{"ÆØÅ ÜBER", "日本語のミーム"} { // tEXt, then iTXt
+		var buf bytes.Buffer
+		opts := Options{Text: text, TemplateName: "drake.png"}
+		if err := run(opts, &buf, loadTestTemplate(t), fontBytes); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := png.Decode(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("%q: output no longer decodes: %v", text, err)
+		}
+		checkChunkCRCs(t, buf.Bytes())
+
+		texts, err := readPNGText(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		want := []pngText{{metaKeyText, text}, {metaKeyTemplate, "drake.png"}}
+		if len(texts) != len(want) || texts[0] != want[0] || texts[1] != want[1] {
+			t.Errorf("metadata = %q, want %q", texts, want)
+		}
+	}
+}
+
+func TestPNGMetadataChunkTypes(t *testing.T) {
+	cases := []struct{ value, typ string }{
+		{"CAFÉ", "tEXt"}, // Latin-1
+		{"€5", "iTXt"},
+	}
+	for _, tc := range cases {
+		chunk := encodeTextChunk(pngText{metaKeyText, tc.value})
+		if typ := string(chunk[4:8]); typ != tc.typ {
+			t.Errorf("%q stored as %s, want %s", tc.value, typ, tc.typ)
+		}
+	}
+}
+
+// TestReadPNGTextBadLength checks that chunk lengths beyond the PNG limit or
+// the end of the file are errors, rather than allocations of that size.
+func TestReadPNGTextBadLength(t *testing.T) {
+	for _, tc := range []struct {
+		typ    string
+		length uint32
+	}{
+		{"tEXt", 0xFFFFFFFF},
+		{"IDAT", 1 << 31},
+		{"tEXt", 1<<31 - 1},
+		{"IDAT", 1<<31 - 1},
+	} {
+		var b bytes.Buffer
+		b.WriteString(pngSignature)
+		binary.Write(&b, binary.BigEndian, tc.length)
+		b.WriteString(tc.typ)
+		b.WriteString("Comment\x00short")
+		if texts, err := readPNGText(&b); err == nil {
+			t.Errorf("%s chunk of length %d read as %q", tc.typ, tc.length, texts)
+		}
+	}
+}
+
+func TestNoMetadata(t *testing.T) {
+	var buf bytes.Buffer
+	if err := run(Options{Text: "HI", NoMetadata: true}, &buf, loadTestTemplate(t), fontBytes); err != nil {
+		t.Fatal(err)
+	}
+	texts, err := readPNGText(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(texts) != 0 {
+		t.Errorf("-no-metadata output has text chunks %q", texts)
+	}
+}
+
+func TestMetadataWriterSmallWrites(t *testing.T) {
+	var plain bytes.Buffer
+	if err := run(Options{Text: "HI", NoMetadata: true}, &plain, loadTestTemplate(t), fontBytes); err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	mw := newMetadataWriter(&out, []pngText{{"k", "v"}})
+	for _, b := range plain.Bytes() { // The header arrives split across writes
+		if _, err := mw.Write([]byte{b}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	chunk := encodeTextChunk(pngText{"k", "v"})
+	want := append(append(plain.Bytes()[:pngHeaderLen:pngHeaderLen], chunk...), plain.Bytes()[pngHeaderLen:]...)
+	if !bytes.Equal(out.Bytes(), want) {
+		t.Error("chunk not inserted right after IHDR")
+	}
+}
+
+// checkChunkCRCs verifies the checksum of every chunk of a PNG file.
+func checkChunkCRCs(t *testing.T, data []byte) {
+	t.Helper()
+	for p := len(pngSignature); p+12 <= len(data); {
+		n := int(binary.BigEndian.Uint32(data[p:]))
+		body := data[p+4 : p+8+n]
+		if got := binary.BigEndian.Uint32(data[p+8+n:]); got != crc32.ChecksumIEEE(body) {
+			t.Errorf("%s chunk has CRC %08x, want %08x", body[:4], got, crc32.ChecksumIEEE(body))
+		}
+		p += 12 + n
+	}
+}
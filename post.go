@@ -0,0 +1,157 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	defaultPostField   = "file"           // Form field carrying the image
+	defaultPostTimeout = 30 * time.Second // Whole request, upload included
+	postSnippetLen     = 200              // Response body bytes shown in the report
+)
+
+// formField is one extra key=value pair sent alongside a posted image.
+type formField struct {
+	Key, Value string
+}
+
+// formFields collects repeated -post-field flags. It implements flag.Value.
+type formFields []formField
+
+// String formats the fields as a comma-separated key=value list.
+func (f *formFields) String() string {
+	parts := make([]string, len(*f))
+	for i, field := range *f {
+		parts[i] = field.Key + "=" + field.Value
+	}
+	return strings.Join(parts, ",")
+}
+
+// Set adds a field given as key=value. The value may be empty.
+func (f *formFields) Set(s string) error {
+	key, value, ok := strings.Cut(s, "=")
+	if !ok || key == "" {
+		return fmt.Errorf("form field %q: want key=value", s)
+	}
+	*f = append(*f, formField{Key: key, Value: value})
+	return nil
+}
+
+// postRequest describes a multipart/form-data upload of a rendered image.
+type postRequest struct {
+	URL      string
+	Field    string // Form field name for the image
+	Filename string // Filename reported for the image part
+	MIMEType string
+	Fields   []formField // Extra form fields, sent before the image
+	Timeout  time.Duration
+}
+
+// postImage uploads data as the file part of a multipart form. It returns
+// the response status and the start of the response body, and fails on
+// transport errors, timeouts and non-2xx responses.
+func postImage(req postRequest, data []byte) (response string, err error) {
+	var body bytes.Buffer
+	mw := multipart.NewWriter(&body)
+	for _, f := range req.Fields {
+		if err := mw.WriteField(f.Key, f.Value); err != nil {
+			return "", fmt.Errorf("building form: %w", err)
+		}
+	}
+	header := make(textproto.MIMEHeader)
+	header.Set("Content-Disposition", fmt.Sprintf(`form-data; name="%s"; filename="%s"`,
+		escapeQuotes(req.Field), escapeQuotes(req.Filename)))
+	header.Set("Content-Type", req.MIMEType)
+	part, err := mw.CreatePart(header)
+	if err != nil {
+		return "", fmt.Errorf("building form: %w", err)
+	}
+	part.Write(data) // Writes to a bytes.Buffer don't fail
+	if err := mw.Close(); err != nil {
+		return "", fmt.Errorf("building form: %w", err)
+	}
+
+	httpReq, err := http.NewRequest(http.MethodPost, req.URL, &body)
+	if err != nil {
+		return "", fmt.Errorf("posting image: %w", unwrapURLError(err))
+	}
+	httpReq.Header.Set("Content-Type", mw.FormDataContentType())
+	client := &http.Client{Timeout: req.Timeout}
+	resp, err := client.Do(httpReq)
+	if err != nil {
+		var ne interface{ Timeout() bool }
+		if errors.As(err, &ne) && ne.Timeout() {
+			return "", fmt.Errorf("posting image: no response within %s", req.Timeout)
+		}
+		return "", fmt.Errorf("posting image: %w", unwrapURLError(err))
+	}
+	defer resp.Body.Close()
+
+	snippet, _ := io.ReadAll(io.LimitReader(resp.Body, postSnippetLen+1))
+	response = resp.Status
+	if s := responseSnippet(snippet); s != "" {
+		response += ": " + s
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return response, fmt.Errorf("posting image: HTTP %s", response)
+	}
+	return response, nil
+}
+
+// responseSnippet flattens the start of a response body onto one line,
+// marking it as truncated if it ran past postSnippetLen.
+func responseSnippet(b []byte) string {
+	truncated := len(b) > postSnippetLen
+	if truncated {
+		b = b[:postSnippetLen]
+		for len(b) > 0 && !utf8.Valid(b) {
+			b = b[:len(b)-1] // Don't cut a character in half
+		}
+	}
+	s := strings.Join(strings.Fields(string(b)), " ")
+	if truncated {
+		s += "…"
+	}
+	return s
+}
+
+// unwrapURLError strips the request URL from net/http errors. Webhook URLs
+// usually carry their credentials in the path, so they stay out of reports.
+func unwrapURLError(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+	return err
+}
+
+// redactURL returns the scheme and host of a URL for reporting, hiding the
+// path and query that authenticate a webhook.
+func redactURL(raw string) string {
+	u, err := url.Parse(raw)
+	if err != nil || u.Host == "" {
+		return "webhook"
+	}
+	if u.Path == "" && u.RawQuery == "" {
+		return u.Scheme + "://" + u.Host
+	}
+	return u.Scheme + "://" + u.Host + "/…"
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+// escapeQuotes escapes a Content-Disposition parameter, as mime/multipart
+// does for its own CreateFormFile.
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPostImage(t *testing.T) {
+	var gotField, gotFilename, gotType, gotChannel string
+	var gotData []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := r.ParseMultipartForm(1 << 20); err != nil {
+			t.Errorf("parsing form: %v", err)
+		}
+		gotChannel = r.FormValue("channel")
+		for name, files := range r.MultipartForm.File {
+			gotField, gotFilename = name, files[0].Filename
+			gotType = files[0].Header.Get("Content-Type")
+			f, _ := files[0].Open()
+			gotData, _ = io.ReadAll(f)
+			f.Close()
+		}
+		io.WriteString(w, "{\"ok\": true,\n \"id\": 42}\n")
+	}))
+	defer srv.Close()
+
+	data := []byte("\x89PNG fake")
+	response, err := postImage(postRequest{
+		URL:      srv.URL + "/hooks/secret",
+		Field:    "image",
+		Filename: "meme.png",
+		MIMEType: "image/png",
+		Fields:   []formField{{Key: "channel", Value: "memes"}},
+		Timeout:  5 * time.Second,
+	}, data)
+	if err != nil {
+		t.Fatalf("postImage: %v", err)
+	}
+	if want := `200 OK: {"ok": true, "id": 42}`; response != want {
+		t.Errorf("response = %q, want %q", response, want)
+	}
+	if gotField != "image" || gotFilename != "meme.png" || gotType != "image/png" || string(gotData) != string(data) {
+		t.Errorf("server got field %q file %q type %q data %q", gotField, gotFilename, gotType, gotData)
+	}
+	if gotChannel != "memes" {
+		t.Errorf("extra field channel = %q, want memes", gotChannel)
+	}
+}
+
+func TestPostImageErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/slow" {
+			time.Sleep(500 * time.Millisecond)
+			return
+		}
+		w.WriteHeader(http.StatusRequestEntityTooLarge)
+		io.WriteString(w, strings.Repeat("x", 1000))
+	}))
+	defer srv.Close()
+
+	req := postRequest{URL: srv.URL + "/big", Field: "file", Filename: "meme.png", MIMEType: "image/png", Timeout: 5 * time.Second}
+	response, err := postImage(req, []byte("data"))
+	if err == nil || !strings.Contains(err.Error(), "413") {
+		t.Errorf("non-2xx error = %v, want the status code", err)
+	}
+	if want := "413 Request Entity Too Large: " + strings.Repeat("x", postSnippetLen) + "…"; response != want {
+		t.Errorf("response not truncated to a snippet: %q", response)
+	}
+
+	req.URL, req.Timeout = srv.URL+"/slow", 50*time.Millisecond
+	if _, err := postImage(req, []byte("data")); err == nil || !strings.Contains(err.Error(), "no response within 50ms") {
+		t.Errorf("timeout error = %v", err)
+	}
+}
+
+func TestRedactURL(t *testing.T) {
+	cases := map[string]string{
+		"https://discord.com/api/webhooks/123/token": "https://discord.com/…",
+		"http://localhost:8080":                      "http://localhost:8080",
+		"https://example.com?key=secret":             "https://example.com/…",
+	}
+	for in, want := range cases {
+		if got := redactURL(in); got != want {
+			t.Errorf("redactURL(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
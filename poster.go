@@ -0,0 +1,86 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+)
+
+// A demotivational poster frames the template with a thin white line on a
+// black matte, with a large title centered beneath it and a smaller
+// subtitle under that. Everything is proportioned from the template width,
+// so a poster looks the same at any size. The title is fitted like any
+// caption: wrapped, and shrunk if it still doesn't fit its strip.
+
+var (
+	posterColor     = color.NRGBA{A: 255}                         // The matte
+	posterLineColor = color.NRGBA{R: 255, G: 255, B: 255, A: 255} // Frame and text
+)
+
+// Poster proportions, as fractions of the template width. Titles hold
+// about nine capitals of the caption font across the template.
+const (
+	posterMargin   = 1.0 / 10  // Matte beside and above the template
+	posterGap      = 1.0 / 100 // Matte between the template and the frame line
+	posterBorder   = 1.0 / 250 // Frame line thickness
+	posterTitle    = 1.0 / 9   // Title font size
+	posterSubtitle = 1.0 / 28  // Subtitle font size
+	posterPadding  = 1.0 / 100 // Space above and below each text line
+)
+
+// posterLayout is the frame of a demotivational poster.
+type posterLayout struct {
+	Frame  pixelRect `json:"frame"`  // Outer edge of the frame line
+	Border int       `json:"border"` // Frame line thickness
+}
+
+// layoutPoster returns the canvas of a demotivational poster for a template
+// of tmpl's size, where the template goes on it, its frame, and the boxes
+// holding the title and subtitle (none for an empty subtitle).
+func layoutPoster(tmpl image.Rectangle, ttFont *typeface, opts Options) (canvas, placed image.Rectangle, poster posterLayout, boxes []captionBox) {
+	w := float64(tmpl.Dx())
+	px := func(fraction float64, least int) int { return max(int(w*fraction), least) }
+	border, gap := px(posterBorder, 1), px(posterGap, 2)
+	margin, pad := px(posterMargin, border+gap+2), px(posterPadding, 1)
+	titleSize, subtitleSize := max(w*posterTitle, minFitSize), max(w*posterSubtitle, minFitSize)
+
+	placed = tmpl.Sub(tmpl.Min).Add(image.Pt(margin, margin))
+	frame := placed.Inset(-(gap + border))
+	width := tmpl.Dx() + 2*margin
+
+	// Each strip holds one line of its text at full size; longer texts
+	// wrap and shrink to fit it
+	y := frame.Max.Y + margin/3
+	strip := func(text string, size float64) captionBox {
+		h := 2*pad + textHeight(captionStyle(ttFont, size, opts), 1)
+		box := captionBox{
+			Rect:     image.Rect(0, y, width, y+h),
+			Text:     text,
+			Position: positionTop,
+			Align:    alignCenter,
+			Fill:     posterLineColor,
+			Plain:    true,
+			Size:     size,
+			PadX:     margin,
+			PadY:     pad,
+		}
+		y += h
+		return box
+	}
+	boxes = append(boxes, strip(opts.Title, titleSize))
+	if opts.Subtitle != "" {
+		boxes = append(boxes, strip(opts.Subtitle, subtitleSize))
+	}
+
+	canvas = image.Rect(0, 0, width, y+margin/2)
+	return canvas, placed, posterLayout{Frame: *newPixelRect(frame), Border: border}, boxes
+}
+
+// drawPosterFrame fills dst with the matte and draws the frame line, for
+// the template to be drawn inside.
+func drawPosterFrame(dst *image.RGBA, poster *posterLayout) {
+	draw.Draw(dst, dst.Bounds(), image.NewUniform(posterColor), image.Point{}, draw.Src)
+	frame := poster.Frame.rect()
+	draw.Draw(dst, frame, image.NewUniform(posterLineColor), image.Point{}, draw.Src)
+	draw.Draw(dst, frame.Inset(poster.Border), image.NewUniform(posterColor), image.Point{}, draw.Src)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"image"
+	"testing"
+)
+
+// TestPosterLayout checks the poster geometry: the template framed inside
+// the matte, and the title and subtitle stacked beneath the frame.
+func TestPosterLayout(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	opts := Options{Demotivational: true, Title: "TEAMWORK", Subtitle: "none of us is as dumb as all of us", Measure: true}
+	var buf bytes.Buffer
+	if err := run(opts, &buf, templateData, fontBytes); err != nil {
+		t.Fatalf("measure: %v", err)
+	}
+	var lay layout
+	if err := json.Unmarshal(buf.Bytes(), &lay); err != nil {
+		t.Fatalf("decoding layout JSON: %v", err)
+	}
+
+	canvas, tmpl := image.Rect(0, 0, lay.Width, lay.Height), lay.Template.rect()
+	if lay.Poster == nil {
+		t.Fatal("no poster in layout")
+	}
+	frame := lay.Poster.Frame.rect()
+	if tmpl.Size() != image.Pt(480, 270) || !tmpl.In(frame.Inset(lay.Poster.Border)) || !frame.In(canvas) {
+		t.Errorf("template %v, frame %v, canvas %v: not nested", tmpl, frame, canvas)
+	}
+	if lay.Width != 480+2*48 || tmpl.Min != image.Pt(48, 48) {
+		t.Errorf("canvas %dx%d with template at %v, want a 48px margin", lay.Width, lay.Height, tmpl.Min)
+	}
+	if len(lay.Captions) != 2 {
+		t.Fatalf("got %d captions, want title and subtitle", len(lay.Captions))
+	}
+	title, subtitle := lay.Captions[0], lay.Captions[1]
+	if title.Block.Y < frame.Max.Y || subtitle.Block.Y < title.Block.Y+title.Block.H || !subtitle.Block.rect().In(canvas) {
+		t.Errorf("title %v and subtitle %v not stacked below the frame %v", title.Block, subtitle.Block, frame)
+	}
+	if title.FontSize <= subtitle.FontSize || title.FitResult != fitFits {
+		t.Errorf("title at %vpt (%s), subtitle at %vpt", title.FontSize, title.FitResult, subtitle.FontSize)
+	}
+
+	// A long title is fitted like a caption rather than running off
+	opts.Title = "A TITLE FAR TOO LONG FOR A SINGLE LINE AT THE TITLE SIZE"
+	buf.Reset()
+	if err := run(opts, &buf, templateData, fontBytes); err != nil {
+		t.Fatalf("measure: %v", err)
+	}
+	if err := json.Unmarshal(buf.Bytes(), &lay); err != nil {
+		t.Fatalf("decoding layout JSON: %v", err)
+	}
+	if cl := lay.Captions[0]; cl.FitResult != fitShrunk || cl.Clamped {
+		t.Errorf("long title: fit %s, clamped %v; want shrunk to fit", cl.FitResult, cl.Clamped)
+	}
+}
+
+func TestPosterNeedsTitle(t *testing.T) {
+	err := validateOptions(Options{Demotivational: true, Subtitle: "x"})
+	var verr *ValidationError
+	if !errors.As(err, &verr) || verr.Issues[0].Field != "title" {
+		t.Errorf("poster without a title: got %v, want an issue with -title", err)
+	}
+}
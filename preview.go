@@ -0,0 +1,193 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// Terminal image protocols accepted by -preview-protocol
+const (
+	previewAuto   = "auto"
+	previewITerm  = "iterm"  // iTerm2 inline images, also understood by WezTerm
+	previewSixel  = "sixel"  // DEC sixel graphics
+	previewBlocks = "blocks" // Unicode half blocks in 24-bit ANSI color
+)
+
+const (
+	defaultPreviewColumns = 80  // Half-block preview width without $COLUMNS
+	maxSixelWidth         = 800 // Sixel previews are downscaled to this width
+)
+
+// detectPreviewProtocol picks the best preview protocol the terminal
+// described by getenv is known to support.
+func detectPreviewProtocol(getenv func(string) string) string {
+	switch getenv("TERM_PROGRAM") {
+	case "iTerm.app", "WezTerm":
+		return previewITerm
+	}
+	if getenv("LC_TERMINAL") == "iTerm2" { // Survives ssh, unlike TERM_PROGRAM
+		return previewITerm
+	}
+	term := getenv("TERM")
+	if strings.Contains(term, "sixel") || strings.HasPrefix(term, "foot") || strings.HasPrefix(term, "mlterm") {
+		return previewSixel
+	}
+	return previewBlocks
+}
+
+// showPreview decodes a rendered PNG and draws it to a terminal. The auto
+// protocol is resolved from the environment.
+func showPreview(w io.Writer, protocol string, encoded []byte) error {
+	img, err := png.Decode(bytes.NewReader(encoded))
+	if err != nil {
+		return fmt.Errorf("decoding image for preview: %w", err)
+	}
+	if protocol == previewAuto {
+		protocol = detectPreviewProtocol(os.Getenv)
+	}
+	columns, err := strconv.Atoi(os.Getenv("COLUMNS"))
+	if err != nil || columns <= 0 {
+		columns = defaultPreviewColumns
+	}
+	return writePreview(w, protocol, img, encoded, columns)
+}
+
+// writePreview draws img to a terminal using protocol. encoded is the
+// image's PNG encoding, which the iTerm2 protocol sends as is; columns is
+// the terminal width for the half-block fallback.
+func writePreview(w io.Writer, protocol string, img image.Image, encoded []byte, columns int) error {
+	bw := bufio.NewWriter(w)
+	switch protocol {
+	case previewITerm:
+		fmt.Fprintf(bw, "\x1b]1337;File=inline=1;size=%d;preserveAspectRatio=1:", len(encoded))
+		enc := base64.NewEncoder(base64.StdEncoding, bw)
+		enc.Write(encoded)
+		enc.Close()
+		bw.WriteString("\a\n")
+	case previewSixel:
+		writeSixel(bw, fitWidth(img, maxSixelWidth))
+	case previewBlocks:
+		writeHalfBlocks(bw, fitWidth(img, columns))
+	default:
+		return fmt.Errorf("unknown preview protocol %q (want auto, iterm, sixel or blocks)", protocol)
+	}
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing preview: %w", err)
+	}
+	return nil
+}
+
+// fitWidth returns img as RGBA, downscaled to at most maxW pixels wide.
+func fitWidth(img image.Image, maxW int) *image.RGBA {
+	b := img.Bounds()
+	w, h := b.Dx(), b.Dy()
+	if w > maxW {
+		w, h = maxW, max(1, h*maxW/w)
+	}
+	dst := image.NewRGBA(image.Rect(0, 0, w, h))
+	xdraw.ApproxBiLinear.Scale(dst, dst.Bounds(), img, b, xdraw.Src, nil)
+	return dst
+}
+
+// writeHalfBlocks renders img with one "▀" per two pixel rows, the upper
+// pixel as foreground color and the lower one as background.
+func writeHalfBlocks(w *bufio.Writer, img *image.RGBA) {
+	b := img.Bounds()
+	for y := b.Min.Y; y < b.Max.Y; y += 2 {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			top := img.RGBAAt(x, y)
+			bottom := color.RGBA{} // Black below an odd last row
+			if y+1 < b.Max.Y {
+				bottom = img.RGBAAt(x, y+1)
+			}
+			fmt.Fprintf(w, "\x1b[38;2;%d;%d;%dm\x1b[48;2;%d;%d;%dm▀",
+				top.R, top.G, top.B, bottom.R, bottom.G, bottom.B)
+		}
+		w.WriteString("\x1b[0m\n")
+	}
+}
+
+// sixelLevels is the number of levels per channel in the fixed sixel
+// palette, giving 6×6×6 = 216 colors.
+const sixelLevels = 6
+
+// sixelIndex maps a color to its entry in the fixed palette. Alpha has
+// already been premultiplied, which composites transparency over black.
+func sixelIndex(c color.RGBA) int {
+	q := func(v uint8) int { return (int(v)*(sixelLevels-1) + 127) / 255 }
+	return (q(c.R)*sixelLevels+q(c.G))*sixelLevels + q(c.B)
+}
+
+// writeSixel encodes img as sixel graphics on a fixed 216-color palette.
+// Each band of six rows is emitted once per color present in it.
+func writeSixel(w *bufio.Writer, img *image.RGBA) {
+	b := img.Bounds()
+	w.WriteString("\x1bPq")
+	fmt.Fprintf(w, "\"1;1;%d;%d", b.Dx(), b.Dy())
+	for i := 0; i < sixelLevels*sixelLevels*sixelLevels; i++ {
+		pct := func(level int) int { return level * 100 / (sixelLevels - 1) }
+		fmt.Fprintf(w, "#%d;2;%d;%d;%d", i,
+			pct(i/(sixelLevels*sixelLevels)), pct(i/sixelLevels%sixelLevels), pct(i%sixelLevels))
+	}
+
+	row := make([]byte, b.Dx())
+	for y0 := b.Min.Y; y0 < b.Max.Y; y0 += 6 {
+		// Sixel bits per color, for the columns of this band
+		bands := make(map[int][]byte)
+		var order []int
+		for dy := 0; dy < 6 && y0+dy < b.Max.Y; dy++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				idx := sixelIndex(img.RGBAAt(x, y0+dy))
+				bits, ok := bands[idx]
+				if !ok {
+					bits = make([]byte, b.Dx())
+					bands[idx] = bits
+					order = append(order, idx)
+				}
+				bits[x-b.Min.X] |= 1 << dy
+			}
+		}
+		for i, idx := range order {
+			if i > 0 {
+				w.WriteByte('$') // Back to the start of the band
+			}
+			w.WriteString("#" + strconv.Itoa(idx))
+			for x, bits := range bands[idx] {
+				row[x] = '?' + bits
+			}
+			writeSixelRun(w, row)
+		}
+		w.WriteByte('-') // Next band
+	}
+	w.WriteString("\x1b\\\n")
+}
+
+// writeSixelRun writes a row of sixel characters, run-length encoding
+// repeats.
+func writeSixelRun(w *bufio.Writer, row []byte) {
+	for i := 0; i < len(row); {
+		j := i + 1
+		for j < len(row) && row[j] == row[i] {
+			j++
+		}
+		if n := j - i; n > 3 {
+			fmt.Fprintf(w, "!%d%c", n, row[i])
+		} else {
+			for ; i < j; i++ {
+				w.WriteByte(row[i])
+			}
+		}
+		i = j
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"image"
+	"image/color"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestDetectPreviewProtocol(t *testing.T) {
+	cases := []struct {
+		env  map[string]string
+		want string
+	}{
+		{map[string]string{"TERM_PROGRAM": "iTerm.app", "TERM": "xterm-256color"}, previewITerm},
+		{map[string]string{"TERM_PROGRAM": "WezTerm"}, previewITerm},
+		{map[string]string{"LC_TERMINAL": "iTerm2", "TERM": "screen"}, previewITerm},
+		{map[string]string{"TERM": "foot"}, previewSixel},
+		{map[string]string{"TERM": "xterm-sixel"}, previewSixel},
+		{map[string]string{"TERM": "xterm-256color"}, previewBlocks},
+		{map[string]string{}, previewBlocks},
+	}
+	for _, tc := range cases {
+		if got := detectPreviewProtocol(func(k string) string { return tc.env[k] }); got != tc.want {
+			t.Errorf("env %v: got %s, want %s", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestHalfBlocks(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 3, 3))
+	img.SetRGBA(0, 0, color.RGBA{R: 255, A: 255})
+	img.SetRGBA(0, 1, color.RGBA{B: 255, A: 255})
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeHalfBlocks(w, img)
+	w.Flush()
+
+	rows := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(rows) != 2 { // Three pixel rows round up to two cell rows
+		t.Fatalf("got %d rows, want 2", len(rows))
+	}
+	if n := strings.Count(rows[0], "▀"); n != 3 {
+		t.Errorf("got %d cells in a row, want 3", n)
+	}
+	if want := "\x1b[38;2;255;0;0m\x1b[48;2;0;0;255m▀"; !strings.HasPrefix(rows[0], want) {
+		t.Errorf("first cell = %q, want red over blue", rows[0][:len(want)])
+	}
+}
+
+func TestSixel(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 10, 7))
+	for x := 0; x < 10; x++ {
+		img.SetRGBA(x, 0, color.RGBA{R: 255, G: 255, B: 255, A: 255})
+	}
+
+	var buf bytes.Buffer
+	w := bufio.NewWriter(&buf)
+	writeSixel(w, img)
+	w.Flush()
+	out := buf.String()
+
+	if !strings.HasPrefix(out, "\x1bPq\"1;1;10;7") || !strings.HasSuffix(out, "\x1b\\\n") {
+		t.Fatalf("not a sixel sequence: %q", out)
+	}
+	// First band: white top row (bit 0), black below (bits 1-5), run-length
+	// encoded; second band: one black row
+	white, black := sixelIndex(color.RGBA{R: 255, G: 255, B: 255}), sixelIndex(color.RGBA{})
+	for _, want := range []string{"#215;2;100;100;100", "#" + strconv.Itoa(white) + "!10@$#" + strconv.Itoa(black) + "!10}-", "#" + strconv.Itoa(black) + "!10@-"} {
+		if !strings.Contains(out, want) {
+			t.Errorf("sixel output lacks %q", want)
+		}
+	}
+}
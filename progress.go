@@ -0,0 +1,239 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	progressEvery    = 100              // Without a terminal, log after this many memes...
+	progressInterval = 10 * time.Second // ...or this long, whichever comes first
+	progressRedraw   = time.Second      // On a terminal, redraw at least this often
+)
+
+// progress reports how far a batch has come on standard error. On a
+// terminal it keeps a single line up to date, with the number of memes
+// done, the rate, the time left and the failures; otherwise it logs such a
+// line every progressEvery memes or progressInterval, whichever comes
+// first. Workers report through a channel, and warnings written to the
+// progress as an io.Writer go through it too, so that they don't tear the
+// line.
+type progress struct {
+	w       io.Writer
+	tty     bool
+	total   int
+	columns func() int // Terminal width, asked again for every line
+	start   time.Time
+
+	events  chan progressEvent
+	stopped chan struct{}
+
+	// Owned by the goroutine reporting events
+	done, failed int
+	logged       int       // done when the last line was logged
+	loggedAt     time.Time // When it was
+	drawn        bool      // Whether a terminal line is showing
+}
+
+// progressEvent is a meme done, or a warning to print.
+type progressEvent struct {
+	failed  bool
+	warning string
+}
+
+// newProgress returns a progress reporting on w, which is a terminal if
+// tty is set, for a batch of total memes, and starts it.
+func newProgress(w io.Writer, tty bool, total int) *progress {
+	p := &progress{
+		w:       w,
+		tty:     tty,
+		total:   total,
+		columns: func() int { return defaultPreviewColumns },
+		start:   time.Now(),
+		events:  make(chan progressEvent),
+		stopped: make(chan struct{}),
+	}
+	if f, ok := w.(*os.File); ok {
+		p.columns = func() int { return terminalColumns(f) }
+	}
+	p.loggedAt = p.start
+	go p.run()
+	return p
+}
+
+// isTerminal reports whether f is a terminal rather than a file or pipe.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// terminalColumns returns the width of the terminal f is, or without one
+// $COLUMNS or 80. It is asked for every line drawn, which is at least
+// every progressRedraw, so the line follows the terminal being resized.
+func terminalColumns(f *os.File) int {
+	if n := terminalWidth(f); n > 0 {
+		return n
+	}
+	if n, err := strconv.Atoi(os.Getenv("COLUMNS")); err == nil && n > 0 {
+		return n
+	}
+	return defaultPreviewColumns
+}
+
+// add reports a meme done, failed if err is set and isn't a skipped
+// duplicate. A nil progress reports nothing.
+func (p *progress) add(err error) {
+	if p != nil {
+		p.events <- progressEvent{failed: err != nil && !errors.Is(err, errDuplicate)}
+	}
+}
+
+// Write prints b as a warning, above the progress line on a terminal.
+func (p *progress) Write(b []byte) (int, error) {
+	p.events <- progressEvent{warning: string(b)}
+	return len(b), nil
+}
+
+// finish stops the progress and prints the final line, once the memes
+// are all done or no more will be. A nil progress does nothing.
+func (p *progress) finish() {
+	if p == nil {
+		return
+	}
+	close(p.events)
+	<-p.stopped
+}
+
+func (p *progress) run() {
+	defer close(p.stopped)
+	tick := time.NewTicker(progressRedraw)
+	defer tick.Stop()
+	for {
+		select {
+		case ev, ok := <-p.events:
+			if !ok {
+				p.final(time.Now())
+				return
+			}
+			p.handle(ev, time.Now())
+		case now := <-tick.C:
+			p.update(now)
+		}
+	}
+}
+
+// handle records ev and updates the output.
+func (p *progress) handle(ev progressEvent, now time.Time) {
+	if ev.warning != "" {
+		p.clear()
+		io.WriteString(p.w, ev.warning)
+		if !strings.HasSuffix(ev.warning, "\n") {
+			io.WriteString(p.w, "\n")
+		}
+		if p.tty {
+			p.draw(now)
+		}
+		return
+	}
+	p.done++
+	if ev.failed {
+		p.failed++
+	}
+	p.update(now)
+}
+
+// update redraws the terminal line, or logs a line if enough memes or
+// time have passed since the last one.
+func (p *progress) update(now time.Time) {
+	if p.tty {
+		p.draw(now)
+		return
+	}
+	if p.due(now) {
+		fmt.Fprintf(p.w, "progress: %s\n", p.status(now))
+		p.logged, p.loggedAt = p.done, now
+	}
+}
+
+// due reports whether a line should be logged at now, without a terminal:
+// when memes were done since the last one, and either progressEvery of
+// them or progressInterval has passed.
+func (p *progress) due(now time.Time) bool {
+	if p.done == p.logged {
+		return false
+	}
+	return p.done-p.logged >= progressEvery || now.Sub(p.loggedAt) >= progressInterval
+}
+
+// draw replaces the terminal line, cut to the terminal width so that it
+// never wraps.
+func (p *progress) draw(now time.Time) {
+	line := p.status(now)
+	if n := p.columns() - 1; len(line) > n {
+		line = line[:max(n, 0)]
+	}
+	fmt.Fprintf(p.w, "\r\x1b[K%s", line)
+	p.drawn = true
+}
+
+// clear removes the terminal line, if one is showing.
+func (p *progress) clear() {
+	if p.drawn {
+		io.WriteString(p.w, "\r\x1b[K")
+		p.drawn = false
+	}
+}
+
+// final prints the last line, ending it on a terminal.
+func (p *progress) final(now time.Time) {
+	var b bytes.Buffer
+	if p.tty {
+		b.WriteString("\r\x1b[K")
+	} else {
+		b.WriteString("progress: ")
+	}
+	elapsed := now.Sub(p.start)
+	fmt.Fprintf(&b, "%d/%d memes in %s, %.1f/s", p.done, p.total, elapsed.Round(time.Second), progressRate(p.done, elapsed))
+	if p.failed > 0 {
+		fmt.Fprintf(&b, ", %d failed", p.failed)
+	}
+	b.WriteByte('\n')
+	p.w.Write(b.Bytes())
+}
+
+// status describes the progress at now: memes done, rate, time left and
+// failures.
+func (p *progress) status(now time.Time) string {
+	elapsed := now.Sub(p.start)
+	s := fmt.Sprintf("%d/%d memes, %.1f/s", p.done, p.total, progressRate(p.done, elapsed))
+	if eta, ok := progressETA(p.done, p.total, elapsed); ok {
+		s += ", ETA " + eta.Round(time.Second).String()
+	}
+	if p.failed > 0 {
+		s += fmt.Sprintf(", %d failed", p.failed)
+	}
+	return s
+}
+
+// progressRate returns how many memes per second done in elapsed makes.
+func progressRate(done int, elapsed time.Duration) float64 {
+	if elapsed <= 0 {
+		return 0
+	}
+	return float64(done) / elapsed.Seconds()
+}
+
+// progressETA returns how long the rest of total will take at the rate
+// done in elapsed makes, and false while there is no rate to go by.
+func progressETA(done, total int, elapsed time.Duration) (time.Duration, bool) {
+	if done <= 0 || elapsed <= 0 {
+		return 0, false
+	}
+	return time.Duration(float64(elapsed) * float64(total-done) / float64(done)), true
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newTestProgress returns a progress writing to b that is only driven by
+// the test, with the batch starting at start.
+func newTestProgress(b *strings.Builder, tty bool, total int, start time.Time) *progress {
+	return &progress{w: b, tty: tty, total: total, columns: func() int { return 40 }, start: start, loggedAt: start}
+}
+
+func TestProgressLogBatching(t *testing.T) {
+	var b strings.Builder
+	start := time.Unix(0, 0)
+	p := newTestProgress(&b, false, 1000, start)
+
+	// 250 memes in 2.5s: a line every 100
+	now := start
+	for i := range 250 {
+		now = now.Add(10 * time.Millisecond)
+		p.handle(progressEvent{failed: i%50 == 0}, now)
+	}
+	want := []string{
+		"progress: 100/1000 memes, 100.0/s, ETA 9s, 2 failed",
+		"progress: 200/1000 memes, 100.0/s, ETA 8s, 4 failed",
+	}
+	if got := strings.Split(strings.TrimSuffix(b.String(), "\n"), "\n"); fmt.Sprint(got) != fmt.Sprint(want) {
+		t.Errorf("got lines\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+
+	// The rest is logged once progressInterval has passed since the last
+	// line, and nothing more while nothing more is done
+	b.Reset()
+	logged := start.Add(2 * time.Second)
+	p.update(logged.Add(progressInterval - time.Millisecond))
+	if b.Len() != 0 {
+		t.Errorf("logged %q before the interval was up", b.String())
+	}
+	p.update(logged.Add(progressInterval))
+	if want := "progress: 250/1000 memes, 20.8/s, ETA 36s, 5 failed\n"; b.String() != want {
+		t.Errorf("after the interval, logged %q, want %q", b.String(), want)
+	}
+	b.Reset()
+	p.update(logged.Add(3 * progressInterval))
+	if b.Len() != 0 {
+		t.Errorf("logged %q with nothing done", b.String())
+	}
+
+	p.final(logged.Add(progressInterval))
+	if want := "progress: 250/1000 memes in 12s, 20.8/s, 5 failed\n"; b.String() != want {
+		t.Errorf("final line %q, want %q", b.String(), want)
+	}
+}
+
+func TestProgressTerminal(t *testing.T) {
+	var b strings.Builder
+	start := time.Unix(0, 0)
+	p := newTestProgress(&b, true, 10, start)
+	p.handle(progressEvent{}, start.Add(time.Second))
+	if want := "\r\x1b[K1/10 memes, 1.0/s, ETA 9s"; b.String() != want {
+		t.Errorf("line %q, want %q", b.String(), want)
+	}
+
+	// A warning replaces the line, which is drawn again below it
+	b.Reset()
+	p.handle(progressEvent{warning: "Warning: careful"}, start.Add(time.Second))
+	if want := "\r\x1b[KWarning: careful\n\r\x1b[K1/10 memes, 1.0/s, ETA 9s"; b.String() != want {
+		t.Errorf("warning written as %q, want %q", b.String(), want)
+	}
+
+	// Lines are cut to the terminal width, as asked for each line
+	b.Reset()
+	p.columns = func() int { return 12 }
+	p.handle(progressEvent{failed: true}, start.Add(2*time.Second))
+	if want := "\r\x1b[K2/10 memes,"; b.String() != want {
+		t.Errorf("narrow line %q, want %q", b.String(), want)
+	}
+
+	b.Reset()
+	p.final(start.Add(4 * time.Second))
+	if want := "\r\x1b[K2/10 memes in 4s, 0.5/s, 1 failed\n"; b.String() != want {
+		t.Errorf("final line %q, want %q", b.String(), want)
+	}
+}
+
+func TestProgressRateAndETA(t *testing.T) {
+	cases := []struct {
+		done, total int
+		elapsed     time.Duration
+		rate        float64
+		eta         time.Duration
+		ok          bool
+	}{
+		{0, 100, 0, 0, 0, false},
+		{0, 100, time.Second, 0, 0, false},
+		{10, 100, time.Second, 10, 9 * time.Second, true},
+		{25, 100, 10 * time.Second, 2.5, 30 * time.Second, true},
+		{3, 7, 1500 * time.Millisecond, 2, 2 * time.Second, true},
+		{100, 100, time.Minute, 100.0 / 60, 0, true},
+	}
+	for _, tc := range cases {
+		if got := progressRate(tc.done, tc.elapsed); got != tc.rate {
+			t.Errorf("progressRate(%d, %v) = %v, want %v", tc.done, tc.elapsed, got, tc.rate)
+		}
+		if eta, ok := progressETA(tc.done, tc.total, tc.elapsed); eta != tc.eta || ok != tc.ok {
+			t.Errorf("progressETA(%d, %d, %v) = %v, %v, want %v, %v", tc.done, tc.total, tc.elapsed, eta, ok, tc.eta, tc.ok)
+		}
+	}
+}
+
+// TestRunBatchProgress checks that the pool reports every job to the
+// progress, failures included.
+func TestRunBatchProgress(t *testing.T) {
+	var b strings.Builder
+	p := newProgress(&b, false, 30)
+	runBatch(30, 4, "", p, func(i int) (string, int64, error) {
+		if i%10 == 0 {
+			return "", 0, errors.New("boom")
+		}
+		return "", 1, nil
+	})
+	p.finish()
+	if !strings.HasPrefix(b.String(), "progress: 30/30 memes in ") || !strings.HasSuffix(b.String(), ", 3 failed\n") {
+		t.Errorf("got %q", b.String())
+	}
+}
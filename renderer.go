@@ -0,0 +1,125 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"image"
+	"image/draw"
+	"io"
+	"sync"
+
+	"golang.org/x/image/font"
+)
+
+// Renderer renders memes from one template and font, for callers that
+// make many, such as servers and batches. The font is parsed and the
+// template converted to RGBA once, and backgrounds and font faces are
+// cached across renders, so each render only lays out and draws its
+// caption.
+//
+// Render is safe for concurrent use. The parsed font and the template are
+// only read while rendering; the background and face caches are guarded
+// by their own locks; and every render draws on a canvas of its own with
+// freetype contexts of its own, which are not shared. Options must not be
+// changed while renders are running.
+type Renderer struct {
+	// Options applies to every render; Render sets the caption.
+	Options Options
+
+	res *resources
+}
+
+// NewRenderer returns a renderer for template with the TrueType font in
+// fontData.
+func NewRenderer(fontData []byte, template image.Image) (*Renderer, error) {
+	ttFont, err := parseTypeface(fontData)
+	if err != nil {
+		return nil, fmt.Errorf("parsing font: %w", err)
+	}
+	rgba, ok := template.(*image.RGBA)
+	if !ok {
+		b := template.Bounds()
+		rgba = image.NewRGBA(image.Rect(0, 0, b.Dx(), b.Dy()))
+		draw.Draw(rgba, rgba.Bounds(), template, b.Min, draw.Src)
+	}
+	return &Renderer{res: &resources{
+		template: rgba,
+		font:     ttFont,
+		fontData: fontData,
+		canvases: newCanvasCache(),
+		faces:    newFaceCache(ttFont),
+	}}, nil
+}
+
+// Render renders the meme captioned with text to w. The text is drawn as
+// given; the command line uppercases captions first.
+func (r *Renderer) Render(text string, w io.Writer) error {
+	opts := r.Options
+	opts.Text = text
+	return r.GenerateContext(context.Background(), opts, w)
+}
+
+// GenerateContext renders the meme described by opts, instead of
+// r.Options, to w. Once ctx is done it stops as soon as it can and returns
+// ctx.Err(): between the stages of rendering, between caption lines and
+// frames, and on the next write to w. When ctx is done before the image is
+// encoded, nothing is written to w at all.
+func (r *Renderer) GenerateContext(ctx context.Context, opts Options, w io.Writer) error {
+	return r.res.renderContext(ctx, opts, w)
+}
+
+// contextWriter is a writer that fails with its context's error once the
+// context is done.
+type contextWriter struct {
+	ctx context.Context
+	w   io.Writer
+}
+
+func (c contextWriter) Write(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.w.Write(p)
+}
+
+// maxCachedFaces is how many faces a faceCache keeps. Shrinking to fit
+// tries one size after another, so it starts over when full rather than
+// growing without bound.
+const maxCachedFaces = 64
+
+// faceKey identifies a face of a font.
+type faceKey struct {
+	size, dpi float64
+	hinting   font.Hinting
+}
+
+// faceCache keeps the faces made of one font, so that renders sharing the
+// font don't allocate a face, glyph caches and all, each time they measure
+// it. truetype faces are not safe for concurrent use, so they are only
+// used with mu held.
+type faceCache struct {
+	font  *typeface
+	mu    sync.Mutex
+	faces map[faceKey]font.Face
+}
+
+func newFaceCache(ttFont *typeface) *faceCache {
+	return &faceCache{font: ttFont, faces: make(map[faceKey]font.Face)}
+}
+
+// metrics returns the metrics of the font at size points and dpi with
+// hinting.
+func (c *faceCache) metrics(size, dpi float64, hinting font.Hinting) font.Metrics {
+	key := faceKey{size, dpi, hinting}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	face, ok := c.faces[key]
+	if !ok {
+		if len(c.faces) >= maxCachedFaces {
+			clear(c.faces)
+		}
+		face = c.font.newFace(size, dpi, hinting)
+		c.faces[key] = face
+	}
+	return face.Metrics()
+}
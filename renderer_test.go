@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sync"
+	"testing"
+)
+
+// newTestRenderer returns a renderer for the test template and the
+// built-in font.
+func newTestRenderer(t testing.TB) *Renderer {
+	t.Helper()
+	tmpl, _, err := image.Decode(bytes.NewReader(loadTestTemplate(t)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, err := NewRenderer(fontBytes, tmpl)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return r
+}
+
+// TestRendererMatchesRun checks that the caches change nothing about the
+// output, render after render.
+func TestRendererMatchesRun(t *testing.T) {
+	r := newTestRenderer(t)
+	r.Options.TextBox = true
+	templateData := loadTestTemplate(t)
+	for _, text := range []string{"HI", "A CAPTION LONG ENOUGH TO WRAP ONTO A SECOND LINE AND SHRINK", "HI"} {
+		var want, got bytes.Buffer
+		if err := run(Options{Text: text, TextBox: true}, &want, templateData, fontBytes); err != nil {
+			t.Fatalf("run(%q): %v", text, err)
+		}
+		if err := r.Render(text, &got); err != nil {
+			t.Fatalf("Render(%q): %v", text, err)
+		}
+		if !bytes.Equal(got.Bytes(), want.Bytes()) {
+			t.Errorf("Render(%q) differs from run", text)
+		}
+	}
+}
+
+// TestRendererConcurrent renders different captions from many goroutines
+// at once; each must come out as it does alone. Run with -race to check
+// the locking.
+func TestRendererConcurrent(t *testing.T) {
+	r := newTestRenderer(t)
+	texts := make([]string, 6)
+	want := make([][]byte, len(texts))
+	for i := range texts {
+		texts[i] = fmt.Sprintf("CAPTION %d %s", i, bytes.Repeat([]byte("WIDE "), i))
+		var buf bytes.Buffer
+		if err := r.Render(texts[i], &buf); err != nil {
+			t.Fatal(err)
+		}
+		want[i] = buf.Bytes()
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 4*len(texts))
+	for range 4 {
+		for i, text := range texts {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				var buf bytes.Buffer
+				if err := r.Render(text, &buf); err != nil {
+					errs <- err
+				} else if !bytes.Equal(buf.Bytes(), want[i]) {
+					errs <- fmt.Errorf("concurrent render of %q differs", text)
+				}
+			}()
+		}
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
+
+// BenchmarkRender compares a render through run, which parses the font
+// and decodes the template every time, with one through a Renderer.
+func BenchmarkRender(b *testing.B) {
+	templateData := loadTestTemplate(b)
+	const text = "ONE DOES NOT SIMPLY WALK INTO MORDOR"
+	b.Run("run", func(b *testing.B) {
+		var out bytes.Buffer
+		b.ReportAllocs()
+		for range b.N {
+			out.Reset()
+			if err := run(Options{Text: text}, &out, templateData, fontBytes); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+	b.Run("renderer", func(b *testing.B) {
+		r := newTestRenderer(b)
+		var out bytes.Buffer
+		b.ReportAllocs()
+		for range b.N {
+			out.Reset()
+			if err := r.Render(text, &out); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
+
+// TestGenerateContextCancelled checks that a context cancelled before
+// GenerateContext writes nothing and returns context.Canceled.
+func TestGenerateContextCancelled(t *testing.T) {
+	r := newTestRenderer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for _, opts := range []Options{
+		{Text: "TOO LATE"},
+		{Text: "TOO LATE", Format: formatSVG},
+		{FrameTexts: []string{"TOO", "LATE"}},
+		{Text: "TOO LATE", Measure: true},
+		{Text: "TOO LATE", Encode: encodeDataURI},
+		{FrameTexts: []string{"TOO", "LATE"}, Encode: encodeDataURI},
+	} {
+		var buf bytes.Buffer
+		if err := r.GenerateContext(ctx, opts, &buf); !errors.Is(err, context.Canceled) {
+			t.Errorf("%+v: got %v, want %v", opts, err, context.Canceled)
+		}
+		if buf.Len() != 0 {
+			t.Errorf("%+v: %d bytes written after cancellation", opts, buf.Len())
+		}
+	}
+}
+
+// cancellingWriter cancels a context on its first write.
+type cancellingWriter struct {
+	cancel context.CancelFunc
+	n      int
+}
+
+func (w *cancellingWriter) Write(p []byte) (int, error) {
+	w.cancel()
+	w.n += len(p)
+	return len(p), nil
+}
+
+// TestGenerateContextCancelledWhileWriting checks that a render stops
+// writing once its context is cancelled.
+func TestGenerateContextCancelledWhileWriting(t *testing.T) {
+	r := newTestRenderer(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	w := &cancellingWriter{cancel: cancel}
+	if err := r.GenerateContext(ctx, Options{Text: "HI"}, w); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want %v", err, context.Canceled)
+	}
+	var full bytes.Buffer
+	if err := r.Render("HI", &full); err != nil {
+		t.Fatal(err)
+	}
+	if w.n == 0 || w.n >= full.Len() {
+		t.Errorf("wrote %d of %d bytes, want the writes after cancelling skipped", w.n, full.Len())
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/draw"
+	"math"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// maxOutputPixels is the most pixels an output image may have, 8192×8192.
+// Rendering keeps several layers of that size, so a typo such as -width
+// 100000 or -scale 1000 is an error rather than an attempt to allocate them.
+const maxOutputPixels = 1 << 26
+
+// checkOutputSize returns an error if a w×h output would have more than
+// maxOutputPixels pixels.
+func checkOutputSize(w, h float64) error {
+	if w*h > maxOutputPixels {
+		return fmt.Errorf("output size %.6gx%.6g is over the limit of %d pixels", w, h, maxOutputPixels)
+	}
+	return nil
+}
+
+// targetSize returns the dimensions a w×h template should be scaled to for a
+// requested width and/or height. A zero request leaves that dimension free:
+// with only one of the two given, the other follows from the aspect ratio
+// (rounded to the nearest pixel, never below 1). With neither given the
+// template size is returned unchanged. Requested sizes over maxOutputPixels
+// are an error.
+func targetSize(w, h, reqW, reqH int) (int, int, error) {
+	if reqW < 0 || reqH < 0 {
+		return 0, 0, fmt.Errorf("output size must not be negative, got %dx%d", reqW, reqH)
+	}
+	if reqW > maxOutputPixels || reqH > maxOutputPixels {
+		return 0, 0, fmt.Errorf("output width or height %d is over the limit of %d pixels", max(reqW, reqH), maxOutputPixels)
+	}
+	switch {
+	case reqW == 0 && reqH == 0:
+		return w, h, nil
+	case reqH == 0:
+		reqH = max((h*reqW*2+w)/(2*w), 1)
+	case reqW == 0:
+		reqW = max((w*reqH*2+h)/(2*h), 1)
+	}
+	if err := checkOutputSize(float64(reqW), float64(reqH)); err != nil {
+		return 0, 0, err
+	}
+	return reqW, reqH, nil
+}
+
+// scalePx returns n pixels at scale, rounded to the nearest pixel (halves
+// away from zero). Scale 0 means 1.
+func scalePx(n int, scale float64) int {
+	if scale == 0 {
+		return n
+	}
+	return int(math.Round(float64(n) * scale))
+}
+
+// scaleSize returns the output dimensions for a w×h image at scale: each
+// dimension rounded to the nearest pixel, never below 1. Sizes over
+// maxOutputPixels are an error.
+func scaleSize(w, h int, scale float64) (int, int, error) {
+	if scale != 0 {
+		if err := checkOutputSize(float64(w)*scale, float64(h)*scale); err != nil {
+			return 0, 0, err
+		}
+	}
+	return max(scalePx(w, scale), 1), max(scalePx(h, scale), 1), nil
+}
+
+// scaleInto resamples src to fill r of dst using Catmull-Rom interpolation.
+func scaleInto(dst draw.Image, r image.Rectangle, src image.Image) {
+	xdraw.CatmullRom.Scale(dst, r, src, src.Bounds(), xdraw.Src, nil)
+}
@@ -0,0 +1,66 @@
+package main
+
+import "testing"
+
+func TestTargetSize(t *testing.T) {
+	cases := []struct {
+		w, h, reqW, reqH int
+		wantW, wantH     int
+	}{
+		{1500, 1065, 0, 0, 1500, 1065},
+		{1500, 1065, 750, 0, 750, 533}, // 532.5 rounds up
+		{1500, 1065, 0, 213, 300, 213},
+		{1500, 1065, 100, 100, 100, 100}, // both given: aspect ratio not kept
+		{1500, 10, 10, 0, 10, 1},         // never rounds down to zero
+		{480, 270, 960, 0, 960, 540},
+	}
+	for _, tc := range cases {
+		gotW, gotH, err := targetSize(tc.w, tc.h, tc.reqW, tc.reqH)
+		if err != nil {
+			t.Errorf("targetSize(%d, %d, %d, %d): %v", tc.w, tc.h, tc.reqW, tc.reqH, err)
+			continue
+		}
+		if gotW != tc.wantW || gotH != tc.wantH {
+			t.Errorf("targetSize(%d, %d, %d, %d) = %dx%d, want %dx%d",
+				tc.w, tc.h, tc.reqW, tc.reqH, gotW, gotH, tc.wantW, tc.wantH)
+		}
+	}
+
+	if _, _, err := targetSize(100, 100, -1, 0); err == nil {
+		t.Error("negative width accepted")
+	}
+	for _, req := range [][2]int{{1e9, 0}, {0, 1e9}, {1 << 62, 1 << 62}, {10000, 10000}, {8193, 0}} {
+		if _, _, err := targetSize(100, 100, req[0], req[1]); err == nil {
+			t.Errorf("%dx%d accepted", req[0], req[1])
+		}
+	}
+	if _, _, err := targetSize(100, 100, 8192, 0); err != nil {
+		t.Errorf("8192x8192: %v", err)
+	}
+}
+
+func TestScaleSize(t *testing.T) {
+	cases := []struct {
+		w, h         int
+		scale        float64
+		wantW, wantH int
+	}{
+		{480, 270, 0, 480, 270}, // 0 means 1
+		{480, 270, 2, 960, 540},
+		{480, 270, 1.5, 720, 405},
+		{241, 135, 1.5, 362, 203}, // 361.5 and 202.5 round up
+		{3, 1, 0.1, 1, 1},         // never rounds down to zero
+	}
+	for _, tc := range cases {
+		gotW, gotH, err := scaleSize(tc.w, tc.h, tc.scale)
+		if err != nil || gotW != tc.wantW || gotH != tc.wantH {
+			t.Errorf("scaleSize(%d, %d, %v) = %dx%d, %v, want %dx%d", tc.w, tc.h, tc.scale, gotW, gotH, err, tc.wantW, tc.wantH)
+		}
+	}
+
+	for _, scale := range []float64{1000, 1e300} {
+		if _, _, err := scaleSize(480, 270, scale); err == nil {
+			t.Errorf("scale %v accepted", scale)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"image"
+	"image/draw"
+	"math"
+
+	"golang.org/x/image/math/f64"
+
+	xdraw "golang.org/x/image/draw"
+)
+
+// normalizeDegrees maps an angle to the range [0, 360).
+func normalizeDegrees(deg float64) float64 {
+	deg = math.Mod(deg, 360)
+	if deg < 0 {
+		deg += 360
+	}
+	return deg
+}
+
+// compositeRotated rotates the drawn content of layer clockwise by deg
+// degrees around the center of its bounding box and composites it onto dst.
+// Only the non-transparent part of layer is transformed, and it is mapped
+// straight onto dst, so no intermediate buffer can clip the rotated corners.
+// Quarter turns are exact pixel transpositions; other angles are resampled
+// bilinearly.
+func compositeRotated(dst, layer *image.RGBA, deg float64) {
+	box := opaqueBounds(layer)
+	if box.Empty() {
+		return
+	}
+	crop := layer.SubImage(box).(*image.RGBA)
+
+	deg = normalizeDegrees(deg)
+	switch deg {
+	case 0:
+		draw.Draw(dst, box, crop, box.Min, draw.Over)
+		return
+	case 90, 180, 270:
+		rotated := rotateQuarterTurns(crop, int(deg)/90)
+		// Keep the centers of the original and rotated boxes aligned
+		size := rotated.Bounds().Size()
+		minPt := image.Pt(
+			box.Min.X+(box.Dx()-size.X)/2,
+			box.Min.Y+(box.Dy()-size.Y)/2,
+		)
+		draw.Draw(dst, image.Rectangle{Min: minPt, Max: minPt.Add(size)}, rotated, image.Point{}, draw.Over)
+		return
+	}
+
+	// Source-to-destination matrix for a clockwise rotation (in y-down image
+	// coordinates) about the box center
+	rad := deg * math.Pi / 180
+	sin, cos := math.Sin(rad), math.Cos(rad)
+	cx := float64(box.Min.X) + float64(box.Dx())/2
+	cy := float64(box.Min.Y) + float64(box.Dy())/2
+	s2d := f64.Aff3{
+		cos, -sin, cx - cx*cos + cy*sin,
+		sin, cos, cy - cx*sin - cy*cos,
+	}
+	xdraw.BiLinear.Transform(dst, s2d, crop, box, xdraw.Over, nil)
+}
+
+// rotateQuarterTurns returns a copy of src rotated clockwise by n quarter
+// turns (n in 1..3), with its bounds starting at the origin.
+func rotateQuarterTurns(src *image.RGBA, n int) *image.RGBA {
+	b := src.Bounds()
+	w, h := b.Dx(), b.Dy()
+	var dst *image.RGBA
+	if n%2 == 1 {
+		dst = image.NewRGBA(image.Rect(0, 0, h, w))
+	} else {
+		dst = image.NewRGBA(image.Rect(0, 0, w, h))
+	}
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			var dx, dy int
+			switch n {
+			case 1:
+				dx, dy = h-1-y, x
+			case 2:
+				dx, dy = w-1-x, h-1-y
+			case 3:
+				dx, dy = y, w-1-x
+			}
+			si := src.PixOffset(b.Min.X+x, b.Min.Y+y)
+			di := dst.PixOffset(dx, dy)
+			copy(dst.Pix[di:di+4], src.Pix[si:si+4])
+		}
+	}
+	return dst
+}
+
+// opaqueBounds returns the smallest rectangle containing every pixel of img
+// with non-zero alpha.
+func opaqueBounds(img *image.RGBA) image.Rectangle {
+	b := img.Bounds()
+	box := image.Rectangle{}
+	for y := b.Min.Y; y < b.Max.Y; y++ {
+		for x := b.Min.X; x < b.Max.X; x++ {
+			if img.Pix[img.PixOffset(x, y)+3] == 0 {
+				continue
+			}
+			box = box.Union(image.Rect(x, y, x+1, y+1))
+		}
+	}
+	return box
+}
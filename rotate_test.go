@@ -0,0 +1,55 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"testing"
+)
+
+// TestRotateZeroIsNoOp checks that full turns produce exactly the unrotated
+// output.
+func TestRotateZeroIsNoOp(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	render := func(deg float64) []byte {
+		var buf bytes.Buffer
+		if err := run(Options{Text: "STONKS", Rotate: deg}, &buf, templateData, fontBytes); err != nil {
+			t.Fatalf("run(rotate %v): %v", deg, err)
+		}
+		return buf.Bytes()
+	}
+	want := render(0)
+	for _, deg := range []float64{360, -720} {
+		if !bytes.Equal(render(deg), want) {
+			t.Errorf("rotate %v differs from unrotated output", deg)
+		}
+	}
+}
+
+func TestRotateQuarterTurns(t *testing.T) {
+	src := image.NewRGBA(image.Rect(10, 20, 13, 22)) // 3×2, offset origin
+	for i := range src.Pix {
+		src.Pix[i] = uint8(i)
+	}
+
+	r1 := rotateQuarterTurns(src, 1)
+	if got := r1.Bounds(); got != image.Rect(0, 0, 2, 3) {
+		t.Fatalf("quarter turn bounds = %v, want 2x3 at origin", got)
+	}
+	// Clockwise: the top-left source pixel ends up top-right
+	if got, want := r1.RGBAAt(1, 0), src.RGBAAt(10, 20); got != want {
+		t.Errorf("top-right after quarter turn = %v, want %v", got, want)
+	}
+
+	// Four quarter turns, or two half turns, are the identity
+	back := rotateQuarterTurns(rotateQuarterTurns(rotateQuarterTurns(r1, 1), 1), 1)
+	half := rotateQuarterTurns(rotateQuarterTurns(src, 2), 2)
+	for _, img := range []*image.RGBA{back, half} {
+		for y := 0; y < 2; y++ {
+			for x := 0; x < 3; x++ {
+				if img.RGBAAt(x, y) != src.RGBAAt(10+x, 20+y) {
+					t.Fatalf("pixel (%d,%d) not restored", x, y)
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// Captions from chat bots and scripts arrive with junk the font can't draw
+// well, so every caption is cleaned up before layout, by rules that can
+// each be turned off:
+//
+//   - NFC normalization composes decomposed accents ("E" + U+0301 becomes
+//     "É"), which the font has glyphs for.
+//   - Control characters (C0 and C1) and invisible format characters such
+//     as zero-width joiners and byte order marks are removed. Newlines,
+//     which break lines, and tabs are kept.
+//   - Runs of breakable whitespace, tabs included, collapse into a single
+//     space. Newlines and no-break spaces are left alone.
+//
+// Last, each caption is checked against the maximum length, so that a
+// multi-kilobyte paste is an error rather than a huge layout.
+
+// defaultMaxLength is the longest caption in characters unless
+// Options.MaxLength says otherwise.
+const defaultMaxLength = 200
+
+// sanitizeCaptions returns opts with every caption text cleaned up as its
+// NoNormalize, KeepControls and KeepSpaces options say, or a
+// *ValidationError if one is longer than its MaxLength allows.
+func sanitizeCaptions(opts Options) (Options, error) {
+	limit := opts.MaxLength
+	if limit == 0 {
+		limit = defaultMaxLength
+	}
+	v := &ValidationError{}
+	clean := func(text string) string {
+		text = sanitizeCaption(text, opts)
+		if n := utf8.RuneCountInString(text); limit > 0 && n > limit {
+			v.add("max-length", strconv.Itoa(limit), fmt.Sprintf("caption %q is %d characters long", truncate(text, 20), n),
+				"shorten it or raise -max-length")
+		}
+		return text
+	}
+
+	opts.Text = clean(opts.Text)
+	opts.Title = clean(opts.Title)
+	opts.Subtitle = clean(opts.Subtitle)
+	opts.Boxes = slices.Clone(opts.Boxes)
+	for i := range opts.Boxes {
+		opts.Boxes[i].Text = clean(opts.Boxes[i].Text)
+	}
+	opts.Bubbles = slices.Clone(opts.Bubbles)
+	for i := range opts.Bubbles {
+		opts.Bubbles[i].Text = clean(opts.Bubbles[i].Text)
+	}
+	opts.FrameTexts = slices.Clone(opts.FrameTexts)
+	for i := range opts.FrameTexts {
+		opts.FrameTexts[i] = clean(opts.FrameTexts[i])
+	}
+	return opts, v.err()
+}
+
+// sanitizeCaption applies the clean-up rules enabled in opts to text.
+func sanitizeCaption(text string, opts Options) string {
+	if !opts.NoNormalize {
+		text = norm.NFC.String(text)
+	}
+	if !opts.KeepControls {
+		text = strings.Map(func(r rune) rune {
+			if isJunk(r) {
+				return -1
+			}
+			return r
+		}, text)
+	}
+	if !opts.KeepSpaces {
+		text = collapseSpaces(text)
+	}
+	return text
+}
+
+// isJunk reports whether r is a control or format character, other than a
+// newline or tab.
+func isJunk(r rune) bool {
+	if r == '\n' || r == '\t' {
+		return false
+	}
+	return unicode.IsControl(r) || unicode.Is(unicode.Cf, r)
+}
+
+// collapseSpaces replaces each run of breakable whitespace in text other
+// than newlines with a single space.
+func collapseSpaces(text string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range text {
+		if r != '\n' && isBreakableSpace(r) {
+			if !space {
+				b.WriteByte(' ')
+			}
+			space = true
+			continue
+		}
+		b.WriteRune(r)
+		space = false
+	}
+	return b.String()
+}
+
+// truncate returns s cut to at most n characters, marked with an ellipsis
+// if anything was cut.
+func truncate(s string, n int) string {
+	if utf8.RuneCountInString(s) <= n {
+		return s
+	}
+	return string([]rune(s)[:n]) + "…"
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestSanitizeCaption(t *testing.T) {
+	cases := []struct {
+		name, in, want string
+		opts           Options
+	}{
+		{"composed", "CAFE\u0301", "CAF\u00c9", Options{}},
+		{"not normalized", "CAFE\u0301", "CAFE\u0301", Options{NoNormalize: true}},
+		{"controls", "HI\x00\x1b THERE\u0085\r\n", "HI THERE\n", Options{}},
+		{"format characters", "ZERO\u200bWIDTH\u200d\ufeff", "ZEROWIDTH", Options{}},
+		{"controls kept", "A\u200bB\x07", "A\u200bB\x07", Options{KeepControls: true}},
+		{"spaces", "A  \t B\n\n  C", "A B\n\n C", Options{}},
+		{"no-break spaces", "A\u00a0\u00a0B", "A\u00a0\u00a0B", Options{}},
+		{"spaces kept", "A  \t B", "A  \t B", Options{KeepSpaces: true}},
+	}
+	for _, tc := range cases {
+		if got := sanitizeCaption(tc.in, tc.opts); got != tc.want {
+			t.Errorf("%s: sanitizeCaption(%q) = %q, want %q", tc.name, tc.in, got, tc.want)
+		}
+	}
+}
+
+func TestSanitizeCaptionsMaxLength(t *testing.T) {
+	long := strings.Repeat("A", defaultMaxLength+1)
+	var verr *ValidationError
+	if _, err := sanitizeCaptions(Options{Text: long}); !errors.As(err, &verr) || verr.Issues[0].Field != "max-length" {
+		t.Errorf("%d characters: got %v, want a -max-length issue", len(long), err)
+	}
+	// The limit applies after clean-up, and to every caption
+	if _, err := sanitizeCaptions(Options{Text: strings.Repeat("A  ", 80)}); err != nil {
+		t.Errorf("collapsible spaces: %v", err)
+	}
+	if _, err := sanitizeCaptions(Options{Boxes: []captionBox{{Text: "HI"}, {Text: long}}}); err == nil {
+		t.Error("long spec box accepted")
+	}
+	if _, err := sanitizeCaptions(Options{Text: long, MaxLength: -1}); err != nil {
+		t.Errorf("no limit: %v", err)
+	}
+	if _, err := sanitizeCaptions(Options{Text: "HELLO", MaxLength: 4}); err == nil {
+		t.Error("limit of 4 accepted 5 characters")
+	}
+}
+
+// TestSanitizeCaptionsCopies checks that cleaning up captions leaves the
+// caller's slices alone.
+func TestSanitizeCaptionsCopies(t *testing.T) {
+	boxes := []captionBox{{Text: "A  B"}}
+	frames := []string{"C  D"}
+	opts, err := sanitizeCaptions(Options{Boxes: boxes, FrameTexts: frames})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opts.Boxes[0].Text != "A B" || opts.FrameTexts[0] != "C D" {
+		t.Errorf("got %q and %q", opts.Boxes[0].Text, opts.FrameTexts[0])
+	}
+	if boxes[0].Text != "A  B" || frames[0] != "C  D" {
+		t.Errorf("caller's captions changed to %q and %q", boxes[0].Text, frames[0])
+	}
+}
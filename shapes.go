@@ -0,0 +1,104 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"math"
+	"slices"
+
+	"golang.org/x/image/vector"
+)
+
+// fillRoundedRect composites a rectangle with rounded corners of the given
+// radius onto dst in color c (straight alpha). Corner edges are
+// anti-aliased by coverage.
+func fillRoundedRect(dst draw.Image, r image.Rectangle, radius int, c color.NRGBA) {
+	if r.Empty() {
+		return
+	}
+	radius = min(radius, r.Dx()/2, r.Dy()/2)
+	mask := image.NewAlpha(image.Rect(0, 0, r.Dx(), r.Dy()))
+	w, h := float64(r.Dx()), float64(r.Dy())
+	rad := float64(radius)
+	for y := 0; y < r.Dy(); y++ {
+		for x := 0; x < r.Dx(); x++ {
+			// Distance from the pixel center to the nearest corner circle
+			// center, for pixels in the corner squares only
+			px, py := float64(x)+0.5, float64(y)+0.5
+			cx := math.Max(rad, math.Min(px, w-rad))
+			cy := math.Max(rad, math.Min(py, h-rad))
+			d := math.Hypot(px-cx, py-cy)
+			coverage := math.Max(0, math.Min(1, rad-d+0.5))
+			if radius == 0 {
+				coverage = 1
+			}
+			mask.Pix[mask.PixOffset(x, y)] = uint8(coverage*255 + 0.5)
+		}
+	}
+	draw.DrawMask(dst, r, image.NewUniform(c), image.Point{}, mask, image.Point{}, draw.Over)
+}
+
+// roundedRectPolygon returns the outline of r with corners rounded to
+// radius as a clockwise polygon, the arcs flattened to segments.
+func roundedRectPolygon(x0, y0, x1, y1, radius float64) []vec {
+	radius = min(radius, (x1-x0)/2, (y1-y0)/2)
+	corners := []struct{ cx, cy, start float64 }{
+		{x1 - radius, y0 + radius, -math.Pi / 2}, // Top right
+		{x1 - radius, y1 - radius, 0},            // Bottom right
+		{x0 + radius, y1 - radius, math.Pi / 2},  // Bottom left
+		{x0 + radius, y0 + radius, math.Pi},      // Top left
+	}
+	const steps = 12 // Segments per corner
+	var poly []vec
+	for _, c := range corners {
+		for i := 0; i <= steps; i++ {
+			a := c.start + float64(i)/steps*math.Pi/2
+			poly = append(poly, vec{c.cx + radius*math.Cos(a), c.cy + radius*math.Sin(a)})
+		}
+	}
+	return poly
+}
+
+// ellipsePolygon returns the ellipse centered on c with radii rx and ry as
+// a clockwise polygon.
+func ellipsePolygon(c vec, rx, ry float64) []vec {
+	const steps = 96
+	poly := make([]vec, steps)
+	for i := range poly {
+		a := float64(i) / steps * 2 * math.Pi
+		poly[i] = vec{c.x + rx*math.Cos(a), c.y + ry*math.Sin(a)}
+	}
+	return poly
+}
+
+// clockwise returns poly wound clockwise on screen (y down), reversing it
+// if needed, so polygons filled together merge instead of cancelling out.
+func clockwise(poly []vec) []vec {
+	var area float64
+	for i, a := range poly {
+		b := poly[(i+1)%len(poly)]
+		area += a.x*b.y - b.x*a.y
+	}
+	if area < 0 {
+		poly = slices.Clone(poly)
+		slices.Reverse(poly)
+	}
+	return poly
+}
+
+// fillPolygons composites the union of the closed polygons polys onto dst
+// in color c, anti-aliased.
+func fillPolygons(dst draw.Image, polys [][]vec, c color.NRGBA) {
+	b := dst.Bounds()
+	z := vector.NewRasterizer(b.Dx(), b.Dy())
+	for _, poly := range polys {
+		poly = clockwise(poly)
+		z.MoveTo(float32(poly[0].x-float64(b.Min.X)), float32(poly[0].y-float64(b.Min.Y)))
+		for _, v := range poly[1:] {
+			z.LineTo(float32(v.x-float64(b.Min.X)), float32(v.y-float64(b.Min.Y)))
+		}
+		z.ClosePath()
+	}
+	z.Draw(dst, b, image.NewUniform(c), image.Point{})
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// -sidecar writes a JSON description of each output next to it, as
+// <output>.json, for pipelines that want to know where an image came from
+// without decoding it: the caption before and after uppercasing, the
+// template and font, what the layout made of the text and a SHA-256 of the
+// bytes written. The output is hashed while it is written, so it needs no
+// extra buffering.
+
+// sidecarVersion is the version of the sidecar schema. Fields may be added
+// within a version; renaming or removing one, or changing its meaning,
+// bumps it.
+const sidecarVersion = 1
+
+// sidecar is the content of a sidecar file.
+type sidecar struct {
+	Version      int    `json:"version"`       // sidecarVersion
+	Caption      string `json:"caption"`       // As drawn, one line per caption box or frame
+	CaptionInput string `json:"caption_input"` // As given, before uppercasing
+	Template     string `json:"template"`      // Template file name, "stdin" or "built-in"
+	Font         string `json:"font"`          // Full name from the font's name table
+	Format       string `json:"format"`        // png or svg
+	Encoding     string `json:"encoding,omitempty"`
+	Width        int    `json:"width"`  // Image width in pixels
+	Height       int    `json:"height"` // Image height in pixels
+	// Captions are the caption boxes as laid out, speech bubbles
+	// included; for an animation those of its first frame.
+	Captions []sidecarCaption `json:"captions"`
+	Bytes    int64            `json:"bytes"`  // Size of the output
+	SHA256   string           `json:"sha256"` // Of the output, in hex
+}
+
+// sidecarCaption is one laid-out caption box in a sidecar.
+type sidecarCaption struct {
+	Text     string       `json:"text"`      // The lines as wrapped, joined by newlines
+	FontSize float64      `json:"font_size"` // Points, after any fitting
+	Box      *pixelRect   `json:"box"`       // Glyph bounds of all lines; absent without ink
+	Lines    []*pixelRect `json:"lines"`     // Glyph bounds per line; null for blank lines
+}
+
+// digestWriter hashes and counts what is written to it.
+type digestWriter struct {
+	h hash.Hash
+	n int64
+}
+
+func (d *digestWriter) Write(p []byte) (int, error) {
+	d.h.Write(p)
+	d.n += int64(len(p))
+	return len(p), nil
+}
+
+// renderSidecar renders the meme described by opts to destWriter like
+// renderContext and returns its sidecar.
+func (res *resources) renderSidecar(ctx context.Context, opts Options, destWriter io.Writer) (*sidecar, error) {
+	opts, err := sanitizeCaptions(opts) // As render sees them
+	if err != nil {
+		return nil, err
+	}
+	d := &digestWriter{h: sha256.New()}
+	if err := res.renderContext(ctx, opts, io.MultiWriter(destWriter, d)); err != nil {
+		return nil, err
+	}
+	lay, err := res.layout(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newSidecar(lay, res.font, opts, d.h.Sum(nil), d.n), nil
+}
+
+// runSidecar is run, also returning the sidecar of what it wrote.
+func runSidecar(opts Options, destWriter io.Writer, templateData, fontData []byte) (*sidecar, error) {
+	res, err := loadResources(templateData, fontData)
+	if err != nil {
+		return nil, err
+	}
+	return res.renderSidecar(context.Background(), opts, destWriter)
+}
+
+// newSidecar returns the sidecar of an output of size bytes with SHA-256
+// digest, rendered with opts as laid out in lay.
+func newSidecar(lay *layout, ttFont *typeface, opts Options, digest []byte, size int64) *sidecar {
+	template := opts.TemplateName
+	if template == "" {
+		template = builtinTemplateName
+	}
+	input := opts.TextInput
+	if input == "" {
+		input = captionText(opts) // Given as drawn
+	}
+	sc := &sidecar{
+		Version:      sidecarVersion,
+		Caption:      captionText(opts),
+		CaptionInput: input,
+		Template:     template,
+		Font:         ttFont.name(),
+		Format:       lay.Format,
+		Encoding:     opts.Encode,
+		Width:        lay.Width,
+		Height:       lay.Height,
+		Captions:     []sidecarCaption{},
+		Bytes:        size,
+		SHA256:       hex.EncodeToString(digest),
+	}
+	captions := lay.Captions
+	for _, b := range lay.Bubbles {
+		captions = append(captions[:len(captions):len(captions)], b.Caption)
+	}
+	for _, cl := range captions {
+		c := sidecarCaption{FontSize: cl.FontSize, Box: cl.Block, Lines: []*pixelRect{}}
+		texts := make([]string, len(cl.Lines))
+		for i, l := range cl.Lines {
+			texts[i] = l.Text
+			c.Lines = append(c.Lines, l.Box)
+		}
+		c.Text = strings.Join(texts, "\n")
+		sc.Captions = append(sc.Captions, c)
+	}
+	return sc
+}
+
+// sidecarPath returns the name of the sidecar file of the output at path.
+func sidecarPath(path string) string {
+	return path + ".json"
+}
+
+// writeSidecar writes sc to the sidecar file of the output at path,
+// replacing an existing one only if force is set.
+func writeSidecar(path string, sc *sidecar, force bool) error {
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(sc); err != nil {
+		return fmt.Errorf("encoding sidecar: %w", err)
+	}
+	if _, err := writeFile(sidecarPath(path), buf.Bytes(), force); err != nil {
+		return fmt.Errorf("sidecar: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRenderSidecar(t *testing.T) {
+	res, err := loadResources(loadTestTemplate(t), fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	opts := Options{Text: "ONE DOES NOT SIMPLY WALK INTO MORDOR", TextInput: "One does not simply walk into Mordor", TemplateName: "boromir.png"}
+	sc, err := res.renderSidecar(context.Background(), opts, &buf)
+	if err != nil {
+		t.Fatalf("renderSidecar: %v", err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	if sc.SHA256 != hex.EncodeToString(sum[:]) || sc.Bytes != int64(buf.Len()) {
+		t.Errorf("sidecar has %d bytes with SHA-256 %s, output %d bytes with %x", sc.Bytes, sc.SHA256, buf.Len(), sum)
+	}
+	if sc.Caption != opts.Text || sc.CaptionInput != opts.TextInput || sc.Template != "boromir.png" {
+		t.Errorf("caption %q from %q on %q", sc.Caption, sc.CaptionInput, sc.Template)
+	}
+	if sc.Version != sidecarVersion || sc.Font == "" || sc.Format != formatPNG || sc.Width != 480 || sc.Height != 270 {
+		t.Errorf("got %+v", sc)
+	}
+	if len(sc.Captions) != 1 {
+		t.Fatalf("got %d captions, want 1", len(sc.Captions))
+	}
+	c := sc.Captions[0]
+	if c.FontSize >= fontSize || c.Box == nil || len(c.Lines) < 2 {
+		t.Errorf("caption laid out at %vpt in %+v over %d lines, want shrunk and wrapped", c.FontSize, c.Box, len(c.Lines))
+	}
+}
+
+// TestBatchSidecars checks that each batch output gets a sidecar of its
+// own.
+func TestBatchSidecars(t *testing.T) {
+	res, err := loadResources(loadTestTemplate(t), fontBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	captions := []string{"first", "second", "third"}
+	result := runBatch(len(captions), 2, "", nil, renderBatchJob(context.Background(), res, Options{}, captions, batchFileNames(dir, ".png", len(captions)), false, true, nil))
+	if code := result.exitCode(); code != 0 {
+		t.Fatalf("exit code %d: %+v", code, result.Artifacts)
+	}
+	for i, caption := range captions {
+		path := filepath.Join(dir, fmt.Sprintf("meme-%d.png", i+1))
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		js, err := os.ReadFile(sidecarPath(path))
+		if err != nil {
+			t.Fatalf("reading sidecar: %v", err)
+		}
+		var sc sidecar
+		if err := json.Unmarshal(js, &sc); err != nil {
+			t.Fatalf("decoding sidecar: %v", err)
+		}
+		sum := sha256.Sum256(data)
+		if sc.CaptionInput != caption || sc.SHA256 != hex.EncodeToString(sum[:]) {
+			t.Errorf("%s: sidecar for %q with SHA-256 %s, want %q with %x", path, sc.CaptionInput, sc.SHA256, caption, sum)
+		}
+	}
+}
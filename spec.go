@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"image"
+	"io"
+	"os"
+)
+
+// A spec file lists text boxes for templates with several captions at fixed
+// places, such as "Drake". It is a JSON array; boxes are numbered from 1 in
+// error messages:
+//
+//	[
+//	  {"x": 500, "y": 0,   "w": 500, "h": 500, "text": "writing tests"},
+//	  {"x": 500, "y": 500, "w": 500, "h": 500, "text": "shipping on friday",
+//	   "align": "left", "color": "#C00000", "max_font_size": 96}
+//	]
+//
+// Coordinates are pixels of the (scaled) template. Each caption is wrapped
+// and, if needed, shrunk to fit its own rectangle.
+
+// textBoxSpec is one entry of a spec file.
+type textBoxSpec struct {
+	X           int     `json:"x"`
+	Y           int     `json:"y"`
+	W           int     `json:"w"`
+	H           int     `json:"h"`
+	Text        *string `json:"text"`                    // Required
+	Align       string  `json:"align,omitempty"`         // left, center or right; default center
+	VAlign      string  `json:"valign,omitempty"`        // top, middle or bottom; default middle
+	Color       string  `json:"color,omitempty"`         // Fill color; default black
+	MaxFontSize float64 `json:"max_font_size,omitempty"` // Points; default the caption size
+}
+
+// loadSpec reads and checks the spec file at path.
+func loadSpec(path string) ([]captionBox, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("opening spec: %w", err)
+	}
+	defer f.Close()
+	boxes, err := parseSpec(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return boxes, nil
+}
+
+// parseSpec decodes a spec and converts its entries to caption boxes.
+// Whether the rectangles fit the image is checked later by validateBoxes,
+// once the output size is known.
+func parseSpec(r io.Reader) ([]captionBox, error) {
+	var specs []textBoxSpec
+	if err := json.NewDecoder(r).Decode(&specs); err != nil {
+		return nil, fmt.Errorf("parsing spec: %w", err)
+	}
+	if len(specs) == 0 {
+		return nil, errors.New("spec has no boxes")
+	}
+	boxes := make([]captionBox, len(specs))
+	for i, s := range specs {
+		box, err := s.captionBox()
+		if err != nil {
+			return nil, fmt.Errorf("box %d: %w", i+1, err)
+		}
+		boxes[i] = box
+	}
+	return boxes, nil
+}
+
+// captionBox converts the entry, checking everything but the bounds.
+func (s textBoxSpec) captionBox() (captionBox, error) {
+	if s.Text == nil || *s.Text == "" {
+		return captionBox{}, errors.New("text is missing")
+	}
+	if s.W <= 0 || s.H <= 0 {
+		return captionBox{}, fmt.Errorf("size %dx%d must be positive", s.W, s.H)
+	}
+
+	box := captionBox{
+		Rect:     image.Rect(s.X, s.Y, s.X+s.W, s.Y+s.H),
+		Text:     *s.Text,
+		Position: positionMiddle,
+		Align:    alignCenter,
+		Size:     fontSize,
+	}
+	switch s.Align {
+	case "":
+	case alignLeft, alignCenter, alignRight:
+		box.Align = s.Align
+	default:
+		return captionBox{}, fmt.Errorf("unknown align %q (want left, center or right)", s.Align)
+	}
+	switch s.VAlign {
+	case "":
+	case positionTop, positionMiddle, positionBottom:
+		box.Position = s.VAlign
+	default:
+		return captionBox{}, fmt.Errorf("unknown valign %q (want top, middle or bottom)", s.VAlign)
+	}
+	if s.Color != "" {
+		c, err := parseColor(s.Color)
+		if err != nil {
+			return captionBox{}, fmt.Errorf("color: %w", err)
+		}
+		box.Fill = c
+	}
+	switch {
+	case s.MaxFontSize < 0:
+		return captionBox{}, fmt.Errorf("max_font_size must be positive, got %v", s.MaxFontSize)
+	case s.MaxFontSize > maxFontSize:
+		return captionBox{}, fmt.Errorf("max_font_size must be at most %g, got %v", maxFontSize, s.MaxFontSize)
+	case s.MaxFontSize > 0:
+		box.Size = max(s.MaxFontSize, minFitSize)
+	}
+	return box, nil
+}
+
+// validateBoxes checks that every box lies inside bounds.
+func validateBoxes(boxes []captionBox, bounds image.Rectangle) error {
+	for i, b := range boxes {
+		if !b.Rect.In(bounds) {
+			return fmt.Errorf("box %d: rectangle %d,%d %dx%d lies outside the %dx%d image",
+				i+1, b.Rect.Min.X, b.Rect.Min.Y, b.Rect.Dx(), b.Rect.Dy(), bounds.Dx(), bounds.Dy())
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"image"
+	"image/color"
+	"strings"
+	"testing"
+)
+
+func TestParseSpec(t *testing.T) {
+	boxes, err := parseSpec(strings.NewReader(`[
+		{"x": 10, "y": 20, "w": 200, "h": 100, "text": "one"},
+		{"x": 0, "y": 0, "w": 50, "h": 50, "text": "two", "align": "right",
+		 "valign": "top", "color": "red", "max_font_size": 40}
+	]`))
+	if err != nil {
+		t.Fatalf("parseSpec: %v", err)
+	}
+	want := []captionBox{
+		{Rect: image.Rect(10, 20, 210, 120), Text: "one", Position: positionMiddle, Align: alignCenter, Size: fontSize},
+		{Rect: image.Rect(0, 0, 50, 50), Text: "two", Position: positionTop, Align: alignRight,
+			Fill: color.NRGBA{R: 255, A: 255}, Size: 40},
+	}
+	if len(boxes) != len(want) {
+		t.Fatalf("got %d boxes, want %d", len(boxes), len(want))
+	}
+	for i := range want {
+		if boxes[i] != want[i] {
+			t.Errorf("box %d = %+v, want %+v", i+1, boxes[i], want[i])
+		}
+	}
+}
+
+func TestParseSpecErrors(t *testing.T) {
+	cases := []struct {
+		spec, want string
+	}{
+		{`[]`, "no boxes"},
+		{`{"x": 1}`, "parsing spec"},
+		{`[{"w": 10, "h": 10, "text": "a"}, {"w": 10, "h": 10}]`, "box 2: text is missing"},
+		{`[{"w": 10, "h": 10, "text": ""}]`, "box 1: text is missing"},
+		{`[{"w": 0, "h": 10, "text": "a"}]`, "box 1: size 0x10"},
+		{`[{"w": 10, "h": 10, "text": "a", "align": "justify"}]`, `box 1: unknown align "justify"`},
+		{`[{"w": 10, "h": 10, "text": "a", "valign": "center"}]`, `box 1: unknown valign "center"`},
+		{`[{"w": 10, "h": 10, "text": "a"}, {"w": 10, "h": 10, "text": "b", "color": "mauve"}]`, "box 2: color"},
+		{`[{"w": 10, "h": 10, "text": "a", "max_font_size": -3}]`, "box 1: max_font_size"},
+	}
+	for _, tc := range cases {
+		_, err := parseSpec(strings.NewReader(tc.spec))
+		if err == nil || !strings.Contains(err.Error(), tc.want) {
+			t.Errorf("parseSpec(%s): err = %v, want it to contain %q", tc.spec, err, tc.want)
+		}
+	}
+}
+
+func TestValidateBoxes(t *testing.T) {
+	bounds := image.Rect(0, 0, 480, 270)
+	boxes := []captionBox{
+		{Rect: image.Rect(0, 0, 480, 270)},
+		{Rect: image.Rect(400, 200, 500, 250)},
+	}
+	err := validateBoxes(boxes, bounds)
+	if err == nil || !strings.Contains(err.Error(), "box 2: rectangle 400,200 100x50 lies outside the 480x270 image") {
+		t.Errorf("validateBoxes: err = %v", err)
+	}
+	if err := validateBoxes(boxes[:1], bounds); err != nil {
+		t.Errorf("box covering the whole image rejected: %v", err)
+	}
+}
+
+// TestSpecBoxesFit checks that each box's caption is shrunk and wrapped to
+// fit its own rectangle, independently of the others.
+func TestSpecBoxesFit(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	boxes := []captionBox{
+		{Rect: image.Rect(240, 0, 480, 135), Text: "A RATHER LONG CAPTION FOR A SMALL BOX", Position: positionMiddle, Align: alignCenter, Size: fontSize},
+		{Rect: image.Rect(240, 135, 480, 270), Text: "OK", Position: positionBottom, Align: alignRight, Size: 48},
+	}
+	lay, err := computeLayout(image.Rect(0, 0, 480, 270), ttFont, Options{Boxes: boxes})
+	if err != nil {
+		t.Fatalf("computeLayout: %v", err)
+	}
+	if len(lay.Captions) != 2 {
+		t.Fatalf("got %d captions, want 2", len(lay.Captions))
+	}
+
+	long, short := lay.Captions[0], lay.Captions[1]
+	if long.FontSize >= fontSize || len(long.Lines) < 2 {
+		t.Errorf("long caption not shrunk and wrapped: %v pt, %d lines", long.FontSize, len(long.Lines))
+	}
+	if short.FontSize != 48 {
+		t.Errorf("short caption size = %v, want its max of 48", short.FontSize)
+	}
+	for i, cl := range lay.Captions {
+		if !cl.Block.rect().In(boxes[i].Rect) {
+			t.Errorf("caption %d block %+v outside its box %v", i+1, cl.Block, boxes[i].Rect)
+		}
+	}
+	// Right-aligned ink ends at the box edge, less the outline
+	if got, want := short.Block.rect().Max.X, boxes[1].Rect.Max.X-outlineThickness; got != want {
+		t.Errorf("right-aligned caption ends at x=%d, want %d", got, want)
+	}
+}
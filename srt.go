@@ -0,0 +1,224 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// srtCue is a single subtitle entry from a SubRip (.srt) file.
+type srtCue struct {
+	Index int           // Cue number as written in the file
+	Start time.Duration // Time the cue appears (inclusive)
+	End   time.Duration // Time the cue disappears (exclusive)
+	Text  string        // Cue text with formatting tags stripped, lines joined by "\n"
+	Line  int           // Line number of the cue's index line, for messages
+}
+
+// srtTagPattern matches the basic formatting tags found in SRT files:
+// HTML-like tags such as <i>, </b> or <font color="..."> and ASS-style
+// override blocks such as {\an8}.
+var srtTagPattern = regexp.MustCompile(`</?[a-zA-Z][^>]*>|\{\\[^}]*\}`)
+
+// srtCaption loads the SubRip file at path and returns the text of the cue
+// selected either by timestamp (at) or by cue number (index). Exactly one of
+// the two selectors must be given. Overlapping cues at the timestamp are
+// resolved in favour of the first one, with a warning on stderr.
+func srtCaption(path, at string, index int) (string, error) {
+	if (at == "") == (index == 0) {
+		return "", errors.New("-srt needs exactly one of -at or -srt-index")
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("opening srt file: %w", err)
+	}
+	defer f.Close()
+
+	cues, err := parseSRT(f)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", path, err)
+	}
+
+	if index != 0 {
+		cue, ok := cueByIndex(cues, index)
+		if !ok {
+			return "", fmt.Errorf("%s: no cue numbered %d", path, index)
+		}
+		return cue.Text, nil
+	}
+
+	t, err := parseSRTTimestamp(at)
+	if err != nil {
+		return "", fmt.Errorf("-at: %w", err)
+	}
+	cue, overlapping, ok := cueAt(cues, t)
+	if !ok {
+		return "", fmt.Errorf("%s: no cue is active at %s", path, at)
+	}
+	if overlapping {
+		fmt.Fprintf(os.Stderr, "Warning: several cues are active at %s, using cue %d (line %d)\n", at, cue.Index, cue.Line)
+	}
+	return cue.Text, nil
+}
+
+// parseSRT reads a SubRip file and returns its cues in file order. A leading
+// UTF-8 BOM and CRLF line endings are accepted. Malformed input produces an
+// error naming the offending line.
+func parseSRT(r io.Reader) ([]srtCue, error) {
+	scanner := bufio.NewScanner(r)
+	var (
+		cues   []srtCue
+		cur    *srtCue
+		text   []string
+		state  = 0 // 0: expecting index, 1: expecting timing, 2: reading text
+		lineNo = 0
+	)
+
+	flush := func() {
+		if cur != nil {
+			cur.Text = strings.TrimSpace(stripSRTTags(strings.Join(text, "\n")))
+			cues = append(cues, *cur)
+		}
+		cur, text, state = nil, nil, 0
+	}
+
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if lineNo == 1 {
+			line = strings.TrimPrefix(line, "\ufeff") // UTF-8 BOM
+		}
+
+		switch state {
+		case 0:
+			if strings.TrimSpace(line) == "" {
+				continue // Tolerate extra blank lines between cues
+			}
+			index, err := strconv.Atoi(strings.TrimSpace(line))
+			if err != nil {
+				return nil, fmt.Errorf("srt line %d: expected cue number, got %q", lineNo, line)
+			}
+			cur = &srtCue{Index: index, Line: lineNo}
+			state = 1
+		case 1:
+			start, end, err := parseSRTTiming(line)
+			if err != nil {
+				return nil, fmt.Errorf("srt line %d: %w", lineNo, err)
+			}
+			cur.Start, cur.End = start, end
+			state = 2
+		case 2:
+			if strings.TrimSpace(line) == "" {
+				flush()
+				continue
+			}
+			text = append(text, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("reading srt: %w", err)
+	}
+	if state == 1 {
+		return nil, fmt.Errorf("srt line %d: cue %d has no timing line", lineNo, cur.Index)
+	}
+	flush()
+	return cues, nil
+}
+
+// parseSRTTiming parses a timing line such as
+// "00:01:23,500 --> 00:01:25,000".
+func parseSRTTiming(line string) (time.Duration, time.Duration, error) {
+	parts := strings.Split(line, "-->")
+	if len(parts) != 2 {
+		return 0, 0, fmt.Errorf("malformed timing line %q", line)
+	}
+	start, err := parseSRTTimestamp(strings.TrimSpace(parts[0]))
+	if err != nil {
+		return 0, 0, err
+	}
+	// Anything after the end timestamp (position hints) is ignored.
+	endField := strings.Fields(parts[1])
+	if len(endField) == 0 {
+		return 0, 0, fmt.Errorf("malformed timing line %q", line)
+	}
+	end, err := parseSRTTimestamp(endField[0])
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		return 0, 0, fmt.Errorf("cue ends (%s) before it starts (%s)", endField[0], strings.TrimSpace(parts[0]))
+	}
+	return start, end, nil
+}
+
+// parseSRTTimestamp parses HH:MM:SS,mmm. A period is accepted in place of
+// the comma, and the milliseconds may be omitted.
+func parseSRTTimestamp(s string) (time.Duration, error) {
+	clock, millis, _ := strings.Cut(strings.Replace(s, ".", ",", 1), ",")
+	fields := strings.Split(clock, ":")
+	if len(fields) != 3 {
+		return 0, fmt.Errorf("malformed timestamp %q (want HH:MM:SS,mmm)", s)
+	}
+	var hms [3]int
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || (i > 0 && n > 59) {
+			return 0, fmt.Errorf("malformed timestamp %q (want HH:MM:SS,mmm)", s)
+		}
+		hms[i] = n
+	}
+	ms := 0
+	if millis != "" {
+		n, err := strconv.Atoi(millis)
+		if err != nil || n < 0 || len(millis) > 3 {
+			return 0, fmt.Errorf("malformed timestamp %q (want HH:MM:SS,mmm)", s)
+		}
+		// "5" means 500ms, as in a decimal fraction
+		for i := len(millis); i < 3; i++ {
+			n *= 10
+		}
+		ms = n
+	}
+	return time.Duration(hms[0])*time.Hour +
+		time.Duration(hms[1])*time.Minute +
+		time.Duration(hms[2])*time.Second +
+		time.Duration(ms)*time.Millisecond, nil
+}
+
+// stripSRTTags removes basic formatting tags from cue text.
+func stripSRTTags(s string) string {
+	return srtTagPattern.ReplaceAllString(s, "")
+}
+
+// cueAt returns the cue active at t. A cue is active from its start time up
+// to, but not including, its end time. When several cues overlap t the first
+// one in file order is returned and overlapping is set.
+func cueAt(cues []srtCue, t time.Duration) (cue srtCue, overlapping bool, found bool) {
+	for _, c := range cues {
+		if t < c.Start || t >= c.End {
+			continue
+		}
+		if found {
+			return cue, true, true
+		}
+		cue, found = c, true
+	}
+	return cue, false, found
+}
+
+// cueByIndex returns the cue with the given cue number.
+func cueByIndex(cues []srtCue, index int) (srtCue, bool) {
+	for _, c := range cues {
+		if c.Index == index {
+			return c, true
+		}
+	}
+	return srtCue{}, false
+}
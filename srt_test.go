@@ -0,0 +1,100 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const testSRT = "\ufeff1\r\n" +
+	"00:00:01,000 --> 00:00:02,500\r\n" +
+	"<i>Hello</i> there\r\n" +
+	"{\\an8}second <font color=\"#fff\">line</font>\r\n" +
+	"\r\n" +
+	"2\r\n" +
+	"00:00:02,500 --> 00:00:04,000\r\n" +
+	"General Kenobi\r\n" +
+	"\r\n" +
+	"3\r\n" +
+	"00:00:03,000 --> 00:00:05,000\r\n" +
+	"Overlap\r\n"
+
+func TestParseSRT(t *testing.T) {
+	cues, err := parseSRT(strings.NewReader(testSRT))
+	if err != nil {
+		t.Fatalf("parseSRT: %v", err)
+	}
+	if len(cues) != 3 {
+		t.Fatalf("got %d cues, want 3", len(cues))
+	}
+	if want := "Hello there\nsecond line"; cues[0].Text != want {
+		t.Errorf("cue 1 text = %q, want %q", cues[0].Text, want)
+	}
+	if cues[0].Index != 1 || cues[0].Start != time.Second || cues[0].End != 2500*time.Millisecond {
+		t.Errorf("cue 1 = %+v", cues[0])
+	}
+}
+
+func TestCueAt(t *testing.T) {
+	cues, err := parseSRT(strings.NewReader(testSRT))
+	if err != nil {
+		t.Fatalf("parseSRT: %v", err)
+	}
+
+	cases := []struct {
+		at          time.Duration
+		wantIndex   int // 0 means no cue
+		overlapping bool
+	}{
+		{at: 999 * time.Millisecond},
+		{at: time.Second, wantIndex: 1},             // start is inclusive
+		{at: 2499 * time.Millisecond, wantIndex: 1}, // last moment of cue 1
+		{at: 2500 * time.Millisecond, wantIndex: 2}, // end is exclusive
+		{at: 3 * time.Second, wantIndex: 2, overlapping: true},
+		{at: 4 * time.Second, wantIndex: 3},
+		{at: 5 * time.Second},
+	}
+	for _, tc := range cases {
+		cue, overlapping, found := cueAt(cues, tc.at)
+		gotIndex := 0
+		if found {
+			gotIndex = cue.Index
+		}
+		if gotIndex != tc.wantIndex || overlapping != tc.overlapping {
+			t.Errorf("cueAt(%v) = cue %d overlapping=%v, want cue %d overlapping=%v",
+				tc.at, gotIndex, overlapping, tc.wantIndex, tc.overlapping)
+		}
+	}
+}
+
+func TestStripSRTTags(t *testing.T) {
+	cases := map[string]string{
+		"<i>italic</i>":                  "italic",
+		"<b>bold</b> and <u>under</u>":   "bold and under",
+		"<font color=\"red\">red</font>": "red",
+		"{\\an8}top":                     "top",
+		"1 < 2 > 0":                      "1 < 2 > 0",
+	}
+	for in, want := range cases {
+		if got := stripSRTTags(in); got != want {
+			t.Errorf("stripSRTTags(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestParseSRTErrors(t *testing.T) {
+	cases := []struct {
+		name, input, wantErr string
+	}{
+		{"timestamp", "1\n00:00:01 --> 00:00:xx,000\nText\n", "srt line 2: malformed timestamp"},
+		{"arrow", "1\n00:00:01,000 00:00:02,000\nText\n", "srt line 2: malformed timing line"},
+		{"index", "1\n00:00:01,000 --> 00:00:02,000\nText\n\nnope\n", "srt line 5: expected cue number"},
+		{"truncated", "1\n", "srt line 1: cue 1 has no timing line"},
+	}
+	for _, tc := range cases {
+		_, err := parseSRT(strings.NewReader(tc.input))
+		if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+			t.Errorf("%s: got error %v, want it to contain %q", tc.name, err, tc.wantErr)
+		}
+	}
+}
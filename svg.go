@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"math"
+	"strings"
+)
+
+// svgFontFamily is the name the embedded caption font is declared under.
+const svgFontFamily = "memegen-caption"
+
+// writeSVG writes the layout as a self-contained SVG document: background
+// (the prepared template, with any caption bar) as an embedded PNG, the
+// caption font as a data URI @font-face, and each caption and the
+// watermark as real text elements. Coordinates are the layout's pixel
+// coordinates, which map one to one onto SVG user units.
+func writeSVG(w io.Writer, lay *layout, background image.Image, fontData []byte, opts Options) error {
+	bw := bufio.NewWriter(w)
+	fmt.Fprintf(bw, `<svg xmlns="http://www.w3.org/2000/svg" width="%d" height="%d" viewBox="0 0 %d %d" xml:space="preserve">`+"\n",
+		lay.Width, lay.Height, lay.Width, lay.Height)
+
+	bw.WriteString("<style>@font-face{font-family:\"" + svgFontFamily + "\";src:url(data:" + fontMediaType(fontData) + ";base64,")
+	if err := writeBase64(bw, func(enc io.Writer) error { _, err := enc.Write(fontData); return err }); err != nil {
+		return fmt.Errorf("embedding font: %w", err)
+	}
+	bw.WriteString(")}</style>\n")
+
+	fmt.Fprintf(bw, `<image x="0" y="0" width="%d" height="%d" href="data:image/png;base64,`, lay.Width, lay.Height)
+	if err := writeBase64(bw, func(enc io.Writer) error { return png.Encode(enc, background) }); err != nil {
+		return fmt.Errorf("embedding template: %w", err)
+	}
+	bw.WriteString("\"/>\n")
+
+	for i, cl := range lay.Captions {
+		writeSVGCaption(bw, i, cl, opts)
+	}
+	for i, b := range lay.Bubbles {
+		// As drawn: the shape in black, and in white inset by the border
+		writeSVGPath(bw, bubblePolygons(b, 0), color.NRGBA{A: 255})
+		writeSVGPath(bw, bubblePolygons(b, float64(b.Border)), color.NRGBA{255, 255, 255, 255})
+		writeSVGCaption(bw, len(lay.Captions)+i, b.Caption, opts)
+	}
+	if wm := lay.Watermark; wm != nil {
+		fmt.Fprintf(bw, `<text x="%d" y="%d" font-family="%s" font-size="%g" %s>%s</text>`+"\n",
+			wm.X, wm.Baseline, svgFontFamily, wm.FontSize, svgOutlinedPaint(fillColor.C, outlineColor.C, scalePx(outlineThickness, opts.Scale)), svgEscape(wm.Text))
+	}
+
+	bw.WriteString("</svg>\n")
+	if err := bw.Flush(); err != nil {
+		return fmt.Errorf("writing SVG: %w", err)
+	}
+	return nil
+}
+
+// writeSVGCaption writes the i'th caption: its text box, then its lines,
+// grouped under a rotation when the caption is tilted.
+func writeSVGCaption(w *bufio.Writer, i int, cl captionLayout, opts Options) {
+	if cl.Rotate != 0 {
+		// The raster path rotates about the center of everything drawn
+		center := cl.TextBox.rect()
+		if center.Empty() {
+			center = cl.Block.rect().Inset(-captionThickness(opts))
+		}
+		fmt.Fprintf(w, `<g transform="rotate(%g %g %g)">`+"\n", cl.Rotate,
+			float64(center.Min.X+center.Max.X)/2, float64(center.Min.Y+center.Max.Y)/2)
+	}
+
+	if cl.TextBox != nil {
+		boxColor := opts.TextBoxColor
+		if boxColor == (color.NRGBA{}) {
+			boxColor = defaultTextBoxColor
+		}
+		r := cl.TextBox
+		fmt.Fprintf(w, `<rect x="%d" y="%d" width="%d" height="%d" rx="%d" %s/>`+"\n",
+			r.X, r.Y, r.W, r.H, scalePx(textBoxRadius, opts.Scale), svgFill(boxColor))
+	}
+
+	var fill, outline color.Color = cl.fill, cl.outline
+	if cl.fill == (color.NRGBA{}) {
+		fill = fillColor.C
+	}
+	if cl.outline == (color.NRGBA{}) {
+		outline = outlineColor.C
+	}
+	paint := svgOutlinedPaint(fill, outline, captionThickness(opts))
+	if len(opts.FillGradient) > 0 && cl.Block != nil && !cl.plain {
+		id := fmt.Sprintf("fill-%d", i+1)
+		writeSVGGradient(w, id, opts.FillGradient, cl.Block.Y, cl.Block.Y+cl.Block.H)
+		paint = fmt.Sprintf(`fill="url(#%s)" %s`, id, svgOutlineStroke(outline, captionThickness(opts)))
+	}
+	if cl.plain {
+		paint = svgFill(cl.fill)
+	}
+	paint += svgOpacity(opts)
+	spacing := ""
+	if opts.Tracking != 0 {
+		spacing = fmt.Sprintf(` letter-spacing="%d"`, scalePx(opts.Tracking, opts.Scale))
+	}
+
+	for j, l := range cl.Lines {
+		if l.Box == nil {
+			continue // Nothing to draw
+		}
+		transform := ""
+		if l.Condense != 0 {
+			// Scale about the ink center, then apply any alignment shift,
+			// as drawCondensed does
+			cx := float64(l.ink.Min.X+l.ink.Max.X) / 2
+			transform = fmt.Sprintf(` transform="matrix(%g 0 0 1 %g 0)"`, l.Condense, cx+float64(l.shift)-l.Condense*cx)
+		}
+		if cl.Arc != 0 {
+			writeSVGArcLine(w, fmt.Sprintf("arc-%d-%d", i+1, j+1), l, cl, spacing+" "+paint)
+			continue
+		}
+		fmt.Fprintf(w, `<text x="%g" y="%d" font-family="%s" font-size="%g"%s%s %s>%s</text>`+"\n",
+			l.X, l.Baseline, svgFontFamily, cl.FontSize, spacing, transform, paint, svgEscape(l.Text))
+	}
+
+	if cl.Rotate != 0 {
+		w.WriteString("</g>\n")
+	}
+}
+
+// writeSVGArcLine writes line l of a caption bent along an arc as text on
+// a path with the given id that follows the arc lineArc computes, centered
+// on it.
+func writeSVGArcLine(w *bufio.Writer, id string, l lineLayout, cl captionLayout, attrs string) {
+	g := lineArc(l, cl.Arc)
+	from, to := g.ends()
+	sweep := 0 // A bowl runs counter-clockwise from the left end
+	if g.theta > 0 {
+		sweep = 1
+	}
+	r := math.Abs(g.radius)
+	fmt.Fprintf(w, `<path id="%s" d="M%.2f %.2f A%.2f %.2f 0 0 %d %.2f %.2f" fill="none"/>`+"\n",
+		id, from.x, from.y, r, r, sweep, to.x, to.y)
+	fmt.Fprintf(w, `<text font-family="%s" font-size="%g"%s><textPath href="#%s" startOffset="50%%" text-anchor="middle">%s</textPath></text>`+"\n",
+		svgFontFamily, cl.FontSize, attrs, id, svgEscape(l.Text))
+}
+
+// writeSVGPath writes the union of the closed polygons polys, filled in c.
+func writeSVGPath(w *bufio.Writer, polys [][]vec, c color.NRGBA) {
+	w.WriteString(`<path d="`)
+	for _, poly := range polys {
+		poly = clockwise(poly) // Nonzero filling merges them
+		for i, v := range poly {
+			cmd := "L"
+			if i == 0 {
+				cmd = "M"
+			}
+			fmt.Fprintf(w, "%s%.2f %.2f", cmd, v.x, v.y)
+		}
+		w.WriteString("Z")
+	}
+	fmt.Fprintf(w, `" %s/>`+"\n", svgFill(c))
+}
+
+// writeBase64 streams what write produces to w as base64.
+func writeBase64(w io.Writer, write func(io.Writer) error) error {
+	enc := base64.NewEncoder(base64.StdEncoding, w)
+	if err := write(enc); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+// svgOutlinedPaint returns the paint attributes for outlined text: fill on
+// top of a stroke in outline twice the outline thickness in pixels, half of
+// which the fill covers, matching the raster outline's reach.
+func svgOutlinedPaint(fill, outline color.Color, thickness int) string {
+	return svgFill(fill) + " " + svgOutlineStroke(outline, thickness)
+}
+
+// svgOutlineStroke returns the stroke attributes of svgOutlinedPaint.
+func svgOutlineStroke(outline color.Color, thickness int) string {
+	return fmt.Sprintf(`stroke="%s" stroke-width="%d" stroke-linejoin="round" paint-order="stroke"`,
+		svgHex(outline), 2*thickness)
+}
+
+// writeSVGGradient defines a vertical gradient through stops from row top
+// to row bottom in canvas coordinates, as drawn by the gradient image.
+func writeSVGGradient(w *bufio.Writer, id string, stops []color.NRGBA, top, bottom int) {
+	fmt.Fprintf(w, `<defs><linearGradient id="%s" gradientUnits="userSpaceOnUse" x1="0" y1="%d" x2="0" y2="%d">`, id, top, bottom)
+	for i, c := range stops {
+		opacity := ""
+		if c.A != 255 {
+			opacity = fmt.Sprintf(` stop-opacity="%.3g"`, float64(c.A)/255)
+		}
+		fmt.Fprintf(w, `<stop offset="%.3g" stop-color="%s"%s/>`, float64(i)/float64(len(stops)-1), svgHex(c), opacity)
+	}
+	w.WriteString("</linearGradient></defs>\n")
+}
+
+// svgFill returns fill attributes for c, with an opacity if it is
+// translucent.
+func svgFill(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	if n.A == 255 {
+		return fmt.Sprintf(`fill="%s"`, svgHex(n))
+	}
+	return fmt.Sprintf(`fill="%s" fill-opacity="%.3g"`, svgHex(n), float64(n.A)/255)
+}
+
+// svgOpacity returns the opacity attributes for caption text, if any. One
+// opacity for both fill and outline makes the text a translucent group, as
+// drawn; different ones become fill-opacity and stroke-opacity, through
+// which a renderer shows what the outline stroke hides under the fill.
+func svgOpacity(opts Options) string {
+	fill, outline := opacities(opts)
+	switch {
+	case opaque(fill, outline):
+		return ""
+	case fill == outline:
+		return fmt.Sprintf(` opacity="%.3g"`, fill)
+	default:
+		return fmt.Sprintf(` fill-opacity="%.3g" stroke-opacity="%.3g"`, fill, outline)
+	}
+}
+
+// svgHex returns c as an opaque #rrggbb color.
+func svgHex(c color.Color) string {
+	n := color.NRGBAModel.Convert(c).(color.NRGBA)
+	return fmt.Sprintf("#%02x%02x%02x", n.R, n.G, n.B)
+}
+
+// svgEscape escapes text for use as XML character data.
+func svgEscape(s string) string {
+	var b strings.Builder
+	xml.EscapeText(&b, []byte(s))
+	return b.String()
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/xml"
+	"image/color"
+	"image/png"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// svgDoc is the subset of the SVG output the tests inspect.
+type svgDoc struct {
+	Width  int    `xml:"width,attr"`
+	Height int    `xml:"height,attr"`
+	Style  string `xml:"style"`
+	Image  struct {
+		Href string `xml:"href,attr"`
+	} `xml:"image"`
+	Texts []svgText `xml:"text"`
+	Defs  []struct {
+		Gradient struct {
+			ID    string `xml:"id,attr"`
+			Y1    string `xml:"y1,attr"`
+			Y2    string `xml:"y2,attr"`
+			Stops []struct {
+				Offset string `xml:"offset,attr"`
+				Color  string `xml:"stop-color,attr"`
+			} `xml:"stop"`
+		} `xml:"linearGradient"`
+	} `xml:"defs"`
+	Groups []struct {
+		Transform string    `xml:"transform,attr"`
+		Texts     []svgText `xml:"text"`
+	} `xml:"g"`
+}
+
+type svgText struct {
+	X         string `xml:"x,attr"`
+	Y         string `xml:"y,attr"`
+	FontSize  string `xml:"font-size,attr"`
+	Fill      string `xml:"fill,attr"`
+	Stroke    string `xml:"stroke,attr"`
+	Transform string `xml:"transform,attr"`
+	Text      string `xml:",chardata"`
+}
+
+// renderSVG renders opts as SVG and returns the parsed document and the
+// layout -measure reports for the same options.
+func renderSVG(t *testing.T, opts Options) (svgDoc, layout) {
+	t.Helper()
+	templateData := loadTestTemplate(t)
+	opts.Format = formatSVG
+
+	var buf bytes.Buffer
+	if err := run(opts, &buf, templateData, fontBytes); err != nil {
+		t.Fatalf("run: %v", err)
+	}
+	var doc svgDoc
+	if err := xml.Unmarshal(buf.Bytes(), &doc); err != nil {
+		t.Fatalf("parsing SVG: %v", err)
+	}
+
+	opts.Measure = true
+	var jsonBuf bytes.Buffer
+	if err := run(opts, &jsonBuf, templateData, fontBytes); err != nil {
+		t.Fatalf("measure: %v", err)
+	}
+	var lay layout
+	if err := json.Unmarshal(jsonBuf.Bytes(), &lay); err != nil {
+		t.Fatalf("decoding layout: %v", err)
+	}
+	return doc, lay
+}
+
+func TestSVGOutput(t *testing.T) {
+	doc, lay := renderSVG(t, Options{Text: "HI & <BYE>\nTHERE", Width: 960, Watermark: "@memegen"})
+
+	if lay.Format != formatSVG {
+		t.Errorf("layout format = %q, want svg", lay.Format)
+	}
+	if doc.Width != lay.Width || doc.Height != lay.Height {
+		t.Errorf("SVG is %dx%d, layout %dx%d", doc.Width, doc.Height, lay.Width, lay.Height)
+	}
+	if !strings.Contains(doc.Style, "@font-face") || !strings.Contains(doc.Style, "data:font/ttf;base64,") {
+		t.Error("font is not embedded")
+	}
+
+	// The background is the template without any text
+	data, ok := strings.CutPrefix(doc.Image.Href, "data:image/png;base64,")
+	if !ok {
+		t.Fatalf("image href is not a PNG data URI: %.40q", doc.Image.Href)
+	}
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatalf("decoding background: %v", err)
+	}
+	bg, err := png.Decode(bytes.NewReader(raw))
+	if err != nil {
+		t.Fatalf("decoding background PNG: %v", err)
+	}
+	var plain bytes.Buffer
+	if err := run(Options{Width: 960}, &plain, loadTestTemplate(t), fontBytes); err != nil {
+		t.Fatal(err)
+	}
+	want, _ := png.Decode(&plain)
+	if err := diffImages(want, bg); err != nil {
+		t.Errorf("background: %v", err)
+	}
+
+	// One text element per caption line, at the laid-out positions, then
+	// the watermark
+	lines := lay.Captions[0].Lines
+	if len(doc.Texts) != len(lines)+1 {
+		t.Fatalf("got %d text elements, want %d", len(doc.Texts), len(lines)+1)
+	}
+	for i, l := range lines {
+		got := doc.Texts[i]
+		if got.Text != l.Text || got.X != strconv.FormatFloat(l.X, 'g', -1, 64) || got.Y != strconv.Itoa(l.Baseline) {
+			t.Errorf("line %d: text %q at %s,%s; want %q at %v,%d", i, got.Text, got.X, got.Y, l.Text, l.X, l.Baseline)
+		}
+		if got.Fill != "#000000" || got.Stroke != "#ffffff" {
+			t.Errorf("line %d: fill %s stroke %s, want black on white", i, got.Fill, got.Stroke)
+		}
+	}
+	if wm := doc.Texts[len(lines)]; wm.Text != "@memegen" || wm.FontSize != "24" {
+		t.Errorf("watermark element = %+v", wm)
+	}
+}
+
+func TestSVGTransforms(t *testing.T) {
+	doc, lay := renderSVG(t, Options{Text: "HI THERE", Tracking: 12, Rotate: -8})
+	if len(doc.Groups) != 1 || !strings.HasPrefix(doc.Groups[0].Transform, "rotate(352 ") {
+		t.Fatalf("rotated caption not grouped under rotate(352 ...): %+v", doc.Groups)
+	}
+	texts := doc.Groups[0].Texts
+	if len(texts) != 1 {
+		t.Fatalf("got %d rotated text elements, want 1", len(texts))
+	}
+	// "HI THERE" at tracking 12 is condensed on the test template
+	if l := lay.Captions[0].Lines[0]; l.Condense == 0 {
+		t.Fatal("test caption is not condensed, pick a longer one")
+	}
+	if !strings.HasPrefix(texts[0].Transform, "matrix(") {
+		t.Errorf("condensed line has transform %q, want a matrix", texts[0].Transform)
+	}
+}
+
+func TestSVGGradient(t *testing.T) {
+	doc, lay := renderSVG(t, Options{Text: "HI", FillGradient: []color.NRGBA{{255, 221, 0, 255}, {255, 51, 0, 255}}})
+	if len(doc.Defs) != 1 || len(doc.Texts) != 1 {
+		t.Fatalf("got %d gradients and %d texts, want 1 of each", len(doc.Defs), len(doc.Texts))
+	}
+	g, block := doc.Defs[0].Gradient, lay.Captions[0].Block
+	if g.Y1 != strconv.Itoa(block.Y) || g.Y2 != strconv.Itoa(block.Y+block.H) {
+		t.Errorf("gradient spans y %s-%s, want the caption block %d-%d", g.Y1, g.Y2, block.Y, block.Y+block.H)
+	}
+	if len(g.Stops) != 2 || g.Stops[0].Color != "#ffdd00" || g.Stops[1].Offset != "1" || g.Stops[1].Color != "#ff3300" {
+		t.Errorf("stops = %+v, want #ffdd00 at 0 and #ff3300 at 1", g.Stops)
+	}
+	if want := "url(#" + g.ID + ")"; doc.Texts[0].Fill != want || doc.Texts[0].Stroke == "" {
+		t.Errorf("text fill %q stroke %q, want %s with the outline stroke", doc.Texts[0].Fill, doc.Texts[0].Stroke, want)
+	}
+}
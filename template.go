@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	// Templates may be any of these; image.Decode sniffs which
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+)
+
+// stdinPath names standard input where a file path is expected.
+const stdinPath = "-"
+
+// readTemplate returns the raw template image at path, or from stdin for
+// stdinPath. Decoding is left to loadResources, which recognises the
+// format from the data itself rather than the file name.
+func readTemplate(path string, stdin io.Reader) ([]byte, error) {
+	if path == stdinPath {
+		data, err := io.ReadAll(stdin)
+		if err != nil {
+			return nil, fmt.Errorf("reading template from stdin: %w", err)
+		}
+		if len(data) == 0 {
+			return nil, fmt.Errorf("reading template from stdin: no data")
+		}
+		return data, nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("reading template: %w", err)
+	}
+	return data, nil
+}
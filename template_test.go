@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/gif"
+	"image/jpeg"
+	"image/png"
+	"strings"
+	"testing"
+)
+
+// TestTemplateFormats checks that JPEG and GIF templates piped in on stdin
+// are recognised from their content.
+func TestTemplateFormats(t *testing.T) {
+	src := image.NewRGBA(image.Rect(0, 0, 320, 180))
+	for i := range src.Pix {
+		src.Pix[i] = 200
+	}
+	encoders := map[string]func(*bytes.Buffer) error{
+		"jpeg": func(b *bytes.Buffer) error { return jpeg.Encode(b, src, nil) },
+		"gif":  func(b *bytes.Buffer) error { return gif.Encode(b, src, nil) },
+		"png":  func(b *bytes.Buffer) error { return png.Encode(b, src) },
+	}
+	for name, encode := range encoders {
+		var in bytes.Buffer
+		if err := encode(&in); err != nil {
+			t.Fatal(err)
+		}
+		data, err := readTemplate(stdinPath, &in)
+		if err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		var out bytes.Buffer
+		if err := run(Options{Text: "LGTM"}, &out, data, fontBytes); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		img, err := png.Decode(&out)
+		if err != nil {
+			t.Fatalf("%s: decoding output: %v", name, err)
+		}
+		if img.Bounds() != src.Bounds() {
+			t.Errorf("%s: output is %v, want the template's %v", name, img.Bounds(), src.Bounds())
+		}
+		// Allow for JPEG compression and the GIF palette
+		if r, _, _, _ := img.At(5, 175).RGBA(); r>>8 < 180 || r>>8 > 220 {
+			t.Errorf("%s: template pixel %v not carried over", name, img.At(5, 175))
+		}
+	}
+}
+
+func TestTemplateStdinErrors(t *testing.T) {
+	if _, err := readTemplate(stdinPath, strings.NewReader("")); err == nil {
+		t.Error("empty stdin accepted")
+	}
+	err := run(Options{Text: "HI"}, &bytes.Buffer{}, []byte("not an image"), fontBytes)
+	if err == nil || !strings.Contains(err.Error(), "want PNG, JPEG or GIF") {
+		t.Errorf("undecodable template: err = %v", err)
+	}
+}
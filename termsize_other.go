@@ -0,0 +1,11 @@
+//go:build !linux && !darwin
+
+package main
+
+import "os"
+
+// terminalWidth returns 0: the terminal size is not available here, so
+// $COLUMNS or the default is used.
+func terminalWidth(f *os.File) int {
+	return 0
+}
@@ -0,0 +1,406 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+
+	"github.com/golang/freetype"
+	"github.com/golang/freetype/truetype"
+	"golang.org/x/image/font"
+	"golang.org/x/image/font/sfnt"
+	"golang.org/x/image/math/fixed"
+)
+
+// textStyle is the set of font settings that determine where glyphs land.
+// Layout measures with a textStyle and the painter draws with the same one,
+// so measurement and drawing always agree.
+type textStyle struct {
+	font      *typeface
+	size      float64      // Font size in points
+	hinting   font.Hinting // Must match between drawing and measuring
+	tracking  int          // Extra pixels between glyphs, may be negative
+	metrics   metricsOverride
+	outline   string     // outlineStamp or outlineStroke; empty means stamp
+	thickness int        // Outline width in pixels
+	arc       float64    // Degrees captions are bent along, for the room they take
+	faces     *faceCache // Faces of font shared between renders, or nil
+}
+
+// Hinting modes accepted in Options.Hinting
+const (
+	hintingNone     = "none"     // Unhinted outlines, true to the design at any size
+	hintingVertical = "vertical" // Heights snapped to the pixel grid, widths unhinted
+	hintingFull     = "full"     // Both snapped, crisp when small (default)
+)
+
+// hintingModes maps hinting mode names to freetype's; empty means full.
+var hintingModes = map[string]font.Hinting{
+	"":              font.HintingFull,
+	hintingNone:     font.HintingNone,
+	hintingVertical: font.HintingVertical,
+	hintingFull:     font.HintingFull,
+}
+
+// newTextStyle returns a style for ttFont at size points with full hinting
+// and the usual outline.
+func newTextStyle(ttFont *typeface, size float64) textStyle {
+	return textStyle{
+		font:      ttFont,
+		size:      size,
+		hinting:   font.HintingFull, // Improve font rendering quality
+		thickness: outlineThickness,
+	}
+}
+
+// measure returns the extent text will occupy when drawn.
+func (s textStyle) measure(text string) (lineExtent, error) {
+	return measureString(s.font, s.size, dpi, s.hinting, s.tracking, text)
+}
+
+// verticalMetrics returns the ascent and descent in pixels used to place
+// baselines, with any override applied.
+func (s textStyle) verticalMetrics() (ascent, descent int) {
+	return verticalMetrics(s.font, s.size, s.hinting, s.metrics, s.faces)
+}
+
+// faceMetrics returns the metrics of the style's font face.
+func (s textStyle) faceMetrics() font.Metrics {
+	if s.faces != nil {
+		return s.faces.metrics(s.size, dpi, s.hinting)
+	}
+	return s.font.newFace(s.size, dpi, s.hinting).Metrics()
+}
+
+// lineHeight returns the distance between baselines of consecutive lines:
+// one em, which provides a reasonable pixel height for an all-caps font.
+func (s textStyle) lineHeight() int {
+	return int(fixed.Int26_6(s.size*dpi*(64.0/72.0)) >> 6)
+}
+
+// textPainter draws outlined lines of text onto a canvas with a textStyle.
+// TrueType glyphs are drawn with a freetype context, CFF glyphs with a
+// font.Face.
+type textPainter struct {
+	textStyle
+	c          *freetype.Context // For TrueType fonts
+	face       font.Face         // For CFF fonts
+	dst        *image.RGBA
+	outlineSrc image.Image // Outline color, outlineColor unless changed
+
+	// Where drawString draws, and in what
+	target draw.Image
+	clip   image.Rectangle
+	src    image.Image
+
+	// fillOnly skips the outline of outlined text, to render the fill on
+	// its own
+	fillOnly bool
+}
+
+// newTextPainter returns a painter drawing onto dst with the given style.
+func newTextPainter(dst *image.RGBA, style textStyle) *textPainter {
+	p := &textPainter{textStyle: style, dst: dst, outlineSrc: outlineColor}
+	if style.font.tt != nil {
+		p.c = freetype.NewContext()
+		p.c.SetDPI(dpi)
+		p.c.SetFont(style.font.tt)
+		p.c.SetFontSize(style.size)
+		p.c.SetHinting(style.hinting)
+	} else {
+		p.face = style.font.newFace(style.size, dpi, style.hinting)
+	}
+	p.setDst(dst, dst.Bounds())
+	return p
+}
+
+// setDst makes drawString draw onto dst, clipped to clip.
+func (p *textPainter) setDst(dst draw.Image, clip image.Rectangle) {
+	p.target, p.clip = dst, clip
+	if p.c != nil {
+		p.c.SetDst(dst)
+		p.c.SetClip(clip)
+	}
+}
+
+// setSrc makes drawString draw in src.
+func (p *textPainter) setSrc(src image.Image) {
+	p.src = src
+	if p.c != nil {
+		p.c.SetSrc(src)
+	}
+}
+
+// drawOutlined draws text with its baseline starting at pt: first the
+// outline, by stamping the text in the outline color at eight offsets around the
+// position, then the text in fill on top. pt may have a fractional x;
+// the offsets are applied in the same fixed-point space so the outline stays
+// symmetric around the fill. Tracking applies identically to every pass.
+// With the stroke outline style, drawStroked draws the line instead.
+func (p *textPainter) drawOutlined(text string, pt fixed.Point26_6, fill image.Image) error {
+	if p.outline == outlineStroke {
+		return p.drawStroked(text, pt, fill)
+	}
+	if p.fillOnly {
+		return p.drawFill(text, pt, fill)
+	}
+
+	// Define offsets for the 8 directions around the center for the outline
+	t := p.thickness
+	offsets := []image.Point{
+		{-t, -t}, {0, -t}, {t, -t},
+		{-t, 0} /* {0, 0} is the center, skip */, {t, 0},
+		{-t, t}, {0, t}, {t, t},
+	}
+
+	// Draw outline parts first
+	p.setSrc(p.outlineSrc)
+	for _, offset := range offsets {
+		offsetPt := pt.Add(fixed.P(offset.X, offset.Y))
+		if err := p.drawString(text, offsetPt); err != nil {
+			// Return error if any part of the outline fails to draw
+			return fmt.Errorf("drawing outline part at offset %v: %w", offset, err)
+		}
+	}
+
+	// Draw main text (fill) on top
+	return p.drawFill(text, pt, fill)
+}
+
+// drawFill draws the fill of outlined text: text in fill, which may be a
+// uniform color or vary across the canvas.
+func (p *textPainter) drawFill(text string, pt fixed.Point26_6, fill image.Image) error {
+	if _, ok := fill.(*image.Uniform); !ok {
+		return p.drawMaskedFill(text, pt, fill)
+	}
+	p.setSrc(fill)
+	if err := p.drawString(text, pt); err != nil {
+		// Return error if the main text fill fails to draw
+		return fmt.Errorf("drawing main text fill: %w", err)
+	}
+	return nil
+}
+
+// drawMaskedFill draws text in fill, an image that varies across the canvas
+// such as a gradient. freetype aligns its source with each glyph, so the
+// glyphs are rasterised into a mask first and fill is composited through it
+// aligned with the canvas.
+func (p *textPainter) drawMaskedFill(text string, pt fixed.Point26_6, fill image.Image) error {
+	ext, err := p.measure(text)
+	if err != nil {
+		return err
+	}
+	r := ext.inkRect(pt).Inset(-1).Intersect(p.dst.Bounds()) // Hinting may reach past the ink
+	if r.Empty() {
+		return nil
+	}
+	mask := image.NewAlpha(r)
+	p.setDst(mask, r)
+	p.setSrc(image.Opaque)
+	err = p.drawString(text, pt)
+	p.setDst(p.dst, p.dst.Bounds())
+	if err != nil {
+		return fmt.Errorf("drawing main text fill: %w", err)
+	}
+	draw.DrawMask(p.dst, r, fill, r.Min, mask, r.Min, draw.Over)
+	return nil
+}
+
+// drawPlain draws text with its baseline starting at pt in a single color,
+// without an outline.
+func (p *textPainter) drawPlain(text string, pt fixed.Point26_6, c color.Color) error {
+	p.setSrc(image.NewUniform(c))
+	if err := p.drawString(text, pt); err != nil {
+		return fmt.Errorf("drawing text: %w", err)
+	}
+	return nil
+}
+
+// drawString draws text starting at pt in the current source color. Glyphs
+// are placed one at a time at the pen positions computed by layoutLine, so
+// kerning and tracking match measure() exactly; with zero tracking the
+// result is identical to DrawString on the whole line.
+func (p *textPainter) drawString(text string, pt fixed.Point26_6) error {
+	offsets, _ := layoutLine(p.font, p.size, dpi, p.hinting, p.tracking, text)
+	for i, r := range []rune(text) {
+		glyphPt := fixed.Point26_6{X: pt.X + offsets[i], Y: pt.Y}
+		if p.c == nil {
+			p.drawGlyph(r, glyphPt)
+			continue
+		}
+		if _, err := p.c.DrawString(string(r), glyphPt); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// drawGlyph draws r from the painter's face with its origin at pt, as
+// DrawString draws with the freetype context: through the glyph's
+// coverage mask, with the source aligned to the target's origin. Runes the
+// font has no glyph for are skipped rather than drawn as .notdef boxes.
+func (p *textPainter) drawGlyph(r rune, pt fixed.Point26_6) {
+	dr, mask, maskp, _, ok := p.face.Glyph(pt, r)
+	if !ok {
+		return
+	}
+	clipped := dr.Intersect(p.clip)
+	if clipped.Empty() {
+		return
+	}
+	draw.DrawMask(p.target, clipped, p.src, image.Point{}, mask, maskp.Add(clipped.Min.Sub(dr.Min)), draw.Over)
+}
+
+// lineExtent is the horizontal extent of a laid-out line of text, relative
+// to the pen position the line starts at.
+type lineExtent struct {
+	Advance fixed.Int26_6 // Pen advance over the whole line
+	InkMin  fixed.Int26_6 // Left edge of the glyph outlines
+	InkMax  fixed.Int26_6 // Right edge of the glyph outlines
+
+	// Vertical glyph bounds relative to the baseline, y growing downwards:
+	// InkTop is negative for glyphs rising above the baseline and
+	// InkBottom positive for descenders.
+	InkTop    fixed.Int26_6
+	InkBottom fixed.Int26_6
+}
+
+// inkRect returns the pixel rectangle covered by the glyph outlines of a line
+// drawn with its pen starting at pt. It is empty for lines without ink.
+func (e lineExtent) inkRect(pt fixed.Point26_6) image.Rectangle {
+	if e.InkMax <= e.InkMin {
+		return image.Rectangle{}
+	}
+	return image.Rect(
+		(pt.X + e.InkMin).Floor(), (pt.Y + e.InkTop).Floor(),
+		(pt.X + e.InkMax).Ceil(), (pt.Y + e.InkBottom).Ceil(),
+	)
+}
+
+// inkWidth returns the width in whole pixels covered by the glyph outlines.
+func (e lineExtent) inkWidth() int {
+	return e.InkMax.Ceil() - e.InkMin.Floor()
+}
+
+// centeredX returns the pen x at which a line with extent e must start so
+// that its ink is centered in a span of width pixels starting at 0. The
+// result keeps the fractional part of the measured width instead of rounding
+// to whole pixels. The ink is never pushed past the left edge when it is
+// wider than the span; clamped reports whether that happened.
+func (e lineExtent) centeredX(width int) (x fixed.Int26_6, clamped bool) {
+	x = (fixed.I(width)-(e.InkMax-e.InkMin))/2 - e.InkMin
+	if x+e.InkMin < 0 {
+		return -e.InkMin, true // Prevent the text starting left of the span
+	}
+	return x, false
+}
+
+// layoutLine computes the pen x offset of each rune in text relative to the
+// start of the line, and the line's extent. Glyph advances and kerning are
+// computed the way freetype.Context.DrawString computes them (same scale,
+// same hinted glyph loading, same kern rounding), with tracking added
+// between each pair of glyphs. CFF fonts are measured with sfnt instead,
+// at the scale their faces draw with.
+func layoutLine(fnt *typeface, size, dpi float64, hinting font.Hinting, tracking int, text string) ([]fixed.Int26_6, lineExtent) {
+	if fnt.cff != nil {
+		return layoutLineCFF(fnt.cff, size, dpi, hinting, tracking, text)
+	}
+	// Same scale as freetype.Context uses internally
+	scale := fixed.Int26_6(size * dpi * (64.0 / 72.0))
+
+	var (
+		glyph   truetype.GlyphBuf
+		ext     lineExtent
+		offsets []fixed.Int26_6
+		pen     fixed.Int26_6
+		prev    truetype.Index
+		hasInk  bool
+	)
+	for i, r := range []rune(text) {
+		index := fnt.tt.Index(r)
+		if i > 0 {
+			kern := fnt.tt.Kern(scale, prev, index)
+			if hinting != font.HintingNone {
+				kern = (kern + 32) &^ 63
+			}
+			pen += kern + fixed.I(tracking)
+		}
+		offsets = append(offsets, pen)
+
+		if err := glyph.Load(fnt.tt, scale, index, hinting); err == nil {
+			// Glyph bounds are y-up; flip them to image coordinates
+			b := glyph.Bounds
+			hasInk = ext.addInk(pen, fixed.Rectangle26_6{
+				Min: fixed.Point26_6{X: b.Min.X, Y: -b.Max.Y},
+				Max: fixed.Point26_6{X: b.Max.X, Y: -b.Min.Y},
+			}, hasInk)
+			pen += glyph.AdvanceWidth
+		}
+		prev = index
+	}
+	ext.Advance = pen
+	return offsets, ext
+}
+
+// layoutLineCFF is layoutLine for a font with CFF outlines.
+func layoutLineCFF(fnt *sfnt.Font, size, dpi float64, hinting font.Hinting, tracking int, text string) ([]fixed.Int26_6, lineExtent) {
+	ppem := cffScale(size, dpi)
+	var (
+		buf     sfnt.Buffer
+		ext     lineExtent
+		offsets []fixed.Int26_6
+		pen     fixed.Int26_6
+		prev    sfnt.GlyphIndex
+		hasInk  bool
+	)
+	for i, r := range []rune(text) {
+		index, _ := fnt.GlyphIndex(&buf, r) // 0, .notdef, when missing
+		if i > 0 {
+			kern, _ := fnt.Kern(&buf, prev, index, ppem, hinting) // 0 without kerning
+			pen += kern + fixed.I(tracking)
+		}
+		offsets = append(offsets, pen)
+
+		if b, advance, err := fnt.GlyphBounds(&buf, index, ppem, hinting); err == nil {
+			if index != 0 { // Missing glyphs are not drawn
+				hasInk = ext.addInk(pen, b, hasInk) // Already y-down
+			}
+			pen += advance
+		}
+		prev = index
+	}
+	ext.Advance = pen
+	return offsets, ext
+}
+
+// addInk widens e to cover glyph bounds b, in image coordinates relative
+// to the glyph origin, drawn with the pen at x. hasInk says whether e
+// covers any ink yet; addInk returns whether it does now.
+func (e *lineExtent) addInk(x fixed.Int26_6, b fixed.Rectangle26_6, hasInk bool) bool {
+	if b.Min.X >= b.Max.X { // Blank glyphs such as space have no ink
+		return hasInk
+	}
+	if !hasInk || x+b.Min.X < e.InkMin {
+		e.InkMin = x + b.Min.X
+	}
+	if !hasInk || x+b.Max.X > e.InkMax {
+		e.InkMax = x + b.Max.X
+	}
+	if !hasInk || b.Min.Y < e.InkTop {
+		e.InkTop = b.Min.Y
+	}
+	if !hasInk || b.Max.Y > e.InkBottom {
+		e.InkBottom = b.Max.Y
+	}
+	return true
+}
+
+// measureString calculates the extent of a string when rendered with the
+// specified font properties, using the same glyph layout as the drawing
+// path so that centering on the measured ink is exact. tracking pixels are
+// added between each pair of glyphs.
+func measureString(fnt *typeface, size, dpi float64, hinting font.Hinting, tracking int, text string) (lineExtent, error) {
+	_, ext := layoutLine(fnt, size, dpi, hinting, tracking, text)
+	return ext, nil
+}
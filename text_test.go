@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bytes"
+	"image"
+	"image/png"
+	"testing"
+
+	"golang.org/x/image/font"
+	"golang.org/x/image/math/fixed"
+)
+
+func TestMeasureStringTracking(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+
+	base, err := measureString(ttFont, fontSize, dpi, font.HintingFull, 0, "HELLO")
+	if err != nil {
+		t.Fatalf("measureString: %v", err)
+	}
+	for _, tracking := range []int{-5, 3, 20} {
+		got, err := measureString(ttFont, fontSize, dpi, font.HintingFull, tracking, "HELLO")
+		if err != nil {
+			t.Fatalf("measureString: %v", err)
+		}
+		// Four gaps between five glyphs
+		if want := base.inkWidth() + 4*tracking; got.inkWidth() != want {
+			t.Errorf("tracking %d: width %d, want %d", tracking, got.inkWidth(), want)
+		}
+	}
+
+	// A single glyph has no gaps to track
+	one, _ := measureString(ttFont, fontSize, dpi, font.HintingFull, 0, "H")
+	oneTracked, _ := measureString(ttFont, fontSize, dpi, font.HintingFull, 50, "H")
+	if one != oneTracked {
+		t.Errorf("single glyph extent changed with tracking: %+v vs %+v", one, oneTracked)
+	}
+}
+
+// TestCaptionCentered renders kerning-prone captions onto a transparent
+// template and checks that the drawn glyphs (outline included) are centered
+// within one pixel.
+func TestCaptionCentered(t *testing.T) {
+	var tmpl bytes.Buffer
+	if err := png.Encode(&tmpl, image.NewNRGBA(image.Rect(0, 0, 900, 200))); err != nil {
+		t.Fatalf("encoding template: %v", err)
+	}
+
+	for _, text := range []string{"AVATAR TO WAVY", "LT YA", "TOTAL", "W"} {
+		for _, tracking := range []int{0, 9} {
+			var buf bytes.Buffer
+			if err := run(Options{Text: text, Tracking: tracking}, &buf, tmpl.Bytes(), fontBytes); err != nil {
+				t.Fatalf("run(%q): %v", text, err)
+			}
+			img, err := png.Decode(&buf)
+			if err != nil {
+				t.Fatalf("decoding output: %v", err)
+			}
+
+			b := img.Bounds()
+			left, right := b.Max.X, b.Min.X-1
+			for y := b.Min.Y; y < b.Max.Y; y++ {
+				for x := b.Min.X; x < b.Max.X; x++ {
+					if _, _, _, a := img.At(x, y).RGBA(); a != 0 {
+						left, right = min(left, x), max(right, x)
+					}
+				}
+			}
+			if right < left {
+				t.Fatalf("%q: nothing drawn", text)
+			}
+			leftMargin, rightMargin := left-b.Min.X, b.Max.X-1-right
+			// The ink center is off by half the margin difference
+			if d := leftMargin - rightMargin; d > 2 || d < -2 {
+				t.Errorf("%q tracking %d: margins %d/%d, not centered within one pixel",
+					text, tracking, leftMargin, rightMargin)
+			}
+		}
+	}
+}
+
+func TestCenteredXSubPixel(t *testing.T) {
+	cases := []struct {
+		ext     lineExtent
+		width   int
+		want    fixed.Int26_6
+		clamped bool
+	}{
+		// 101px of ink in 200px leaves 49.5px on each side
+		{lineExtent{InkMin: 0, InkMax: fixed.I(101)}, 200, fixed.I(49) + 32, false},
+		// A left side bearing of 3.25px is compensated for exactly
+		{lineExtent{InkMin: fixed.I(3) + 16, InkMax: fixed.I(53) + 16}, 100, fixed.I(25) - fixed.I(3) - 16, false},
+		// Ink wider than the span starts at the left edge
+		{lineExtent{InkMin: fixed.I(2), InkMax: fixed.I(302)}, 200, -fixed.I(2), true},
+	}
+	for _, tc := range cases {
+		got, clamped := tc.ext.centeredX(tc.width)
+		if got != tc.want || clamped != tc.clamped {
+			t.Errorf("centeredX(%+v, %d) = %v, %v; want %v, %v", tc.ext, tc.width, got, clamped, tc.want, tc.clamped)
+		}
+	}
+}
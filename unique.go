@@ -0,0 +1,83 @@
+package main
+
+import (
+	"image"
+	"math"
+	"math/rand/v2"
+)
+
+// uniquePixelsPer is the density of -unique: one pixel in this many is
+// perturbed. A change of one in the lowest bit of one channel on 0.025% of
+// the pixels keeps the PSNR far above 90 dB.
+const uniquePixelsPer = 4096
+
+// minUniquePixels is the least number of pixels -unique perturbs, so small
+// images still get a distinct hash with overwhelming probability.
+const minUniquePixels = 16
+
+// perturbUnique flips the lowest bit of one color channel in a sparse,
+// seed-determined selection of pixels outside the excluded regions, so that
+// renders with different seeds produce different files that look the same.
+// It returns the number of pixels changed.
+func perturbUnique(img *image.RGBA, seed uint64, exclude []image.Rectangle) int {
+	b := img.Bounds()
+	total := b.Dx() * b.Dy()
+	want := max(total/uniquePixelsPer, minUniquePixels)
+
+	rng := rand.New(rand.NewPCG(seed, seed^0x9e3779b97f4a7c15))
+	chosen := make(map[int]bool, want)
+	// Bound the attempts so images that are almost entirely excluded can't
+	// loop forever
+	for attempts := 0; len(chosen) < want && attempts < 16*want; attempts++ {
+		i := rng.IntN(total)
+		pt := image.Pt(b.Min.X+i%b.Dx(), b.Min.Y+i/b.Dx())
+		if chosen[i] || inAny(pt, exclude) {
+			continue
+		}
+		chosen[i] = true
+		channel := rng.IntN(3) // R, G or B; alpha is left alone
+		img.Pix[img.PixOffset(pt.X, pt.Y)+channel] ^= 1
+	}
+	return len(chosen)
+}
+
+// inAny reports whether pt lies in any of rects.
+func inAny(pt image.Point, rects []image.Rectangle) bool {
+	for _, r := range rects {
+		if pt.In(r) {
+			return true
+		}
+	}
+	return false
+}
+
+// captionRegions returns the parts of the canvas -unique must leave alone:
+// the caption with its outline and text box, a caption bar, and the
+// watermark.
+func captionRegions(lay *layout, opts Options) []image.Rectangle {
+	outline := captionThickness(opts)
+	var regions []image.Rectangle
+	if lay.Bar != nil {
+		regions = append(regions, lay.Bar.rect())
+	}
+
+	for _, cl := range lay.Captions {
+		caption := cl.TextBox.rect()
+		if caption.Empty() {
+			caption = cl.Block.rect().Inset(-outline)
+		}
+		if cl.Rotate != 0 && !caption.Empty() {
+			// Any rotation about the center stays within the circle through
+			// the corners; one extra pixel covers bilinear resampling.
+			c := caption.Min.Add(caption.Max).Div(2)
+			r := int(math.Ceil(math.Hypot(float64(caption.Dx()), float64(caption.Dy()))/2)) + 1
+			caption = image.Rect(c.X-r, c.Y-r, c.X+r, c.Y+r)
+		}
+		regions = append(regions, caption)
+	}
+
+	if lay.Watermark != nil {
+		regions = append(regions, lay.Watermark.Box.rect().Inset(-scalePx(outlineThickness, opts.Scale)))
+	}
+	return regions
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"image"
+	"image/color"
+	"image/png"
+	"testing"
+)
+
+func TestUniqueHashes(t *testing.T) {
+	templateData := loadTestTemplate(t)
+	render := func(opts Options) ([]byte, image.Image) {
+		t.Helper()
+		var buf bytes.Buffer
+		if err := run(opts, &buf, templateData, fontBytes); err != nil {
+			t.Fatalf("run(%+v): %v", opts, err)
+		}
+		img, err := png.Decode(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("decoding render: %v", err)
+		}
+		return buf.Bytes(), img
+	}
+
+	plainData, plain := render(Options{Text: "HI", Watermark: "@memegen"})
+	seen := map[[32]byte]uint64{sha256.Sum256(plainData): 0}
+	for _, seed := range []uint64{1, 2, 3, 1 << 40} {
+		opts := Options{Text: "HI", Watermark: "@memegen", Unique: true, UniqueSeed: seed}
+		data, img := render(opts)
+		sum := sha256.Sum256(data)
+		if prev, dup := seen[sum]; dup {
+			t.Errorf("seed %d produced the same file as seed %d", seed, prev)
+		}
+		seen[sum] = seed
+
+		again, _ := render(opts)
+		if !bytes.Equal(data, again) {
+			t.Errorf("seed %d is not deterministic", seed)
+		}
+
+		// Only the low bit of a few pixels may change
+		changed := 0
+		b := img.Bounds()
+		for y := b.Min.Y; y < b.Max.Y; y++ {
+			for x := b.Min.X; x < b.Max.X; x++ {
+				pr, pg, pb, pa := plain.At(x, y).RGBA()
+				ur, ug, ub, ua := img.At(x, y).RGBA()
+				if pr == ur && pg == ug && pb == ub && pa == ua {
+					continue
+				}
+				changed++
+				// RGBA() scales 8-bit values by 0x101
+				if d := absDiff(pr, ur) + absDiff(pg, ug) + absDiff(pb, ub); d != 0x101 || pa != ua {
+					t.Errorf("seed %d: pixel (%d,%d) changed by more than one low bit", seed, x, y)
+				}
+			}
+		}
+		if want := max(b.Dx()*b.Dy()/uniquePixelsPer, minUniquePixels); changed == 0 || changed > want {
+			t.Errorf("seed %d: %d pixels changed, want 1..%d", seed, changed, want)
+		}
+	}
+}
+
+func absDiff(a, b uint32) uint32 {
+	if a > b {
+		return a - b
+	}
+	return b - a
+}
+
+// TestUniqueSparesCaption perturbs a canvas far more than -unique would and
+// checks that the excluded regions are untouched.
+func TestUniqueSparesCaption(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	exclude := []image.Rectangle{image.Rect(0, 0, 64, 32), image.Rect(40, 40, 64, 64)}
+	n := perturbUnique(img, 7, exclude)
+	if n != minUniquePixels {
+		t.Errorf("changed %d pixels, want %d", n, minUniquePixels)
+	}
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			if inAny(image.Pt(x, y), exclude) && img.RGBAAt(x, y) != (color.RGBA{}) {
+				t.Fatalf("excluded pixel (%d,%d) changed", x, y)
+			}
+		}
+	}
+
+	// A fully excluded canvas must not hang or change
+	if n := perturbUnique(img, 7, []image.Rectangle{img.Bounds()}); n != 0 {
+		t.Errorf("fully excluded canvas: changed %d pixels", n)
+	}
+}
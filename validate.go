@@ -0,0 +1,246 @@
+package main
+
+import (
+	"cmp"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// FieldIssue is one problem with one option. Field is the option's flag
+// name, which is also its config file key.
+type FieldIssue struct {
+	Field      string `json:"field"`
+	Value      string `json:"value"`
+	Reason     string `json:"reason"`
+	Suggestion string `json:"suggestion,omitempty"`
+}
+
+// ValidationError reports every problem found in a set of options at once,
+// so they can all be fixed in one go.
+type ValidationError struct {
+	Issues []FieldIssue `json:"issues"`
+}
+
+// Error lists the issues one per line.
+func (e *ValidationError) Error() string {
+	lines := make([]string, len(e.Issues))
+	for i, is := range e.Issues {
+		lines[i] = fmt.Sprintf("-%s %q: %s", is.Field, is.Value, is.Reason)
+		if is.Suggestion != "" {
+			lines[i] += " (" + is.Suggestion + ")"
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// add records an issue with field.
+func (e *ValidationError) add(field, value, reason, suggestion string) {
+	e.Issues = append(e.Issues, FieldIssue{Field: field, Value: value, Reason: reason, Suggestion: suggestion})
+}
+
+// addErr records err as an issue with field, if it is non-nil.
+func (e *ValidationError) addErr(field, value string, err error) {
+	if err != nil {
+		e.add(field, value, err.Error(), "")
+	}
+}
+
+// err returns e as an error, or nil if no issues were recorded.
+func (e *ValidationError) err() error {
+	if len(e.Issues) == 0 {
+		return nil
+	}
+	return e
+}
+
+// validateOptions checks opts without touching files or the network,
+// returning a *ValidationError listing every problem found. Checks that
+// need the template, such as whether spec boxes fit it, happen at layout.
+func validateOptions(opts Options) error {
+	var v ValidationError
+	oneOf(&v, "position", opts.Position, "", positionTop, positionMiddle, positionBottom, alignLeft, alignRight)
+	oneOf(&v, "caption-bar-position", opts.CaptionBarPosition, "", positionTop, positionBottom)
+	oneOf(&v, "watermark-corner", opts.WatermarkCorner, "", "tl", "tr", "bl", "br")
+	oneOf(&v, "outline-style", opts.OutlineStyle, "", outlineStamp, outlineStroke)
+	oneOf(&v, "hinting", opts.Hinting, "", hintingNone, hintingVertical, hintingFull)
+	oneOf(&v, "bubble-shape", opts.BubbleShape, "", bubbleRect, bubbleEllipse)
+	oneOf(&v, "fit", opts.Fit, "", fitShrink, fitError, fitClip)
+	oneOf(&v, "format", opts.Format, "", formatPNG, formatSVG)
+	oneOf(&v, "encode", opts.Encode, "", encodeBase64, encodeDataURI)
+
+	if opts.Width < 0 {
+		v.add("width", strconv.Itoa(opts.Width), "must not be negative", "use 0 to keep the template width")
+	}
+	if opts.Height < 0 {
+		v.add("height", strconv.Itoa(opts.Height), "must not be negative", "use 0 to keep the template height")
+	}
+	fillOpacity, outlineOpacity := opacities(opts)
+	if !(fillOpacity >= 0 && fillOpacity <= 1) {
+		v.add("fill-opacity", fmt.Sprint(fillOpacity), "must be between 0 and 1", "use 0.5 for half transparent")
+	}
+	if !(outlineOpacity >= 0 && outlineOpacity <= 1) {
+		v.add("outline-opacity", fmt.Sprint(outlineOpacity), "must be between 0 and 1", "use 0.5 for half transparent")
+	}
+	// The checks of other options sized relative to these fall back on the
+	// defaults when they are invalid, rather than report bogus limits
+	scale, size := cmp.Or(opts.Scale, 1), cmp.Or(opts.Size, fontSize)
+	if opts.Scale != 0 && !checkScale(&v, opts.Scale) { // 0 means 1
+		scale = 1
+	}
+	if opts.Size != 0 && !checkFontSize(&v, "size", opts.Size, fontSize) { // 0 means the default
+		size = fontSize
+	}
+	if opts.BubbleSize != 0 { // 0 means the default
+		checkFontSize(&v, "bubble-size", opts.BubbleSize, defaultBubbleSize)
+	}
+	if opts.Padding != nil && *opts.Padding < 0 {
+		v.add("padding", strconv.Itoa(*opts.Padding), "must not be negative", "use 0 for none")
+	}
+	// As drawn, after -scale. Far beyond one em overflows fixed-point widths.
+	if em := size * dpi / 72 * scale; math.Abs(float64(scalePx(opts.Tracking, scale))) > em {
+		v.add("tracking", strconv.Itoa(opts.Tracking), fmt.Sprintf("must be at most one em, %g pixels, either way", math.Floor(em/scale)), "")
+	}
+	if math.IsNaN(opts.Rotate) || math.IsInf(opts.Rotate, 0) {
+		v.add("rotate", fmt.Sprint(opts.Rotate), "must be a finite number of degrees", "")
+	}
+	if math.IsNaN(opts.Arc) {
+		v.add("arc", fmt.Sprint(opts.Arc), "must be a number of degrees", fmt.Sprintf("from -%g to %g", maxArc, maxArc))
+	}
+	if opts.WatermarkSize != 0 { // 0 means the default
+		checkWatermarkSize(&v, opts.WatermarkSize)
+	}
+	if opts.TextRect != "" {
+		switch {
+		case len(opts.Boxes) > 0:
+			v.add("text-rect", opts.TextRect, "cannot be combined with -spec or -panel", "give the box in the spec instead")
+		case opts.CaptionBar:
+			v.add("text-rect", opts.TextRect, "cannot be combined with -caption-bar", "")
+		case opts.Demotivational:
+			v.add("text-rect", opts.TextRect, "cannot be combined with -demotivational", "")
+		}
+	}
+	if len(opts.FrameTexts) > 1 {
+		switch {
+		case opts.Format == formatSVG:
+			v.add("format", opts.Format, "cannot be animated", "use png with -frames or -frame-text")
+		case len(opts.Boxes) > 0 || opts.Demotivational:
+			v.add("frames", strconv.Itoa(len(opts.FrameTexts)), "cannot be combined with -spec, -panel or -demotivational", "")
+		}
+	}
+	if opts.FrameDelay < 0 || opts.FrameDelay > maxFrameDelay {
+		v.add("frame-delay", opts.FrameDelay.String(), "out of range", fmt.Sprintf("give 0 to %v", maxFrameDelay))
+	}
+	if opts.Loops < 0 {
+		v.add("loop", strconv.Itoa(opts.Loops), "must not be negative", "use 0 to loop forever")
+	}
+	if opts.CaptionBar && len(opts.Boxes) > 0 {
+		v.add("caption-bar", "true", "cannot be combined with -spec", "")
+	}
+	if opts.Demotivational {
+		if opts.Title == "" {
+			v.add("title", "", "is required with -demotivational", "")
+		}
+		if opts.CaptionBar {
+			v.add("demotivational", "true", "cannot be combined with -caption-bar", "")
+		}
+	}
+	return v.err()
+}
+
+// validateZeroFlags checks the flags that leave an Options field 0, which
+// validateOptions takes to mean the default. Their own defaults aren't 0,
+// so a 0 was given on the command line or in the config, and is reported.
+func validateZeroFlags(v *ValidationError, size, bubbleSize, scale, watermarkSize float64) {
+	if size == 0 {
+		checkFontSize(v, "size", size, fontSize)
+	}
+	if bubbleSize == 0 {
+		checkFontSize(v, "bubble-size", bubbleSize, defaultBubbleSize)
+	}
+	if scale == 0 {
+		checkScale(v, scale)
+	}
+	if watermarkSize == 0 {
+		checkWatermarkSize(v, watermarkSize)
+	}
+}
+
+// checkScale records an issue and returns false unless scale is a
+// positive number.
+func checkScale(v *ValidationError, scale float64) bool {
+	if scale > 0 && !math.IsInf(scale, 0) {
+		return true
+	}
+	v.add("scale", fmt.Sprint(scale), "must be a positive number", "use 2 for twice the size")
+	return false
+}
+
+// checkFontSize records an issue with field and returns false unless points
+// is at least one pixel and at most maxFontSize. def is the field's default.
+func checkFontSize(v *ValidationError, field string, points, def float64) bool {
+	if points*dpi/72 >= 1 && points <= maxFontSize {
+		return true
+	}
+	v.add(field, fmt.Sprint(points), fmt.Sprintf("must be at least one pixel and at most %g points", maxFontSize),
+		fmt.Sprintf("the default is %g", def))
+	return false
+}
+
+// checkWatermarkSize records an issue unless points is positive and at
+// most maxFontSize.
+func checkWatermarkSize(v *ValidationError, points float64) {
+	if !(points > 0 && points <= maxFontSize) {
+		v.add("watermark-size", fmt.Sprint(points), fmt.Sprintf("must be positive and at most %g points", maxFontSize),
+			fmt.Sprintf("the default is %g", defaultWatermarkSize))
+	}
+}
+
+// oneOf records an issue if value isn't one of choices, suggesting the
+// closest choice when there is a likely typo. The empty string, if listed,
+// means the default and isn't offered.
+func oneOf(v *ValidationError, field, value string, choices ...string) {
+	var named []string
+	for _, c := range choices {
+		if value == c {
+			return
+		}
+		if c != "" {
+			named = append(named, c)
+		}
+	}
+	suggestion := "want " + strings.Join(named, ", ")
+	best, bestDist := "", math.MaxInt
+	for _, c := range named {
+		if d := editDistance(strings.ToLower(value), c); d < bestDist {
+			best, bestDist = c, d
+		}
+	}
+	if bestDist <= max(1, len(best)/3) && bestDist < len(value) { // Close enough to be a typo
+		suggestion = fmt.Sprintf("did you mean %q?", best)
+	}
+	v.add(field, value, "unknown value", suggestion)
+}
+
+// editDistance returns the Levenshtein distance between a and b.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestValidateOptionsReportsAll(t *testing.T) {
+	opts := Options{
+		Text:            "HI",
+		Position:        "bottm",
+		Width:           -10,
+		WatermarkCorner: "middle",
+		Format:          "jpeg",
+		Rotate:          math.NaN(),
+	}
+	err := validateOptions(opts)
+	var verr *ValidationError
+	if !errors.As(err, &verr) {
+		t.Fatalf("validateOptions = %v, want a *ValidationError", err)
+	}
+	want := []FieldIssue{
+		{Field: "position", Value: "bottm", Reason: "unknown value", Suggestion: `did you mean "bottom"?`},
+		{Field: "watermark-corner", Value: "middle", Reason: "unknown value", Suggestion: "want tl, tr, bl, br"},
+		{Field: "format", Value: "jpeg", Reason: "unknown value", Suggestion: "want png, svg"},
+		{Field: "width", Value: "-10", Reason: "must not be negative", Suggestion: "use 0 to keep the template width"},
+		{Field: "rotate", Value: "NaN", Reason: "must be a finite number of degrees"},
+	}
+	if len(verr.Issues) != len(want) {
+		t.Fatalf("got %d issues, want %d:\n%v", len(verr.Issues), len(want), verr)
+	}
+	for i := range want {
+		if verr.Issues[i] != want[i] {
+			t.Errorf("issue %d = %+v, want %+v", i, verr.Issues[i], want[i])
+		}
+	}
+
+	// Rendering fails the same way, before anything is written
+	var buf bytes.Buffer
+	if err := run(opts, &buf, loadTestTemplate(t), fontBytes); !errors.As(err, &verr) || len(verr.Issues) != len(want) {
+		t.Errorf("run = %v, want the same validation error", err)
+	}
+	if buf.Len() != 0 {
+		t.Errorf("invalid options wrote %d bytes", buf.Len())
+	}
+}
+
+func TestValidateOptionsDefaults(t *testing.T) {
+	if err := validateOptions(Options{Text: "HI"}); err != nil {
+		t.Errorf("zero options rejected: %v", err)
+	}
+}
+
+func TestValidateSizeAndPadding(t *testing.T) {
+	zero, negative := 0, -1
+	zero64, half, over, nan := 0.0, 0.5, 1.5, math.NaN()
+	cases := []struct {
+		opts  Options
+		field string // Empty if valid
+	}{
+		{Options{Size: 36, Padding: &zero}, ""},
+		{Options{Size: 1}, ""},
+		{Options{Size: 0.5}, "size"},
+		{Options{Size: -12}, "size"},
+		{Options{Size: math.NaN()}, "size"},
+		{Options{Size: maxFontSize}, ""},
+		{Options{Size: 1e9}, "size"},
+		{Options{BubbleSize: math.Inf(1)}, "bubble-size"},
+		{Options{WatermarkSize: 1e9}, "watermark-size"},
+		{Options{WatermarkSize: math.NaN()}, "watermark-size"},
+		{Options{Padding: &negative}, "padding"},
+		{Options{Scale: 2.5}, ""},
+		{Options{Scale: -2}, "scale"},
+		{Options{Scale: math.Inf(1)}, "scale"},
+		{Options{FillOpacity: &zero64, OutlineOpacity: &half}, ""},
+		{Options{FillOpacity: &over}, "fill-opacity"},
+		{Options{OutlineOpacity: &nan}, "outline-opacity"},
+		{Options{TextRect: "0,0,100,100", CaptionBar: true}, "text-rect"},
+		{Options{Tracking: -144}, ""},
+		{Options{Tracking: 145}, "tracking"},
+		{Options{Tracking: 50, Size: 36}, "tracking"},
+		{Options{Tracking: math.MaxInt32}, "tracking"},
+		{Options{Tracking: 144, Scale: 2.5}, ""},
+		{Options{Tracking: 145, Scale: 2.5}, "tracking"},
+		{Options{Arc: -400}, ""},
+		{Options{Arc: math.NaN()}, "arc"},
+		{Options{FrameTexts: []string{"A", ""}, FrameDelay: time.Minute, Loops: 3}, ""},
+		{Options{FrameTexts: []string{"A", "B"}, Format: formatSVG}, "format"},
+		{Options{FrameDelay: -time.Second}, "frame-delay"},
+		{Options{Loops: -1}, "loop"},
+		{Options{TextRect: "0,0,100,100", Boxes: []captionBox{{Text: "HI"}}}, "text-rect"},
+	}
+	for _, tc := range cases {
+		err := validateOptions(tc.opts)
+		var verr *ValidationError
+		switch {
+		case tc.field == "" && err != nil:
+			t.Errorf("%+v rejected: %v", tc.opts, err)
+		case tc.field != "" && (!errors.As(err, &verr) || verr.Issues[0].Field != tc.field):
+			t.Errorf("%+v: got %v, want an issue with -%s", tc.opts, err, tc.field)
+		}
+	}
+}
+
+// TestValidateInvalidSizeAlone checks that an invalid -size or -scale is
+// reported alone, not also through the limits derived from it.
+func TestValidateInvalidSizeAlone(t *testing.T) {
+	for _, opts := range []Options{{Size: -5}, {Scale: math.NaN(), Tracking: 10}} {
+		var verr *ValidationError
+		if err := validateOptions(opts); !errors.As(err, &verr) || len(verr.Issues) != 1 {
+			t.Errorf("%+v: got %v, want a single issue", opts, err)
+		}
+	}
+}
+
+// TestValidateZeroFlags checks that sizes given as 0 are rejected, while
+// the flags' defaults pass.
+func TestValidateZeroFlags(t *testing.T) {
+	var v ValidationError
+	validateZeroFlags(&v, fontSize, defaultBubbleSize, 1, defaultWatermarkSize)
+	if len(v.Issues) != 0 {
+		t.Errorf("defaults rejected: %v", &v)
+	}
+	validateZeroFlags(&v, 0, 0, 0, 0)
+	var fields []string
+	for _, is := range v.Issues {
+		fields = append(fields, is.Field)
+	}
+	if got, want := strings.Join(fields, " "), "size bubble-size scale watermark-size"; got != want {
+		t.Errorf("issues with %s, want %s", got, want)
+	}
+}
@@ -0,0 +1,120 @@
+package main
+
+import (
+	"fmt"
+	"image"
+
+	"github.com/golang/freetype"
+)
+
+const (
+	defaultWatermarkSize   = 24.0 // Default watermark font size in points
+	defaultWatermarkCorner = "br" // Default watermark corner
+	watermarkInset         = 8    // Distance from the image edges in pixels
+	minWatermarkSize       = 4.0  // Smallest size a too-wide watermark shrinks to
+)
+
+// watermarkLayout is the placement of the watermark.
+type watermarkLayout struct {
+	Text     string     `json:"text"`
+	Corner   string     `json:"corner"`
+	FontSize float64    `json:"font_size"` // Points, after any shrinking
+	X        int        `json:"x"`         // Pen start
+	Baseline int        `json:"baseline"`
+	Box      *pixelRect `json:"box,omitempty"`
+	Shrunk   bool       `json:"shrunk"` // Font size reduced to fit the width
+}
+
+// watermarkStyle returns the text style for a watermark at size points,
+// already scaled. The caption's tracking is not applied to the watermark.
+func watermarkStyle(ttFont *typeface, size float64, opts Options) textStyle {
+	style := newTextStyle(ttFont, size)
+	style.hinting = hintingModes[opts.Hinting]
+	style.metrics = opts.Metrics.scaled(opts.Scale)
+	style.outline = opts.OutlineStyle
+	style.thickness = scalePx(outlineThickness, opts.Scale)
+	style.faces = opts.faces
+	return style
+}
+
+// layoutWatermark places opts.Watermark in the requested corner. A watermark
+// wider than the image is shrunk until it fits rather than clipped.
+func layoutWatermark(bounds image.Rectangle, ttFont *typeface, opts Options) (*watermarkLayout, error) {
+	corner := opts.WatermarkCorner
+	if corner == "" {
+		corner = defaultWatermarkCorner
+	}
+	switch corner {
+	case "tl", "tr", "bl", "br":
+	default:
+		return nil, fmt.Errorf("unknown watermark corner %q (want tl, tr, bl or br)", corner)
+	}
+
+	size := opts.WatermarkSize
+	if size == 0 {
+		size = defaultWatermarkSize
+	}
+	if size < 0 {
+		return nil, fmt.Errorf("watermark size must be positive, got %v", size)
+	}
+	if opts.Scale != 0 {
+		size *= opts.Scale
+	}
+	size = capFontSize(size, bounds.Dy())
+
+	// Shrink until the watermark fits between the insets. Hinting makes
+	// widths not quite proportional to size, so re-measure after scaling.
+	style := watermarkStyle(ttFont, size, opts)
+	inset := scalePx(watermarkInset, opts.Scale) + style.thickness
+	available := bounds.Dx() - 2*inset
+	ext, err := style.measure(opts.Watermark)
+	if err != nil {
+		return nil, fmt.Errorf("measuring watermark width: %w", err)
+	}
+	shrunk := false
+	for ext.inkWidth() > available && style.size > minWatermarkSize {
+		size = max(style.size*float64(available)/float64(ext.inkWidth())-0.5, minWatermarkSize)
+		style = watermarkStyle(ttFont, size, opts)
+		shrunk = true
+		if ext, err = style.measure(opts.Watermark); err != nil {
+			return nil, fmt.Errorf("measuring watermark width: %w", err)
+		}
+	}
+
+	// Unlike captions, the watermark sits by the font's own ascent unless
+	// it is overridden
+	ascent, descent := style.verticalMetrics()
+	if !style.metrics.Ascent.Set {
+		ascent = style.faceMetrics().Ascent.Ceil()
+	}
+
+	// Align the ink, not the pen position, with the inset
+	x := inset - ext.InkMin.Floor()
+	if corner == "tr" || corner == "br" {
+		x = bounds.Dx() - inset - ext.InkMax.Ceil()
+	}
+	y := inset + ascent
+	if corner == "bl" || corner == "br" {
+		y = bounds.Dy() - inset - descent
+	}
+
+	return &watermarkLayout{
+		Text:     opts.Watermark,
+		Corner:   corner,
+		FontSize: style.size,
+		X:        x,
+		Baseline: y,
+		Box:      newPixelRect(ext.inkRect(freetype.Pt(x, y))),
+		Shrunk:   shrunk,
+	}, nil
+}
+
+// drawWatermark draws a laid-out watermark using the same outline treatment
+// as the caption. The text is drawn as given (not uppercased).
+func drawWatermark(dst *image.RGBA, ttFont *typeface, wm *watermarkLayout, opts Options) error {
+	painter := newTextPainter(dst, watermarkStyle(ttFont, wm.FontSize, opts))
+	if err := painter.drawOutlined(wm.Text, freetype.Pt(wm.X, wm.Baseline), fillColor); err != nil {
+		return fmt.Errorf("drawing watermark: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"strings"
+	"unicode"
+)
+
+// Caption wrapping rules:
+//
+//   - Explicit newlines always break.
+//   - Otherwise lines break only at runs of breakable whitespace, greedily,
+//     so each line holds as many words as fit. A run at which a line breaks
+//     is dropped entirely: continuation lines never start with spaces.
+//   - Runs inside a line are kept as typed ("A    B" stays spaced out), if
+//     sanitizeCaptions hasn't already collapsed them.
+//   - Leading and trailing whitespace is trimmed, so it never counts
+//     towards a line's width for centering.
+//   - No-break spaces (U+00A0, and the figure and narrow variants) join
+//     words and are never broken at. They render as ordinary spaces.
+//   - A single word wider than the limit gets a line of its own rather than
+//     being split.
+//
+// The wrapped lines are what both measurement and drawing use, so centering
+// always sees exactly the text that is drawn.
+
+// isNoBreakSpace reports whether r is a space that must not be broken at.
+func isNoBreakSpace(r rune) bool {
+	return r == '\u00a0' || r == '\u2007' || r == '\u202f'
+}
+
+// isBreakableSpace reports whether a line may break at r.
+func isBreakableSpace(r rune) bool {
+	return unicode.IsSpace(r) && !isNoBreakSpace(r)
+}
+
+// wrapText wraps text into lines no wider than maxWidth, as reported by
+// width. Each explicit line is wrapped independently.
+func wrapText(text string, maxWidth int, width func(string) (int, error)) ([]string, error) {
+	var lines []string
+	for _, para := range strings.Split(text, "\n") {
+		wrapped, err := wrapParagraph(para, maxWidth, width)
+		if err != nil {
+			return nil, err
+		}
+		lines = append(lines, wrapped...)
+	}
+	return lines, nil
+}
+
+// wrapParagraph wraps a single line of text without explicit newlines.
+func wrapParagraph(para string, maxWidth int, width func(string) (int, error)) ([]string, error) {
+	words, seps := splitWords(para)
+	if len(words) == 0 {
+		return []string{""}, nil
+	}
+
+	var lines []string
+	cur := words[0]
+	for i, word := range words[1:] {
+		candidate := cur + seps[i] + word
+		w, err := width(candidate)
+		if err != nil {
+			return nil, err
+		}
+		if w <= maxWidth {
+			cur = candidate
+			continue
+		}
+		// Break here; the whitespace run collapses into the break
+		lines = append(lines, cur)
+		cur = word
+	}
+	return append(lines, cur), nil
+}
+
+// splitWords splits s at runs of breakable whitespace. It returns the words
+// and the run following each word except the last; leading and trailing
+// whitespace is discarded. No-break spaces inside words are replaced by
+// plain spaces.
+func splitWords(s string) (words, seps []string) {
+	start := 0
+	inWord := false
+	for i, r := range s {
+		switch space := isBreakableSpace(r); {
+		case space && inWord:
+			words = append(words, nbspToSpace(s[start:i]))
+			start, inWord = i, false
+		case !space && !inWord:
+			if len(words) > 0 {
+				seps = append(seps, s[start:i])
+			}
+			start, inWord = i, true
+		}
+	}
+	if inWord {
+		words = append(words, nbspToSpace(s[start:]))
+	}
+	return words, seps
+}
+
+// nbspToSpace replaces no-break spaces with plain spaces for drawing, since
+// fonts often lack glyphs for them.
+func nbspToSpace(s string) string {
+	return strings.Map(func(r rune) rune {
+		if isNoBreakSpace(r) {
+			return ' '
+		}
+		return r
+	}, s)
+}
+
+// wrapWidth returns the function wrapText uses to measure a candidate line
+// in style: the width of its inked glyphs.
+func wrapWidth(style textStyle) func(string) (int, error) {
+	return func(s string) (int, error) {
+		ext, err := style.measure(s)
+		if err != nil {
+			return 0, err
+		}
+		return ext.inkWidth(), nil
+	}
+}
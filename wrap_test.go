@@ -0,0 +1,76 @@
+package main
+
+import (
+	"image"
+	"reflect"
+	"testing"
+)
+
+// runeWidth measures every rune, spaces included, as 10px wide.
+func runeWidth(s string) (int, error) {
+	return 10 * len([]rune(s)), nil
+}
+
+func TestWrapText(t *testing.T) {
+	const nbsp = "\u00a0"
+	cases := []struct {
+		name  string
+		text  string
+		width int
+		want  []string
+	}{
+		{"fits", "A B", 100, []string{"A B"}},
+		{"greedy", "AA BB CC", 50, []string{"AA BB", "CC"}},
+		{"inner run kept", "A          B", 200, []string{"A          B"}},
+		{"run collapses at break", "A          B", 50, []string{"A", "B"}},
+		{"trailing spaces trimmed", "AB   ", 100, []string{"AB"}},
+		{"trailing spaces before newline", "AB   \nCD", 100, []string{"AB", "CD"}},
+		{"leading spaces trimmed", "  AB CD", 60, []string{"AB CD"}},
+		{"tabs are breakable", "AB\tCD", 30, []string{"AB", "CD"}},
+		{"newline always breaks", "A\nB", 100, []string{"A", "B"}},
+		{"blank lines kept", "A\n\nB", 100, []string{"A", "", "B"}},
+		{"newline after collapsed run", "AA BB   \n   CC", 30, []string{"AA", "BB", "CC"}},
+		{"whitespace only", "   ", 100, []string{""}},
+		{"nbsp never breaks", "AA" + nbsp + "BB CC", 30, []string{"AA BB", "CC"}},
+		{"nbsp renders as space", "A" + nbsp + "B", 100, []string{"A B"}},
+		{"nbsp run unbroken", "A" + nbsp + nbsp + nbsp + "B", 20, []string{"A   B"}},
+		{"mixed nbsp and spaces", "A" + nbsp + " " + nbsp + "B", 30, []string{"A ", " B"}},
+		{"space then nbsp", "AA " + nbsp + "BB", 30, []string{"AA", " BB"}},
+		{"narrow and figure no-break spaces", "A\u202fB\u2007C", 20, []string{"A B C"}},
+		{"long word overflows alone", "A VERYLONGWORD B", 50, []string{"A", "VERYLONGWORD", "B"}},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := wrapText(tc.text, tc.width, runeWidth)
+			if err != nil {
+				t.Fatalf("wrapText: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("wrapText(%q, %d) = %q, want %q", tc.text, tc.width, got, tc.want)
+			}
+		})
+	}
+}
+
+// TestWrappedLinesCentered checks that a caption with trailing and
+// collapsed whitespace is centered on its visible glyphs, the same as the
+// plain text.
+func TestWrappedLinesCentered(t *testing.T) {
+	ttFont, err := parseTypeface(fontBytes)
+	if err != nil {
+		t.Fatalf("parsing font: %v", err)
+	}
+	bounds := image.Rect(0, 0, 300, 540)
+
+	plain, err := computeLayout(bounds, ttFont, Options{Text: "HI\nYOU"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	spaced, err := computeLayout(bounds, ttFont, Options{Text: "HI      YOU   "})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(plain.Captions[0].Lines, spaced.Captions[0].Lines) {
+		t.Errorf("wrapped layout %+v differs from explicit lines %+v", spaced.Captions[0].Lines, plain.Captions[0].Lines)
+	}
+}